@@ -0,0 +1,42 @@
+// Command pzip-sfx is the extraction stub of self-extracting archives made
+// with pz -self-extract. Run on its own it does nothing useful; with a zip
+// archive appended it extracts that archive to the current directory or to
+// the folder given as its argument.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MattInnovates/Project-Zipper/internal/zipper"
+)
+
+func main() {
+	exe, err := os.Executable()
+	if err != nil {
+		exitWithError(err)
+	}
+
+	destDir := "."
+	if len(os.Args) > 1 {
+		destDir = strings.Join(os.Args[1:], " ")
+	}
+	absDestDir, err := filepath.Abs(destDir)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	fmt.Fprintf(os.Stdout, "Extracting %s to %s...\n", filepath.Base(exe), absDestDir)
+	stats, err := zipper.ExtractWithOptions(exe, absDestDir, zipper.ExtractOptions{})
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Fprintf(os.Stdout, "✓ Extracted %d files\n", stats.FileCount)
+}
+
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, "pz-sfx:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/MattInnovates/Project-Zipper/internal/zipper"
+)
+
+// benchLevels are the compression levels -bench compares unless -level
+// picks one.
+var benchLevels = []int{1, 6, 9}
+
+// benchRun is the outcome of archiving the benchmarked files once.
+type benchRun struct {
+	Format     string
+	Level      int
+	Threads    int
+	TotalBytes int64 // bytes of file data archived
+	Size       int64 // size of the archive
+	Elapsed    time.Duration
+}
+
+// benchThreads returns the worker counts -bench compares: 1 and doubling
+// up to the number of CPUs, which is always included.
+func benchThreads() []int {
+	var counts []int
+	for n := 1; n < runtime.NumCPU(); n *= 2 {
+		counts = append(counts, n)
+	}
+	return append(counts, runtime.NumCPU())
+}
+
+// doBench archives the sources in each of formats at each of levels and
+// worker counts, and prints the size and speed of every run so settings
+// can be compared. Archives are written to a temporary folder and removed.
+func doBench(args []string, formats []string, levels, threads []int, opts zipper.CreateOptions) {
+	sources, err := createSources(args)
+	if err != nil {
+		exitWithError(err)
+	}
+	tempDir, err := os.MkdirTemp("", "pz-bench-*")
+	if err != nil {
+		exitWithError(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	opts.Store = false
+	opts.Progress = nil
+	opts.ProgressEvents = nil
+	opts.OnEntry = nil
+
+	run := func(format string, level, workers int) (benchRun, error) {
+		opts.Level = level
+		opts.Workers = workers
+		archivePath := filepath.Join(tempDir, "bench.zip")
+		if isGzipFormat(format) {
+			format = "tar.gz"
+			archivePath = filepath.Join(tempDir, "bench.tar.gz")
+		}
+		start := time.Now()
+		var stats zipper.ArchiveStats
+		var err error
+		switch {
+		case isGzipFormat(format) && len(sources) > 1:
+			stats, err = zipper.GzipSourcesWithOptions(sources, archivePath, opts)
+		case isGzipFormat(format):
+			stats, err = zipper.GzipWithOptions(sources[0], archivePath, opts)
+		case len(sources) > 1:
+			stats, err = zipper.ZipSourcesWithOptions(sources, archivePath, opts)
+		default:
+			stats, err = zipper.ZipWithOptions(sources[0], archivePath, opts)
+		}
+		elapsed := time.Since(start)
+		os.Remove(archivePath)
+		os.Remove(archivePath + ".sha256")
+		return benchRun{Format: format, Level: level, Threads: workers, TotalBytes: stats.TotalBytes, Size: stats.ArchiveSize, Elapsed: elapsed}, err
+	}
+
+	// A first run, not shown, brings the files into the OS cache so that
+	// every run reads them from memory
+	fmt.Fprintf(statusOut, "Benchmarking %s...\n", strings.Join(sources, ", "))
+	warmup, err := run(formats[0], levels[0], threads[len(threads)-1])
+	if err != nil {
+		exitWithError(err)
+	}
+	if warmup.TotalBytes == 0 {
+		exitWithError(errors.New("nothing to benchmark: no file data in the sources"))
+	}
+	runs := len(formats) * len(levels) * len(threads)
+	noun := "runs"
+	if runs == 1 {
+		noun = "run"
+	}
+	fmt.Fprintf(statusOut, "  %s in the files; %d %s\n", formatBytes(warmup.TotalBytes), runs, noun)
+
+	if jsonOut == nil {
+		fmt.Printf("%-6s  %5s  %7s  %10s  %7s  %8s  %12s\n", "Format", "Level", "Threads", "Size", "Ratio", "Time", "Throughput")
+	}
+	for _, format := range formats {
+		for _, level := range levels {
+			for _, workers := range threads {
+				r, err := run(format, level, workers)
+				if err != nil {
+					exitWithError(err)
+				}
+				if jsonOut != nil {
+					jsonOut.Bench(r)
+					continue
+				}
+				fmt.Printf("%-6s  %5d  %7d  %10s  %7s  %8s  %10s/s\n", r.Format, r.Level, r.Threads, formatBytes(r.Size), r.ratio(), r.Elapsed.Round(time.Millisecond), formatBytes(r.throughput()))
+			}
+		}
+	}
+}
+
+// ratio returns the compression ratio of r for display.
+func (r benchRun) ratio() string {
+	if r.Size <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f:1", float64(r.TotalBytes)/float64(r.Size))
+}
+
+// throughput returns the bytes of files archived per second.
+func (r benchRun) throughput() int64 {
+	seconds := r.Elapsed.Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+	return int64(float64(r.TotalBytes) / seconds)
+}
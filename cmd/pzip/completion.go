@@ -0,0 +1,258 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// flagChoices lists the values of flags that take one of a fixed set.
+var flagChoices = map[string][]string{
+	"f":          {"zip", "gz"},
+	"format":     {"zip", "gz"},
+	"overwrite":  {"always", "skip", "newer", "fail", "prompt", "rename"},
+	"on-error":   {"abort", "skip", "warn"},
+	"collisions": {"error", "rename", "overwrite"},
+	"names":      {"auto", "cp437", "gbk", "shift-jis", "utf-8"},
+	"context":    {"install", "uninstall", "status"},
+	"config":     {"show"},
+	"completion": {"bash", "zsh", "fish", "powershell"},
+	"log-level":  {"debug", "info", "warn", "error"},
+	"tar-format": {"pax", "gnu", "ustar"},
+}
+
+// pathFlags take a file or folder.
+var pathFlags = map[string]bool{"o": true, "snapshot": true, "restore": true, "sfx-stub": true, "log-file": true, "serve-root": true}
+
+// archiveModeFlags select modes whose arguments start with an archive, so
+// that only archives and folders are completed.
+var archiveModeFlags = []string{"x", "t", "verify", "a", "u", "rm", "restore", "diff", "cat", "mount", "convert", "merge"}
+
+// archivePatterns match the archives pz reads, including the first part of
+// a split archive.
+var archivePatterns = []string{"zip", "tar.gz", "tgz", "gz", "001"}
+
+// completionFlag is a flag as the completion scripts describe it.
+type completionFlag struct {
+	name    string
+	usage   string
+	value   bool // takes a value
+	choices []string
+}
+
+// completionFlags returns every flag, sorted by name.
+func completionFlags() []completionFlag {
+	var flags []completionFlag
+	flag.VisitAll(func(f *flag.Flag) {
+		isBool := false
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok {
+			isBool = b.IsBoolFlag()
+		}
+		usage, _, _ := strings.Cut(f.Usage, "\n")
+		flags = append(flags, completionFlag{name: f.Name, usage: usage, value: !isBool, choices: flagChoices[f.Name]})
+	})
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+	return flags
+}
+
+// writeCompletion writes the completion script for shell to w.
+func writeCompletion(w io.Writer, shell string) error {
+	flags := completionFlags()
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, flags)
+	case "zsh":
+		writeZshCompletion(w, flags)
+	case "fish":
+		writeFishCompletion(w, flags)
+	case "powershell", "pwsh":
+		writePowerShellCompletion(w, flags)
+	default:
+		return fmt.Errorf("unknown shell: %s (use bash, zsh, fish or powershell)", shell)
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, flags []completionFlag) {
+	var names, valueFlags, plainValueFlags []string
+	for _, f := range flags {
+		names = append(names, "-"+f.name)
+		if f.value {
+			valueFlags = append(valueFlags, "-"+f.name)
+			if f.choices == nil && !pathFlags[f.name] {
+				plainValueFlags = append(plainValueFlags, "-"+f.name)
+			}
+		}
+	}
+	var modes []string
+	for _, name := range archiveModeFlags {
+		modes = append(modes, "-"+name)
+	}
+
+	fmt.Fprintln(w, "# bash completion for pz; add to ~/.bashrc:")
+	fmt.Fprintln(w, "#   source <(pz -completion bash)")
+	fmt.Fprintln(w, "_pz() {")
+	fmt.Fprintln(w, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"")
+	fmt.Fprintln(w, "    COMPREPLY=()")
+	fmt.Fprintln(w, "    case \"$prev\" in")
+	for _, f := range flags {
+		if f.choices != nil {
+			fmt.Fprintf(w, "        -%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", f.name, strings.Join(f.choices, " "))
+		}
+	}
+	fmt.Fprintf(w, "        %s) return ;;\n", strings.Join(plainValueFlags, "|"))
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "    if [[ \"$cur\" == -* ]]; then")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "    local word f")
+	fmt.Fprintln(w, "    for word in \"${COMP_WORDS[@]:1:COMP_CWORD-1}\"; do")
+	fmt.Fprintln(w, "        case \"$word\" in")
+	fmt.Fprintf(w, "            %s)\n", strings.Join(modes, "|"))
+	fmt.Fprintln(w, "                while IFS= read -r f; do")
+	var tests []string
+	for _, ext := range archivePatterns {
+		tests = append(tests, fmt.Sprintf("$f == *.%s", ext))
+	}
+	fmt.Fprintf(w, "                    [[ -d $f || %s ]] && COMPREPLY+=(\"$f\")\n", strings.Join(tests, " || "))
+	fmt.Fprintln(w, "                done < <(compgen -f -- \"$cur\")")
+	fmt.Fprintln(w, "                return ;;")
+	fmt.Fprintln(w, "        esac")
+	fmt.Fprintln(w, "    done")
+	fmt.Fprintln(w, "    COMPREPLY=($(compgen -f -- \"$cur\"))")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _pz pz")
+}
+
+func writeZshCompletion(w io.Writer, flags []completionFlag) {
+	escape := strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+	var modes []string
+	for _, name := range archiveModeFlags {
+		modes = append(modes, "-"+name)
+	}
+
+	fmt.Fprintln(w, "#compdef pz")
+	fmt.Fprintln(w, "# zsh completion for pz; add to ~/.zshrc after compinit:")
+	fmt.Fprintln(w, "#   source <(pz -completion zsh)")
+	fmt.Fprintln(w, "_pz() {")
+	fmt.Fprintln(w, "    local state")
+	fmt.Fprintln(w, "    _arguments \\")
+	for _, f := range flags {
+		spec := fmt.Sprintf("-%s[%s]", f.name, escape.Replace(f.usage))
+		switch {
+		case f.choices != nil:
+			spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(f.choices, " "))
+		case pathFlags[f.name]:
+			spec += fmt.Sprintf(":%s:_files", f.name)
+		case f.value:
+			spec += fmt.Sprintf(":%s: ", f.name)
+		}
+		fmt.Fprintf(w, "        '%s' \\\n", spec)
+	}
+	fmt.Fprintln(w, "        '*:file:->files'")
+	fmt.Fprintln(w, "    if [[ $state == files ]]; then")
+	fmt.Fprintf(w, "        if (( ${words[(I)(%s)]} )); then\n", strings.Join(modes, "|"))
+	fmt.Fprintf(w, "            _files -g '*.(%s)'\n", strings.Join(archivePatterns, "|"))
+	fmt.Fprintln(w, "        else")
+	fmt.Fprintln(w, "            _files")
+	fmt.Fprintln(w, "        fi")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "compdef _pz pz")
+}
+
+func writeFishCompletion(w io.Writer, flags []completionFlag) {
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+	}
+	var seen []string
+	for _, name := range archiveModeFlags {
+		seen = append(seen, "-o "+name)
+	}
+
+	fmt.Fprintln(w, "# fish completion for pz; save as ~/.config/fish/completions/pz.fish:")
+	fmt.Fprintln(w, "#   pz -completion fish > ~/.config/fish/completions/pz.fish")
+	for _, f := range flags {
+		line := fmt.Sprintf("complete -c pz -o %s -d %s", f.name, quote(f.usage))
+		switch {
+		case f.choices != nil:
+			line += fmt.Sprintf(" -x -a %s", quote(strings.Join(f.choices, " ")))
+		case pathFlags[f.name]:
+			line += " -r -F"
+		case f.value:
+			line += " -x"
+		}
+		fmt.Fprintln(w, line)
+	}
+	for _, ext := range archivePatterns {
+		fmt.Fprintf(w, "complete -c pz -n '__fish_seen_argument %s' -k -x -a '(__fish_complete_suffix .%s)'\n", strings.Join(seen, " "), ext)
+	}
+}
+
+func writePowerShellCompletion(w io.Writer, flags []completionFlag) {
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	var modes []string
+	for _, name := range archiveModeFlags {
+		modes = append(modes, quote("-"+name))
+	}
+	var patterns []string
+	for _, ext := range archivePatterns {
+		patterns = append(patterns, strings.ReplaceAll(ext, ".", `\.`))
+	}
+
+	fmt.Fprintln(w, "# PowerShell completion for pz; add to $PROFILE:")
+	fmt.Fprintln(w, "#   pz -completion powershell | Out-String | Invoke-Expression")
+	fmt.Fprintln(w, "Register-ArgumentCompleter -Native -CommandName pz, pz.exe -ScriptBlock {")
+	fmt.Fprintln(w, "    param($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintln(w, "    $flags = [ordered]@{")
+	for _, f := range flags {
+		fmt.Fprintf(w, "        %s = %s\n", quote("-"+f.name), quote(f.usage))
+	}
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $choices = @{")
+	for _, f := range flags {
+		if f.choices != nil {
+			quoted := make([]string, len(f.choices))
+			for i, c := range f.choices {
+				quoted[i] = quote(c)
+			}
+			fmt.Fprintf(w, "        %s = @(%s)\n", quote("-"+f.name), strings.Join(quoted, ", "))
+		}
+	}
+	fmt.Fprintln(w, "    }")
+	var plain []string
+	for _, f := range flags {
+		if f.value && f.choices == nil && !pathFlags[f.name] {
+			plain = append(plain, quote("-"+f.name))
+		}
+	}
+	fmt.Fprintf(w, "    $valueFlags = @(%s)\n", strings.Join(plain, ", "))
+	fmt.Fprintf(w, "    $archiveModes = @(%s)\n", strings.Join(modes, ", "))
+	fmt.Fprintln(w, "    $words = @($commandAst.CommandElements | Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })")
+	fmt.Fprintln(w, "    $prev = if ($words.Count -gt 1) { $words[-1] } else { '' }")
+	fmt.Fprintln(w, "    if ($choices.ContainsKey($prev)) {")
+	fmt.Fprintln(w, "        $choices[$prev] | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	fmt.Fprintln(w, "        }")
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    if ($valueFlags -contains $prev) { return }")
+	fmt.Fprintln(w, "    if ($wordToComplete -like '-*') {")
+	fmt.Fprintln(w, "        $flags.Keys | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterName', $flags[$_])")
+	fmt.Fprintln(w, "        }")
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    if (@($words | Where-Object { $archiveModes -contains $_ }).Count -eq 0) { return }")
+	fmt.Fprintln(w, "    $dir = $wordToComplete.Substring(0, $wordToComplete.LastIndexOfAny([char[]]'\\/') + 1)")
+	fmt.Fprintln(w, "    Get-ChildItem -Path \"$wordToComplete*\" -ErrorAction SilentlyContinue |")
+	fmt.Fprintf(w, "        Where-Object { $_.PSIsContainer -or $_.Name -match '\\.(%s)$' } | ForEach-Object {\n", strings.Join(patterns, "|"))
+	fmt.Fprintln(w, "            $path = $dir + $_.Name")
+	fmt.Fprintln(w, "            if ($path -match '\\s') { $path = \"'$path'\" }")
+	fmt.Fprintln(w, "            [System.Management.Automation.CompletionResult]::new($path, $_.Name, 'ProviderItem', $_.FullName)")
+	fmt.Fprintln(w, "        }")
+	fmt.Fprintln(w, "}")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// flagAliases maps the short spellings of flags to the name settings use.
+var flagAliases = map[string]string{"f": "format", "j": "flatten"}
+
+// modeFlags select what pz does rather than how, so the config file cannot
+// set them.
+var modeFlags = map[string]bool{
+	"x": true, "t": true, "a": true, "u": true, "rm": true, "snapshot": true,
+	"restore": true, "diff": true, "mount": true, "cat": true, "convert": true, "merge": true,
+	"verify": true, "watch": true, "dry-run": true, "context": true, "config": true,
+	"completion": true, "serve": true, "serve-public": true, "bench": true,
+}
+
+// configPath returns the path of the config file: $PZIP_CONFIG, or
+// pzip/config.toml in the user's config folder (~/.config on Linux,
+// %AppData% on Windows, ~/Library/Application Support on macOS).
+func configPath() (string, error) {
+	if path := os.Getenv("PZIP_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pzip", "config.toml"), nil
+}
+
+// configSetting is a key = value line of the config file.
+type configSetting struct {
+	name   string
+	values []string // one per element of an array
+	array  bool
+	line   int
+}
+
+// parseConfig parses the subset of TOML the config file uses: key = value
+// lines, where a value is a string, number, boolean or array of those, and
+// # starts a comment. Tables are not supported.
+func parseConfig(data, path string) ([]configSetting, error) {
+	var settings []configSetting
+	seen := make(map[string]int)
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", path, i+1, fmt.Sprintf(format, args...))
+		}
+		if line[0] == '[' {
+			return nil, fail("tables are not supported; settings go at the top level")
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t\"'") {
+			return nil, fail("expected key = value")
+		}
+		// A flag and its alias, such as f and format, are the same setting
+		if first, ok := seen[settingName(key)]; ok {
+			return nil, fail("%s is already set on line %d", settingName(key), first)
+		}
+		seen[settingName(key)] = i + 1
+
+		// Arrays may continue over the following lines
+		start := i
+		values, array, err := parseConfigValue(value)
+		for errors.Is(err, errUnclosedArray) && i+1 < len(lines) {
+			i++
+			value += "\n" + lines[i]
+			values, array, err = parseConfigValue(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, start+1, key, err)
+		}
+		settings = append(settings, configSetting{name: key, values: values, array: array, line: start + 1})
+	}
+	return settings, nil
+}
+
+var errUnclosedArray = errors.New("array is not closed with ]")
+
+// parseConfigValue parses the value of a setting, which may be followed by a
+// comment.
+func parseConfigValue(s string) (values []string, array bool, err error) {
+	s = strings.TrimLeft(s, " \t")
+	if !strings.HasPrefix(s, "[") {
+		value, rest, err := parseConfigScalar(s)
+		if err != nil {
+			return nil, false, err
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+			return nil, false, fmt.Errorf("unexpected %q after the value", rest)
+		}
+		return []string{value}, false, nil
+	}
+
+	rest := s[1:]
+	for {
+		rest = skipConfigSpace(rest)
+		switch {
+		case rest == "":
+			return nil, true, errUnclosedArray
+		case rest[0] == ']':
+			if after := strings.TrimSpace(rest[1:]); after != "" && after[0] != '#' {
+				return nil, true, fmt.Errorf("unexpected %q after the array", after)
+			}
+			return values, true, nil
+		}
+		value, after, err := parseConfigScalar(rest)
+		if err != nil {
+			return nil, true, err
+		}
+		values = append(values, value)
+		rest = skipConfigSpace(after)
+		if strings.HasPrefix(rest, ",") {
+			rest = rest[1:]
+		} else if !strings.HasPrefix(rest, "]") && rest != "" {
+			return nil, true, fmt.Errorf("expected , or ] in the array, not %q", rest)
+		}
+	}
+}
+
+// skipConfigSpace skips white space, line breaks and comments within an
+// array.
+func skipConfigSpace(s string) string {
+	for {
+		s = strings.TrimLeft(s, " \t\n")
+		if !strings.HasPrefix(s, "#") {
+			return s
+		}
+		_, s, _ = strings.Cut(s, "\n")
+	}
+}
+
+// parseConfigScalar parses the string, number or boolean at the start of s,
+// returning it as a flag would be given it and what follows it.
+func parseConfigScalar(s string) (value, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		// Basic strings escape as Go strings do
+		for i := 1; i < len(s) && s[i] != '\n'; i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return value, s[i+1:], nil
+			}
+		}
+		return "", "", errors.New("string is not closed with \"")
+	case strings.HasPrefix(s, "'"):
+		// Literal strings, such as Windows paths, are taken as they are
+		end := strings.IndexAny(s[1:], "'\n")
+		if end < 0 || s[1+end] != '\'' {
+			return "", "", errors.New("string is not closed with '")
+		}
+		return s[1 : 1+end], s[2+end:], nil
+	}
+	end := strings.IndexAny(s, " \t\n,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	value, rest = s[:end], s[end:]
+	if value == "true" || value == "false" {
+		return value, rest, nil
+	}
+	number := strings.ReplaceAll(value, "_", "")
+	if _, err := strconv.ParseFloat(number, 64); err != nil || value == "" {
+		return "", "", fmt.Errorf("invalid value %q (quote strings)", value)
+	}
+	return number, rest, nil
+}
+
+// applyConfig sets the flags the command line left alone from the settings
+// in the config file at path, and returns the names of those it set. A
+// missing file sets nothing.
+func applyConfig(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	settings, err := parseConfig(string(data), path)
+	if err != nil {
+		return nil, err
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[settingName(f.Name)] = true })
+	fromConfig := make(map[string]bool)
+	for _, s := range settings {
+		name := settingName(s.name)
+		f := flag.Lookup(s.name)
+		if f == nil || modeFlags[name] {
+			return nil, fmt.Errorf("%s:%d: unknown setting %s", path, s.line, s.name)
+		}
+		_, list := f.Value.(*listFlag)
+		if s.array && !list {
+			return nil, fmt.Errorf("%s:%d: %s takes a single value, not an array", path, s.line, s.name)
+		}
+		if given[name] {
+			continue
+		}
+		for _, v := range s.values {
+			if err := f.Value.Set(v); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %v", path, s.line, s.name, err)
+			}
+		}
+		fromConfig[name] = true
+	}
+	return fromConfig, nil
+}
+
+// settingName returns the name the config file and -config show use for
+// the flag called name.
+func settingName(name string) string {
+	if alias, ok := flagAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// showConfig prints where the config file is looked for and the value of
+// every setting, noting those that come from the file or the command line.
+func showConfig(path string, fromConfig map[string]bool) {
+	status := "not found"
+	if _, err := os.Stat(path); err == nil {
+		status = "loaded"
+	}
+	fmt.Printf("# %s (%s)\n", path, status)
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[settingName(f.Name)] = true })
+	var names []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, alias := flagAliases[f.Name]; !alias && !modeFlags[f.Name] {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		line := fmt.Sprintf("%s = %s", name, configValue(flag.Lookup(name)))
+		switch {
+		case given[name]:
+			line = fmt.Sprintf("%-40s # command line", line)
+		case fromConfig[name]:
+			line = fmt.Sprintf("%-40s # config file", line)
+		}
+		fmt.Println(line)
+	}
+}
+
+// configValue returns the value of f as the config file would give it.
+func configValue(f *flag.Flag) string {
+	if list, ok := f.Value.(*listFlag); ok {
+		quoted := make([]string, len(*list))
+		for i, v := range *list {
+			quoted[i] = strconv.Quote(v)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	if getter, ok := f.Value.(flag.Getter); ok {
+		switch getter.Get().(type) {
+		case bool, int, int64, float64:
+			return f.Value.String()
+		}
+	}
+	return strconv.Quote(f.Value.String())
+}
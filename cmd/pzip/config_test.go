@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	type setting struct {
+		name   string
+		values []string
+		array  bool
+	}
+	tests := []struct {
+		name string
+		data string
+		want []setting
+		err  string // part of the error, or "" for none
+	}{
+		{name: "scalars", data: "format = \"gz\"\nlevel = 9 # smallest\nflatten = true\n", want: []setting{
+			{"format", []string{"gz"}, false}, {"level", []string{"9"}, false}, {"flatten", []string{"true"}, false},
+		}},
+		{name: "number with underscores", data: "max-memory = 1_000_000", want: []setting{{"max-memory", []string{"1000000"}, false}}},
+		{name: "basic string escapes", data: `comment = "a\tb \"c\""`, want: []setting{{"comment", []string{"a\tb \"c\""}, false}}},
+		{name: "literal string", data: `dest = 'C:\Users\me\out'`, want: []setting{{"dest", []string{`C:\Users\me\out`}, false}}},
+		{name: "hash inside string", data: `comment = "build #4" # note`, want: []setting{{"comment", []string{"build #4"}, false}}},
+		{name: "multi-line array with comments", data: "exclude = [\n  \"*.log\", # logs\n  # caches\n  'build/**',\n]\nlevel = 1\n", want: []setting{
+			{"exclude", []string{"*.log", "build/**"}, true}, {"level", []string{"1"}, false},
+		}},
+		{name: "empty array", data: "exclude = []", want: []setting{{"exclude", nil, true}}},
+		{name: "comments and blank lines", data: "# pz settings\r\n\r\n  # indented\r\nformat = \"zip\"\r\n", want: []setting{{"format", []string{"zip"}, false}}},
+		{name: "duplicate key", data: "level = 1\nlevel = 2", err: "level is already set on line 1"},
+		{name: "duplicate through alias", data: "format = \"gz\"\nf = \"zip\"", err: "format is already set on line 1"},
+		{name: "table", data: "[create]\nlevel = 1", err: "tables are not supported"},
+		{name: "bare word", data: "format = gz", err: "quote strings"},
+		{name: "unclosed basic string", data: `format = "gz`, err: "not closed with \""},
+		{name: "literal string without escapes", data: `comment = 'it\'s'`, err: "unexpected"},
+		{name: "unclosed array", data: "exclude = [\"a\",\n\"b\"", err: "not closed with ]"},
+		{name: "missing comma", data: `exclude = ["a" "b"]`, err: "expected , or ]"},
+		{name: "missing value", data: "level =", err: "invalid value"},
+		{name: "missing key", data: "= 1", err: "expected key = value"},
+	}
+	for _, tt := range tests {
+		settings, err := parseConfig(tt.data, "config.toml")
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v; want one containing %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []setting
+		for _, s := range settings {
+			got = append(got, setting{s.name, s.values, s.array})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestApplyConfig applies a config file to flags declared as main declares
+// them, with some of them given on the command line.
+func TestApplyConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		data    string
+		want    map[string]string // flag values afterwards
+		fromCfg []string          // settings reported as from the file
+		err     string
+	}{
+		{
+			name:    "config fills what the command line leaves",
+			data:    "format = \"gz\"\nlevel = 9\nexclude = [\"*.log\", \"*.tmp\"]\n",
+			want:    map[string]string{"format": "gz", "level": "9", "exclude": "*.log, *.tmp"},
+			fromCfg: []string{"exclude", "format", "level"},
+		},
+		{
+			name:    "command line wins",
+			args:    []string{"-level", "1", "-exclude", "*.bak"},
+			data:    "level = 9\nexclude = [\"*.log\"]\n",
+			want:    map[string]string{"level": "1", "exclude": "*.bak"},
+			fromCfg: nil,
+		},
+		{
+			name:    "command line alias wins over the full name",
+			args:    []string{"-f", "zip"},
+			data:    "format = \"gz\"",
+			want:    map[string]string{"format": "zip"},
+			fromCfg: nil,
+		},
+		{
+			name:    "alias in the config file",
+			data:    "f = \"gz\"\nj = true",
+			want:    map[string]string{"format": "gz", "flatten": "true"},
+			fromCfg: []string{"flatten", "format"},
+		},
+		{name: "mode flag", data: "x = true", err: "unknown setting x"},
+		{name: "unknown flag", data: "colour = \"red\"", err: "unknown setting colour"},
+		{name: "array for a single value", data: "level = [1, 2]", err: "takes a single value"},
+		{name: "invalid value", data: "level = 1.5", err: "level"},
+	}
+	for _, tt := range tests {
+		saved := flag.CommandLine
+		flag.CommandLine = flag.NewFlagSet("pz", flag.ContinueOnError)
+		flag.Bool("x", false, "")
+		format := flag.String("f", "zip", "")
+		flag.StringVar(format, "format", "zip", "")
+		flatten := flag.Bool("flatten", false, "")
+		flag.BoolVar(flatten, "j", false, "")
+		flag.Int("level", 0, "")
+		var exclude listFlag
+		flag.Var(&exclude, "exclude", "")
+
+		path := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fromConfig, err := func() (map[string]bool, error) {
+			defer func() { flag.CommandLine = saved }()
+			if err := flag.CommandLine.Parse(tt.args); err != nil {
+				return nil, err
+			}
+			fromConfig, err := applyConfig(path)
+			for name, want := range tt.want {
+				if got := flag.Lookup(name).Value.String(); got != want {
+					t.Errorf("%s: %s = %q; want %q", tt.name, name, got, want)
+				}
+			}
+			return fromConfig, err
+		}()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v; want one containing %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var names []string
+		for name := range fromConfig {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, tt.fromCfg) {
+			t.Errorf("%s: from the config file %v; want %v", tt.name, names, tt.fromCfg)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// errNoContextMenu is returned by the context menu functions outside
+// Windows, where handleContextMenu stops before calling them.
+var errNoContextMenu = errors.New("context menu integration is only available on Windows")
+
+func installContextMenu() error { return errNoContextMenu }
+
+func uninstallContextMenu() error { return errNoContextMenu }
+
+func checkContextMenuStatus() {}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MattInnovates/Project-Zipper/internal/zipper"
+)
+
+// jsonOut is set by -json; progress and results are then written to stdout
+// as line-delimited JSON instead of the progress bar and summary.
+var jsonOut *jsonReporter
+
+// jsonProgressInterval limits how often progress events are emitted.
+const jsonProgressInterval = 100 * time.Millisecond
+
+type jsonReporter struct {
+	mu        sync.Mutex
+	enc       *json.Encoder
+	startTime time.Time
+	lastEmit  time.Time
+}
+
+func newJSONReporter() *jsonReporter {
+	return &jsonReporter{enc: json.NewEncoder(os.Stdout), startTime: time.Now()}
+}
+
+type jsonProgressEvent struct {
+	Event      string `json:"event"`
+	Done       int64  `json:"done"`
+	Total      int64  `json:"total"`
+	File       string `json:"file,omitempty"`
+	FilesDone  int    `json:"files_done"`
+	FilesTotal int    `json:"files_total,omitempty"`
+}
+
+type jsonResultEvent struct {
+	Event      string   `json:"event"`
+	Mode       string   `json:"mode"`
+	Output     string   `json:"output"`
+	Parts      []string `json:"parts,omitempty"`
+	Stats      any      `json:"stats"`
+	DurationMS int64    `json:"duration_ms"`
+	// Ratio is uncompressed:compressed bytes, as used by -max-ratio
+	Ratio float64 `json:"ratio,omitempty"`
+}
+
+type jsonEntryEvent struct {
+	Event          string `json:"event"`
+	Name           string `json:"name"`
+	Dir            bool   `json:"dir,omitempty"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressed_size,omitempty"` // zip only
+}
+
+type jsonSkipEvent struct {
+	Event string `json:"event"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+type jsonPlanEvent struct {
+	Event string      `json:"event"`
+	Mode  string      `json:"mode"`
+	Plan  zipper.Plan `json:"plan"`
+}
+
+type jsonReportEvent struct {
+	Event          string        `json:"event"`
+	TotalBytes     int64         `json:"total_bytes"`
+	CompressedSize int64         `json:"compressed_size"`
+	Ratio          float64       `json:"ratio,omitempty"`
+	Files          []reportEntry `json:"files"` // largest first
+}
+
+type jsonBenchEvent struct {
+	Event       string  `json:"event"`
+	Format      string  `json:"format"`
+	Level       int     `json:"level"`
+	Threads     int     `json:"threads"`
+	TotalBytes  int64   `json:"total_bytes"`
+	Size        int64   `json:"size"`
+	Ratio       float64 `json:"ratio,omitempty"`
+	DurationMS  int64   `json:"duration_ms"`
+	BytesPerSec int64   `json:"bytes_per_sec"`
+}
+
+type jsonErrorEvent struct {
+	Event string `json:"event"`
+	Error string `json:"error"`
+}
+
+func (r *jsonReporter) emit(v any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(v)
+}
+
+func (r *jsonReporter) OnEvent(e zipper.ProgressEvent) {
+	now := time.Now()
+	r.mu.Lock()
+	due := now.Sub(r.lastEmit) >= jsonProgressInterval || e.BytesDone >= e.BytesTotal
+	if due {
+		r.lastEmit = now
+	}
+	r.mu.Unlock()
+	if due {
+		r.emit(jsonProgressEvent{
+			Event:      "progress",
+			Done:       e.BytesDone,
+			Total:      e.BytesTotal,
+			File:       e.File,
+			FilesDone:  e.FilesDone,
+			FilesTotal: e.FilesTotal,
+		})
+	}
+}
+
+// OnEntry emits an entry event for each file added or extracted with -v.
+func (r *jsonReporter) OnEntry(e zipper.EntryEvent) {
+	event := jsonEntryEvent{Event: "entry", Name: e.Name, Dir: e.IsDir, Size: e.Size}
+	if e.CompressedSize > 0 {
+		event.CompressedSize = e.CompressedSize
+	}
+	r.emit(event)
+}
+
+// OnSkip emits a skip event for each unreadable file with -on-error warn.
+func (r *jsonReporter) OnSkip(name string, err error) {
+	r.emit(jsonSkipEvent{Event: "skip", Name: name, Error: err.Error()})
+}
+
+// Result emits the final event of a run. uncompressed and compressed give
+// the ratio and may be zero when it does not apply.
+func (r *jsonReporter) Result(mode, output string, parts []string, stats any, uncompressed, compressed int64) {
+	event := jsonResultEvent{
+		Event:      "result",
+		Mode:       mode,
+		Output:     output,
+		Parts:      parts,
+		Stats:      stats,
+		DurationMS: time.Since(r.startTime).Milliseconds(),
+	}
+	if compressed > 0 {
+		event.Ratio = float64(uncompressed) / float64(compressed)
+	}
+	r.emit(event)
+}
+
+// Plan emits what a -dry-run would create or extract, in place of a result.
+func (r *jsonReporter) Plan(mode string, plan zipper.Plan) {
+	r.emit(jsonPlanEvent{Event: "plan", Mode: mode, Plan: plan})
+}
+
+// Report emits the compression report of -report, listing every file
+// rather than the top ones.
+func (r *jsonReporter) Report(report *compressionReport, stats zipper.ArchiveStats, archiveSize int64) {
+	event := jsonReportEvent{Event: "report", TotalBytes: stats.TotalBytes, CompressedSize: archiveSize, Files: report.sorted()}
+	if archiveSize > 0 {
+		event.Ratio = float64(stats.TotalBytes) / float64(archiveSize)
+	}
+	r.emit(event)
+}
+
+// Bench emits one run of -bench.
+func (r *jsonReporter) Bench(run benchRun) {
+	event := jsonBenchEvent{
+		Event:       "bench",
+		Format:      run.Format,
+		Level:       run.Level,
+		Threads:     run.Threads,
+		TotalBytes:  run.TotalBytes,
+		Size:        run.Size,
+		DurationMS:  run.Elapsed.Milliseconds(),
+		BytesPerSec: run.throughput(),
+	}
+	if run.Size > 0 {
+		event.Ratio = float64(run.TotalBytes) / float64(run.Size)
+	}
+	r.emit(event)
+}
+
+func (r *jsonReporter) Error(err error) {
+	r.emit(jsonErrorEvent{Event: "error", Error: err.Error()})
+}
@@ -1,173 +1,1569 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/MattInnovates/Project-Zipper/internal/zipper"
-	"golang.org/x/sys/windows/registry"
+)
+
+// -nice keeps to at most niceWorkers threads and buffers of niceBufferSize
+// unless -buffer-size is given, and pauses niceEntryPause before each file.
+const (
+	niceWorkers    = 2
+	niceBufferSize = 64 << 10
+	niceEntryPause = time.Millisecond
 )
 
 func main() {
 	extractFlag := flag.Bool("x", false, "extract mode: extract archive to destination")
+	testFlag := flag.Bool("t", false, "test mode: read and checksum every entry without extracting")
+	appendFlag := flag.Bool("a", false, "append mode: add a folder to an existing zip archive")
+	updateFlag := flag.Bool("u", false, "update mode: re-archive only the files of a folder that changed since the zip was made")
+	removeFlag := flag.Bool("rm", false, "remove mode: delete entries matching the given patterns from a zip archive")
+	snapshotFlag := flag.String("snapshot", "", "backup mode: snapshot file tracking a full + incremental backup chain of the folder")
+	restoreFlag := flag.String("restore", "", "restore mode: layer a full backup and its incrementals into this folder")
+	diffFlag := flag.Bool("diff", false, "diff mode: list files added, removed or modified in a folder or second archive since the archive was made")
+	hashFlag := flag.Bool("hash", false, "diff mode: compare file contents by CRC-32 instead of modification times")
+	benchFlag := flag.Bool("bench", false, "bench mode: archive a folder as zip and tar.gz at several levels and thread counts, comparing size and speed")
+	serveFlag := flag.String("serve", "", "serve mode: listen on this address, e.g. localhost:8080, for create and extract jobs submitted over HTTP")
+	servePublicFlag := flag.Bool("serve-public", false, "serve mode: allow listening on addresses other machines can reach")
+	serveRootFlag := flag.String("serve-root", "", "serve mode: only accept jobs whose sources, output and dest are inside this folder")
+	catFlag := flag.Bool("cat", false, "cat mode: write the contents of files in an archive to standard output without extracting it")
+	mountFlag := flag.Bool("mount", false, "mount mode: serve an archive read-only at a mount point until interrupted (Linux and macOS, needs FUSE)")
+	convertFlag := flag.Bool("convert", false, "convert mode: write an archive's entries to a new archive in the format of the output path")
+	mergeFlag := flag.Bool("merge", false, "merge mode: combine the entries of several zip and tar.gz archives into a new archive")
+	verifyFlag := flag.Bool("verify", false, "verify mode: check files against the archive's SHA-256 manifest")
+	threadsFlag := flag.Int("threads", 0, "number of files processed in parallel (default 20% of CPU cores)")
+	levelFlag := flag.Int("level", 0, "compression level from 1 (fastest) to 9 (smallest); 0 picks one from the total size")
+	storeFlag := flag.Bool("store", false, "store files without compression, overriding -level")
+	manifestFlag := flag.Bool("manifest", false, "create mode: add a "+zipper.ManifestName+" entry with per-file SHA-256 digests")
+	dedupeFlag := flag.Bool("dedupe", false, "create mode: store byte-identical files once (hard links in tar.gz, reused compressed data in zip)")
+	reproducibleFlag := flag.Bool("reproducible", false, "create mode: give the same archive bytes for the same files (sorted entries, fixed times, no owners)")
+	mtimeFlag := flag.String("mtime", "", "create mode: record files modified later as modified at this time (Unix seconds, YYYY-MM-DD or RFC 3339; default $SOURCE_DATE_EPOCH)")
+	commentFlag := flag.String("comment", "", "create, append and update modes: store this text in the zip comment")
 	formatFlag := flag.String("f", "zip", "archive format: zip or gz (tar.gz)")
+	tarFormatFlag := flag.String("tar-format", "pax", "header format of tar.gz entries: pax, gnu or ustar (oldest tools; no long names or files of 8 GB)")
+	flag.StringVar(formatFlag, "format", "zip", "archive format (same as -f)")
+	nameTemplateFlag := flag.String("name-template", "", "create, watch and backup modes: name new archives from a template such as \"{base}-{date}-{n}.zip\"")
+	outputFlag := flag.String("o", "", "create mode: archive path, a folder ending in / to name it automatically there, or s3://bucket/key to upload it")
+	jsonFlag := flag.Bool("json", false, "write progress and the result as line-delimited JSON on stdout")
+	quietFlag := flag.Bool("q", false, "quiet: print only the resulting paths, no progress or summary")
+	noProgressFlag := flag.Bool("no-progress", false, "do not show progress (summaries are still printed to stderr)")
+	verboseFlag := flag.Bool("v", false, "list each entry as it is added or extracted")
 	contextFlag := flag.String("context", "", "install/uninstall Windows context menu: install, uninstall, or status")
+	configFlag := flag.String("config", "", "show the config file location and the effective settings: show")
+	completionFlag := flag.String("completion", "", "print a shell completion script: bash, zsh, fish or powershell")
+	noTimesFlag := flag.Bool("no-times", false, "extract mode: do not restore file modification times")
+	var exclude listFlag
+	flag.Var(&exclude, "exclude", "create mode: leave out files and folders matching this pattern, e.g. \"*.log\" or \"build/**\" (repeatable)")
+	noHiddenFlag := flag.Bool("no-hidden", false, "leave out hidden files and folders (dot files, and on Windows the hidden attribute) when creating or extracting")
+	noJunkFlag := flag.Bool("no-junk", false, "leave out OS junk such as .DS_Store, Thumbs.db, desktop.ini and __MACOSX when creating or extracting")
+	dereferenceFlag := flag.Bool("dereference", false, "create mode: archive what symbolic links point to instead of leaving the links out")
+	onErrorFlag := flag.String("on-error", "warn", "create mode: unreadable files policy: abort, skip, or warn (skip and list them)")
+	var maxMemory, bufferSize, limitRate, maxTotal, maxFileSize, splitSize sizeFlag
+	flag.Var(&maxMemory, "max-memory", "create mode: cap on file data buffered in memory, e.g. 512M (default 256M)")
+	flag.Var(&splitSize, "split", "create mode: split the archive into numbered parts of this size, e.g. 100M")
+	selfExtractFlag := flag.Bool("self-extract", false, "create mode: make a self-extracting zip by prepending an extraction stub")
+	sfxStubFlag := flag.String("sfx-stub", "", "create mode: extraction stub for -self-extract, built from cmd/pzip-sfx for the target platform (default pz-sfx next to pz)")
+	flag.Var(&bufferSize, "buffer-size", "size of the I/O buffers used to copy file data, e.g. 4M (default 1M)")
+	flag.Var(&limitRate, "limit-rate", "cap on bytes per second read from source files, written when extracting, or uploaded, e.g. 20M")
+	niceFlag := flag.Bool("nice", false, "run in the background: lower the process priority, use at most 2 threads and small buffers, and pause between files")
+	maxRatioFlag := flag.Float64("max-ratio", 0, "extract mode: abort when an entry or the archive exceeds this compression ratio (default 200, -1 disables)")
+	flag.Var(&maxTotal, "max-total", "extract mode: abort if files total more than this size, e.g. 10G")
+	maxEntriesFlag := flag.Int("max-entries", 0, "extract mode: abort if the archive has more entries than this")
+	flag.Var(&maxFileSize, "max-file-size", "extract mode: abort if any file is larger than this size, e.g. 2G")
+	maxDepthFlag := flag.Int("max-depth", 0, "extract mode: abort if an entry path is nested deeper than this")
+	overwriteFlag := flag.String("overwrite", "always", "extract mode: existing files policy: always, skip, newer, fail, prompt or rename")
+	noClobberFlag := flag.Bool("n", false, "extract mode: never overwrite existing files (same as -overwrite skip)")
+	flattenFlag := flag.Bool("flatten", false, "extract mode: extract every file directly into the destination, without folders")
+	flag.BoolVar(flattenFlag, "j", false, "extract mode: same as -flatten (junk paths)")
+	collisionsFlag := flag.String("collisions", "error", "extract and merge modes: files -flatten or several archives give the same name: error, rename or overwrite (last one wins)")
+	namesFlag := flag.String("names", "auto", "extract mode: code page of zip entry names not marked as UTF-8: auto, cp437, gbk, shift-jis or utf-8")
+	recursiveFlag := flag.Bool("recursive", false, "extract mode: also extract zip and tar.gz archives found inside the archive, in place")
+	recursiveDepthFlag := flag.Int("recursive-depth", 5, "extract mode: how many levels of nested archives -recursive extracts")
+	resumeFlag := flag.Bool("resume", false, "extract mode: continue an interrupted extraction, keeping files already extracted intact")
+	keepCorruptFlag := flag.Bool("keep-corrupt", false, "extract mode: keep files that fail their CRC-32 check instead of removing them")
+	dryRunFlag := flag.Bool("dry-run", false, "create and extract modes: list what would be archived or extracted without writing anything")
+	watchFlag := flag.Bool("watch", false, "create mode: keep the archive up to date as the folder changes, until interrupted")
+	watchIntervalFlag := flag.Duration("watch-interval", 0, "watch mode: scan the folder for changes this often, e.g. 30s, instead of relying on change notifications")
+	reportFlag := flag.Bool("report", false, "create mode: report the compression ratio and the files taking the most space in the archive")
+	reportTopFlag := flag.Int("report-top", 10, "create mode: number of files -report lists")
+	logFileFlag := flag.String("log-file", "", "append a log of each run to this file, for troubleshooting failed runs")
+	logLevelFlag := flag.String("log-level", "info", "detail of -log-file: debug (every entry), info, warn or error")
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <source> [destination]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintln(flag.CommandLine.Output(), "\nCreate or extract archives.")
 		fmt.Fprintln(flag.CommandLine.Output(), "CREATE MODE (default):")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz <folder>           Create a zip archive of the folder")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz -f gz <folder>     Create a tar.gz archive of the folder")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -f gz -tar-format gnu <folder>  Write GNU tar headers for tools without PAX support")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz report.pdf         Create report.zip holding a single file")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz <dirA> <dirB> <file.txt>  Archive several sources, each under its own name")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -o D:/out/name.zip <folder>  Choose where the archive is written")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -o s3://bucket/backups/name.zip <folder>  Upload the archive as it is written")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -name-template \"{base}-{date}-{n}\" <folder>  Name the archive folder-2024-06-01-1.zip, -2, ...")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -q <folder>        Print only the archive path, e.g. for scripts")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -dry-run <folder>  List what would be archived without writing anything")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -v <folder>        List each file as it is added")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -level 9 <folder>  Compress as small as possible (1-9, or -store for none)")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -exclude \"*.log\" -exclude \"build/**\" <folder>  Leave out matching files")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -no-hidden -no-junk <folder>  Leave out dot files and .DS_Store, Thumbs.db, ...")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -dereference <folder>  Include the files and folders symbolic links point to")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -on-error abort <folder>  Stop at the first unreadable file instead of skipping it")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -threads 8 <folder>  Process 8 files in parallel")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -max-memory 1G <folder>  Allow up to 1 GB of buffered file data")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -limit-rate 20M <folder>  Read the folder at no more than 20 MB per second")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -nice <folder>  Archive in the background without slowing down the machine")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -manifest <folder>  Embed per-file SHA-256 digests in the archive")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -f gz -dedupe <folder>  Store identical files once, as hard links")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -reproducible <folder>  Create the same archive, byte for byte, from the same files")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -mtime 2024-06-01 <folder>  Record no modification time later than June 1, 2024")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -report <folder>   Show the ratio and the 10 files taking the most space")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -comment \"nightly build\" <folder>  Store a comment in the zip archive")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -split 100M <folder>  Write the archive as parts folder.zip.001, .002, ...")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -self-extract <folder>  Create an archive recipients can run to extract")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -a <archive.zip> <folder>  Add a folder to an existing zip archive")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -u <archive.zip> <folder>  Update a zip with the folder's changed files")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -watch <folder>    Archive the folder, then again after each change")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -watch -watch-interval 30s <folder>  Scan for changes every 30s instead, for")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        network shares that send no change notifications")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -rm <archive.zip> \"logs/**\"  Delete matching entries from a zip archive")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nBACKUP MODE:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -snapshot <file.json> <folder>  Full backup, then incrementals of changed files")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -restore <dest> <full.zip> [incremental.zip...]  Restore a backup chain")
 		fmt.Fprintln(flag.CommandLine.Output(), "\nEXTRACT MODE:")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x <archive.zip>   Extract archive to current directory")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x <archive.tar.gz> <dest>  Extract archive to destination folder")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x <archive.zip.001>  Reassemble and extract a split archive")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x https://example.com/release.zip <dest>  Download and extract in one go")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -v <archive.zip>  List each file as it is extracted")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -dry-run <archive.zip> <dest>  Show what would be created, overwritten or")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        rejected as unsafe, without writing anything")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -no-junk <archive.zip>  Extract without __MACOSX, .DS_Store and other OS junk")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -no-times <archive.zip>  Extract without restoring file times")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -j <archive.zip> <dest>  Extract all files into dest, without folders")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -resume <archive.zip> <dest>  Continue an interrupted extraction")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -recursive <archive.zip>  Also extract archives inside it, each into a")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        folder of its name")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -names shift-jis <archive.zip>  Decode names written by old Japanese tools")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -n <archive.zip>  Keep files that already exist")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -overwrite prompt <archive.zip>  Ask before replacing existing files")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x -overwrite rename <archive.zip>  Write colliding files as \"name (1).ext\"")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nTEST MODE:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -t <archive.zip>   Verify every entry without writing to disk")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -verify <archive.zip> [folder]  Check the archive, or files extracted")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        to folder, against its SHA-256 manifest")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nCAT MODE:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -cat <archive.zip> <path/in/archive> ...  Write files from the archive to")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        standard output, to pipe or grep without extracting")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nDIFF MODE:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -diff <archive.zip> <folder>  List files added, removed or modified since the")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        archive was made; exits 1 if there are any")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -diff -hash <archive.zip> <other.zip>  Compare contents, not modification times")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nMERGE MODE:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -merge <out.zip> <a.zip> <b.tar.gz> ...  Combine archives into one, copying")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        zip entries without recompressing them")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -merge -collisions rename <out.tar.gz> <a.zip> <b.zip>  Keep both of two files")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        with the same name")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nCONVERT MODE:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -convert <archive.zip> <archive.tar.gz>  Rewrite an archive in another format,")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        streaming entries without extracting them")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nMOUNT MODE (Linux and macOS):")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -mount <archive.zip> <mountpoint>  Browse and read an archive as a read-only")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        folder without extracting it, until Ctrl+C")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nBENCH MODE:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -bench <folder>    Archive the folder as zip and tar.gz at levels 1, 6 and 9 with")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        1 thread up to all CPUs, and compare size, time and throughput")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -bench -f gz -level 6 <folder>  Compare thread counts for one format and level")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nSERVE MODE:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -serve localhost:8080  Run create and extract jobs submitted over HTTP:")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        POST /jobs, GET /jobs/{id} for progress, DELETE /jobs/{id}")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        to cancel or forget a finished job, GET /jobs/{id}/archive")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        to download. Requests need the token printed at startup,")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        or $PZIP_SERVE_TOKEN")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -serve localhost:8080 -serve-root /srv/jobs  Only accept paths inside /srv/jobs")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nCONFIG FILE:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  Defaults such as format = \"gz\" or exclude = [\"*.log\"] go in pzip/config.toml in the")
+		fmt.Fprintln(flag.CommandLine.Output(), "  user config folder (~/.config on Linux), or the file $PZIP_CONFIG names; flags override them.")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -config show        Show the config file and the effective settings")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nLOGGING:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -log-file pz.log -log-level debug <folder>  Append every entry, skipped file,")
+		fmt.Fprintln(flag.CommandLine.Output(), "                        warning and error of the run to pz.log")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nSHELL COMPLETION:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -completion bash    Print a completion script for bash, zsh, fish or powershell")
 		fmt.Fprintln(flag.CommandLine.Output(), "\nCONTEXT MENU (Windows):")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz --context install    Add 'Compress with pz' to Windows context menu")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz --context uninstall  Remove from Windows context menu")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz --context status     Check installation status")
 	}
 
-	flag.Parse()
+	flag.Parse()
+
+	// Settings in the config file apply where no flag was given
+	configFile, err := configPath()
+	if err != nil {
+		exitWithError(err)
+	}
+	fromConfig, err := applyConfig(configFile)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	setupProgress(*quietFlag, *noProgressFlag)
+	verbose = *verboseFlag
+	dryRun = *dryRunFlag
+	if *reportFlag {
+		reportTop = max(*reportTopFlag, 1)
+	}
+	if *jsonFlag {
+		jsonOut = newJSONReporter()
+	}
+
+	// Handle context menu operations
+	if *contextFlag != "" {
+		handleContextMenu(*contextFlag)
+		return
+	}
+	if *configFlag != "" {
+		if *configFlag != "show" {
+			exitWithError(fmt.Errorf("unknown -config command: %s (use show)", *configFlag))
+		}
+		showConfig(configFile, fromConfig)
+		return
+	}
+	if *completionFlag != "" {
+		if err := writeCompletion(os.Stdout, *completionFlag); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	if flag.NArg() < 1 && *serveFlag == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Ctrl+C stops the operation between reads rather than killing the
+	// process mid-write
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// -on-error warn skips unreadable files like skip, and the create
+	// modes list them as they are skipped
+	errorPolicy := zipper.ErrorSkip
+	warnSkipped = *onErrorFlag == "warn"
+	if !warnSkipped {
+		var err error
+		if errorPolicy, err = zipper.ParseErrorPolicy(*onErrorFlag); err != nil {
+			exitWithError(fmt.Errorf("unknown error policy: %s (use abort, skip or warn)", *onErrorFlag))
+		}
+	}
+
+	// Build systems set SOURCE_DATE_EPOCH to the time of the last commit
+	mtime, mtimeSource := *mtimeFlag, "-mtime"
+	if mtime == "" {
+		mtime, mtimeSource = os.Getenv("SOURCE_DATE_EPOCH"), "SOURCE_DATE_EPOCH"
+	}
+	var clampTime time.Time
+	if mtime != "" {
+		var err error
+		if clampTime, err = parseTime(mtime); err != nil {
+			exitWithError(fmt.Errorf("%s: %w", mtimeSource, err))
+		}
+	}
+
+	if *nameTemplateFlag != "" {
+		if err := zipper.ValidateNameTemplate(*nameTemplateFlag); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	logger, err := openLog(*logFileFlag, *logLevelFlag)
+	if err != nil {
+		exitWithError(err)
+	}
+	runLog = logger
+
+	tarFormat, err := zipper.ParseTarFormat(*tarFormatFlag)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	var entryPause time.Duration
+	if *niceFlag {
+		if err := zipper.LowerPriority(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: -nice could not lower the priority: %v\n", err)
+		}
+		*threadsFlag = min(zipper.WorkerCount(*threadsFlag), niceWorkers)
+		if bufferSize == 0 {
+			bufferSize = niceBufferSize
+		}
+		entryPause = niceEntryPause
+	}
+
+	createOpts := zipper.CreateOptions{
+		Context:      ctx,
+		MaxMemory:    int64(maxMemory),
+		BufferSize:   int(bufferSize),
+		RateLimit:    int64(limitRate),
+		EntryPause:   entryPause,
+		Manifest:     *manifestFlag,
+		Level:        *levelFlag,
+		Store:        *storeFlag,
+		Workers:      *threadsFlag,
+		Exclude:      exclude,
+		SkipHidden:   *noHiddenFlag,
+		SkipJunk:     *noJunkFlag,
+		Dereference:  *dereferenceFlag,
+		ErrorPolicy:  errorPolicy,
+		Comment:      *commentFlag,
+		TarFormat:    tarFormat,
+		Dedupe:       *dedupeFlag,
+		Reproducible: *reproducibleFlag,
+		ClampModTime: clampTime,
+		Logger:       logger,
+	}
+	if *commentFlag != "" {
+		// Only zip archives have a comment, which also holds the checksum
+		if !strings.EqualFold(*formatFlag, "zip") && !*appendFlag && !*updateFlag && !*mergeFlag && !*convertFlag {
+			exitWithError(errors.New("-comment needs the zip format"))
+		}
+		if len(*commentFlag) > zipper.MaxCommentLength {
+			exitWithError(fmt.Errorf("-comment is longer than %d bytes", zipper.MaxCommentLength))
+		}
+	}
+
+	if *serveFlag != "" {
+		overwrite, err := zipper.ParseOverwritePolicy(*overwriteFlag)
+		if err != nil {
+			exitWithError(err)
+		}
+		doServe(*serveFlag, *serveRootFlag, *servePublicFlag, createOpts, zipper.ExtractOptions{
+			Context:       ctx,
+			SkipHidden:    *noHiddenFlag,
+			SkipJunk:      *noJunkFlag,
+			SkipTimes:     *noTimesFlag,
+			Workers:       *threadsFlag,
+			BufferSize:    int(bufferSize),
+			RateLimit:     int64(limitRate),
+			EntryPause:    entryPause,
+			MaxRatio:      *maxRatioFlag,
+			MaxTotalBytes: int64(maxTotal),
+			MaxEntries:    *maxEntriesFlag,
+			MaxFileSize:   int64(maxFileSize),
+			MaxPathDepth:  *maxDepthFlag,
+			Overwrite:     overwrite,
+			Logger:        logger,
+		})
+	} else if *benchFlag {
+		// -f, -level and -threads narrow the runs to the value given
+		formats, levels, threads := []string{"zip", "tar.gz"}, benchLevels, benchThreads()
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "f", "format":
+				formats = []string{*formatFlag}
+			case "level":
+				levels = []int{*levelFlag}
+			case "threads":
+				threads = []int{*threadsFlag}
+			}
+		})
+		if *niceFlag {
+			threads = []int{*threadsFlag}
+		}
+		doBench(flag.Args(), formats, levels, threads, createOpts)
+	} else if *testFlag {
+		doTest(flag.Args())
+	} else if *appendFlag || *updateFlag {
+		doAppend(flag.Args(), *updateFlag, createOpts)
+	} else if *snapshotFlag != "" {
+		doBackup(flag.Args(), *snapshotFlag, *nameTemplateFlag, createOpts)
+	} else if *restoreFlag != "" {
+		doRestore(flag.Args(), *restoreFlag, zipper.ExtractOptions{
+			Context:    ctx,
+			SkipTimes:  *noTimesFlag,
+			Workers:    *threadsFlag,
+			BufferSize: int(bufferSize),
+			RateLimit:  int64(limitRate),
+			EntryPause: entryPause,
+			MaxRatio:   *maxRatioFlag,
+			Logger:     logger,
+		})
+	} else if *removeFlag {
+		doRemove(flag.Args())
+	} else if *verifyFlag {
+		doVerify(flag.Args())
+	} else if *diffFlag {
+		doDiff(flag.Args(), zipper.DiffOptions{
+			Context:    ctx,
+			Hash:       *hashFlag,
+			Exclude:    exclude,
+			SkipHidden: *noHiddenFlag,
+			SkipJunk:   *noJunkFlag,
+		})
+	} else if *catFlag {
+		doCat(flag.Args())
+	} else if *mountFlag {
+		doMount(flag.Args())
+	} else if *convertFlag {
+		doConvert(flag.Args(), createOpts)
+	} else if *mergeFlag {
+		collisions, err := zipper.ParseCollisionPolicy(*collisionsFlag)
+		if err != nil {
+			exitWithError(err)
+		}
+		doMerge(flag.Args(), zipper.MergeOptions{CreateOptions: createOpts, Collisions: collisions})
+	} else if *extractFlag {
+		overwrite, err := zipper.ParseOverwritePolicy(*overwriteFlag)
+		if err != nil {
+			exitWithError(err)
+		}
+		if *noClobberFlag {
+			overwrite = zipper.OverwriteNever
+		}
+		collisions, err := zipper.ParseCollisionPolicy(*collisionsFlag)
+		if err != nil {
+			exitWithError(err)
+		}
+		names, err := zipper.ParseNameEncoding(*namesFlag)
+		if err != nil {
+			exitWithError(err)
+		}
+		recursive := 0
+		if *recursiveFlag {
+			if *flattenFlag {
+				exitWithError(errors.New("-recursive cannot be combined with -flatten"))
+			}
+			recursive = max(*recursiveDepthFlag, 1)
+		}
+		doExtract(flag.Args(), zipper.ExtractOptions{
+			Context:           ctx,
+			SkipHidden:        *noHiddenFlag,
+			SkipJunk:          *noJunkFlag,
+			SkipTimes:         *noTimesFlag,
+			Workers:           *threadsFlag,
+			BufferSize:        int(bufferSize),
+			RateLimit:         int64(limitRate),
+			EntryPause:        entryPause,
+			MaxRatio:          *maxRatioFlag,
+			MaxTotalBytes:     int64(maxTotal),
+			MaxEntries:        *maxEntriesFlag,
+			MaxFileSize:       int64(maxFileSize),
+			MaxPathDepth:      *maxDepthFlag,
+			KeepCorrupt:       *keepCorruptFlag,
+			Resume:            *resumeFlag,
+			Flatten:           *flattenFlag,
+			FlattenCollisions: collisions,
+			NameEncoding:      names,
+			Recursive:         recursive,
+			Overwrite:         overwrite,
+			Logger:            logger,
+		})
+	} else if *watchFlag {
+		if *selfExtractFlag || splitSize > 0 {
+			exitWithError(errors.New("-watch cannot be combined with -split or -self-extract"))
+		}
+		doWatch(flag.Args(), *formatFlag, *outputFlag, *nameTemplateFlag, *watchIntervalFlag, createOpts)
+	} else {
+		sfxStub := ""
+		if *selfExtractFlag {
+			if isGzipFormat(*formatFlag) {
+				exitWithError(errors.New("self-extracting archives must use the zip format"))
+			}
+			stub, err := findSFXStub(*sfxStubFlag)
+			if err != nil {
+				exitWithError(err)
+			}
+			sfxStub = stub
+		}
+		doCreate(flag.Args(), *formatFlag, *outputFlag, *nameTemplateFlag, int64(splitSize), sfxStub, createOpts)
+	}
+}
+
+func doCreate(args []string, format, output, nameTemplate string, splitSize int64, sfxStub string, opts zipper.CreateOptions) {
+	sources, err := createSources(args)
+	if err != nil {
+		exitWithError(err)
+	}
+	absTarget := sources[0]
+	if dryRun {
+		planCreate(sources, opts)
+		return
+	}
+
+	// A single folder's contents are archived at the top level; several
+	// sources each keep their own name, and the archive is named after the
+	// folder containing the first
+	nameFrom := absTarget
+	if len(sources) > 1 {
+		nameFrom = filepath.Dir(absTarget)
+	}
+
+	var archivePath string
+	var stats zipper.ArchiveStats
+
+	printer := newCreateProgressPrinter(strings.Join(sources, ", "), opts.Workers)
+	setCreateCallbacks(&opts, printer)
+	var report *compressionReport
+	if reportTop > 0 {
+		report = &compressionReport{}
+		opts.OnEntry = report.onEntry(opts.OnEntry)
+	}
+
+	// Archives for object storage are uploaded as they are written, without
+	// a local copy
+	if strings.HasPrefix(output, "s3://") {
+		if splitSize > 0 || sfxStub != "" || report != nil {
+			exitWithError(errors.New("-split, -self-extract and -report need a local output"))
+		}
+		uploadArchive(sources, format, output, opts, printer)
+		return
+	}
+
+	parent, base, err := outputLocation(output, nameFrom, format)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	names := zipper.NameFields{Base: base, Time: time.Now()}
+	archivePath, err = archiveName(parent, format, nameTemplate, names)
+	if err != nil {
+		exitWithError(err)
+	}
+	switch {
+	case isGzipFormat(format) && len(sources) > 1:
+		stats, err = zipper.GzipSourcesWithOptions(sources, archivePath, opts)
+	case isGzipFormat(format):
+		stats, err = zipper.GzipWithOptions(absTarget, archivePath, opts)
+	case len(sources) > 1:
+		stats, err = zipper.ZipSourcesWithOptions(sources, archivePath, opts)
+	default:
+		stats, err = zipper.ZipWithOptions(absTarget, archivePath, opts)
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+	if zipper.TemplateNeedsHash(nameTemplate) {
+		names.Hash = stats.Checksum
+		if archivePath, err = renameWithHash(archivePath, format, nameTemplate, names); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	archiveSize := int64(0)
+	if archiveInfo, err := os.Stat(archivePath); err == nil {
+		archiveSize = archiveInfo.Size()
+	}
+	if jsonOut == nil {
+		printer.Complete(archivePath, stats)
+	}
+	if report != nil {
+		if jsonOut != nil {
+			jsonOut.Report(report, stats, archiveSize)
+		} else {
+			report.print(statusOut, stats, archiveSize, reportTop)
+		}
+	}
+
+	if sfxStub != "" {
+		sfxPath := strings.TrimSuffix(archivePath, ".zip") + ".run"
+		if strings.HasSuffix(strings.ToLower(sfxStub), ".exe") {
+			sfxPath = strings.TrimSuffix(archivePath, ".zip") + ".exe"
+		}
+		if err := zipper.MakeSelfExtracting(archivePath, sfxStub, sfxPath); err != nil {
+			exitWithError(err)
+		}
+		if err := os.Remove(archivePath); err != nil {
+			exitWithError(err)
+		}
+		if jsonOut == nil {
+			fmt.Fprintf(statusOut, "  Self-extracting archive: run %s to extract\n", filepath.Base(sfxPath))
+		}
+		archivePath = sfxPath
+	}
+
+	var parts []string
+	if splitSize > 0 {
+		parts, err = zipper.SplitArchive(archivePath, splitSize)
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+
+	if jsonOut != nil {
+		jsonOut.Result("create", archivePath, parts, stats, stats.TotalBytes, archiveSize)
+		return
+	}
+	if len(parts) > 0 {
+		fmt.Fprintf(statusOut, "  Split into %d parts of up to %s\n", len(parts), formatBytes(splitSize))
+		for _, part := range parts {
+			fmt.Println(part)
+		}
+		return
+	}
+	fmt.Println(archivePath)
+}
+
+// setCreateCallbacks reports the progress, entries and skipped files of an
+// archive being created to printer, or as JSON with -json.
+func setCreateCallbacks(opts *zipper.CreateOptions, printer *createProgressPrinter) {
+	opts.ProgressEvents = printer.OnEvent
+	if verbose {
+		opts.OnEntry = printer.OnEntry
+	}
+	if warnSkipped {
+		opts.OnSkip = printer.OnSkip
+	}
+	if jsonOut != nil {
+		opts.ProgressEvents = jsonOut.OnEvent
+		if verbose {
+			opts.OnEntry = jsonOut.OnEntry
+		}
+		if warnSkipped {
+			opts.OnSkip = jsonOut.OnSkip
+		}
+	}
+}
+
+// doWatch archives a folder, then brings the archive up to date each time
+// the folder changes until interrupted. Zip archives are synced, so only
+// changed files are recompressed; tar.gz archives are written again. A
+// positive interval polls the folder instead of using change notifications.
+func doWatch(args []string, format, output, nameTemplate string, interval time.Duration, opts zipper.CreateOptions) {
+	sources, err := createSources(args)
+	if err != nil {
+		exitWithError(err)
+	}
+	if len(sources) > 1 {
+		exitWithError(errors.New("-watch takes a single folder"))
+	}
+	srcDir := sources[0]
+	if info, err := os.Stat(srcDir); err != nil {
+		exitWithError(err)
+	} else if !info.IsDir() {
+		exitWithError(errors.New("-watch needs a folder to watch"))
+	}
+	if strings.HasPrefix(output, "s3://") {
+		exitWithError(errors.New("-watch needs a local output"))
+	}
+	if interval < 0 {
+		exitWithError(errors.New("-watch-interval must be positive"))
+	}
+	if zipper.TemplateNeedsHash(nameTemplate) {
+		// The archive keeps its name while its contents change
+		exitWithError(errors.New("-watch cannot name the archive after its checksum"))
+	}
+
+	parent, base, err := outputLocation(output, srcDir, format)
+	if err != nil {
+		exitWithError(err)
+	}
+	gz := isGzipFormat(format)
+	archivePath, err := archiveName(parent, format, nameTemplate, zipper.NameFields{Base: base, Time: time.Now()})
+	if err != nil {
+		exitWithError(err)
+	}
+
+	first := true
+	run := func() {
+		printer := newCreateProgressPrinter(srcDir, opts.Workers)
+		runOpts := opts
+		setCreateCallbacks(&runOpts, printer)
+
+		var stats zipper.ArchiveStats
+		var err error
+		switch {
+		case gz:
+			stats, err = zipper.GzipWithOptions(srcDir, archivePath, runOpts)
+		case first:
+			stats, err = zipper.ZipWithOptions(srcDir, archivePath, runOpts)
+		default:
+			stats, err = zipper.SyncWithProgress(archivePath, srcDir, runOpts)
+		}
+		if err != nil {
+			if first {
+				exitWithError(err)
+			}
+			// A failed run is retried on the next change
+			if !errors.Is(err, context.Canceled) {
+				fmt.Fprintf(os.Stderr, "pz: %v\n", err)
+			}
+			return
+		}
+
+		switch {
+		case jsonOut != nil:
+			jsonOut.Result("create", archivePath, nil, stats, stats.TotalBytes, stats.ArchiveSize)
+		case !first && !gz && stats.FileCount == 0:
+			printWarnings(stats.Warnings)
+			if stats.Removed > 0 {
+				fmt.Fprintf(statusOut, "✓ Archive updated: %d deleted entries dropped (%d files unchanged)\n", stats.Removed, stats.Unchanged)
+			} else {
+				fmt.Fprintf(statusOut, "✓ Archive is up to date (%d files unchanged)\n", stats.Unchanged)
+			}
+		default:
+			printer.Complete(archivePath, stats)
+			if stats.Removed > 0 {
+				fmt.Fprintf(statusOut, "  %d deleted entries dropped\n", stats.Removed)
+			}
+		}
+		first = false
+		if jsonOut == nil {
+			fmt.Fprintf(statusOut, "[%s] Watching %s for changes (Ctrl+C to stop)...\n", time.Now().Format("15:04:05"), srcDir)
+		}
+	}
+
+	run()
+	watchOpts := zipper.WatchOptions{Poll: interval > 0, Interval: interval, Ignore: []string{archivePath}}
+	if err := zipper.WatchTree(opts.Context, srcDir, watchOpts, run); err != nil {
+		exitWithError(err)
+	}
+	fmt.Println(archivePath)
+}
+
+// planCreate lists what archiving sources would include, for -dry-run.
+func planCreate(sources []string, opts zipper.CreateOptions) {
+	if warnSkipped {
+		opts.OnSkip = newCreateProgressPrinter("", opts.Workers).OnSkip
+	}
+	plan, err := zipper.PlanCreate(sources, opts)
+	if err != nil {
+		exitWithError(err)
+	}
+	if jsonOut != nil {
+		jsonOut.Plan("create", plan)
+		return
+	}
+	printPlan(plan)
+	fmt.Fprintf(statusOut, "Dry run: would archive %d files (%s) from %s\n", plan.FileCount, formatBytes(plan.TotalBytes), strings.Join(sources, ", "))
+	if len(plan.SkippedFiles) > 0 {
+		fmt.Fprintf(statusOut, "  %d unreadable files or folders would be skipped\n", len(plan.SkippedFiles))
+	}
+}
+
+// planExtract lists what extracting the archive into destDir would do, for
+// -dry-run. Unsafe paths fail the run, as they would fail extraction.
+func planExtract(archivePath, destDir string, opts zipper.ExtractOptions) {
+	var plan zipper.Plan
+	var err error
+	if isGzipArchive(archivePath) {
+		plan, err = zipper.PlanExtractGzip(archivePath, destDir, opts)
+	} else {
+		plan, err = zipper.PlanExtract(archivePath, destDir, opts)
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+	if jsonOut != nil {
+		jsonOut.Plan("extract", plan)
+	} else {
+		printPlan(plan)
+		fmt.Fprintf(statusOut, "Dry run: would extract %d files (%s) to %s, overwriting %d existing files\n",
+			plan.FileCount, formatBytes(plan.TotalBytes), destDir, plan.Overwritten)
+	}
+	if len(plan.Unsafe) > 0 {
+		exitWithError(fmt.Errorf("%d entries have paths outside the destination; extraction would fail", len(plan.Unsafe)))
+	}
+}
+
+// printPlan writes each planned entry on stdout with its action, followed
+// by any warnings.
+func printPlan(plan zipper.Plan) {
+	for _, e := range plan.Entries {
+		if e.IsDir {
+			fmt.Printf("  %-9s %s\n", e.Action, e.Name)
+		} else {
+			fmt.Printf("  %-9s %s (%s)\n", e.Action, e.Name, formatBytes(e.Size))
+		}
+	}
+	printWarnings(plan.Warnings)
+}
+
+// archiveBaseName returns the name an archive of target is given, without
+// extension: a folder's name, or a file's name without its extension, so
+// that report.pdf is archived as report.zip.
+func archiveBaseName(target string) string {
+	base := filepath.Base(target)
+	if info, err := os.Stat(target); err == nil && !info.IsDir() {
+		if name := strings.TrimSuffix(base, filepath.Ext(base)); name != "" {
+			return name
+		}
+	}
+	return base
+}
+
+// createSources returns the absolute paths of the files and folders to
+// archive. The arguments are separate sources when each of them exists, and
+// are otherwise joined with spaces so that a path with spaces can be given
+// unquoted.
+func createSources(args []string) ([]string, error) {
+	paths := args
+	for _, arg := range args {
+		if _, err := os.Stat(arg); err != nil {
+			paths = []string{strings.Join(args, " ")}
+			break
+		}
+	}
+	sources := make([]string, len(paths))
+	for i, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		sources[i] = abs
+	}
+	return sources, nil
+}
+
+// outputLocation returns the folder and base name (without extension) the
+// archive of target is named from. By default that is next to target; -o
+// gives either a folder, when it exists or ends in a separator, or the
+// archive path itself. Either way an existing archive is not replaced: the
+// name gets the next version suffix, as without -o.
+func outputLocation(output, target, format string) (string, string, error) {
+	if output == "" {
+		return filepath.Dir(target), archiveBaseName(target), nil
+	}
+	absOutput, err := filepath.Abs(output)
+	if err != nil {
+		return "", "", err
+	}
+
+	isDir := strings.HasSuffix(output, "/") || strings.HasSuffix(output, string(filepath.Separator))
+	if info, err := os.Stat(absOutput); err == nil && info.IsDir() {
+		isDir = true
+	}
+	if isDir {
+		return absOutput, archiveBaseName(target), os.MkdirAll(absOutput, 0755)
+	}
+
+	name, err := trimArchiveExt(filepath.Base(absOutput), format)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Dir(absOutput), name, os.MkdirAll(filepath.Dir(absOutput), 0755)
+}
+
+// isGzipFormat reports whether the -f format names tar.gz.
+func isGzipFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case "gz", "gzip", "tar.gz", "tgz":
+		return true
+	}
+	return false
+}
+
+// archiveName returns the path of a new archive in parent, named after
+// names.Base with a version suffix when taken, or from template when one is
+// given. Templates that use the archive's checksum are applied by
+// renameWithHash once it is written.
+func archiveName(parent, format, template string, names zipper.NameFields) (string, error) {
+	if !isGzipFormat(format) && !strings.EqualFold(format, "zip") {
+		return "", fmt.Errorf("unsupported format: %s (use 'zip' or 'gz')", format)
+	}
+	if template != "" && !zipper.TemplateNeedsHash(template) {
+		ext, err := templateExt(template, format)
+		if err != nil {
+			return "", err
+		}
+		return zipper.NextTemplateName(parent, template, ext, names)
+	}
+	if isGzipFormat(format) {
+		return zipper.NextGzipArchiveName(parent, names.Base)
+	}
+	return zipper.NextArchiveName(parent, names.Base)
+}
+
+// renameWithHash moves the archive at archivePath to the name template gives
+// it with names.Hash.
+func renameWithHash(archivePath, format, template string, names zipper.NameFields) (string, error) {
+	ext, err := templateExt(template, format)
+	if err != nil {
+		return "", err
+	}
+	newPath, err := zipper.NextTemplateName(filepath.Dir(archivePath), template, ext, names)
+	if err != nil {
+		return "", err
+	}
+	return newPath, zipper.RenameArchive(archivePath, newPath)
+}
+
+// templateExt returns the extension archives named from template get: the
+// format's, or .tgz for a tar.gz when template ends with it, as written in
+// template.
+func templateExt(template, format string) (string, error) {
+	if _, err := trimArchiveExt(template, format); err != nil {
+		return "", err
+	}
+	lower := strings.ToLower(template)
+	ext := ".zip"
+	if isGzipFormat(format) {
+		ext = ".tar.gz"
+		if strings.HasSuffix(lower, ".tgz") {
+			ext = ".tgz"
+		}
+	}
+	if strings.HasSuffix(lower, ext) {
+		ext = template[len(template)-len(ext):]
+	}
+	return ext, nil
+}
+
+// trimArchiveExt returns name without its archive extension, if it has one,
+// failing when the extension does not match format.
+func trimArchiveExt(name, format string) (string, error) {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			if (ext == ".zip") != strings.EqualFold(format, "zip") {
+				return "", fmt.Errorf("output %s does not match the %s format", name, format)
+			}
+			return name[:len(name)-len(ext)], nil
+		}
+	}
+	return name, nil
+}
+
+// uploadArchive creates the archive of sources and streams it to the S3
+// object named by output, adding the format's extension when output has
+// none.
+func uploadArchive(sources []string, format, output string, opts zipper.CreateOptions, printer *createProgressPrinter) {
+	name, err := trimArchiveExt(output, format)
+	if err != nil {
+		exitWithError(err)
+	}
+	gz := false
+	switch strings.ToLower(format) {
+	case "gz", "gzip", "tar.gz", "tgz":
+		gz = true
+	case "zip":
+	default:
+		exitWithError(fmt.Errorf("unsupported format: %s (use 'zip' or 'gz')", format))
+	}
+	if name == output {
+		if gz {
+			output += ".tar.gz"
+		} else {
+			output += ".zip"
+		}
+	}
+
+	dest, err := zipper.NewS3Destination(opts.Context, output)
+	if err != nil {
+		exitWithError(err)
+	}
+	dest.RateLimit = opts.RateLimit
+	var stats zipper.ArchiveStats
+	if gz {
+		stats, err = zipper.GzipToDestination(sources, dest, opts)
+	} else {
+		stats, err = zipper.ZipToDestination(sources, dest, opts)
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if jsonOut != nil {
+		jsonOut.Result("create", output, nil, stats, stats.TotalBytes, stats.ArchiveSize)
+		return
+	}
+	printer.Complete(output, stats)
+	fmt.Println(output)
+}
+
+// doAppend adds a folder to an existing zip, or with update set refreshes
+// the files it was created from.
+func doAppend(args []string, update bool, opts zipper.CreateOptions) {
+	mode := "append"
+	if update {
+		mode = "update"
+	}
+	if len(args) < 2 {
+		exitWithError(fmt.Errorf("%s mode requires an archive file and a folder", mode))
+	}
+	if isGzipArchive(args[0]) {
+		exitWithError(fmt.Errorf("%s mode supports zip archives only", mode))
+	}
+
+	absArchivePath, err := filepath.Abs(args[0])
+	if err != nil {
+		exitWithError(err)
+	}
+	absTarget, err := filepath.Abs(strings.Join(args[1:], " "))
+	if err != nil {
+		exitWithError(err)
+	}
+
+	info, err := os.Stat(absTarget)
+	if err != nil {
+		exitWithError(err)
+	}
+	if !info.IsDir() {
+		exitWithError(errors.New("target must be a directory"))
+	}
+
+	printer := newCreateProgressPrinter(absTarget, opts.Workers)
+	setCreateCallbacks(&opts, printer)
+
+	var stats zipper.ArchiveStats
+	if update {
+		stats, err = zipper.UpdateWithProgress(absArchivePath, absTarget, opts)
+	} else {
+		stats, err = zipper.AppendWithProgress(absArchivePath, absTarget, opts)
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if jsonOut != nil {
+		archiveSize := int64(0)
+		if info, err := os.Stat(absArchivePath); err == nil {
+			archiveSize = info.Size()
+		}
+		jsonOut.Result(mode, absArchivePath, nil, stats, stats.TotalBytes, archiveSize)
+		return
+	}
+	if update && stats.FileCount == 0 {
+		printWarnings(stats.Warnings)
+		fmt.Fprintf(statusOut, "✓ Archive is up to date (%d files unchanged)\n", stats.Unchanged)
+	} else {
+		printer.Complete(absArchivePath, stats)
+		if update {
+			fmt.Fprintf(statusOut, "  %d files unchanged\n", stats.Unchanged)
+		}
+	}
+	fmt.Println(absArchivePath)
+}
+
+func doBackup(args []string, snapshotPath, nameTemplate string, opts zipper.CreateOptions) {
+	absTarget, err := filepath.Abs(strings.Join(args, " "))
+	if err != nil {
+		exitWithError(err)
+	}
+	info, err := os.Stat(absTarget)
+	if err != nil {
+		exitWithError(err)
+	}
+	if !info.IsDir() {
+		exitWithError(errors.New("target must be a directory"))
+	}
+	absSnapshot, err := filepath.Abs(snapshotPath)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	// Each backup in the chain gets the next versioned name
+	names := zipper.NameFields{Base: filepath.Base(absTarget), Time: time.Now()}
+	archivePath, err := archiveName(filepath.Dir(absTarget), "zip", nameTemplate, names)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	printer := newCreateProgressPrinter(absTarget, opts.Workers)
+	setCreateCallbacks(&opts, printer)
+
+	stats, err := zipper.BackupWithProgress(absTarget, archivePath, absSnapshot, opts)
+	if err != nil {
+		exitWithError(err)
+	}
+	if zipper.TemplateNeedsHash(nameTemplate) {
+		names.Hash = stats.Checksum
+		if archivePath, err = renameWithHash(archivePath, "zip", nameTemplate, names); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	if jsonOut != nil {
+		archiveSize := int64(0)
+		if info, err := os.Stat(archivePath); err == nil {
+			archiveSize = info.Size()
+		}
+		jsonOut.Result("backup", archivePath, nil, stats, stats.TotalBytes, archiveSize)
+		return
+	}
+	printer.Complete(archivePath, stats.ArchiveStats)
+	if stats.Incremental {
+		fmt.Fprintf(statusOut, "  Incremental backup: %d changed, %d unchanged, %d deleted\n", stats.FileCount, stats.Unchanged, len(stats.Deleted))
+	} else {
+		fmt.Fprintf(statusOut, "  Full backup; snapshot written to %s\n", absSnapshot)
+	}
+	fmt.Println(archivePath)
+}
+
+func doRestore(args []string, destDir string, opts zipper.ExtractOptions) {
+	if len(args) < 1 {
+		exitWithError(errors.New("restore mode requires a full backup archive"))
+	}
+	absDestDir, err := filepath.Abs(destDir)
+	if err != nil {
+		exitWithError(err)
+	}
+	archives := make([]string, len(args))
+	for i, arg := range args {
+		if archives[i], err = filepath.Abs(arg); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	if jsonOut != nil {
+		opts.ProgressEvents = jsonOut.OnEvent
+		if verbose {
+			opts.OnEntry = jsonOut.OnEntry
+		}
+	}
+
+	start := time.Now()
+	stats, err := zipper.RestoreBackup(archives, absDestDir, opts)
+	if err != nil {
+		exitWithError(err)
+	}
+	if jsonOut != nil {
+		jsonOut.Result("restore", absDestDir, nil, stats, stats.TotalBytes, 0)
+		return
+	}
+	printWarnings(stats.Warnings)
+	fmt.Fprintf(statusOut, "✓ Restore complete: %d archives -> %s (%s extracted, %d files, %s)\n",
+		len(archives),
+		absDestDir,
+		formatBytes(stats.TotalBytes),
+		stats.FileCount,
+		formatDuration(time.Since(start)),
+	)
+	fmt.Println(absDestDir)
+}
+
+func doRemove(args []string) {
+	if len(args) < 2 {
+		exitWithError(errors.New("remove mode requires an archive file and at least one pattern"))
+	}
+	if isGzipArchive(args[0]) {
+		exitWithError(errors.New("remove mode supports zip archives only"))
+	}
+
+	absArchivePath, err := filepath.Abs(args[0])
+	if err != nil {
+		exitWithError(err)
+	}
+
+	removed, err := zipper.RemoveEntries(absArchivePath, args[1:])
+	if err != nil {
+		exitWithError(err)
+	}
+	for _, name := range removed {
+		fmt.Fprintf(os.Stdout, "  removed  %s\n", name)
+	}
+	fmt.Fprintf(os.Stdout, "✓ Removed %d entries from %s\n", len(removed), filepath.Base(absArchivePath))
+}
+
+func doExtract(args []string, opts zipper.ExtractOptions) {
+	if len(args) < 1 {
+		exitWithError(errors.New("extract mode requires an archive file"))
+	}
+
+	archivePath := strings.Join(args, " ")
+	if len(args) > 1 {
+		// If multiple args, first is archive, rest is destination
+		archivePath = args[0]
+	}
+
+	// Archives given by URL are downloaded as they are extracted
+	remote := strings.HasPrefix(archivePath, "http://") || strings.HasPrefix(archivePath, "https://")
+	absArchivePath := archivePath
+	var archiveSize int64
+	var err error
+	if !remote {
+		absArchivePath, err = filepath.Abs(archivePath)
+		if err != nil {
+			exitWithError(err)
+		}
+
+		info, err := os.Stat(absArchivePath)
+		if err != nil {
+			exitWithError(err)
+		}
+		if info.IsDir() {
+			exitWithError(errors.New("source must be an archive file, not a directory"))
+		}
+		archiveSize = info.Size()
+	}
+
+	// Determine destination
+	var destDir string
+	if len(args) > 1 {
+		destDir = strings.Join(args[1:], " ")
+	} else {
+		// Extract to current directory
+		destDir, err = os.Getwd()
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+
+	absDestDir, err := filepath.Abs(destDir)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if dryRun {
+		if remote {
+			exitWithError(errors.New("-dry-run needs a local archive"))
+		}
+		planExtract(absArchivePath, absDestDir, opts)
+		return
+	}
+
+	printer := newExtractProgressPrinter(absArchivePath, absDestDir, opts.Workers)
+	opts.ProgressEvents = printer.OnEvent
+	if verbose {
+		opts.OnEntry = printer.OnEntry
+	}
+	if jsonOut != nil {
+		opts.ProgressEvents = jsonOut.OnEvent
+		if verbose {
+			opts.OnEntry = jsonOut.OnEntry
+		}
+	}
+	if opts.Overwrite == zipper.OverwritePrompt {
+		opts.ConfirmOverwrite = newOverwritePrompter(printer).Confirm
+	}
+
+	// Auto-detect format based on file extension
+	var stats zipper.ExtractStats
+	if remote {
+		stats, err = zipper.ExtractFromURL(opts.Context, archivePath, absDestDir, opts)
+	} else if isGzipArchive(absArchivePath) {
+		stats, err = zipper.ExtractGzipWithOptions(absArchivePath, absDestDir, opts)
+	} else {
+		// Default to zip
+		stats, err = zipper.ExtractWithOptions(absArchivePath, absDestDir, opts)
+	}
+
+	if err != nil {
+		exitWithError(err)
+	}
+	if jsonOut != nil {
+		jsonOut.Result("extract", absDestDir, nil, stats, stats.TotalBytes, archiveSize)
+		return
+	}
+	printer.Complete(stats)
+
+	fmt.Println(absDestDir)
+}
 
-	// Handle context menu operations
-	if *contextFlag != "" {
-		handleContextMenu(*contextFlag)
-		return
+// findSFXStub returns the extraction stub to use for -self-extract: path if
+// given, otherwise pz-sfx next to this executable.
+func findSFXStub(path string) (string, error) {
+	if path == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return "", err
+		}
+		name := "pz-sfx"
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+		path = filepath.Join(filepath.Dir(exe), name)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("extraction stub not found (build it with go build -o pz-sfx ./cmd/pzip-sfx): %w", err)
 	}
+	return filepath.Abs(path)
+}
 
-	if flag.NArg() < 1 {
-		flag.Usage()
-		os.Exit(2)
+// isGzipArchive reports whether path names a tar.gz archive rather than a
+// zip, including the first part of a split archive.
+func isGzipArchive(path string) bool {
+	lower := strings.TrimSuffix(strings.ToLower(path), zipper.FirstPartSuffix)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".gz")
+}
+
+func doTest(args []string) {
+	if len(args) != 1 {
+		exitWithError(errors.New("test mode requires a single archive file"))
+	}
+
+	absArchivePath, err := filepath.Abs(args[0])
+	if err != nil {
+		exitWithError(err)
 	}
 
-	if *extractFlag {
-		doExtract(flag.Args())
+	start := time.Now()
+	var stats zipper.CheckStats
+	if isGzipArchive(absArchivePath) {
+		stats, err = zipper.CheckGzip(absArchivePath, nil)
 	} else {
-		doCreate(flag.Args(), *formatFlag)
+		stats, err = zipper.CheckZip(absArchivePath, nil)
 	}
+	printCheckResults(absArchivePath, stats, err, start)
 }
 
-func doCreate(args []string, format string) {
-	target := strings.Join(args, " ")
-	absTarget, err := filepath.Abs(target)
-	if err != nil {
-		exitWithError(err)
+func doVerify(args []string) {
+	if len(args) < 1 {
+		exitWithError(errors.New("verify mode requires an archive file"))
 	}
 
-	info, err := os.Stat(absTarget)
+	absArchivePath, err := filepath.Abs(args[0])
 	if err != nil {
 		exitWithError(err)
 	}
-	if !info.IsDir() {
-		exitWithError(errors.New("target must be a directory"))
+
+	// Optional folder holding extracted files; the archive itself otherwise
+	dir := ""
+	if len(args) > 1 {
+		if dir, err = filepath.Abs(strings.Join(args[1:], " ")); err != nil {
+			exitWithError(err)
+		}
 	}
 
-	parent := filepath.Dir(absTarget)
-	base := filepath.Base(absTarget)
+	start := time.Now()
+	stats, err := zipper.VerifyManifest(absArchivePath, dir)
+	printCheckResults(absArchivePath, stats, err, start)
+}
 
-	var archivePath string
-	var stats zipper.ArchiveStats
+func doDiff(args []string, opts zipper.DiffOptions) {
+	if len(args) < 2 {
+		exitWithError(errors.New("diff mode requires an archive and a folder or second archive"))
+	}
 
-	printer := newCreateProgressPrinter(absTarget)
+	absArchivePath, err := filepath.Abs(args[0])
+	if err != nil {
+		exitWithError(err)
+	}
+	other, err := filepath.Abs(strings.Join(args[1:], " "))
+	if err != nil {
+		exitWithError(err)
+	}
 
-	switch strings.ToLower(format) {
-	case "gz", "gzip", "tar.gz":
-		archivePath, err = zipper.NextGzipArchiveName(parent, base)
-		if err != nil {
-			exitWithError(err)
-		}
-		stats, err = zipper.GzipWithProgressAndFile(absTarget, archivePath, printer.OnProgressWithFile)
-		if err != nil {
-			exitWithError(err)
+	start := time.Now()
+	report, err := zipper.DiffWithOptions(absArchivePath, other, opts)
+	if err != nil {
+		exitWithError(err)
+	}
+	if jsonOut != nil {
+		jsonOut.Result("diff", other, nil, report, 0, 0)
+	} else {
+		for _, name := range report.Added {
+			fmt.Fprintf(os.Stdout, "  +  %s\n", name)
 		}
-	case "zip":
-		archivePath, err = zipper.NextArchiveName(parent, base)
-		if err != nil {
-			exitWithError(err)
+		for _, name := range report.Removed {
+			fmt.Fprintf(os.Stdout, "  -  %s\n", name)
 		}
-		stats, err = zipper.ZipWithProgressAndFile(absTarget, archivePath, printer.OnProgressWithFile)
-		if err != nil {
-			exitWithError(err)
+		for _, name := range report.Modified {
+			fmt.Fprintf(os.Stdout, "  M  %s\n", name)
 		}
-	default:
-		exitWithError(fmt.Errorf("unsupported format: %s (use 'zip' or 'gz')", format))
 	}
 
-	printer.Complete(archivePath, stats)
-	fmt.Println(archivePath)
+	if !report.Changed() {
+		fmt.Fprintf(statusOut, "✓ %s matches %s (%d files, %s)\n",
+			filepath.Base(absArchivePath), filepath.Base(other), report.Unchanged, formatDuration(time.Since(start)))
+		return
+	}
+	fmt.Fprintf(statusOut, "✗ %s differs from %s: %d added, %d removed, %d modified, %d unchanged\n",
+		filepath.Base(absArchivePath), filepath.Base(other),
+		len(report.Added), len(report.Removed), len(report.Modified), report.Unchanged)
+	os.Exit(1)
 }
 
-func doExtract(args []string) {
-	if len(args) < 1 {
-		exitWithError(errors.New("extract mode requires an archive file"))
+// doMerge combines the archives given after the output path into a new
+// archive there, versioning its name rather than replacing an archive.
+func doMerge(args []string, opts zipper.MergeOptions) {
+	if len(args) < 3 {
+		exitWithError(errors.New("merge mode requires an output archive and at least two archives to merge"))
 	}
-
-	archivePath := strings.Join(args, " ")
-	if len(args) > 1 {
-		// If multiple args, first is archive, rest is destination
-		archivePath = args[0]
+	output, err := filepath.Abs(args[0])
+	if err != nil {
+		exitWithError(err)
+	}
+	inputs := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		if inputs[i], err = filepath.Abs(arg); err != nil {
+			exitWithError(err)
+		}
 	}
 
-	absArchivePath, err := filepath.Abs(archivePath)
+	archivePath, err := nextOutputName(output, opts.Comment)
 	if err != nil {
 		exitWithError(err)
 	}
 
-	info, err := os.Stat(absArchivePath)
+	names := make([]string, len(inputs))
+	for i, input := range inputs {
+		names[i] = filepath.Base(input)
+	}
+	printer := newCreateProgressPrinter(strings.Join(names, ", "), opts.Workers)
+	setCreateCallbacks(&opts.CreateOptions, printer)
+	stats, err := zipper.MergeArchives(inputs, archivePath, opts)
 	if err != nil {
+		os.Remove(archivePath)
 		exitWithError(err)
 	}
-	if info.IsDir() {
-		exitWithError(errors.New("source must be an archive file, not a directory"))
+
+	if jsonOut != nil {
+		jsonOut.Result("merge", archivePath, nil, stats, stats.TotalBytes, stats.ArchiveSize)
+		return
 	}
+	printer.Complete(archivePath, stats)
+	fmt.Println(archivePath)
+}
 
-	// Determine destination
-	var destDir string
-	if len(args) > 1 {
-		destDir = strings.Join(args[1:], " ")
-	} else {
-		// Extract to current directory
-		destDir, err = os.Getwd()
+// doCat writes the files named in an archive to standard output in turn.
+func doCat(args []string) {
+	if len(args) < 2 {
+		exitWithError(errors.New("cat mode requires an archive and the paths of files in it"))
+	}
+	absArchivePath, err := filepath.Abs(args[0])
+	if err != nil {
+		exitWithError(err)
+	}
+	out := bufio.NewWriterSize(os.Stdout, 256<<10)
+	for _, name := range args[1:] {
+		rc, err := zipper.ReadEntry(absArchivePath, name)
 		if err != nil {
+			out.Flush()
 			exitWithError(err)
 		}
+		_, err = io.Copy(out, rc)
+		rc.Close()
+		if err != nil {
+			out.Flush()
+			exitWithError(fmt.Errorf("%s: %w", name, err))
+		}
 	}
+	if err := out.Flush(); err != nil {
+		exitWithError(err)
+	}
+}
 
-	absDestDir, err := filepath.Abs(destDir)
+func doMount(args []string) {
+	if len(args) != 2 {
+		exitWithError(errors.New("mount mode requires an archive and a mount point"))
+	}
+	absArchivePath, err := filepath.Abs(args[0])
 	if err != nil {
 		exitWithError(err)
 	}
+	mountPoint, err := filepath.Abs(args[1])
+	if err != nil {
+		exitWithError(err)
+	}
+	if info, err := os.Stat(mountPoint); err != nil {
+		exitWithError(err)
+	} else if !info.IsDir() {
+		exitWithError(errors.New("mount point must be a directory"))
+	}
+	if err := mountArchive(absArchivePath, mountPoint); err != nil {
+		exitWithError(err)
+	}
+}
 
-	printer := newExtractProgressPrinter(absArchivePath, absDestDir)
-
-	// Auto-detect format based on file extension
-	var stats zipper.ExtractStats
-	if strings.HasSuffix(strings.ToLower(absArchivePath), ".tar.gz") || strings.HasSuffix(strings.ToLower(absArchivePath), ".tgz") {
-		stats, err = zipper.ExtractGzipWithProgress(absArchivePath, absDestDir, printer.OnProgress)
-	} else if strings.HasSuffix(strings.ToLower(absArchivePath), ".gz") {
-		// Check if it's a tar.gz by trying to open as such
-		stats, err = zipper.ExtractGzipWithProgress(absArchivePath, absDestDir, printer.OnProgress)
-	} else {
-		// Default to zip
-		stats, err = zipper.ExtractWithProgress(absArchivePath, absDestDir, printer.OnProgress)
+// doConvert writes the entries of an archive to a new archive in the format
+// of the output path, versioning its name rather than replacing an archive.
+func doConvert(args []string, opts zipper.CreateOptions) {
+	if len(args) != 2 {
+		exitWithError(errors.New("convert mode requires an archive and the archive to convert it to"))
+	}
+	absArchivePath, err := filepath.Abs(args[0])
+	if err != nil {
+		exitWithError(err)
+	}
+	output, err := filepath.Abs(args[1])
+	if err != nil {
+		exitWithError(err)
+	}
+	archivePath, err := nextOutputName(output, opts.Comment)
+	if err != nil {
+		exitWithError(err)
 	}
 
+	printer := newCreateProgressPrinter(filepath.Base(absArchivePath), opts.Workers)
+	setCreateCallbacks(&opts, printer)
+	stats, err := zipper.ConvertArchive(absArchivePath, archivePath, opts)
 	if err != nil {
+		os.Remove(archivePath)
 		exitWithError(err)
 	}
-	printer.Complete(stats)
 
-	fmt.Println(absDestDir)
+	if jsonOut != nil {
+		jsonOut.Result("convert", archivePath, nil, stats, stats.TotalBytes, stats.ArchiveSize)
+		return
+	}
+	printer.Complete(archivePath, stats)
+	fmt.Println(archivePath)
+}
+
+// nextOutputName returns the path of an archive written to output by the
+// merge and convert modes, whose extension picks the format: output
+// itself, or the next versioned name if it exists.
+func nextOutputName(output, comment string) (string, error) {
+	name := filepath.Base(output)
+	if isGzipArchive(output) {
+		if comment != "" {
+			return "", errors.New("-comment needs the zip format")
+		}
+		base, err := trimArchiveExt(name, "gz")
+		if err != nil {
+			return "", err
+		}
+		if base == name {
+			base = strings.TrimSuffix(name, filepath.Ext(name)) // .gz
+		}
+		return zipper.NextGzipArchiveName(filepath.Dir(output), base)
+	}
+	if !strings.EqualFold(filepath.Ext(name), ".zip") {
+		return "", fmt.Errorf("unsupported output format: %s (use .zip, .tar.gz or .tgz)", name)
+	}
+	base, err := trimArchiveExt(name, "zip")
+	if err != nil {
+		return "", err
+	}
+	return zipper.NextArchiveName(filepath.Dir(output), base)
+}
+
+// printCheckResults reports each checked file and the overall result of a
+// test or verify run, exiting non-zero on failure.
+func printCheckResults(archivePath string, stats zipper.CheckStats, err error, start time.Time) {
+	for _, e := range stats.Entries {
+		if e.Err != nil {
+			fmt.Fprintf(os.Stdout, "  FAIL  %s: %v\n", e.Name, e.Err)
+		} else {
+			fmt.Fprintf(os.Stdout, "  OK    %s\n", e.Name)
+		}
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Fprintf(os.Stdout, "✓ No errors detected in %s (%s, %d files, %s)\n",
+		filepath.Base(archivePath),
+		formatBytes(stats.TotalBytes),
+		stats.FileCount,
+		formatDuration(time.Since(start)),
+	)
+	if stats.Comment != "" {
+		fmt.Fprintf(os.Stdout, "  Comment: %s\n", strings.ReplaceAll(stats.Comment, "\n", "\n           "))
+	}
 }
 
 func exitWithError(err error) {
+	if runLog != nil {
+		runLog.Error("pz failed", "error", err)
+	}
+	if jsonOut != nil {
+		jsonOut.Error(err)
+	}
 	fmt.Fprintln(os.Stderr, "pz:", err)
 	os.Exit(1)
 }
@@ -178,12 +1574,18 @@ type createProgressPrinter struct {
 	started     bool
 	startTime   time.Time
 	total       int64
-	lastLen     int
 	currentFile string
+	barDisplay
+	logger     progressLogger
+	workers    int
+	filesDone  int
+	filesTotal int
+	event      zipper.ProgressEvent // latest detailed progress, if any
+	rate       rateMeter
 }
 
-func newCreateProgressPrinter(source string) *createProgressPrinter {
-	return &createProgressPrinter{source: source}
+func newCreateProgressPrinter(source string, workers int) *createProgressPrinter {
+	return &createProgressPrinter{source: source, workers: zipper.WorkerCount(workers)}
 }
 
 func (p *createProgressPrinter) OnProgress(done, total int64) {
@@ -192,15 +1594,21 @@ func (p *createProgressPrinter) OnProgress(done, total int64) {
 		p.startTime = time.Now()
 		p.total = total
 		numCPU := runtime.NumCPU()
-		workers := numCPU / 5
-		if workers < 1 {
-			workers = 1
-		}
-		fmt.Fprintf(os.Stdout, "[%s] Creating archive for %s (%s) using %d/%d CPUs...\n", p.startTime.Format("15:04:05"), p.source, formatBytes(total), workers, numCPU)
+		fmt.Fprintf(statusOut, "[%s] Creating archive for %s (%s) using %d/%d CPUs...\n", p.startTime.Format("15:04:05"), p.source, formatBytes(total), p.workers, numCPU)
 	}
 
-	line := p.renderLine(done, total)
-	p.printLine(line)
+	p.rate.update(done)
+	switch progress {
+	case progressRich:
+		render := func(barWidth int) string { return p.renderLine(done, total, barWidth) }
+		p.draw(fitLine(render, ""), panelLines(&p.rate, done, total, p.event, p.workers)...)
+	case progressBar:
+		p.printLine(func(barWidth int) string {
+			return p.renderLine(done, total, barWidth) + " ETA " + p.rate.left(done, total)
+		})
+	case progressLines:
+		p.logger.log(p.startTime, done, total)
+	}
 }
 
 func (p *createProgressPrinter) OnProgressWithFile(done, total int64, currentFile string) {
@@ -208,9 +1616,15 @@ func (p *createProgressPrinter) OnProgressWithFile(done, total int64, currentFil
 	p.OnProgress(done, total)
 }
 
-func (p *createProgressPrinter) renderLine(done, total int64) string {
-	const barWidth = 50
+// OnEvent takes detailed progress, adding the file count to the bar and the
+// files in flight to the rich display.
+func (p *createProgressPrinter) OnEvent(e zipper.ProgressEvent) {
+	p.event = e
+	p.filesDone, p.filesTotal = e.FilesDone, e.FilesTotal
+	p.OnProgressWithFile(e.BytesDone, e.BytesTotal, e.File)
+}
 
+func (p *createProgressPrinter) renderLine(done, total int64, barWidth int) string {
 	filled := 0
 	percent := 100.0
 	if total > 0 {
@@ -242,48 +1656,43 @@ func (p *createProgressPrinter) renderLine(done, total int64) string {
 		speed = fmt.Sprintf("%s/s", formatBytes(speedValue))
 	}
 
-	return fmt.Sprintf("[%s] %3.0f%% (%s/%s) %s", bar, percent, formatBytes(done), formatBytes(total), speed)
-}
-
-func (p *createProgressPrinter) printLine(line string) {
-	// Move cursor up if we printed file line before
-	if p.currentFile != "" && p.lastLen > 0 {
-		fmt.Print("\033[2K\r\033[1A\033[2K\r") // Clear current line, move up, clear that line
-	} else if p.lastLen > 0 {
-		fmt.Print("\r") // Just return to start of line
+	line := fmt.Sprintf("[%s] %3.0f%% (%s/%s) %s", bar, percent, formatBytes(done), formatBytes(total), speed)
+	if p.filesTotal > 0 {
+		line += fmt.Sprintf(" %d/%d files", p.filesDone, p.filesTotal)
 	}
+	return line
+}
 
-	// Print progress bar
-	fmt.Print(line)
+func (p *createProgressPrinter) printLine(render func(barWidth int) string) {
+	p.draw(fitLine(render, p.currentFile))
+}
 
-	// Print current file on same line if available
-	if p.currentFile != "" {
-		const maxFileLen = 50
-		displayFile := p.currentFile
-		if len(displayFile) > maxFileLen {
-			displayFile = "..." + displayFile[len(displayFile)-maxFileLen+3:]
-		}
-		fileLine := fmt.Sprintf("\n%s", displayFile)
-		fmt.Print(fileLine)
-	}
+// OnEntry lists an entry added to the archive in place of the bar, which is
+// redrawn on the next update.
+func (p *createProgressPrinter) OnEntry(e zipper.EntryEvent) {
+	p.clear()
+	fmt.Fprintf(statusOut, "  adding: %s%s\n", e.Name, compressionNote(e))
+}
 
-	p.lastLen = len(line)
+// OnSkip warns about a file that could not be read in place of the bar.
+func (p *createProgressPrinter) OnSkip(name string, err error) {
+	p.clear()
+	fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", name, err)
 }
 
 func (p *createProgressPrinter) Complete(zipPath string, stats zipper.ArchiveStats) {
 	if !p.started {
-		fmt.Println("No files to archive; created empty zip.")
+		fmt.Fprintln(statusOut, "No files to archive; created empty zip.")
 		return
 	}
-	fmt.Print("\n")
-	p.lastLen = 0
-	zipInfo, err := os.Stat(zipPath)
-	zipSize := int64(0)
-	if err == nil {
+	p.finish()
+	printWarnings(stats.Warnings)
+	zipSize := stats.ArchiveSize
+	if zipInfo, err := os.Stat(zipPath); err == nil {
 		zipSize = zipInfo.Size()
 	}
 	elapsed := time.Since(p.startTime)
-	fmt.Fprintf(os.Stdout, "✓ Archive complete: %s -> %s (%s source, %s archive, %d files, %s)\n",
+	fmt.Fprintf(statusOut, "✓ Archive complete: %s -> %s (%s source, %s archive, %d files, %s)\n",
 		p.source,
 		zipPath,
 		formatBytes(stats.TotalBytes),
@@ -291,8 +1700,14 @@ func (p *createProgressPrinter) Complete(zipPath string, stats zipper.ArchiveSta
 		stats.FileCount,
 		formatDuration(elapsed),
 	)
+	if len(stats.SkippedFiles) > 0 {
+		fmt.Fprintf(statusOut, "  %d unreadable files or folders skipped\n", len(stats.SkippedFiles))
+	}
+	if stats.Deduplicated > 0 {
+		fmt.Fprintf(statusOut, "  Deduplicated: %d identical files (%s)\n", stats.Deduplicated, formatBytes(stats.DeduplicatedBytes))
+	}
 	if stats.Checksum != "" {
-		fmt.Fprintf(os.Stdout, "  SHA-256: %s\n", stats.Checksum)
+		fmt.Fprintf(statusOut, "  SHA-256: %s\n", stats.Checksum)
 	}
 }
 
@@ -303,13 +1718,21 @@ type extractProgressPrinter struct {
 	started   bool
 	startTime time.Time
 	total     int64
-	lastLen   int
+	barDisplay
+	logger      progressLogger
+	workers     int
+	currentFile string
+	filesDone   int
+	filesTotal  int
+	event       zipper.ProgressEvent // latest detailed progress, if any
+	rate        rateMeter
 }
 
-func newExtractProgressPrinter(zipPath, destDir string) *extractProgressPrinter {
+func newExtractProgressPrinter(zipPath, destDir string, workers int) *extractProgressPrinter {
 	return &extractProgressPrinter{
 		zipPath: zipPath,
 		destDir: destDir,
+		workers: zipper.WorkerCount(workers),
 	}
 }
 
@@ -319,20 +1742,24 @@ func (p *extractProgressPrinter) OnProgress(done, total int64) {
 		p.startTime = time.Now()
 		p.total = total
 		numCPU := runtime.NumCPU()
-		workers := numCPU / 5
-		if workers < 1 {
-			workers = 1
-		}
-		fmt.Fprintf(os.Stdout, "[%s] Extracting %s (%s) using %d/%d CPUs...\n", p.startTime.Format("15:04:05"), filepath.Base(p.zipPath), formatBytes(total), workers, numCPU)
+		fmt.Fprintf(statusOut, "[%s] Extracting %s (%s) using %d/%d CPUs...\n", p.startTime.Format("15:04:05"), filepath.Base(p.zipPath), formatBytes(total), p.workers, numCPU)
 	}
 
-	line := p.renderLine(done, total)
-	p.printLine(line)
+	p.rate.update(done)
+	switch progress {
+	case progressRich:
+		render := func(barWidth int) string { return p.renderLine(done, total, barWidth) }
+		p.draw(fitLine(render, ""), panelLines(&p.rate, done, total, p.event, p.workers)...)
+	case progressBar:
+		p.printLine(func(barWidth int) string {
+			return p.renderLine(done, total, barWidth) + " ETA " + p.rate.left(done, total)
+		})
+	case progressLines:
+		p.logger.log(p.startTime, done, total)
+	}
 }
 
-func (p *extractProgressPrinter) renderLine(done, total int64) string {
-	const barWidth = 50
-
+func (p *extractProgressPrinter) renderLine(done, total int64, barWidth int) string {
 	filled := 0
 	percent := 100.0
 	if total > 0 {
@@ -363,38 +1790,122 @@ func (p *extractProgressPrinter) renderLine(done, total int64) string {
 		speed = fmt.Sprintf("%s/s", formatBytes(speedValue))
 	}
 
-	return fmt.Sprintf("[%s] %3.0f%% (%s/%s) %s", bar, percent, formatBytes(done), formatBytes(total), speed)
+	line := fmt.Sprintf("[%s] %3.0f%% (%s/%s) %s", bar, percent, formatBytes(done), formatBytes(total), speed)
+	switch {
+	case p.filesTotal > 0:
+		line += fmt.Sprintf(" %d/%d files", p.filesDone, p.filesTotal)
+	case p.filesDone > 0:
+		line += fmt.Sprintf(" %d files", p.filesDone)
+	}
+	return line
+}
+
+func (p *extractProgressPrinter) printLine(render func(barWidth int) string) {
+	p.draw(fitLine(render, p.currentFile))
+}
+
+// OnEntry lists an extracted entry in place of the bar, using the same
+// labels as unzip.
+func (p *extractProgressPrinter) OnEntry(e zipper.EntryEvent) {
+	p.clear()
+	switch {
+	case e.IsDir:
+		fmt.Fprintf(statusOut, "   creating: %s\n", e.Name)
+	case e.Method == zip.Store && e.CompressedSize >= 0:
+		fmt.Fprintf(statusOut, " extracting: %s\n", e.Name)
+	default:
+		fmt.Fprintf(statusOut, "  inflating: %s\n", e.Name)
+	}
+}
+
+// OnEvent takes detailed progress, adding the file count and current file
+// to the bar and the files in flight to the rich display.
+func (p *extractProgressPrinter) OnEvent(e zipper.ProgressEvent) {
+	p.event = e
+	p.currentFile, p.filesDone, p.filesTotal = e.File, e.FilesDone, e.FilesTotal
+	p.OnProgress(e.BytesDone, e.BytesTotal)
+}
+
+// overwritePrompter asks on the terminal whether to replace existing files.
+type overwritePrompter struct {
+	printer *extractProgressPrinter
+	in      *bufio.Reader
+	all     *bool // answer given for every remaining file, if any
+}
+
+func newOverwritePrompter(printer *extractProgressPrinter) *overwritePrompter {
+	return &overwritePrompter{printer: printer, in: bufio.NewReader(os.Stdin)}
 }
 
-func (p *extractProgressPrinter) printLine(line string) {
-	if pad := p.lastLen - len(line); pad > 0 {
-		line += strings.Repeat(" ", pad)
+func (o *overwritePrompter) Confirm(path string) bool {
+	if o.all != nil {
+		return *o.all
+	}
+	// Keep the prompt off the progress bar
+	o.printer.clear()
+	for {
+		fmt.Fprintf(os.Stderr, "Overwrite %s? [y]es, [n]o, [A]ll, [N]one: ", path)
+		answer, err := o.in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		switch answer {
+		case "y", "yes":
+			return true
+		case "A":
+			yes := true
+			o.all = &yes
+			return true
+		case "N":
+			no := false
+			o.all = &no
+			return false
+		}
+		if answer == "n" || answer == "no" || err != nil {
+			return false
+		}
 	}
-	fmt.Printf("\r%s", line)
-	p.lastLen = len(line)
 }
 
 func (p *extractProgressPrinter) Complete(stats zipper.ExtractStats) {
 	if !p.started {
-		fmt.Println("No files extracted.")
+		fmt.Fprintln(statusOut, "No files extracted.")
 		return
 	}
-	fmt.Print("\n")
-	p.lastLen = 0
+	p.finish()
+	printWarnings(stats.Warnings)
 	elapsed := time.Since(p.startTime)
-	fmt.Fprintf(os.Stdout, "✓ Extraction complete: %s -> %s (%s extracted, %d files, %s)\n",
+	fmt.Fprintf(statusOut, "✓ Extraction complete: %s -> %s (%s extracted, %d files, %s)\n",
 		filepath.Base(p.zipPath),
 		p.destDir,
 		formatBytes(stats.TotalBytes),
 		stats.FileCount,
 		formatDuration(elapsed),
 	)
+	if stats.Nested > 0 {
+		fmt.Fprintf(statusOut, "  Nested archives: %d extracted in place\n", stats.Nested)
+	}
+	if stats.Resumed > 0 {
+		fmt.Fprintf(statusOut, "  Resumed: %d files already extracted were kept\n", stats.Resumed)
+	}
+	if stats.NameEncoding != "" {
+		fmt.Fprintf(statusOut, "  Names: decoded from %s (choose another code page with -names)\n", stats.NameEncoding)
+	}
+	if stats.Skipped > 0 || stats.Overwritten > 0 || len(stats.Renamed) > 0 {
+		fmt.Fprintf(statusOut, "  Existing files: %d overwritten, %d skipped, %d renamed\n", stats.Overwritten, stats.Skipped, len(stats.Renamed))
+	}
 }
 
 type progressPrinter = createProgressPrinter
 
 func newProgressPrinter(source string) *progressPrinter {
-	return newCreateProgressPrinter(source)
+	return newCreateProgressPrinter(source, 0)
+}
+
+// shortenPath keeps the end of a path longer than max characters.
+func shortenPath(path string, max int) string {
+	if len(path) <= max {
+		return path
+	}
+	return "..." + path[len(path)-max+3:]
 }
 
 func formatBytes(n int64) string {
@@ -414,6 +1925,91 @@ func formatBytes(n int64) string {
 	return fmt.Sprintf("%.1f %s", value, suffixes[exp])
 }
 
+// parseSize parses a byte count with an optional K, M, G or T suffix (powers of 1024).
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = strings.TrimSpace(s[:n-1])
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// runLog is the -log-file logger, which also records the error a run ends
+// with.
+var runLog *slog.Logger
+
+// openLog returns a logger appending to path at the named level, or nil
+// when path is empty. The command line is logged first so that each run can
+// be told apart.
+func openLog(path, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level: %s (use debug, info, warn or error)", level)
+	}
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	logger := slog.New(slog.NewTextHandler(f, &slog.HandlerOptions{Level: lvl}))
+	logger.Info("pz started", "args", os.Args[1:])
+	return logger, nil
+}
+
+// parseTime parses a time given as Unix seconds, a YYYY-MM-DD date (UTC) or
+// an RFC 3339 timestamp.
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use Unix seconds, YYYY-MM-DD or RFC 3339)", s)
+}
+
+// sizeFlag is a flag.Value holding a byte count such as 512M or 2G.
+type sizeFlag int64
+
+func (f *sizeFlag) String() string {
+	if f == nil || *f == 0 {
+		return ""
+	}
+	return formatBytes(int64(*f))
+}
+
+func (f *sizeFlag) Set(s string) error {
+	n, err := parseSize(s)
+	if err != nil {
+		return err
+	}
+	*f = sizeFlag(n)
+	return nil
+}
+
 func formatDuration(d time.Duration) string {
 	if d < time.Second {
 		return fmt.Sprintf("%dms", d.Milliseconds())
@@ -461,168 +2057,17 @@ func handleContextMenu(action string) {
 	}
 }
 
-// installContextMenu adds registry entries for Windows Explorer context menu
-func installContextMenu() error {
-	exePath, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("cannot get executable path: %w", err)
-	}
-
-	// Check if running as administrator
-	if !isAdmin() {
-		fmt.Println("⚠ Administrator privileges required for context menu installation")
-		fmt.Println("Attempting to restart with administrator privileges...")
-		return runAsAdmin("--context", "install")
-	}
-
-	// Directory background context menu (right-click in folder)
-	keys := []struct {
-		path    string
-		command string
-		name    string
-	}{
-		{
-			path:    `Directory\\shell\\pz_zip`,
-			command: fmt.Sprintf(`"%s" "%%V"`, exePath),
-			name:    "Compress to ZIP",
-		},
-		{
-			path:    `Directory\\shell\\pz_targz`,
-			command: fmt.Sprintf(`"%s" -f gz "%%V"`, exePath),
-			name:    "Compress to tar.gz",
-		},
-		{
-			path:    `Directory\\Background\\shell\\pz_zip`,
-			command: fmt.Sprintf(`"%s" "%%V"`, exePath),
-			name:    "Compress folder to ZIP",
-		},
-		{
-			path:    `Directory\\Background\\shell\\pz_targz`,
-			command: fmt.Sprintf(`"%s" -f gz "%%V"`, exePath),
-			name:    "Compress folder to tar.gz",
-		},
-		{
-			path:    `*\\shell\\pz_zip`,
-			command: fmt.Sprintf(`"%s" "%%1"`, exePath),
-			name:    "Compress to ZIP",
-		},
-		{
-			path:    `*\\shell\\pz_extract`,
-			command: fmt.Sprintf(`"%s" -x "%%1"`, exePath),
-			name:    "Extract here",
-		},
-	}
-
-	for _, k := range keys {
-		key, _, err := registry.CreateKey(registry.CLASSES_ROOT, k.path, registry.SET_VALUE)
-		if err != nil {
-			return fmt.Errorf("failed to create key %s: %w", k.path, err)
-		}
-		if err := key.SetStringValue("", k.name); err != nil {
-			key.Close()
-			return fmt.Errorf("failed to set name for %s: %w", k.path, err)
-		}
-		key.Close()
-
-		// Set icon
-		iconKey, _, err := registry.CreateKey(registry.CLASSES_ROOT, k.path, registry.SET_VALUE)
-		if err == nil {
-			iconKey.SetStringValue("Icon", exePath+",0")
-			iconKey.Close()
-		}
-
-		// Create command subkey
-		cmdKey, _, err := registry.CreateKey(registry.CLASSES_ROOT, k.path+`\\command`, registry.SET_VALUE)
-		if err != nil {
-			return fmt.Errorf("failed to create command key for %s: %w", k.path, err)
-		}
-		if err := cmdKey.SetStringValue("", k.command); err != nil {
-			cmdKey.Close()
-			return fmt.Errorf("failed to set command for %s: %w", k.path, err)
-		}
-		cmdKey.Close()
-	}
-
-	return nil
-}
-
-// uninstallContextMenu removes registry entries
-func uninstallContextMenu() error {
-	// Check if running as administrator
-	if !isAdmin() {
-		fmt.Println("⚠ Administrator privileges required for context menu uninstallation")
-		fmt.Println("Attempting to restart with administrator privileges...")
-		return runAsAdmin("--context", "uninstall")
-	}
-
-	keys := []string{
-		`Directory\\shell\\pz_zip`,
-		`Directory\\shell\\pz_targz`,
-		`Directory\\Background\\shell\\pz_zip`,
-		`Directory\\Background\\shell\\pz_targz`,
-		`*\\shell\\pz_zip`,
-		`*\\shell\\pz_extract`,
-	}
-
-	var errors []string
-	for _, k := range keys {
-		if err := registry.DeleteKey(registry.CLASSES_ROOT, k); err != nil {
-			if err != registry.ErrNotExist {
-				errors = append(errors, fmt.Sprintf("%s: %v", k, err))
-			}
-		}
-	}
-
-	if len(errors) > 0 {
-		return fmt.Errorf("some keys could not be removed:\\n%s", strings.Join(errors, "\\n"))
-	}
-
-	return nil
-}
-
-// checkContextMenuStatus checks if context menu is installed
-func checkContextMenuStatus() {
-	key, err := registry.OpenKey(registry.CLASSES_ROOT, `Directory\\shell\\pz_zip`, registry.QUERY_VALUE)
-	if err == nil {
-		key.Close()
-		fmt.Println("✓ Context menu is installed")
+// listFlag is a flag.Value collecting every use of a repeatable flag.
+type listFlag []string
 
-		exePath, _ := os.Executable()
-		cmdKey, err := registry.OpenKey(registry.CLASSES_ROOT, `Directory\\shell\\pz_zip\\command`, registry.QUERY_VALUE)
-		if err == nil {
-			cmd, _, _ := cmdKey.GetStringValue("")
-			cmdKey.Close()
-			fmt.Printf("  Executable: %s\n", exePath)
-			fmt.Printf("  Command: %s\n", cmd)
-		}
-	} else {
-		fmt.Println("✗ Context menu is not installed")
-		fmt.Println("  Run: pz --context install")
+func (f *listFlag) String() string {
+	if f == nil {
+		return ""
 	}
+	return strings.Join(*f, ", ")
 }
 
-// isAdmin checks if the current process has administrator privileges
-func isAdmin() bool {
-	_, err := os.Open("\\\\.\\PHYSICALDRIVE0")
-	return err == nil
-}
-
-// runAsAdmin restarts the program with administrator privileges
-func runAsAdmin(args ...string) error {
-	exePath, err := os.Executable()
-	if err != nil {
-		return err
-	}
-
-	verb := "runas"
-	cmd := exec.Command("powershell", "-Command", "Start-Process", "-Verb", verb, "-FilePath", exePath, "-ArgumentList", strings.Join(args, ","), "-Wait")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to elevate privileges: %w", err)
-	}
-
-	os.Exit(0)
+func (f *listFlag) Set(s string) error {
+	*f = append(*f, s)
 	return nil
 }
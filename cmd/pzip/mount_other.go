@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// mountArchive is only available where FUSE is.
+func mountArchive(archivePath, mountPoint string) error {
+	return errors.New("mount mode is only supported on Linux and macOS")
+}
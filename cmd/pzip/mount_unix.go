@@ -0,0 +1,176 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/MattInnovates/Project-Zipper/internal/zipper"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// mountArchive serves the archive read-only at mountPoint through FUSE until
+// interrupted or unmounted.
+func mountArchive(archivePath, mountPoint string) error {
+	archive, err := zipper.OpenArchiveFS(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	// The archive cannot change while mounted, so the kernel may cache
+	// everything
+	timeout := time.Hour
+	root := &archiveNode{archive: archive, name: "."}
+	if root.info, err = archive.Stat("."); err != nil {
+		return err
+	}
+	server, err := fs.Mount(mountPoint, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:  archivePath,
+			Name:    "pz",
+			Options: []string{"ro"},
+		},
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+		UID:          uint32(os.Getuid()),
+		GID:          uint32(os.Getgid()),
+	})
+	if err != nil {
+		return fmt.Errorf("mount %s: %w", mountPoint, err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Unmount()
+	}()
+	fmt.Fprintf(statusOut, "✓ Mounted %s at %s (Ctrl+C or umount to unmount)\n", archivePath, mountPoint)
+	server.Wait()
+	return nil
+}
+
+// archiveNode is a file, folder or link of a mounted archive.
+type archiveNode struct {
+	fs.Inode
+	archive *zipper.ArchiveFS
+	name    string
+	info    iofs.FileInfo
+}
+
+var (
+	_ fs.NodeOnAdder    = (*archiveNode)(nil)
+	_ fs.NodeGetattrer  = (*archiveNode)(nil)
+	_ fs.NodeOpener     = (*archiveNode)(nil)
+	_ fs.NodeReadlinker = (*archiveNode)(nil)
+)
+
+// OnAdd builds the whole tree when the root is mounted, from the archive's
+// index, keeping every node so that none has to be looked up again.
+func (n *archiveNode) OnAdd(ctx context.Context) {
+	if n.name != "." {
+		return
+	}
+	inodes := map[string]*fs.Inode{".": &n.Inode}
+	iofs.WalkDir(n.archive, ".", func(name string, d iofs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		parent := inodes[path.Dir(name)]
+		child := parent.NewPersistentInode(ctx, &archiveNode{archive: n.archive, name: name, info: info},
+			fs.StableAttr{Mode: fileType(info.Mode())})
+		parent.AddChild(path.Base(name), child, true)
+		inodes[name] = child
+		return nil
+	})
+}
+
+// fileType returns the file type bits of mode as stat reports them.
+func fileType(mode iofs.FileMode) uint32 {
+	switch {
+	case mode.IsDir():
+		return fuse.S_IFDIR
+	case mode&iofs.ModeSymlink != 0:
+		return fuse.S_IFLNK
+	}
+	return fuse.S_IFREG
+}
+
+func (n *archiveNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fileType(n.info.Mode()) | uint32(n.info.Mode().Perm())
+	out.Nlink = 1
+	if !n.info.IsDir() {
+		out.Size = uint64(n.info.Size())
+		out.Blocks = (out.Size + 511) / 512
+	}
+	mtime := n.info.ModTime()
+	out.SetTimes(nil, &mtime, &mtime)
+	return fs.OK
+}
+
+func (n *archiveNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	f, err := n.archive.Open(n.name)
+	if err != nil {
+		return nil, 0, fs.ToErrno(err)
+	}
+	r, ok := f.(archiveFile)
+	if !ok {
+		f.Close()
+		return nil, 0, syscall.EISDIR
+	}
+	return &archiveHandle{r}, fuse.FOPEN_KEEP_CACHE, fs.OK
+}
+
+func (n *archiveNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	target, err := n.archive.ReadLink(n.name)
+	if err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return []byte(target), fs.OK
+}
+
+// archiveFile is a file opened from a zipper.ArchiveFS.
+type archiveFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// archiveHandle is a file of a mounted archive opened for reading.
+type archiveHandle struct {
+	file archiveFile
+}
+
+var (
+	_ fs.FileReader   = (*archiveHandle)(nil)
+	_ fs.FileReleaser = (*archiveHandle)(nil)
+)
+
+func (h *archiveHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := h.file.ReadAt(dest, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fs.ToErrno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), fs.OK
+}
+
+func (h *archiveHandle) Release(ctx context.Context) syscall.Errno {
+	return fs.ToErrno(h.file.Close())
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MattInnovates/Project-Zipper/internal/zipper"
+)
+
+// progressStyle selects how the progress printers report progress.
+type progressStyle int
+
+const (
+	progressRich  progressStyle = iota // bar and files in flight, redrawn in place
+	progressBar                        // single bar for dumb terminals
+	progressLines                      // periodic one-line updates for logs
+	progressOff
+)
+
+// progressLineInterval is how often progress is logged when stderr is not a
+// terminal.
+const progressLineInterval = 5 * time.Second
+
+var (
+	// statusOut receives the progress bar and summaries, keeping stdout for
+	// the resulting paths so they can be piped. Quiet mode discards it.
+	statusOut io.Writer = os.Stderr
+	progress            = progressRich
+	// verbose lists each entry as it is processed (-v).
+	verbose bool
+	// warnSkipped lists unreadable files as they are skipped (-on-error
+	// warn).
+	warnSkipped bool
+	// dryRun reports what create and extract modes would do instead of
+	// doing it (-dry-run).
+	dryRun bool
+	// reportTop is the number of files the compression report of create
+	// mode lists (-report-top), zero when there is no report (-report).
+	reportTop int
+)
+
+// setupProgress picks the progress style from the -q and -no-progress flags
+// and whether stderr is a terminal.
+func setupProgress(quiet, noProgress bool) {
+	switch {
+	case quiet:
+		statusOut = io.Discard
+		progress = progressOff
+	case noProgress:
+		progress = progressOff
+	case !isTerminal(os.Stderr):
+		progress = progressLines
+	case !richTerminal():
+		progress = progressBar
+	}
+}
+
+// richTerminal reports whether the terminal can redraw the several lines of
+// the rich display. Windows consoles leave TERM unset.
+func richTerminal() bool {
+	term := os.Getenv("TERM")
+	if term == "" {
+		return runtime.GOOS == "windows"
+	}
+	return term != "dumb"
+}
+
+// isTerminal reports whether f is a terminal or console rather than a file
+// or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressLogger writes the periodic one-line updates used instead of the
+// bar when output is not a terminal.
+type progressLogger struct {
+	last time.Time
+	done bool
+}
+
+func (l *progressLogger) log(start time.Time, done, total int64) {
+	now := time.Now()
+	finished := done >= total
+	if l.done || (!finished && now.Sub(l.last) < progressLineInterval) {
+		return
+	}
+	l.last = now
+	l.done = finished
+
+	percent := 100.0
+	if total > 0 {
+		percent = float64(done) / float64(total) * 100
+	}
+	speed := int64(0)
+	if elapsed := now.Sub(start).Seconds(); elapsed > 0 {
+		speed = int64(float64(done)/elapsed + 0.5)
+	}
+	fmt.Fprintf(statusOut, "[%s] %3.0f%% (%s/%s) %s/s\n", now.Format("15:04:05"), percent, formatBytes(done), formatBytes(total), formatBytes(speed))
+}
+
+// barDisplay draws a progress bar in place, with the rich display's lines
+// below it.
+type barDisplay struct {
+	lastLen int // length of the bar line, zero when none is shown
+	below   int // number of lines shown below the bar
+}
+
+func (b *barDisplay) draw(line string, below ...string) {
+	b.clear()
+	fmt.Fprint(statusOut, line)
+	for _, l := range below {
+		if l != "" {
+			fmt.Fprintf(statusOut, "\n%s", l)
+			b.below++
+		}
+	}
+	b.lastLen = len(line)
+}
+
+// clear erases the bar so another line can be printed in its place.
+func (b *barDisplay) clear() {
+	b.clearBelow()
+	if b.lastLen > 0 {
+		fmt.Fprint(statusOut, "\033[2K\r")
+	}
+	b.lastLen = 0
+}
+
+// finish leaves the final bar in place, without the lines below it, and
+// moves to the next line.
+func (b *barDisplay) finish() {
+	b.clearBelow()
+	if b.lastLen > 0 {
+		fmt.Fprint(statusOut, "\n")
+	}
+	b.lastLen = 0
+}
+
+// clearBelow erases the lines below the bar, moving back up to it.
+func (b *barDisplay) clearBelow() {
+	fmt.Fprint(statusOut, strings.Repeat("\033[2K\r\033[1A", b.below))
+	b.below = 0
+}
+
+// panelFileWidth is the width of the file names in the rich display, which
+// keeps its lines within 80 columns.
+const panelFileWidth = 34
+
+// panelLines returns the lines the rich display shows below the bar: the
+// estimated time left and the compression ratio so far, then each file in
+// flight with its own bar, one per worker.
+func panelLines(rate *rateMeter, done, total int64, e zipper.ProgressEvent, workers int) []string {
+	status := "  ETA " + rate.left(done, total)
+	if e.CompressedBytes > 0 && done > 0 {
+		status += fmt.Sprintf(", compressed to %.0f%% (%s)", float64(e.CompressedBytes)*100/float64(done), formatBytes(e.CompressedBytes))
+	}
+	lines := []string{status}
+
+	active := e.Active
+	if len(active) > workers {
+		active = active[:workers]
+	}
+	for _, f := range active {
+		lines = append(lines, fmt.Sprintf("  %-*s %s", panelFileWidth, shortenPath(f.Name, panelFileWidth), fileBar(f)))
+	}
+	if more := len(e.Active) - len(active); more > 0 {
+		lines = append(lines, fmt.Sprintf("  ... and %d more", more))
+	}
+	return lines
+}
+
+// fileBar renders the progress of one file in flight.
+func fileBar(f zipper.FileProgress) string {
+	const barWidth = 20
+	filled, percent := barWidth, 100.0
+	if f.BytesTotal > 0 && f.BytesDone < f.BytesTotal {
+		filled = int(f.BytesDone * barWidth / f.BytesTotal)
+		percent = float64(f.BytesDone) / float64(f.BytesTotal) * 100
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", barWidth-filled)
+	return fmt.Sprintf("[%s] %3.0f%% of %s", bar, percent, formatBytes(f.BytesTotal))
+}
+
+// rateMeter smooths the transfer rate for the time left, so the estimate
+// does not jump with each small or large file.
+type rateMeter struct {
+	last     time.Time
+	lastDone int64
+	rate     float64 // bytes per second
+}
+
+// rateInterval is how far apart the samples of a rateMeter are; rateWeight
+// is the weight of each new sample.
+const (
+	rateInterval = 500 * time.Millisecond
+	rateWeight   = 0.3
+)
+
+func (m *rateMeter) update(done int64) {
+	now := time.Now()
+	if m.last.IsZero() {
+		m.last, m.lastDone = now, done
+		return
+	}
+	elapsed := now.Sub(m.last)
+	if elapsed < rateInterval {
+		return
+	}
+	sample := float64(done-m.lastDone) / elapsed.Seconds()
+	if m.rate == 0 {
+		m.rate = sample
+	} else {
+		m.rate += rateWeight * (sample - m.rate)
+	}
+	m.last, m.lastDone = now, done
+}
+
+// left estimates the time left from the smoothed rate.
+func (m *rateMeter) left(done, total int64) string {
+	switch {
+	case done >= total:
+		return "0s"
+	case m.rate <= 0:
+		return "--"
+	}
+	left := time.Duration(float64(total-done) / m.rate * float64(time.Second))
+	return formatDuration(max(left.Round(time.Second), time.Second))
+}
+
+// terminalWidth returns the width of the terminal progress is drawn on,
+// from the console itself or $COLUMNS, and 80 when neither says.
+func terminalWidth() int {
+	if width := consoleWidth(os.Stderr); width > 0 {
+		return width
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return 80
+}
+
+// barWidth is the width of the overall progress bar, which narrows down to
+// minBarWidth on small terminals to leave minFileWidth for the current file.
+const (
+	barWidth     = 50
+	minBarWidth  = 10
+	minFileWidth = 24
+)
+
+// fitLine renders a progress line so that it fits the terminal, since a
+// wrapped line breaks redrawing it: render is given a narrower bar when the
+// line is too long, and is cut short if that is not enough. The current
+// file goes at the end, shortened to the room left.
+func fitLine(render func(barWidth int) string, file string) string {
+	width := terminalWidth() - 1
+	line := render(barWidth)
+	reserve := 0 // room kept for the file
+	if file != "" {
+		reserve = min(len(file), minFileWidth) + 1
+	}
+	if excess := len(line) + reserve - width; excess > 0 {
+		line = render(max(barWidth-excess, minBarWidth))
+	}
+	if len(line) >= width {
+		return line[:width]
+	}
+	if room := width - len(line) - 1; file != "" && room > 3 {
+		line += " " + shortenPath(file, room)
+	}
+	return line
+}
+
+// printWarnings lists the problems that did not stop an operation. Like
+// errors they are shown even in quiet mode.
+func printWarnings(warnings []zipper.Warning) {
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+}
+
+// compressionNote describes how a zip entry was stored, as zip -v does. It
+// is empty for tar.gz entries, which have no size of their own.
+func compressionNote(e zipper.EntryEvent) string {
+	if e.CompressedSize < 0 {
+		return ""
+	}
+	if e.IsDir || e.Method == zip.Store {
+		return " (stored 0%)"
+	}
+	saved := int64(0)
+	if e.Size > 0 && e.CompressedSize < e.Size {
+		saved = 100 - e.CompressedSize*100/e.Size
+	}
+	return fmt.Sprintf(" (deflated %d%%)", saved)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/MattInnovates/Project-Zipper/internal/zipper"
+)
+
+// compressionReport collects the files written to an archive for -report,
+// which shows where the archive's space goes so exclude patterns can be
+// tuned.
+type compressionReport struct {
+	entries []reportEntry
+}
+
+type reportEntry struct {
+	Name           string `json:"name"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressed_size"` // -1 in tar.gz archives
+}
+
+// onEntry records each file before passing it on to next, if any. The
+// library makes these calls one at a time.
+func (r *compressionReport) onEntry(next zipper.EntryFunc) zipper.EntryFunc {
+	return func(e zipper.EntryEvent) {
+		if !e.IsDir {
+			r.entries = append(r.entries, reportEntry{Name: e.Name, Size: e.Size, CompressedSize: e.CompressedSize})
+		}
+		if next != nil {
+			next(e)
+		}
+	}
+}
+
+// sorted returns the files largest first by the space they take in the
+// archive. Entries of tar.gz archives, compressed as one stream, have no
+// size of their own there and are ranked by their size.
+func (r *compressionReport) sorted() []reportEntry {
+	entries := append([]reportEntry(nil), r.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].space() > entries[j].space()
+	})
+	return entries
+}
+
+func (e reportEntry) space() int64 {
+	if e.CompressedSize < 0 {
+		return e.Size
+	}
+	return e.CompressedSize
+}
+
+// saved returns the percentage of size compression saved, as zip -v shows.
+func saved(size, compressed int64) int64 {
+	if size <= 0 || compressed >= size {
+		return 0
+	}
+	return 100 - compressed*100/size
+}
+
+// print writes the overall ratio and a table of the top files taking the
+// most space to w.
+func (r *compressionReport) print(w io.Writer, stats zipper.ArchiveStats, archiveSize int64, top int) {
+	fmt.Fprintf(w, "  Compression: %s -> %s", formatBytes(stats.TotalBytes), formatBytes(archiveSize))
+	if archiveSize > 0 {
+		fmt.Fprintf(w, " (%.1f:1, %d%% saved)", float64(stats.TotalBytes)/float64(archiveSize), saved(stats.TotalBytes, archiveSize))
+	}
+	fmt.Fprintln(w)
+
+	entries := r.sorted()
+	if len(entries) == 0 {
+		return
+	}
+	if len(entries) > top {
+		entries = entries[:top]
+	}
+	if entries[0].CompressedSize < 0 {
+		fmt.Fprintf(w, "  Largest %d files (tar.gz compresses them as one stream):\n", len(entries))
+	} else {
+		fmt.Fprintf(w, "  Largest %d files in the archive:\n", len(entries))
+	}
+	fmt.Fprintf(w, "  %10s  %10s  %5s  %s\n", "Size", "Compressed", "Saved", "Name")
+	for _, e := range entries {
+		compressed, percent := "-", "-"
+		if e.CompressedSize >= 0 {
+			compressed = formatBytes(e.CompressedSize)
+			percent = fmt.Sprintf("%d%%", saved(e.Size, e.CompressedSize))
+		}
+		fmt.Fprintf(w, "  %10s  %10s  %5s  %s\n", formatBytes(e.Size), compressed, percent, e.Name)
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MattInnovates/Project-Zipper/internal/zipper"
+)
+
+// serveShutdownTimeout is how long -serve waits for requests in progress
+// when interrupted.
+const serveShutdownTimeout = 5 * time.Second
+
+// serveTokenEnv names the environment variable that sets the -serve token
+// instead of a random one.
+const serveTokenEnv = "PZIP_SERVE_TOKEN"
+
+// jobServer runs the create and extract jobs submitted to -serve, so that
+// other programs can hand archiving to pz over HTTP instead of running it
+// and parsing its output. Jobs run concurrently, each with the options
+// given on the command line.
+type jobServer struct {
+	ctx         context.Context
+	createOpts  zipper.CreateOptions
+	extractOpts zipper.ExtractOptions
+	workDir     string // where archives are created when a job names no output
+	root        string // folder paths in jobs must be inside, or "" for any
+	token       string // bearer token every request must carry
+	wg          sync.WaitGroup
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	outputs map[string]bool // archives running create jobs are writing
+	nextID  int
+}
+
+// jobRequest is the body of POST /jobs.
+type jobRequest struct {
+	Op      string   `json:"op"`      // "create" or "extract"
+	Sources []string `json:"sources"` // create: files and folders to archive
+	Format  string   `json:"format"`  // create: "zip" (default) or "gz"
+	Output  string   `json:"output"`  // create: archive to write; one in the work folder by default
+	Archive string   `json:"archive"` // extract: archive path or http(s) URL
+	Dest    string   `json:"dest"`    // extract: folder to extract to
+}
+
+// job is the state of a submitted job as the API reports it. The server's
+// mutex guards its fields.
+type job struct {
+	ID       string      `json:"id"`
+	Op       string      `json:"op"`
+	State    string      `json:"state"`  // running, done, failed or canceled
+	Output   string      `json:"output"` // archive created or folder extracted to
+	Progress jobProgress `json:"progress"`
+	Stats    any         `json:"stats,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Started  time.Time   `json:"started"`
+	Finished *time.Time  `json:"finished,omitempty"`
+
+	cancel  context.CancelFunc
+	created bool // Output was created by the job, so it is removed on failure
+}
+
+type jobProgress struct {
+	Done       int64  `json:"done"`
+	Total      int64  `json:"total"`
+	File       string `json:"file,omitempty"`
+	FilesDone  int    `json:"files_done"`
+	FilesTotal int    `json:"files_total,omitempty"`
+}
+
+// doServe serves the job API on addr until interrupted. Only loopback
+// addresses are accepted unless public is set, every request must carry the
+// token printed at startup, and when root is given the paths of jobs must be
+// inside it. Archives of jobs without an output are written to a temporary
+// folder removed on exit.
+func doServe(addr, root string, public bool, createOpts zipper.CreateOptions, extractOpts zipper.ExtractOptions) {
+	if extractOpts.Overwrite == zipper.OverwritePrompt {
+		exitWithError(errors.New("-overwrite prompt cannot be used with -serve"))
+	}
+	if root != "" {
+		var err error
+		if root, err = filepath.Abs(root); err != nil {
+			exitWithError(err)
+		}
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			exitWithError(err)
+		}
+	}
+	token := os.Getenv(serveTokenEnv)
+	if token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			exitWithError(err)
+		}
+		token = hex.EncodeToString(b)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		exitWithError(err)
+	}
+	if tcpAddr, ok := ln.Addr().(*net.TCPAddr); !public && (!ok || !tcpAddr.IP.IsLoopback()) {
+		ln.Close()
+		exitWithError(fmt.Errorf("-serve %s listens beyond this machine; use localhost, or add -serve-public to allow it", addr))
+	}
+	workDir, err := os.MkdirTemp("", "pz-serve-*")
+	if err != nil {
+		exitWithError(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	ctx := createOpts.Context
+	s := &jobServer{
+		ctx:         ctx,
+		createOpts:  createOpts,
+		extractOpts: extractOpts,
+		workDir:     workDir,
+		root:        root,
+		token:       token,
+		jobs:        make(map[string]*job),
+		outputs:     make(map[string]bool),
+	}
+	srv := &http.Server{Handler: s.handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(statusOut, "Serving archive jobs on http://%s (Ctrl+C to stop)\n", ln.Addr())
+	if os.Getenv(serveTokenEnv) == "" {
+		fmt.Fprintf(statusOut, "  Token: %s (send as Authorization: Bearer <token>, or set %s)\n", token, serveTokenEnv)
+	}
+	if root != "" {
+		fmt.Fprintf(statusOut, "  Jobs are confined to %s\n", root)
+	}
+	err = srv.Serve(ln)
+	// Running jobs stop with the interrupt
+	s.wg.Wait()
+	if err != http.ErrServerClosed {
+		os.RemoveAll(workDir)
+		exitWithError(err)
+	}
+}
+
+func (s *jobServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.submit)
+	mux.HandleFunc("GET /jobs", s.list)
+	mux.HandleFunc("GET /jobs/{id}", s.status)
+	mux.HandleFunc("DELETE /jobs/{id}", s.deleteJob)
+	mux.HandleFunc("GET /jobs/{id}/archive", s.download)
+	return s.guard(mux)
+}
+
+// guard rejects requests without the server's bearer token, and requests
+// from web pages, which browsers mark with an Origin header, so that a page
+// cannot submit jobs to a server on the same machine. Bodies must be
+// declared as JSON, which a page cannot send without an Origin either.
+func (s *jobServer) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			writeError(w, http.StatusForbidden, errors.New("requests from web pages are not accepted"))
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+			return
+		}
+		if r.Method == http.MethodPost {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// path returns p as an absolute path, checking that it is inside the
+// server's root when it has one. Symbolic links in the part of p that
+// exists are resolved first, so that they cannot lead outside the root.
+func (s *jobServer) path(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil || s.root == "" {
+		return abs, err
+	}
+	resolved, rest := abs, ""
+	for {
+		if target, err := filepath.EvalSymlinks(resolved); err == nil {
+			resolved = filepath.Join(target, rest)
+			break
+		}
+		parent := filepath.Dir(resolved)
+		if parent == resolved {
+			break
+		}
+		rest = filepath.Join(filepath.Base(resolved), rest)
+		resolved = parent
+	}
+	rel, err := filepath.Rel(s.root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside %s", p, s.root)
+	}
+	return abs, nil
+}
+
+// submit starts the job described by the request body and answers with its
+// state.
+func (s *jobServer) submit(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job: %w", err))
+		return
+	}
+	j, err := s.start(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// list answers with every job, oldest first.
+func (s *jobServer) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, *j)
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(a, b int) bool {
+		x, _ := strconv.Atoi(jobs[a].ID)
+		y, _ := strconv.Atoi(jobs[b].ID)
+		return x < y
+	})
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *jobServer) status(w http.ResponseWriter, r *http.Request) {
+	if j, ok := s.lookup(w, r); ok {
+		writeJSON(w, http.StatusOK, j)
+	}
+}
+
+// deleteJob cancels a running job, which stops between reads, after which
+// its state is canceled. A finished job is forgotten instead, removing the
+// archive it wrote to the work folder; archives written to an output of
+// the job's own are kept.
+func (s *jobServer) deleteJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	if j.State == "running" {
+		j.cancel()
+		writeJSON(w, http.StatusAccepted, j)
+		return
+	}
+	s.mu.Lock()
+	delete(s.jobs, j.ID)
+	s.mu.Unlock()
+	if j.Op == "create" && filepath.Dir(j.Output) == s.workDir {
+		os.Remove(j.Output)
+		os.Remove(j.Output + ".sha256")
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// download sends the archive a finished create job wrote.
+func (s *jobServer) download(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	if j.Op != "create" || j.State != "done" {
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s has no archive to download", j.ID))
+		return
+	}
+	f, err := os.Open(j.Output)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(j.Output)))
+	http.ServeContent(w, r, filepath.Base(j.Output), info.ModTime(), f)
+}
+
+// lookup returns a copy of the job named by the request, answering 404
+// when there is none.
+func (s *jobServer) lookup(w http.ResponseWriter, r *http.Request) (job, bool) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", id))
+		return job{}, false
+	}
+	return *j, true
+}
+
+// start checks req and runs it in the background, returning the new job.
+func (s *jobServer) start(req jobRequest) (job, error) {
+	var run func(ctx context.Context, j *job) (any, error)
+	var output string
+	var err error
+	switch req.Op {
+	case "create":
+		run, output, err = s.prepareCreate(req)
+	case "extract":
+		run, output, err = s.prepareExtract(req)
+	default:
+		err = fmt.Errorf("unknown op: %q (use create or extract)", req.Op)
+	}
+	if err != nil {
+		return job{}, err
+	}
+
+	s.mu.Lock()
+	if req.Op == "create" && output != "" {
+		// Checked again under the lock, as another job may have claimed the
+		// output since prepareCreate found it free
+		if _, err := os.Stat(output); err == nil || s.outputs[output] {
+			s.mu.Unlock()
+			return job{}, fmt.Errorf("output already exists: %s", output)
+		}
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.nextID++
+	j := &job{
+		ID:      strconv.Itoa(s.nextID),
+		Op:      req.Op,
+		State:   "running",
+		Output:  output,
+		Started: time.Now(),
+		cancel:  cancel,
+	}
+	if req.Op == "create" && output == "" {
+		ext := ".zip"
+		if isGzipFormat(req.Format) {
+			ext = ".tar.gz"
+		}
+		j.Output = filepath.Join(s.workDir, "job-"+j.ID+ext)
+	}
+	if req.Op == "create" {
+		s.outputs[j.Output] = true
+	}
+	s.jobs[j.ID] = j
+	snapshot := *j
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		stats, err := run(ctx, j)
+		s.finish(j, stats, err)
+	}()
+	return snapshot, nil
+}
+
+// prepareCreate checks a create request and returns the function running
+// it, with the archive path when the request gives one.
+func (s *jobServer) prepareCreate(req jobRequest) (func(context.Context, *job) (any, error), string, error) {
+	if len(req.Sources) == 0 {
+		return nil, "", errors.New("create needs sources")
+	}
+	if req.Format != "" && req.Format != "zip" && !isGzipFormat(req.Format) {
+		return nil, "", fmt.Errorf("unsupported format: %s (use 'zip' or 'gz')", req.Format)
+	}
+	if req.Archive != "" || req.Dest != "" {
+		return nil, "", errors.New("archive and dest are for extract jobs")
+	}
+	sources := make([]string, len(req.Sources))
+	for i, source := range req.Sources {
+		abs, err := s.path(source)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return nil, "", err
+		}
+		sources[i] = abs
+	}
+	output := ""
+	if req.Output != "" {
+		var err error
+		if output, err = s.path(req.Output); err != nil {
+			return nil, "", err
+		}
+		if _, err := os.Stat(output); err == nil {
+			return nil, "", fmt.Errorf("output already exists: %s", output)
+		}
+	}
+
+	run := func(ctx context.Context, j *job) (any, error) {
+		opts := s.createOpts
+		opts.Context = ctx
+		opts.ProgressEvents = s.progress(j)
+		s.mu.Lock()
+		archivePath := j.Output
+		j.created = true
+		s.mu.Unlock()
+		switch {
+		case isGzipFormat(req.Format) && len(sources) > 1:
+			return zipper.GzipSourcesWithOptions(sources, archivePath, opts)
+		case isGzipFormat(req.Format):
+			return zipper.GzipWithOptions(sources[0], archivePath, opts)
+		case len(sources) > 1:
+			return zipper.ZipSourcesWithOptions(sources, archivePath, opts)
+		default:
+			return zipper.ZipWithOptions(sources[0], archivePath, opts)
+		}
+	}
+	return run, output, nil
+}
+
+// prepareExtract checks an extract request and returns the function running
+// it, with the destination folder.
+func (s *jobServer) prepareExtract(req jobRequest) (func(context.Context, *job) (any, error), string, error) {
+	if req.Archive == "" || req.Dest == "" {
+		return nil, "", errors.New("extract needs archive and dest")
+	}
+	if len(req.Sources) > 0 || req.Output != "" || req.Format != "" {
+		return nil, "", errors.New("sources, output and format are for create jobs")
+	}
+	remote := strings.HasPrefix(req.Archive, "http://") || strings.HasPrefix(req.Archive, "https://")
+	archivePath := req.Archive
+	if !remote {
+		var err error
+		if archivePath, err = s.path(archivePath); err != nil {
+			return nil, "", err
+		}
+		info, err := os.Stat(archivePath)
+		if err != nil {
+			return nil, "", err
+		}
+		if info.IsDir() {
+			return nil, "", errors.New("archive must be a file, not a directory")
+		}
+	}
+	destDir, err := s.path(req.Dest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	run := func(ctx context.Context, j *job) (any, error) {
+		opts := s.extractOpts
+		opts.Context = ctx
+		opts.ProgressEvents = s.progress(j)
+		switch {
+		case remote:
+			return zipper.ExtractFromURL(ctx, archivePath, destDir, opts)
+		case isGzipArchive(archivePath):
+			return zipper.ExtractGzipWithOptions(archivePath, destDir, opts)
+		default:
+			return zipper.ExtractWithOptions(archivePath, destDir, opts)
+		}
+	}
+	return run, destDir, nil
+}
+
+// progress returns a callback recording progress events in j.
+func (s *jobServer) progress(j *job) zipper.ProgressEventFunc {
+	return func(e zipper.ProgressEvent) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		j.Progress = jobProgress{
+			Done:       e.BytesDone,
+			Total:      e.BytesTotal,
+			File:       e.File,
+			FilesDone:  e.FilesDone,
+			FilesTotal: e.FilesTotal,
+		}
+	}
+}
+
+// finish records the outcome of j. A failed create job's partial archive
+// is removed, and its output may be given to another job.
+func (s *jobServer) finish(j *job, stats any, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	j.Finished = &now
+	j.Progress.File = ""
+	switch {
+	case err == nil:
+		j.State = "done"
+		j.Stats = stats
+	case errors.Is(err, context.Canceled):
+		j.State = "canceled"
+	default:
+		j.State = "failed"
+		j.Error = err.Error()
+	}
+	if err != nil && j.created {
+		os.Remove(j.Output)
+	}
+	if j.Op == "create" {
+		delete(s.outputs, j.Output)
+	}
+	if runLog != nil {
+		runLog.Info("job "+j.State, "id", j.ID, "op", j.Op, "output", j.Output)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
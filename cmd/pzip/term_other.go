@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "os"
+
+// consoleWidth is not known on this platform.
+func consoleWidth(f *os.File) int {
+	return 0
+}
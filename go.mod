@@ -2,4 +2,9 @@ module github.com/MattInnovates/Project-Zipper
 
 go 1.24.0
 
-require golang.org/x/sys v0.39.0
+require (
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/hanwen/go-fuse/v2 v2.11.0
+	golang.org/x/sys v0.39.0
+	golang.org/x/text v0.33.0
+)
@@ -0,0 +1,246 @@
+package zipper
+
+import (
+	"archive/zip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AppendWithProgress adds srcDir to an existing zip archive under the
+// directory's base name. Existing entries are carried across without
+// recompression; an existing entry with the same name as a new one is
+// replaced. A manifest already in the archive is updated to match.
+func AppendWithProgress(zipPath, srcDir string, opts CreateOptions) (stats ArchiveStats, err error) {
+	log := logCreate(&opts, "append", zipPath)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+
+	srcDir, zipPath = longPath(srcDir), longPath(zipPath)
+
+	// New entries are nested under the directory's own name
+	skips := newSkipList(opts)
+	files, err := collectSources([]string{srcDir}, opts, skips)
+	if err != nil {
+		return stats, err
+	}
+	replaced := make(map[string]bool, len(files))
+	for _, job := range files {
+		name := filepath.ToSlash(job.rel)
+		if job.isDir {
+			name += "/"
+		} else {
+			stats.TotalBytes += job.info.Size()
+			stats.FileCount++
+		}
+		replaced[name] = true
+	}
+
+	keep := func(f *zip.File) bool { return !replaced[f.Name] }
+	add := func(writer *zip.Writer, digests *manifest) error {
+		return writeZipFiles(writer, files, &stats, filepath.Dir(zipPath), opts, skips, digests)
+	}
+	stats.Checksum, err = rewriteZip(zipPath, keep, add, opts.Manifest, opts.Comment)
+	skips.apply(&stats)
+	return stats, err
+}
+
+// rewriteZip rebuilds zipPath through a temporary file next to it. Existing
+// entries for which keep returns true are copied without recompression, then
+// add appends any new entries. A manifest already in the archive, or one
+// requested with wantManifest, is rewritten to cover the kept entries and
+// the digests recorded by add. The archive's comment is kept unless comment
+// replaces it. It returns the checksum of the new archive.
+func rewriteZip(zipPath string, keep func(*zip.File) bool, add func(w *zip.Writer, digests *manifest) error, wantManifest bool, comment string) (checksum string, err error) {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	if comment == "" {
+		comment, _ = splitZipComment(reader.Comment)
+	}
+
+	var oldDigests *manifest
+	for _, f := range reader.File {
+		if f.Name == ManifestName {
+			rc, err := f.Open()
+			if err != nil {
+				return "", err
+			}
+			oldDigests, err = parseManifest(rc)
+			rc.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	var digests *manifest
+	if oldDigests != nil || wantManifest {
+		digests = newManifest()
+	}
+
+	tempFile, err := tempFileFor(zipPath)
+	if err != nil {
+		return "", err
+	}
+	tempPath := tempFile.Name()
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	writer := zip.NewWriter(tempFile)
+	for _, f := range reader.File {
+		if f.Name == ManifestName || !keep(f) {
+			continue
+		}
+		if err := copyZipFile(writer, f); err != nil {
+			return "", err
+		}
+		if digests == nil || f.FileInfo().IsDir() {
+			continue
+		}
+		if sum, ok := oldDigests.lookup(f.Name); ok {
+			digests.set(f.Name, sum)
+			continue
+		}
+		// Not covered by the old manifest; hash the entry's contents
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		d := digestReader(rc)
+		rc.Close()
+		if d.err != nil {
+			return "", d.err
+		}
+		digests.set(f.Name, d.sum)
+	}
+
+	if add != nil {
+		if err := add(writer, digests); err != nil {
+			return "", err
+		}
+	}
+	if digests != nil {
+		if err := digests.writeZip(writer, time.Now()); err != nil {
+			return "", err
+		}
+	}
+
+	if checksum, err = finishZip(writer, tempFile, comment); err != nil {
+		return "", err
+	}
+
+	// Replace the original with the rewritten archive
+	reader.Close()
+	if err = os.Rename(tempPath, zipPath); err != nil {
+		return "", err
+	}
+	return checksum, nil
+}
+
+// UpdateWithProgress brings a zip archive created from srcDir up to date,
+// recompressing only files that are new or whose size or modification time
+// differs from their entry. Unchanged entries are carried across without
+// recompression, and entries whose source files were removed are kept. The
+// archive is left untouched when nothing changed.
+func UpdateWithProgress(zipPath, srcDir string, opts CreateOptions) (stats ArchiveStats, err error) {
+	return updateZip(zipPath, srcDir, opts, false)
+}
+
+// SyncWithProgress is UpdateWithProgress that also drops the entries of
+// files and folders removed from srcDir, leaving the archive a copy of the
+// folder as it is now. Entries of files skipped as unreadable are kept.
+func SyncWithProgress(zipPath, srcDir string, opts CreateOptions) (stats ArchiveStats, err error) {
+	return updateZip(zipPath, srcDir, opts, true)
+}
+
+// updateZip is UpdateWithProgress, dropping removed entries when prune is
+// set.
+func updateZip(zipPath, srcDir string, opts CreateOptions, prune bool) (stats ArchiveStats, err error) {
+	op := "update"
+	if prune {
+		op = "sync"
+	}
+	log := logCreate(&opts, op, zipPath)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+
+	srcDir, zipPath = longPath(srcDir), longPath(zipPath)
+	skips := newSkipList(opts)
+	files, err := collectFiles(srcDir, "", opts, skips)
+	if err != nil {
+		return stats, err
+	}
+
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return stats, err
+	}
+	existing := make(map[string]*zip.File, len(reader.File))
+	for _, f := range reader.File {
+		existing[f.Name] = f
+	}
+	comment, checksum := splitZipComment(reader.Comment)
+	reader.Close()
+
+	var changed []fileJob
+	changedNames := make(map[string]bool)
+	current := make(map[string]bool, len(files))
+	for _, job := range files {
+		name := filepath.ToSlash(job.rel)
+		if job.isDir {
+			name += "/"
+		}
+		current[name] = true
+		f, ok := existing[name]
+		if ok && (job.isDir || !entryChanged(f, job.info)) {
+			if !job.isDir {
+				stats.Unchanged++
+			}
+			continue
+		}
+		changed = append(changed, job)
+		changedNames[name] = true
+		if !job.isDir {
+			stats.TotalBytes += job.info.Size()
+			stats.FileCount++
+		}
+	}
+
+	removed := func(name string) bool {
+		return prune && name != ManifestName && !current[name] && !skips.covers(strings.TrimSuffix(name, "/"))
+	}
+	for name := range existing {
+		if removed(name) {
+			stats.Removed++
+		}
+	}
+
+	if len(changed) == 0 && stats.Removed == 0 && !opts.Manifest && (opts.Comment == "" || opts.Comment == comment) {
+		stats.Checksum = checksum
+		return stats, nil
+	}
+
+	keep := func(f *zip.File) bool { return !changedNames[f.Name] && !removed(f.Name) }
+	add := func(writer *zip.Writer, digests *manifest) error {
+		return writeZipFiles(writer, changed, &stats, filepath.Dir(zipPath), opts, skips, digests)
+	}
+	stats.Checksum, err = rewriteZip(zipPath, keep, add, opts.Manifest, opts.Comment)
+	skips.apply(&stats)
+	return stats, err
+}
+
+// entryChanged reports whether the file described by info differs in size or
+// modification time from the zip entry f.
+func entryChanged(f *zip.File, info fs.FileInfo) bool {
+	if f.UncompressedSize64 != uint64(info.Size()) {
+		return true
+	}
+	// Entries carry the modification time to the second
+	return !f.Modified.Equal(info.ModTime().Truncate(time.Second))
+}
@@ -0,0 +1,78 @@
+package zipper
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRewriteKeepsNeighbours appends to, updates and removes from an archive
+// next to a file named like a temporary copy of it, which must survive.
+func TestRewriteKeepsNeighbours(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	more := filepath.Join(dir, "more")
+	for _, d := range []string{src, more} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(more, "b.txt"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "out.zip")
+	if _, err := ZipWithOptions(src, zipPath, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	neighbour := zipPath + ".tmp"
+	if err := os.WriteFile(neighbour, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AppendWithProgress(zipPath, more, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UpdateWithProgress(zipPath, src, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveEntries(zipPath, []string{"b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(neighbour); err != nil || string(data) != "keep me" {
+		t.Errorf("%s = %q, %v; want it left alone", neighbour, data, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("folder holds %v; want temporary files removed", names)
+	}
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		if f.Name == "a.txt" && f.UncompressedSize64 != uint64(len("changed")) {
+			t.Errorf("a.txt holds %d bytes; want the update", f.UncompressedSize64)
+		}
+	}
+	if len(names) != 2 || names[0] != "more/" || names[1] != "a.txt" {
+		t.Errorf("archive holds %v; want more/ and a.txt", names)
+	}
+}
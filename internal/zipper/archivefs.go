@@ -0,0 +1,464 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchiveFS is a zip or tar.gz archive opened as a read-only file system, so
+// that its files can be listed and read without extracting them. Files open
+// as fs.File values that also implement io.ReaderAt and io.Seeker. Stored
+// zip entries are read in place; other entries are decompressed from their
+// start, and for tar.gz archives, whose entries cannot be located without
+// decompressing those before them, from the start of the archive, so
+// reading backwards within a large file or archive is slow.
+//
+// Open and Stat follow symbolic links within the archive; Lstat and
+// ReadLink do not.
+type ArchiveFS struct {
+	path  string
+	file  *archiveFile
+	gzip  bool
+	nodes map[string]*fsNode
+}
+
+// fsNode is a file or folder of an ArchiveFS. It implements fs.FileInfo
+// and fs.DirEntry.
+type fsNode struct {
+	name     string // slash-separated path, "." for the root
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	link     string    // target of a tar.gz symbolic link
+	zip      *zip.File // entry holding the data of a zip file or link
+	index    int       // header holding the data of a tar.gz file, -1 for none
+	children []*fsNode // sorted by name
+}
+
+// OpenArchiveFS opens the zip or tar.gz archive at archivePath, which may
+// name the first part of a split archive, as a file system. Entries with
+// paths outside the archive are left out, as extraction rejects them.
+// Folders that have no entry of their own are listed all the same.
+func OpenArchiveFS(archivePath string) (*ArchiveFS, error) {
+	file, err := openArchive(longPath(archivePath))
+	if err != nil {
+		return nil, err
+	}
+	a := &ArchiveFS{
+		path:  archivePath,
+		file:  file,
+		gzip:  isGzipName(archivePath),
+		nodes: map[string]*fsNode{".": {name: ".", mode: fs.ModeDir | 0755, index: -1}},
+	}
+	if a.gzip {
+		err = a.indexTar()
+	} else {
+		err = a.indexZip()
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, n := range a.nodes {
+		sort.Slice(n.children, func(i, j int) bool { return n.children[i].name < n.children[j].name })
+	}
+	return a, nil
+}
+
+func (a *ArchiveFS) indexZip() error {
+	reader, err := zip.NewReader(a.file, a.file.Size())
+	if err != nil {
+		return err
+	}
+	decodeZipNames(reader.File, NameEncodingAuto)
+	for _, f := range reader.File {
+		n := &fsNode{mode: f.Mode(), size: int64(f.UncompressedSize64), modTime: f.Modified, index: -1}
+		if !n.mode.IsDir() {
+			n.zip = f
+		}
+		a.add(f.Name, n)
+	}
+	return nil
+}
+
+func (a *ArchiveFS) indexTar() error {
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(a.file.reader(), 256<<10))
+	if err != nil {
+		return err
+	}
+	defer gzReader.Close()
+	tarReader := tar.NewReader(gzReader)
+	for i := 0; ; i++ {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return gzipStreamError(a.path, err)
+		}
+		n := &fsNode{mode: header.FileInfo().Mode(), size: header.Size, modTime: header.ModTime, index: -1}
+		switch header.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeReg, tar.TypeGNUSparse:
+			n.index = i
+		case tar.TypeSymlink:
+			n.link = header.Linkname
+		case tar.TypeLink:
+			// A hard link reads the data of the file it links to
+			target := a.nodes[cleanEntryName(header.Linkname)]
+			if target == nil || target.index < 0 {
+				continue
+			}
+			n.mode, n.size, n.index = target.mode, target.size, target.index
+		default:
+			continue
+		}
+		a.add(header.Name, n)
+	}
+}
+
+// cleanEntryName returns the path of an archive entry as ArchiveFS names
+// it, or "" if the entry lies outside the archive.
+func cleanEntryName(name string) string {
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))[1:]
+	if name == "" {
+		return "."
+	}
+	if !fs.ValidPath(name) {
+		return ""
+	}
+	return name
+}
+
+// add records n under the entry name, with any folders above it that have
+// not been seen. A later entry of the same name replaces an earlier one, as
+// it would when extracting.
+func (a *ArchiveFS) add(entryName string, n *fsNode) {
+	name := cleanEntryName(entryName)
+	if name == "" || name == "." {
+		return
+	}
+	n.name = name
+	if old := a.nodes[name]; old != nil {
+		if old.mode.IsDir() && n.mode.IsDir() {
+			old.mode, old.modTime = n.mode, n.modTime
+			return
+		}
+		n.children = old.children
+		*old = *n
+		return
+	}
+	a.nodes[name] = n
+	for child := n; child.name != "."; {
+		dir := path.Dir(child.name)
+		parent, seen := a.nodes[dir]
+		if !seen {
+			parent = &fsNode{name: dir, mode: fs.ModeDir | 0755, index: -1}
+			a.nodes[dir] = parent
+		}
+		parent.children = append(parent.children, child)
+		if seen {
+			return
+		}
+		child = parent
+	}
+}
+
+// Close closes the archive.
+func (a *ArchiveFS) Close() error {
+	return a.file.Close()
+}
+
+// lookup returns the node named name, following symbolic links in its last
+// element when follow is set.
+func (a *ArchiveFS) lookup(op, name string, follow bool) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	n := a.nodes[name]
+	for hops := 0; n != nil && follow && n.mode&fs.ModeSymlink != 0; hops++ {
+		target, err := a.readLink(n)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if hops == 40 {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+		}
+		if path.IsAbs(target) {
+			n = nil
+			break
+		}
+		n = a.nodes[cleanEntryName(path.Join(path.Dir(n.name), target))]
+	}
+	if n == nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// Open opens the named file or folder.
+func (a *ArchiveFS) Open(name string) (fs.File, error) {
+	n, err := a.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return &fsDir{node: n}, nil
+	}
+	return &fsFile{fsys: a, node: n}, nil
+}
+
+// Stat returns information about the named file or folder.
+func (a *ArchiveFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := a.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Lstat returns information about the named file or folder without
+// following a symbolic link.
+func (a *ArchiveFS) Lstat(name string) (fs.FileInfo, error) {
+	n, err := a.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// ReadLink returns the target of the named symbolic link.
+func (a *ArchiveFS) ReadLink(name string) (string, error) {
+	n, err := a.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	target, err := a.readLink(n)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return target, nil
+}
+
+func (a *ArchiveFS) readLink(n *fsNode) (string, error) {
+	if n.zip == nil {
+		return n.link, nil
+	}
+	target, err := readZipEntry(n.zip)
+	return string(target), err
+}
+
+// ReadDir returns the entries of the named folder, sorted by name.
+func (a *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := a.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries := make([]fs.DirEntry, len(n.children))
+	for i, child := range n.children {
+		entries[i] = child
+	}
+	return entries, nil
+}
+
+// openData returns a reader over the data of the file n, which can also
+// seek for stored zip entries.
+func (a *ArchiveFS) openData(n *fsNode) (io.ReadCloser, error) {
+	if n.zip != nil {
+		if n.zip.Method == zip.Store {
+			if offset, err := n.zip.DataOffset(); err == nil {
+				return sectionData{io.NewSectionReader(a.file, offset, n.size)}, nil
+			}
+		}
+		return n.zip.Open()
+	}
+
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(a.file.reader(), 256<<10))
+	if err != nil {
+		return nil, err
+	}
+	tarReader := tar.NewReader(gzReader)
+	for i := 0; i <= n.index; i++ {
+		if _, err := tarReader.Next(); err != nil {
+			gzReader.Close()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, gzipStreamError(a.path, err)
+		}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{tarReader, gzReader}, nil
+}
+
+// sectionData is the data of a stored zip entry, read in place from the
+// archive. Unlike io.NopCloser it keeps Seek, so reads at any offset do not
+// reopen the entry.
+type sectionData struct{ *io.SectionReader }
+
+func (sectionData) Close() error { return nil }
+
+func (n *fsNode) Name() string               { return path.Base(n.name) }
+func (n *fsNode) Size() int64                { return n.size }
+func (n *fsNode) Mode() fs.FileMode          { return n.mode }
+func (n *fsNode) ModTime() time.Time         { return n.modTime }
+func (n *fsNode) IsDir() bool                { return n.mode.IsDir() }
+func (n *fsNode) Sys() any                   { return nil }
+func (n *fsNode) Type() fs.FileMode          { return n.mode.Type() }
+func (n *fsNode) Info() (fs.FileInfo, error) { return n, nil }
+func (n *fsNode) String() string             { return fs.FormatFileInfo(n) }
+
+// fsDir is a folder opened from an ArchiveFS.
+type fsDir struct {
+	node   *fsNode
+	offset int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.node, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the folder's next n entries, or all remaining ones when
+// n <= 0, as fs.ReadDirFile describes.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.node.children[d.offset:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.offset += len(rest)
+	entries := make([]fs.DirEntry, len(rest))
+	for i, child := range rest {
+		entries[i] = child
+	}
+	return entries, nil
+}
+
+// fsFile is a file opened from an ArchiveFS. Its data is opened on the first
+// read, and opened again to read before the current position.
+type fsFile struct {
+	fsys   *ArchiveFS
+	node   *fsNode
+	mu     sync.Mutex
+	data   io.ReadCloser
+	pos    int64 // position of data
+	offset int64 // position of Read and Seek
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.node, nil }
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at off, as io.ReaderAt describes.
+func (f *fsFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.node.name, Err: fs.ErrInvalid}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *fsFile) readAt(p []byte, off int64) (int, error) {
+	if off >= f.node.size {
+		return 0, io.EOF
+	}
+	_, seekable := f.data.(io.Seeker)
+	if f.data == nil || off < f.pos && !seekable {
+		if f.data != nil {
+			f.data.Close()
+		}
+		data, err := f.fsys.openData(f.node)
+		if err != nil {
+			f.data = nil
+			return 0, err
+		}
+		f.data, f.pos = data, 0
+	}
+	if seeker, ok := f.data.(io.Seeker); ok {
+		if _, err := seeker.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		f.pos = off
+	}
+	if off > f.pos {
+		skipped, err := io.CopyN(io.Discard, f.data, off-f.pos)
+		f.pos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(f.data, p)
+	f.pos += int64(n)
+	switch {
+	case off+int64(n) >= f.node.size && (err == nil || err == io.ErrUnexpectedEOF):
+		err = io.EOF
+	case err == io.EOF:
+		// The data ended before the size of the entry: it is truncated
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Seek sets the position of the next Read, as io.Seeker describes.
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.node.size
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.node.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *fsFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.data == nil {
+		return nil
+	}
+	err := f.data.Close()
+	f.data = nil
+	return err
+}
+
+var _ fs.ReadDirFS = (*ArchiveFS)(nil)
+var _ fs.StatFS = (*ArchiveFS)(nil)
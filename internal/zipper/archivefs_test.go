@@ -0,0 +1,82 @@
+package zipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveFSStoredReadsSeek(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "stored.zip")
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "data.bin", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	zw.Close()
+	f.Close()
+
+	fsys, err := OpenArchiveFS(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	file, err := fsys.Open("data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	ff := file.(*fsFile)
+
+	buf := make([]byte, 1000)
+	var opened io.ReadCloser
+	// Out of order reads, as FUSE readahead makes them
+	for _, off := range []int64{90000, 500, 50000, 0, 99500} {
+		n, err := ff.ReadAt(buf, off)
+		want := data[off:min(off+int64(len(buf)), int64(len(data)))]
+		if n != len(want) || !bytes.Equal(buf[:n], want) || err != nil && err != io.EOF {
+			t.Fatalf("ReadAt(%d) = %d, %v; want the %d bytes there", off, n, err, len(want))
+		}
+		if opened == nil {
+			opened = ff.data
+		} else if ff.data != opened {
+			t.Errorf("ReadAt(%d) reopened the stored entry", off)
+		}
+	}
+}
+
+func TestArchiveFSTruncatedEntry(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "truncated.zip")
+	data := make([]byte, 115000)
+	for i := range data {
+		data[i] = byte(i * i >> 7)
+	}
+	writeTruncatedZip(t, zipPath, data)
+
+	rc, err := ReadEntry(zipPath, "data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	// The deflate stream ends early, which must not pass for the end of
+	// the file
+	got, err := io.ReadAll(rc)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("reading a truncated entry = %d bytes, %v; want %v", len(got), err, io.ErrUnexpectedEOF)
+	}
+}
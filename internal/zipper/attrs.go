@@ -0,0 +1,24 @@
+package zipper
+
+// MS-DOS attribute bits stored in the low byte of a zip entry's external
+// attributes. Only the bits that are meaningful to round-trip are kept.
+const (
+	dosReadOnly = 0x01
+	dosHidden   = 0x02
+	dosSystem   = 0x04
+
+	dosAttributeMask = dosReadOnly | dosHidden | dosSystem
+)
+
+// restoreAttributes reapplies archived MS-DOS attributes to path. Failures
+// are reported as warnings for the entry name since the file contents were
+// extracted successfully.
+func restoreAttributes(path, name string, attrs uint32, warnings *warningList) {
+	attrs &= dosAttributeMask
+	if attrs == 0 {
+		return
+	}
+	if err := setFileAttributes(path, attrs); err != nil {
+		warnings.add(WarningAttributesDropped, name, "cannot restore attributes: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package zipper
+
+import "io/fs"
+
+// fileAttributes returns no attributes; MS-DOS attributes only exist on Windows.
+func fileAttributes(info fs.FileInfo) uint32 {
+	return 0
+}
+
+// setFileAttributes is a no-op; permissions are already restored from the file mode.
+func setFileAttributes(path string, attrs uint32) error {
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package zipper
+
+import (
+	"io/fs"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileAttributes returns the hidden, system and read-only attribute bits of info.
+func fileAttributes(info fs.FileInfo) uint32 {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return 0
+	}
+	return data.FileAttributes & dosAttributeMask
+}
+
+// setFileAttributes adds attrs to the existing attributes of path.
+func setFileAttributes(path string, attrs uint32) error {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	current, err := windows.GetFileAttributes(p)
+	if err != nil {
+		return err
+	}
+	if current&attrs == attrs {
+		return nil
+	}
+	return windows.SetFileAttributes(p, current|attrs)
+}
@@ -0,0 +1,244 @@
+package zipper
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// backupInfoName is the entry describing a backup archive: whether it is
+// incremental and which files were deleted since the previous backup.
+const backupInfoName = ".pzip-backup.json"
+
+type backupInfo struct {
+	Incremental bool     `json:"incremental"`
+	Deleted     []string `json:"deleted,omitempty"`
+}
+
+// snapshot records the state of a source tree at the time of a backup.
+type snapshot struct {
+	Created time.Time               `json:"created"`
+	Files   map[string]snapshotFile `json:"files"`
+}
+
+type snapshotFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// BackupStats describes a backup archive created by BackupWithProgress.
+type BackupStats struct {
+	ArchiveStats
+	Incremental bool     `json:"incremental"`       // only changed files were archived
+	Deleted     []string `json:"deleted,omitempty"` // files removed since the previous backup
+}
+
+// BackupWithProgress archives srcDir as part of a backup chain tracked by
+// the snapshot file at snapshotPath. Without a snapshot every file is
+// archived and the snapshot is created; otherwise only files that are new or
+// whose size or modification time changed are archived, along with the
+// names of deleted files. The snapshot is updated once the archive is
+// complete. RestoreBackup layers a chain of these archives back together.
+func BackupWithProgress(srcDir, zipPath, snapshotPath string, opts CreateOptions) (stats BackupStats, err error) {
+	log := logCreate(&opts, "backup", zipPath)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+
+	srcDir, zipPath, snapshotPath = longPath(srcDir), longPath(zipPath), longPath(snapshotPath)
+	prev, err := loadSnapshot(snapshotPath)
+	if err != nil {
+		return stats, err
+	}
+	stats.Incremental = prev != nil
+
+	skips := newSkipList(opts)
+	files, err := collectFiles(srcDir, "", opts, skips)
+	if err != nil {
+		return stats, err
+	}
+
+	next := &snapshot{Created: time.Now(), Files: make(map[string]snapshotFile)}
+	var jobs []fileJob
+	changed := make(map[string]fileJob)
+	current := make(map[string]bool)
+	for _, job := range files {
+		if job.isDir {
+			jobs = append(jobs, job)
+			continue
+		}
+		name := filepath.ToSlash(job.rel)
+		current[name] = true
+		if prev != nil {
+			if old, ok := prev.Files[name]; ok && old.Size == job.info.Size() && old.ModTime.Equal(job.info.ModTime()) {
+				next.Files[name] = old
+				stats.Unchanged++
+				continue
+			}
+		}
+		jobs = append(jobs, job)
+		changed[name] = job
+		stats.TotalBytes += job.info.Size()
+		stats.FileCount++
+	}
+	if prev != nil {
+		for name, old := range prev.Files {
+			switch {
+			case current[name]:
+			case skips.covers(name):
+				// Unreadable this time rather than deleted
+				next.Files[name] = old
+			default:
+				stats.Deleted = append(stats.Deleted, name)
+			}
+		}
+		sort.Strings(stats.Deleted)
+	}
+
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return stats, err
+	}
+	// A failed backup leaves neither a partial archive nor a snapshot that
+	// disagrees with the archives on disk
+	defer func() {
+		if err != nil {
+			zipFile.Close()
+			os.Remove(zipPath)
+		}
+	}()
+	writer := zip.NewWriter(zipFile)
+
+	// Digests are always computed since the snapshot records them
+	digests := newManifest()
+	if err := writeZipFiles(writer, jobs, &stats.ArchiveStats, filepath.Dir(zipPath), opts, skips, digests); err != nil {
+		return stats, err
+	}
+	skips.apply(&stats.ArchiveStats)
+	for _, name := range digests.names {
+		job := changed[name]
+		next.Files[name] = snapshotFile{Size: job.info.Size(), ModTime: job.info.ModTime(), SHA256: digests.digests[name]}
+	}
+
+	info, err := json.MarshalIndent(backupInfo{Incremental: stats.Incremental, Deleted: stats.Deleted}, "", "  ")
+	if err == nil {
+		var w io.Writer
+		if w, err = writer.CreateHeader(&zip.FileHeader{Name: backupInfoName, Method: zip.Deflate, Modified: next.Created}); err == nil {
+			_, err = w.Write(info)
+		}
+	}
+	if err == nil && opts.Manifest {
+		err = digests.writeZip(writer, next.Created)
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	if stats.Checksum, err = finishZip(writer, zipFile, opts.Comment); err != nil {
+		return stats, err
+	}
+	return stats, saveSnapshot(snapshotPath, next)
+}
+
+// loadSnapshot reads the snapshot at path, returning nil if it does not exist.
+func loadSnapshot(path string) (*snapshot, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// saveSnapshot replaces the snapshot at path.
+func saveSnapshot(path string, s *snapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := tempFileFor(path)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// RestoreBackup extracts a backup chain into destDir: a full backup followed
+// by its incremental backups in the order they were made. Later archives
+// overwrite earlier files and files recorded as deleted are removed.
+func RestoreBackup(archives []string, destDir string, opts ExtractOptions) (stats ExtractStats, err error) {
+	opts.Overwrite = OverwriteAlways
+	for i, archivePath := range archives {
+		info, err := readBackupInfo(archivePath)
+		if err != nil {
+			return stats, err
+		}
+		if i == 0 && info.Incremental {
+			return stats, fmt.Errorf("%s is an incremental backup; the chain must start with a full backup", filepath.Base(archivePath))
+		}
+
+		s, err := ExtractWithOptions(archivePath, destDir, opts)
+		if err != nil {
+			return stats, err
+		}
+		stats.TotalBytes += s.TotalBytes
+		stats.FileCount += s.FileCount
+		stats.Overwritten += s.Overwritten
+		stats.Warnings = append(stats.Warnings, s.Warnings...)
+
+		if err := os.Remove(filepath.Join(destDir, backupInfoName)); err != nil && !os.IsNotExist(err) {
+			return stats, err
+		}
+		for _, name := range info.Deleted {
+			if !filepath.IsLocal(name) {
+				return stats, fmt.Errorf("invalid file path: %s", name)
+			}
+			if err := os.Remove(filepath.Join(destDir, filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+				return stats, err
+			}
+		}
+	}
+	return stats, nil
+}
+
+// readBackupInfo reads the backup description stored in a backup archive.
+func readBackupInfo(zipPath string) (backupInfo, error) {
+	var info backupInfo
+	reader, err := zip.OpenReader(longPath(zipPath))
+	if err != nil {
+		return info, err
+	}
+	defer reader.Close()
+
+	rc, err := reader.Open(backupInfoName)
+	if errors.Is(err, os.ErrNotExist) {
+		return info, fmt.Errorf("%s is not a backup archive", filepath.Base(zipPath))
+	}
+	if err != nil {
+		return info, err
+	}
+	defer rc.Close()
+	err = json.NewDecoder(rc).Decode(&info)
+	return info, err
+}
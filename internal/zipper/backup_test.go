@@ -0,0 +1,48 @@
+package zipper
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFailedBackupLeavesNoArchive(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "full.zip")
+	snapshotPath := filepath.Join(dir, "backup.snapshot")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := BackupWithProgress(src, zipPath, snapshotPath, CreateOptions{Context: ctx}); err == nil {
+		t.Fatal("canceled backup succeeded")
+	}
+	for _, path := range []string{zipPath, snapshotPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind after a failed backup", filepath.Base(path))
+		}
+	}
+
+	// The snapshot is replaced without touching files named like a
+	// temporary copy of it
+	neighbour := snapshotPath + ".tmp"
+	if err := os.WriteFile(neighbour, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BackupWithProgress(src, zipPath, snapshotPath, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(neighbour); err != nil || string(data) != "keep me" {
+		t.Errorf("%s = %q, %v; want it left alone", neighbour, data, err)
+	}
+	if _, err := loadSnapshot(snapshotPath); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,91 @@
+package zipper
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxRatio is the default limit on the compression ratio
+// (uncompressed:compressed) of an entry or a whole archive. Real data rarely
+// compresses beyond 100:1, while deflate bombs reach about 1032:1; files
+// smaller than bombCheckMinSize are never checked, so small runs of zeros
+// still extract.
+const DefaultMaxRatio = 200
+
+// bombCheckMinSize is the expanded size below which ratios are not checked;
+// tiny highly compressible files are harmless whatever their ratio.
+const bombCheckMinSize = 64 << 20
+
+// ErrArchiveBomb is wrapped by errors returned when an archive expands beyond
+// its declared sizes or the allowed compression ratio.
+var ErrArchiveBomb = errors.New("possible decompression bomb")
+
+// maxRatioOrDefault resolves the MaxRatio option; zero means the default and
+// a negative value disables the check.
+func maxRatioOrDefault(ratio float64) float64 {
+	if ratio == 0 {
+		return DefaultMaxRatio
+	}
+	return ratio
+}
+
+// checkRatio reports an error if expanded bytes produced from compressed
+// bytes exceed maxRatio.
+func checkRatio(name string, expanded, compressed int64, maxRatio float64) error {
+	if maxRatio < 0 || expanded < bombCheckMinSize {
+		return nil
+	}
+	if compressed < 1 {
+		compressed = 1
+	}
+	if ratio := float64(expanded) / float64(compressed); ratio > maxRatio {
+		return fmt.Errorf("%w: %s expands to %d bytes from %d (ratio %.0f:1 exceeds %.0f:1)",
+			ErrArchiveBomb, name, expanded, compressed, ratio, maxRatio)
+	}
+	return nil
+}
+
+// checkZipEntryRatio checks the declared sizes of a zip entry.
+func checkZipEntryRatio(f *zip.File, maxRatio float64) error {
+	return checkRatio(f.Name, int64(f.UncompressedSize64), int64(f.CompressedSize64), maxRatio)
+}
+
+// declaredSizeReader fails once more than limit bytes have been read, so an
+// entry cannot expand beyond the uncompressed size declared in its header.
+type declaredSizeReader struct {
+	r     io.Reader
+	name  string
+	limit uint64
+	read  uint64
+}
+
+func (d *declaredSizeReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.read += uint64(n)
+	if d.read > d.limit {
+		return n, fmt.Errorf("%w: %s expands beyond its declared size of %d bytes", ErrArchiveBomb, d.name, d.limit)
+	}
+	return n, err
+}
+
+// ratioReader aborts once the total bytes expanded from an archive exceed
+// maxRatio times the compressed bytes consumed so far. It is used for
+// streamed formats where sizes are only known as the archive is read.
+type ratioReader struct {
+	r          io.Reader
+	name       string
+	expanded   *int64
+	compressed *int64
+	maxRatio   float64
+}
+
+func (rr *ratioReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	*rr.expanded += int64(n)
+	if cerr := checkRatio(rr.name, *rr.expanded, *rr.compressed, rr.maxRatio); cerr != nil {
+		return n, cerr
+	}
+	return n, err
+}
@@ -0,0 +1,164 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckRatio(t *testing.T) {
+	tests := []struct {
+		name                 string
+		expanded, compressed int64
+		maxRatio             float64
+		bomb                 bool
+	}{
+		{"small file at any ratio", bombCheckMinSize - 1, 1, DefaultMaxRatio, false},
+		{"large file under the ratio", 100 << 20, 1 << 20, DefaultMaxRatio, false},
+		{"large file at the ratio", 200 << 20, 1 << 20, DefaultMaxRatio, false},
+		{"large file over the ratio", 201 << 20, 1 << 20, DefaultMaxRatio, true},
+		{"flat deflate bomb", 10 << 30, 10 << 20, DefaultMaxRatio, true},
+		{"nothing compressed", bombCheckMinSize, 0, DefaultMaxRatio, true},
+		{"lower limit", 100 << 20, 1 << 20, 50, true},
+		{"disabled", 10 << 30, 1, -1, false},
+	}
+	for _, tt := range tests {
+		err := checkRatio("entry", tt.expanded, tt.compressed, tt.maxRatio)
+		if got := errors.Is(err, ErrArchiveBomb); got != tt.bomb {
+			t.Errorf("%s: checkRatio = %v; want bomb %v", tt.name, err, tt.bomb)
+		}
+	}
+}
+
+func TestMaxRatioOrDefault(t *testing.T) {
+	for _, tt := range []struct{ in, want float64 }{{0, DefaultMaxRatio}, {50, 50}, {-1, -1}} {
+		if got := maxRatioOrDefault(tt.in); got != tt.want {
+			t.Errorf("maxRatioOrDefault(%v) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDeclaredSizeReader(t *testing.T) {
+	data := make([]byte, 1000)
+	tests := []struct {
+		limit uint64
+		bomb  bool
+	}{
+		{1000, false},
+		{2000, false},
+		{999, true},
+		{0, true},
+	}
+	for _, tt := range tests {
+		r := &declaredSizeReader{r: bytes.NewReader(data), name: "entry", limit: tt.limit}
+		_, err := io.Copy(io.Discard, r)
+		if got := errors.Is(err, ErrArchiveBomb); got != tt.bomb {
+			t.Errorf("limit %d: copy = %v; want bomb %v", tt.limit, err, tt.bomb)
+		}
+	}
+}
+
+// bombSize is large enough for the ratio to be checked and compresses to
+// about a thousandth of its size.
+const bombSize = bombCheckMinSize + 16<<20
+
+func TestExtractZipBomb(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "bomb.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("zeros.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, bombSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	dest := filepath.Join(dir, "out")
+	if _, err := ExtractWithOptions(zipPath, dest, ExtractOptions{}); !errors.Is(err, ErrArchiveBomb) {
+		t.Fatalf("extract = %v; want %v", err, ErrArchiveBomb)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "zeros.bin")); err == nil {
+		t.Error("zeros.bin was written")
+	}
+}
+
+func TestExtractGzipBomb(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "bomb.tar.gz")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, _ := gzip.NewWriterLevel(f, gzip.BestCompression)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{Name: "zeros.bin", Mode: 0644, Size: bombSize}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(make([]byte, bombSize)); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gw.Close()
+	f.Close()
+
+	if _, err := ExtractGzipWithOptions(archivePath, filepath.Join(dir, "out"), ExtractOptions{}); !errors.Is(err, ErrArchiveBomb) {
+		t.Fatalf("extract = %v; want %v", err, ErrArchiveBomb)
+	}
+}
+
+func TestExtractZipDeclaredSizeOverrun(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "overrun.zip")
+	data := bytes.Repeat([]byte("overrun "), 1000)
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestSpeed)
+	fw.Write(data)
+	fw.Close()
+
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	// The header claims a tenth of the data the entry expands to
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "data.txt",
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: uint64(len(data) / 10),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(compressed.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	dest := filepath.Join(dir, "out")
+	if _, err := ExtractWithOptions(zipPath, dest, ExtractOptions{}); err == nil {
+		t.Fatal("extracting an entry larger than its declared size succeeded")
+	}
+	if info, err := os.Stat(filepath.Join(dest, "data.txt")); err == nil && info.Size() > int64(len(data)/10) {
+		t.Errorf("data.txt holds %d bytes; want at most the declared %d", info.Size(), len(data)/10)
+	}
+}
@@ -0,0 +1,79 @@
+package zipper
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultBufferSize is the default size of the buffers used to copy entry
+// data between archives and files.
+const DefaultBufferSize = 1 << 20
+
+// bufferPools holds a *sync.Pool of *[]byte per buffer size.
+var bufferPools sync.Map
+
+func getBuffer(size int) *[]byte {
+	pool, _ := bufferPools.LoadOrStore(size, &sync.Pool{
+		New: func() any {
+			b := make([]byte, size)
+			return &b
+		},
+	})
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+func putBuffer(b *[]byte) {
+	if pool, ok := bufferPools.Load(len(*b)); ok {
+		pool.(*sync.Pool).Put(b)
+	}
+}
+
+// bufferSizeOrDefault returns size, or DefaultBufferSize if size is not positive.
+func bufferSizeOrDefault(size int) int {
+	if size <= 0 {
+		return DefaultBufferSize
+	}
+	return size
+}
+
+// copyBuffered copies src to dst through a pooled buffer of bufferSize bytes.
+// The buffer is filled before each write, so the small reads returned by
+// decompressors turn into large sequential writes. Only io.EOF from src ends
+// the copy successfully; any other error, including io.ErrUnexpectedEOF
+// from a truncated stream, is returned.
+func copyBuffered(dst io.Writer, src io.Reader, bufferSize int) (int64, error) {
+	buf := getBuffer(bufferSizeOrDefault(bufferSize))
+	defer putBuffer(buf)
+
+	var written int64
+	for {
+		n, err := fillBuffer(src, *buf)
+		if n > 0 {
+			if _, werr := dst.Write((*buf)[:n]); werr != nil {
+				return written, werr
+			}
+			written += int64(n)
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// fillBuffer reads from src until buf is full or a read fails, returning
+// the error of the read as is. Unlike io.ReadFull it does not turn a short
+// read into io.ErrUnexpectedEOF, which would hide the same error from src.
+func fillBuffer(src io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := src.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,124 @@
+package zipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCopyBufferedErrors(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	var out bytes.Buffer
+	n, err := copyBuffered(&out, iotest.OneByteReader(bytes.NewReader(data)), 64)
+	if err != nil || n != int64(len(data)) || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("copy = %d, %v; want %d bytes and no error", n, err, len(data))
+	}
+
+	truncated := io.MultiReader(bytes.NewReader(data[:500]), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if n, err := copyBuffered(io.Discard, truncated, 64); err != io.ErrUnexpectedEOF || n != 500 {
+		t.Fatalf("copy of truncated stream = %d, %v; want 500, io.ErrUnexpectedEOF", n, err)
+	}
+}
+
+// writeTruncatedZip writes a zip whose single deflated entry stops halfway
+// through its data, while its CRC-32 and sizes describe the whole file.
+func writeTruncatedZip(t *testing.T, zipPath string, data []byte) {
+	t.Helper()
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestSpeed)
+	fw.Write(data)
+	fw.Close()
+	half := compressed.Bytes()[:compressed.Len()/2]
+
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "data.bin",
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(len(half)),
+		UncompressedSize64: uint64(len(data)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(half)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTruncatedEntryFails(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "truncated.zip")
+	data := make([]byte, 115000)
+	for i := range data {
+		data[i] = byte(i * i >> 7)
+	}
+	writeTruncatedZip(t, zipPath, data)
+
+	if _, err := ExtractWithOptions(zipPath, filepath.Join(dir, "out"), ExtractOptions{}); err == nil {
+		t.Error("extracting a truncated entry succeeded")
+	}
+
+	stats, err := CheckZip(zipPath, nil)
+	if err == nil {
+		t.Error("checking a truncated entry succeeded")
+	}
+	if len(stats.Entries) != 1 || stats.Entries[0].Err == nil {
+		t.Errorf("check entries = %+v; want data.bin failed", stats.Entries)
+	}
+}
+
+// benchmarkExtract extracts a zip of count files of size bytes each with
+// several buffer sizes.
+func benchmarkExtract(b *testing.B, count, size int) {
+	dir := b.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * i >> 9)
+	}
+	for i := 0; i < count; i++ {
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprintf("f%05d", i)), data, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	zipPath := filepath.Join(dir, "bench.zip")
+	if _, err := ZipWithOptions(src, zipPath, CreateOptions{}); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bufferSize := range []int{32 << 10, 256 << 10, DefaultBufferSize, 4 << 20} {
+		b.Run(fmt.Sprintf("buffer=%dK", bufferSize>>10), func(b *testing.B) {
+			b.SetBytes(int64(count * size))
+			for i := 0; i < b.N; i++ {
+				out := filepath.Join(dir, "out")
+				if _, err := ExtractWithOptions(zipPath, out, ExtractOptions{BufferSize: bufferSize}); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				os.RemoveAll(out)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkExtractManySmallFiles(b *testing.B) { benchmarkExtract(b, 2000, 4<<10) }
+
+func BenchmarkExtractFewHugeFiles(b *testing.B) { benchmarkExtract(b, 2, 64<<20) }
@@ -0,0 +1,171 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+// CheckResult is the outcome of testing a single archive entry.
+type CheckResult struct {
+	Name string
+	Size int64
+	Err  error // nil if the entry decompressed and matched its checksum
+}
+
+// CheckStats describes an archive tested by CheckZip or CheckGzip.
+type CheckStats struct {
+	TotalBytes int64
+	FileCount  int
+	Entries    []CheckResult // files in archive order
+	// Comment is the zip comment, without the checksum line.
+	Comment string
+}
+
+// Failed returns the entries that did not pass.
+func (s CheckStats) Failed() []CheckResult {
+	var failed []CheckResult
+	for _, e := range s.Entries {
+		if e.Err != nil {
+			failed = append(failed, e)
+		}
+	}
+	return failed
+}
+
+// CheckZip reads and checksums every file in a zip archive without writing
+// anything to disk. Failed entries are recorded in the returned stats and
+// reported together with a *ChecksumError. Legacy entry names are decoded
+// as under NameEncodingAuto.
+func CheckZip(zipPath string, progress ProgressFunc) (stats CheckStats, err error) {
+	zipPath = longPath(zipPath)
+	archive, err := openArchive(zipPath)
+	if err != nil {
+		return stats, err
+	}
+	defer archive.Close()
+
+	reader, err := zip.NewReader(archive, archive.Size())
+	if err != nil {
+		return stats, err
+	}
+	stats.Comment, _ = splitZipComment(reader.Comment)
+	decodeZipNames(reader.File, NameEncodingAuto)
+
+	var files []*zip.File
+	for _, f := range reader.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, f)
+			stats.TotalBytes += int64(f.UncompressedSize64)
+		}
+	}
+	stats.FileCount = len(files)
+	stats.Entries = make([]CheckResult, len(files))
+
+	tracker := newProgressTracker(withoutFile(progress), nil, DefaultProgressInterval, stats.TotalBytes, len(files))
+	tracker.update()
+
+	jobChan := make(chan int, len(files))
+	for i := range files {
+		jobChan <- i
+	}
+	close(jobChan)
+
+	var wg sync.WaitGroup
+	for i := 0; i < WorkerCount(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobChan {
+				f := files[i]
+				n, err := checkZipFile(f)
+				stats.Entries[i] = CheckResult{Name: f.Name, Size: n, Err: err}
+				tracker.advance(n)
+			}
+		}()
+	}
+	wg.Wait()
+	tracker.update()
+
+	if failed := stats.Failed(); len(failed) > 0 {
+		names := make([]string, len(failed))
+		for i, e := range failed {
+			names[i] = e.Name
+		}
+		return stats, &ChecksumError{Archive: filepath.Base(zipPath), Entries: names}
+	}
+	return stats, nil
+}
+
+// checkZipFile decompresses f, letting archive/zip verify its CRC-32.
+func checkZipFile(f *zip.File) (int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	src := &declaredSizeReader{r: rc, name: f.Name, limit: f.UncompressedSize64}
+	return copyBuffered(io.Discard, src, 0)
+}
+
+// CheckGzip reads every entry of a tar.gz archive without writing anything
+// to disk and verifies the CRC-32 of the gzip stream. Progress is measured
+// in compressed bytes, as for ExtractGzipWithOptions. Since the stream
+// cannot be read past damaged data, the first failure ends the check.
+func CheckGzip(gzipPath string, progress ProgressFunc) (stats CheckStats, err error) {
+	gzipPath = longPath(gzipPath)
+	archive, err := openArchive(gzipPath)
+	if err != nil {
+		return stats, err
+	}
+	defer archive.Close()
+
+	totalBytes := archive.Size()
+	tracker := newProgressTracker(withoutFile(progress), nil, DefaultProgressInterval, totalBytes, 0)
+	counted := &countingReader{r: archive.reader(), onRead: tracker.advance}
+
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(counted, 256<<10))
+	if err != nil {
+		return stats, err
+	}
+	defer gzReader.Close()
+
+	tarReader := tar.NewReader(gzReader)
+	tracker.update()
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, gzipStreamError(gzipPath, err)
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeGNUSparse {
+			continue
+		}
+
+		n, err := copyBuffered(io.Discard, tarReader, 0)
+		stats.Entries = append(stats.Entries, CheckResult{Name: header.Name, Size: n, Err: err})
+		if err != nil {
+			if isCorruptData(err) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return stats, &ChecksumError{Archive: filepath.Base(gzipPath), Entries: []string{header.Name}}
+			}
+			return stats, err
+		}
+		stats.TotalBytes += n
+		stats.FileCount++
+	}
+
+	if _, err := io.Copy(io.Discard, gzReader); err != nil && !errors.Is(err, gzip.ErrHeader) {
+		return stats, gzipStreamError(gzipPath, err)
+	}
+
+	tracker.setDone(totalBytes)
+	return stats, nil
+}
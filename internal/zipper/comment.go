@@ -0,0 +1,47 @@
+package zipper
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"strings"
+)
+
+// checksumPrefix starts the line of the zip comment holding the archive's
+// SHA-256 checksum.
+const checksumPrefix = "SHA256: "
+
+// MaxCommentLength is the longest CreateOptions.Comment, in bytes, that
+// fits in a zip comment alongside the checksum line.
+const MaxCommentLength = 0xFFFF - len("\n"+checksumPrefix) - sha256.Size*2
+
+// ZipComment returns the comment of the zip archive at zipPath, without the
+// checksum line pz adds to it.
+func ZipComment(zipPath string) (string, error) {
+	reader, err := zip.OpenReader(longPath(zipPath))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	comment, _ := splitZipComment(reader.Comment)
+	return comment, nil
+}
+
+// joinZipComment returns the zip comment holding comment and checksum, the
+// checksum on the last line.
+func joinZipComment(comment, checksum string) string {
+	if comment == "" {
+		return checksumPrefix + checksum
+	}
+	return comment + "\n" + checksumPrefix + checksum
+}
+
+// splitZipComment separates a zip comment into the comment given by the
+// user and the checksum stored on its last line, if there is one.
+func splitZipComment(raw string) (comment, checksum string) {
+	i := strings.LastIndexByte(raw, '\n')
+	last := raw[i+1:]
+	if !strings.HasPrefix(last, checksumPrefix) {
+		return raw, ""
+	}
+	return strings.TrimSuffix(raw[:max(i, 0)], "\r"), strings.TrimPrefix(last, checksumPrefix)
+}
@@ -0,0 +1,260 @@
+package zipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+)
+
+// flateWriterPools caches deflate compressors per level (HuffmanOnly through
+// BestCompression); they are expensive to allocate for many small files.
+var flateWriterPools [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+
+func getFlateWriter(w io.Writer, level int) (*flate.Writer, error) {
+	if fw, ok := flateWriterPools[level-flate.HuffmanOnly].Get().(*flate.Writer); ok {
+		fw.Reset(w)
+		return fw, nil
+	}
+	return flate.NewWriter(w, level)
+}
+
+func putFlateWriter(fw *flate.Writer, level int) {
+	flateWriterPools[level-flate.HuffmanOnly].Put(fw)
+}
+
+// Files are sampled to tell whether they are worth deflating: sampleSize
+// bytes from the start, and only when there are at least minSampleSize.
+// Samples with at least minStoreEntropy bits of information per byte are
+// deflated on trial and stored when that saves less than 1/storeMargin.
+const (
+	sampleSize      = 64 << 10
+	minSampleSize   = 4 << 10
+	minStoreEntropy = 7.5
+	storeMargin     = 32
+)
+
+// incompressible reports whether sample, the start of a file, looks already
+// compressed or encrypted. Byte entropy close to that of random data
+// rules most files out cheaply; the trial deflate catches data such as
+// repeated runs of varied bytes, whose entropy is high but which compresses
+// well.
+func incompressible(sample []byte) bool {
+	if len(sample) < minSampleSize {
+		return false
+	}
+	var counts [256]int
+	for _, b := range sample {
+		counts[b]++
+	}
+	entropy := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(len(sample))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	if entropy < minStoreEntropy {
+		return false
+	}
+
+	out := &countingWriter{w: io.Discard}
+	fw, err := getFlateWriter(out, flate.BestSpeed)
+	if err != nil {
+		return false
+	}
+	_, err = fw.Write(sample)
+	if err == nil {
+		err = fw.Close()
+	}
+	putFlateWriter(fw, flate.BestSpeed)
+	n := int64(len(sample))
+	return err == nil && out.n > n-n/storeMargin
+}
+
+// sampleMethod returns the compression method for a file of the given size
+// read from src: Store for names getCompressionMethod marks as compressed,
+// or for content that looks it. The sample read is put back in front of the
+// returned reader.
+func sampleMethod(name string, size int64, src io.Reader) (uint16, io.Reader, error) {
+	method := getCompressionMethod(name)
+	if method == zip.Store || size < minSampleSize {
+		return method, src, nil
+	}
+	sample := make([]byte, min(size, sampleSize))
+	n, err := io.ReadFull(src, sample)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return method, src, err
+	}
+	sample = sample[:n]
+	if incompressible(sample) {
+		method = zip.Store
+	}
+	return method, io.MultiReader(bytes.NewReader(sample), src), nil
+}
+
+// zipLoader returns a fileLoader that compresses each file on the worker
+// goroutine, computing its CRC-32 along the way, so the writer only has to
+// append raw entries. Compressed data stays in memory when the file fits the
+// inline limit and is spilled to a temporary file in spillDir otherwise.
+// Source files are read through buffers of bufferSize bytes at the pace rate
+// allows, and tracker is told of the bytes read and of the compressed size of
+// each file once done.
+// When digest is set the SHA-256 of each file is computed as well. Level
+// flate.NoCompression stores every file. Reading stops with an error once
+// ctx is done.
+func zipLoader(ctx context.Context, level int, spillDir string, bufferSize int, digest bool, rate *rateLimiter, tracker *progressTracker) fileLoader {
+	return func(p *readPipeline, fd *fileData) error {
+		f, err := os.Open(fd.job.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		size := info.Size()
+
+		fd.compressed = true
+
+		var dst io.Writer
+		var buf *bytes.Buffer
+		// Deflate may expand incompressible data by a few bytes per block,
+		// so that is what must fit the inline limit
+		reserve := size + size/1024 + 64
+		if reserve <= p.inlineLimit {
+			if !p.budget.acquire(reserve) {
+				return errPipelineStopped
+			}
+			fd.held = reserve
+			buf = bytes.NewBuffer(make([]byte, 0, reserve))
+			dst = buf
+		} else {
+			fd.spill, err = os.CreateTemp(spillDir, ".pzip-spill-*")
+			if err != nil {
+				return err
+			}
+			dst = fd.spill
+		}
+
+		crc := crc32.NewIEEE()
+		var sum hash.Hash
+		var hashes io.Writer = crc
+		if digest {
+			sum = sha256.New()
+			hashes = io.MultiWriter(crc, sum)
+		}
+		counted := &countingReader{r: contextReader{ctx: ctx, r: rate.reader(f)}, onRead: func(n int64) {
+			tracker.read(filepath.ToSlash(fd.job.rel), size, n)
+		}}
+		var src io.Reader = io.TeeReader(counted, hashes)
+		if level == flate.NoCompression {
+			fd.method = zip.Store
+		} else {
+			fd.method, src, err = sampleMethod(fd.job.path, size, src)
+		}
+		out := &countingWriter{w: dst}
+		switch {
+		case err != nil:
+		case fd.method == zip.Store:
+			fd.rawSize, err = copyBuffered(out, src, bufferSize)
+		default:
+			var fw *flate.Writer
+			if fw, err = getFlateWriter(out, level); err == nil {
+				fd.rawSize, err = copyBuffered(fw, src, bufferSize)
+				if err == nil {
+					err = fw.Close()
+				}
+				putFlateWriter(fw, level)
+			}
+		}
+		if err == nil && fd.rawSize < size {
+			err = shrankError(fd.job.path, size, fd.rawSize)
+		}
+		if err == nil && fd.spill != nil {
+			_, err = fd.spill.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			p.release(*fd)
+			fd.held, fd.spill = 0, nil
+			return err
+		}
+		fd.crc32 = crc.Sum32()
+		if sum != nil {
+			fd.sha256 = sum.Sum(nil)
+		}
+		fd.compressedSize = out.n
+		tracker.wrote(out.n)
+
+		if buf != nil {
+			fd.data = buf.Bytes()
+			if int64(len(fd.data)) < fd.held/2 {
+				// Well compressed; keep a right-sized copy and return the rest
+				fd.data = append([]byte(nil), fd.data...)
+				p.budget.release(fd.held - int64(cap(fd.data)))
+				fd.held = int64(cap(fd.data))
+			}
+		}
+		return nil
+	}
+}
+
+// prepareRawHeader fills in the header fields that CreateHeader would set but
+// CreateRaw leaves to the caller: the UTF-8 name flag, the format versions and
+// the extended timestamp extra field carrying the exact modification time.
+func prepareRawHeader(fh *zip.FileHeader) {
+	if !isASCII(fh.Name) && utf8.ValidString(fh.Name) {
+		fh.Flags |= 0x800
+	}
+	fh.CreatorVersion = fh.CreatorVersion&0xff00 | 20
+	fh.ReaderVersion = 20
+	if !fh.Modified.IsZero() {
+		var extra [9]byte
+		binary.LittleEndian.PutUint16(extra[0:], 0x5455) // extended timestamp
+		binary.LittleEndian.PutUint16(extra[2:], 5)
+		extra[4] = 1 // modification time only
+		binary.LittleEndian.PutUint32(extra[5:], uint32(fh.Modified.Unix()))
+		fh.Extra = append(fh.Extra, extra[:]...)
+	}
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// compressedReader returns the compressed data of an entry prepared by zipLoader.
+func (fd fileData) compressedReader() io.Reader {
+	if fd.spill != nil {
+		return fd.spill
+	}
+	return bytes.NewReader(fd.data)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,50 @@
+package zipper
+
+import (
+	"archive/zip"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestZipFileNearMemoryLimit zips a file just under the inline limit of a
+// single worker, whose deflate reserve exceeds the whole memory budget.
+func TestZipFileNearMemoryLimit(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	const maxMemory = 1 << 20
+	data := make([]byte, maxMemory-100)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := os.WriteFile(filepath.Join(src, "big.bin"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	zipPath := filepath.Join(dir, "out.zip")
+	done := make(chan error, 1)
+	go func() {
+		_, err := ZipWithOptions(src, zipPath, CreateOptions{Workers: 1, MaxMemory: maxMemory})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("zipping a file near the memory limit hung")
+	}
+
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.File) != 1 || r.File[0].UncompressedSize64 != uint64(len(data)) {
+		t.Fatalf("archive holds %d entries; want big.bin of %d bytes", len(r.File), len(data))
+	}
+}
@@ -0,0 +1,19 @@
+package zipper
+
+// ConvertArchive writes the entries of the zip or tar.gz archive at src to a
+// new archive at dst, a tar.gz when its name ends in .tar.gz, .tgz or .gz
+// and a zip when it ends in .zip, streaming them from one to the other
+// without extracting to disk. Other names of dst are rejected. Paths, modes,
+// modification times and links are kept, as are the manifest and the zip
+// comment, unless opts.Comment gives another; entries are recompressed at
+// opts.Level unless both archives are zips. Context, the progress callbacks,
+// OnEntry, Store, Workers and BufferSize apply as when creating an archive.
+func ConvertArchive(src, dst string, opts CreateOptions) (stats ArchiveStats, err error) {
+	log := logCreate(&opts, "convert", dst)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+
+	if opts.Comment == "" && !isGzipName(src) && !isGzipName(dst) {
+		opts.Comment, _ = ZipComment(src)
+	}
+	return mergeArchives([]string{src}, dst, MergeOptions{CreateOptions: opts}, true)
+}
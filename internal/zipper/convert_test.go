@@ -0,0 +1,41 @@
+package zipper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertRejectsUnknownFormat(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "in.zip")
+	if _, err := ZipWithOptions(src, zipPath, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"out.tar.zst", "out.tar", "out.tar.bz2", "out"} {
+		out := filepath.Join(dir, name)
+		if _, err := ConvertArchive(zipPath, out, CreateOptions{}); err == nil {
+			t.Errorf("converting to %s succeeded", name)
+		}
+		if _, err := MergeArchives([]string{zipPath, zipPath}, out, MergeOptions{}); err == nil {
+			t.Errorf("merging to %s succeeded", name)
+		}
+		if _, err := os.Stat(out); err == nil {
+			t.Errorf("%s was written", name)
+		}
+	}
+
+	for _, name := range []string{"out.tar.gz", "out.tgz", "out.zip"} {
+		if _, err := ConvertArchive(zipPath, filepath.Join(dir, name), CreateOptions{}); err != nil {
+			t.Errorf("converting to %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package zipper
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ChecksumError is returned when extracted data fails its CRC-32 check.
+// Extraction carries on past corrupt zip entries, so the error is returned
+// alongside complete ExtractStats once every entry has been processed.
+type ChecksumError struct {
+	Archive string
+	// Entries lists the corrupt entries. It is empty for tar.gz archives,
+	// whose single CRC-32 covers the whole gzip stream.
+	Entries []string
+}
+
+func (e *ChecksumError) Error() string {
+	if len(e.Entries) == 0 {
+		return fmt.Sprintf("checksum mismatch in %s", e.Archive)
+	}
+	return fmt.Sprintf("checksum mismatch in %s: %d corrupt entries: %s",
+		e.Archive, len(e.Entries), strings.Join(e.Entries, ", "))
+}
+
+// isCorruptData reports whether err came from decompressing damaged data
+// rather than from reading the archive or writing the output.
+func isCorruptData(err error) bool {
+	var flateErr flate.CorruptInputError
+	return errors.Is(err, zip.ErrChecksum) || errors.Is(err, gzip.ErrChecksum) || errors.As(err, &flateErr)
+}
+
+// gzipStreamError converts a gzip checksum failure while reading gzipPath
+// into a *ChecksumError.
+func gzipStreamError(gzipPath string, err error) error {
+	if errors.Is(err, gzip.ErrChecksum) {
+		return &ChecksumError{Archive: filepath.Base(gzipPath)}
+	}
+	return err
+}
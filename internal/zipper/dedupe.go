@@ -0,0 +1,211 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// duplicateSet records the files left out of an archive's data because an
+// earlier file has the same content.
+type duplicateSet struct {
+	copies map[string][]fileJob // entry name of the first file -> later files
+	sums   map[string][]byte    // SHA-256 of each file hashed, by entry name
+}
+
+// copiesOf returns the files with the same content as job, if any.
+func (d *duplicateSet) copiesOf(job fileJob) []fileJob {
+	if d == nil {
+		return nil
+	}
+	return d.copies[job.rel]
+}
+
+// findDuplicates hashes the files that share their size with another and
+// returns files without those whose content matches an earlier file's,
+// along with the set of them. Empty files and files that cannot be read are
+// kept as they are; the latter fail or are skipped when archived.
+func findDuplicates(ctx context.Context, files []fileJob, workers int) ([]fileJob, *duplicateSet, error) {
+	bySize := make(map[int64][]int)
+	for i, job := range files {
+		if !job.isDir && job.info.Mode().IsRegular() && job.info.Size() > 0 {
+			bySize[job.info.Size()] = append(bySize[job.info.Size()], i)
+		}
+	}
+	var candidates []int
+	for _, group := range bySize {
+		if len(group) > 1 {
+			candidates = append(candidates, group...)
+		}
+	}
+
+	// Hash the candidates in parallel
+	sums := make([][]byte, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(candidates)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() == nil {
+					sums[i], _ = sumFile(files[i].path)
+				}
+			}
+		}()
+	}
+	for _, i := range candidates {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	d := &duplicateSet{copies: make(map[string][]fileJob), sums: make(map[string][]byte)}
+	first := make(map[string]string) // SHA-256 -> entry name of the first file
+	unique := make([]fileJob, 0, len(files))
+	for i, job := range files {
+		if sums[i] == nil {
+			unique = append(unique, job)
+			continue
+		}
+		d.sums[job.rel] = sums[i]
+		if original, ok := first[string(sums[i])]; ok {
+			d.copies[original] = append(d.copies[original], job)
+			continue
+		}
+		first[string(sums[i])] = job.rel
+		unique = append(unique, job)
+	}
+	return unique, d, nil
+}
+
+// writeZipCopies writes the files with the same content as the entry just
+// written from fd, reusing its compressed data instead of compressing them
+// again.
+func writeZipCopies(writer *zip.Writer, fd fileData, copies []fileJob, stats *ArchiveStats, tracker *progressTracker, opts CreateOptions, digests *manifest) error {
+	for _, job := range copies {
+		header, err := zip.FileInfoHeader(job.info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(job.rel)
+		header.ExternalAttrs |= fileAttributes(job.info)
+		normalizeZipHeader(header, opts)
+		header.Method = fd.method
+		header.CRC32 = fd.crc32
+		header.UncompressedSize64 = uint64(fd.rawSize)
+		header.CompressedSize64 = uint64(fd.compressedSize)
+		prepareRawHeader(header)
+
+		src := io.Reader(bytes.NewReader(fd.data))
+		if fd.spill != nil {
+			if _, err := fd.spill.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			src = fd.spill
+		}
+		w, err := writer.CreateRaw(header)
+		if err == nil {
+			_, err = io.Copy(w, src)
+		}
+		if err != nil {
+			return err
+		}
+		if digests != nil {
+			digests.add(header.Name, fd.sha256)
+		}
+		countCopy(header.Name, fd.rawSize, fd.compressedSize, fd.method, stats, tracker, opts)
+	}
+	return nil
+}
+
+// writeTarLinks writes the files with the same content as the entry target
+// as hard links to it.
+func writeTarLinks(tw *tar.Writer, target string, copies []fileJob, sum []byte, stats *ArchiveStats, tracker *progressTracker, opts CreateOptions, digests *manifest) error {
+	for _, job := range copies {
+		header, err := tar.FileInfoHeader(job.info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(job.rel)
+		normalizeTarHeader(header, opts)
+		header.Typeflag = tar.TypeLink
+		header.Linkname = target
+		header.Size = 0
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if digests != nil {
+			digests.add(header.Name, sum)
+		}
+		countCopy(header.Name, job.info.Size(), -1, 0, stats, tracker, opts)
+	}
+	return nil
+}
+
+// countCopy records a duplicate written to an archive in stats and
+// progress, and reports it to OnEntry.
+func countCopy(name string, size, compressedSize int64, method uint16, stats *ArchiveStats, tracker *progressTracker, opts CreateOptions) {
+	stats.Deduplicated++
+	stats.DeduplicatedBytes += size
+	tracker.read(name, size, size)
+	if compressedSize > 0 {
+		tracker.wrote(compressedSize)
+	}
+	tracker.fileDone(name)
+	if opts.OnEntry != nil {
+		tracker.locked(func() {
+			opts.OnEntry(EntryEvent{Name: name, Size: size, CompressedSize: compressedSize, Method: method})
+		})
+	}
+}
+
+// skipCopies passes the files with the same content as a file that failed
+// to load to skips, since their data was never read.
+func skipCopies(tracker *progressTracker, skips *skipList, copies []fileJob, cause error) (err error) {
+	for _, job := range copies {
+		if err = skipFile(tracker, skips, fileData{job: job, err: cause}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractedFile is a file written while extracting a tar archive, which
+// later hard link entries may copy.
+type extractedFile struct {
+	path string
+	size int64
+}
+
+// copyExtracted writes destPath as a copy of src, a file already extracted,
+// for a hard link entry, at the pace rate allows. Copies rather than links
+// keep the files independent and work on file systems without hard links.
+func copyExtracted(ctx context.Context, src, destPath string, mode fs.FileMode, rate *rateLimiter, bufferSize int) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := copyBuffered(out, contextReader{ctx: ctx, r: rate.reader(in)}, bufferSize); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
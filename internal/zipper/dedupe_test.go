@@ -0,0 +1,163 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeDedupeSource writes a folder where b.txt and sub/c.txt repeat a.txt,
+// d.txt differs from them at the same size, and two files are empty.
+func writeDedupeSource(t *testing.T, dir string) string {
+	t.Helper()
+	src := filepath.Join(dir, "src")
+	files := map[string]string{
+		"a.txt": "same content", "b.txt": "same content", "sub/c.txt": "same content",
+		"d.txt": "SAME CONTENT", "e.txt": "", "f.txt": "",
+	}
+	for name, data := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src
+}
+
+func TestFindDuplicates(t *testing.T) {
+	src := writeDedupeSource(t, t.TempDir())
+	files, err := collectFiles(src, "", CreateOptions{}, newSkipList(CreateOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unique, duplicates, err := findDuplicates(context.Background(), files, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kept []string
+	for _, job := range unique {
+		if !job.isDir {
+			kept = append(kept, filepath.ToSlash(job.rel))
+		}
+	}
+	if want := []string{"a.txt", "d.txt", "e.txt", "f.txt"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v; want %v", kept, want)
+	}
+	var copies []string
+	for _, job := range unique {
+		for _, c := range duplicates.copiesOf(job) {
+			copies = append(copies, filepath.ToSlash(job.rel)+" <- "+filepath.ToSlash(c.rel))
+		}
+	}
+	if want := []string{"a.txt <- b.txt", "a.txt <- sub/c.txt"}; !reflect.DeepEqual(copies, want) {
+		t.Errorf("copies %v; want %v", copies, want)
+	}
+}
+
+func TestDedupeTarHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	src := writeDedupeSource(t, dir)
+	gzPath := filepath.Join(dir, "out.tar.gz")
+	stats, err := GzipWithOptions(src, gzPath, CreateOptions{Dedupe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Deduplicated != 2 || stats.DeduplicatedBytes != 2*int64(len("same content")) {
+		t.Errorf("deduplicated %d files of %d bytes; want 2 of %d", stats.Deduplicated, stats.DeduplicatedBytes, 2*len("same content"))
+	}
+
+	f, err := os.Open(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := make(map[string]string)
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeLink {
+			links[header.Name] = header.Linkname
+		}
+	}
+	if want := map[string]string{"b.txt": "a.txt", "sub/c.txt": "a.txt"}; !reflect.DeepEqual(links, want) {
+		t.Errorf("hard links %v; want %v", links, want)
+	}
+
+	dest := filepath.Join(dir, "out")
+	if _, err := ExtractGzipWithOptions(gzPath, dest, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b.txt", "sub/c.txt"} {
+		if data, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); err != nil || string(data) != "same content" {
+			t.Errorf("%s extracted as %q, %v", name, data, err)
+		}
+	}
+}
+
+func TestDedupeZipCopies(t *testing.T) {
+	dir := t.TempDir()
+	src := writeDedupeSource(t, dir)
+	zipPath := filepath.Join(dir, "out.zip")
+	stats, err := ZipWithOptions(src, zipPath, CreateOptions{Dedupe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Deduplicated != 2 {
+		t.Errorf("deduplicated %d files; want 2", stats.Deduplicated)
+	}
+
+	// Zip has no links, so each copy is a full entry with the original's
+	// compressed data
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	entries := make(map[string]*zip.File)
+	for _, f := range reader.File {
+		entries[f.Name] = f
+	}
+	original := entries["a.txt"]
+	if original == nil {
+		t.Fatal("a.txt not archived")
+	}
+	for _, name := range []string{"b.txt", "sub/c.txt"} {
+		f := entries[name]
+		if f == nil {
+			t.Errorf("%s not archived", name)
+			continue
+		}
+		if f.Method != original.Method || f.CRC32 != original.CRC32 || f.CompressedSize64 != original.CompressedSize64 {
+			t.Errorf("%s: method %d, CRC %08x, %d bytes; want those of a.txt", name, f.Method, f.CRC32, f.CompressedSize64)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(data) != "same content" {
+			t.Errorf("%s reads as %q, %v", name, data, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package zipper
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// Destination receives an archive as it is written, for archives sent
+// somewhere other than a local file, such as object storage. Writes are
+// made sequentially; a slow Destination slows archiving, so progress
+// reflects the upload.
+type Destination interface {
+	io.Writer
+	// Close completes the archive once all of it has been written.
+	Close() error
+	// Abort discards what was written when archiving fails, including
+	// when Close fails.
+	Abort() error
+}
+
+// ZipToDestination creates a zip archive and streams it to dest. A single
+// source folder is archived by its contents, as by ZipWithOptions, and
+// several sources each under their own name, as by ZipSourcesWithOptions.
+// Large compressed files spill to the system temporary folder. The
+// checksum is that of the archive sent; it is not stored in the zip
+// comment, which would need the archive rewritten, but opts.Comment is.
+func ZipToDestination(sources []string, dest Destination, opts CreateOptions) (stats ArchiveStats, err error) {
+	skips := newSkipList(opts)
+	files, err := collectAll(sources, opts, skips)
+	if err != nil {
+		dest.Abort()
+		return stats, err
+	}
+	stats = sourceStats(files)
+
+	out := newDestinationWriter(dest)
+	writer := zip.NewWriter(out)
+	err = writer.SetComment(opts.Comment)
+	if err == nil {
+		err = writeZipArchive(writer, files, &stats, "", opts, skips)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	return stats, out.finish(&stats, err)
+}
+
+// GzipToDestination creates a tar.gz archive of sources, chosen as by
+// ZipToDestination, and streams it to dest.
+func GzipToDestination(sources []string, dest Destination, opts CreateOptions) (stats ArchiveStats, err error) {
+	skips := newSkipList(opts)
+	files, err := collectAll(sources, opts, skips)
+	if err != nil {
+		dest.Abort()
+		return stats, err
+	}
+	stats = sourceStats(files)
+
+	level, err := compressionLevel(opts, stats.TotalBytes)
+	if err != nil {
+		dest.Abort()
+		return stats, err
+	}
+	out := newDestinationWriter(dest)
+	err = writeGzipArchive(out, files, &stats, level, opts, skips)
+	return stats, out.finish(&stats, err)
+}
+
+// collectAll collects a single source as collectRoot does, and several as
+// collectSources does.
+func collectAll(sources []string, opts CreateOptions, skips *skipList) ([]fileJob, error) {
+	if len(sources) == 1 {
+		return collectRoot(longPath(sources[0]), opts, skips)
+	}
+	return collectSources(sources, opts, skips)
+}
+
+// destinationWriter counts and hashes the bytes written to a Destination.
+type destinationWriter struct {
+	dest Destination
+	hash hash.Hash
+	size int64
+}
+
+func newDestinationWriter(dest Destination) *destinationWriter {
+	return &destinationWriter{dest: dest, hash: sha256.New()}
+}
+
+func (w *destinationWriter) Write(p []byte) (int, error) {
+	n, err := w.dest.Write(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+// finish closes the destination, or aborts it when err is set or closing
+// fails, and records the archive's size and checksum in stats. An upload
+// left neither completed nor aborted would keep its parts stored.
+func (w *destinationWriter) finish(stats *ArchiveStats, err error) error {
+	if err != nil {
+		w.dest.Abort()
+		return err
+	}
+	if err := w.dest.Close(); err != nil {
+		w.dest.Abort()
+		return err
+	}
+	stats.ArchiveSize = w.size
+	stats.Checksum = hex.EncodeToString(w.hash.Sum(nil))
+	return nil
+}
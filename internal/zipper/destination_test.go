@@ -0,0 +1,56 @@
+package zipper
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// recordingDestination keeps what is written and how the upload ended.
+type recordingDestination struct {
+	bytes.Buffer
+	closeErr error
+	closed   bool
+	aborted  bool
+}
+
+func (d *recordingDestination) Close() error {
+	d.closed = true
+	return d.closeErr
+}
+
+func (d *recordingDestination) Abort() error {
+	d.aborted = true
+	return nil
+}
+
+func TestDestinationAbortedWhenCloseFails(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	errComplete := errors.New("complete upload failed")
+	for name, create := range map[string]func([]string, Destination, CreateOptions) (ArchiveStats, error){
+		"zip":    ZipToDestination,
+		"tar.gz": GzipToDestination,
+	} {
+		ok := &recordingDestination{}
+		if _, err := create([]string{src}, ok, CreateOptions{}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !ok.closed || ok.aborted || ok.Len() == 0 {
+			t.Errorf("%s: closed %v, aborted %v, %d bytes; want a completed upload", name, ok.closed, ok.aborted, ok.Len())
+		}
+
+		failing := &recordingDestination{closeErr: errComplete}
+		if _, err := create([]string{src}, failing, CreateOptions{}); !errors.Is(err, errComplete) {
+			t.Errorf("%s: create = %v; want %v", name, err, errComplete)
+		}
+		if !failing.aborted {
+			t.Errorf("%s: upload not aborted after Close failed", name)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiffOptions configures DiffWithOptions.
+type DiffOptions struct {
+	// Context cancels the comparison when done; nil never cancels.
+	Context context.Context
+	// Hash compares the contents of files of the same size by CRC-32,
+	// reading every such file, instead of comparing modification times.
+	Hash bool
+	// Exclude, SkipHidden and SkipJunk leave out files as for
+	// CreateOptions, on both sides of the comparison.
+	Exclude    []string
+	SkipHidden bool
+	SkipJunk   bool
+}
+
+// DiffReport lists the files that differ between an archive and a folder
+// or another archive, by entry name in sorted order.
+type DiffReport struct {
+	// Added lists files only in the folder or second archive.
+	Added []string `json:"added,omitempty"`
+	// Removed lists files only in the archive.
+	Removed []string `json:"removed,omitempty"`
+	// Modified lists files on both sides whose size, or modification time
+	// or contents under DiffOptions.Hash, differ.
+	Modified []string `json:"modified,omitempty"`
+	// Unchanged counts the files that match.
+	Unchanged int `json:"unchanged"`
+}
+
+// Changed reports whether any file differs.
+func (r DiffReport) Changed() bool {
+	return len(r.Added) > 0 || len(r.Removed) > 0 || len(r.Modified) > 0
+}
+
+// Diff compares the files in an archive with those in a folder, or in a
+// second archive, by size and modification time.
+func Diff(archive, other string) (DiffReport, error) {
+	return DiffWithOptions(archive, other, DiffOptions{})
+}
+
+// DiffWithOptions compares the files in a zip or tar.gz archive with those
+// in other, a folder or a second archive. Only files are compared, not
+// folders. Modification times are compared to the second, as by
+// UpdateWithProgress, so a folder matches an archive made from it exactly
+// when updating the archive would change nothing. An archive whose files
+// all sit under a folder named like the folder compared, as
+// AppendWithProgress stores them, is compared by the paths below it.
+func DiffWithOptions(archive, other string, opts DiffOptions) (report DiffReport, err error) {
+	if err := validateGlobs(opts.Exclude); err != nil {
+		return report, err
+	}
+	ctx := optionsContext(opts.Context)
+	old, err := diffArchiveEntries(ctx, longPath(archive), opts)
+	if err != nil {
+		return report, err
+	}
+
+	other = longPath(other)
+	info, err := os.Stat(other)
+	if err != nil {
+		return report, err
+	}
+	var current map[string]diffEntry
+	if info.IsDir() {
+		if current, err = diffFolderEntries(other, opts); err != nil {
+			return report, err
+		}
+		old = trimDiffPrefix(old, filepath.Base(other)+"/", current)
+	} else if current, err = diffArchiveEntries(ctx, other, opts); err != nil {
+		return report, err
+	}
+
+	for name, a := range old {
+		b, ok := current[name]
+		switch {
+		case !ok:
+			report.Removed = append(report.Removed, name)
+			continue
+		case a.size != b.size:
+			report.Modified = append(report.Modified, name)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		same, err := a.sameAs(b, opts.Hash)
+		if err != nil {
+			return report, err
+		}
+		if same {
+			report.Unchanged++
+		} else {
+			report.Modified = append(report.Modified, name)
+		}
+	}
+	for name := range current {
+		if _, ok := old[name]; !ok {
+			report.Added = append(report.Added, name)
+		}
+	}
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	sort.Strings(report.Modified)
+	return report, nil
+}
+
+// diffEntry is a file as DiffWithOptions compares it. Files in a folder
+// have their CRC-32 computed when it is needed.
+type diffEntry struct {
+	size    int64
+	modTime time.Time
+	crc     uint32
+	path    string // set for files in a folder, until hashed
+}
+
+// sameAs reports whether e and other, of the same size, match.
+func (e *diffEntry) sameAs(other diffEntry, hash bool) (bool, error) {
+	if !hash {
+		return e.modTime.Truncate(time.Second).Equal(other.modTime.Truncate(time.Second)), nil
+	}
+	for _, entry := range []*diffEntry{e, &other} {
+		if entry.path == "" {
+			continue
+		}
+		f, err := os.Open(entry.path)
+		if err != nil {
+			return false, err
+		}
+		h := crc32.NewIEEE()
+		_, err = copyBuffered(h, f, 0)
+		f.Close()
+		if err != nil {
+			return false, err
+		}
+		entry.crc, entry.path = h.Sum32(), ""
+	}
+	return e.crc == other.crc, nil
+}
+
+// diffSkips reports whether the archive entry name is left out of a
+// comparison: pz's own metadata entries and those the filters leave out.
+func diffSkips(name string, attrs uint32, filter entryFilter, exclude []string) bool {
+	return name == ManifestName || name == backupInfoName ||
+		filter.skipEntry(name, attrs) || matchAnyGlob(exclude, name)
+}
+
+// diffArchiveEntries lists the files in the archive at path. The CRC-32 of
+// tar.gz entries is only computed under opts.Hash, which reads the whole
+// archive; zip entries carry theirs.
+func diffArchiveEntries(ctx context.Context, path string, opts DiffOptions) (map[string]diffEntry, error) {
+	archive, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	filter := entryFilter{hidden: opts.SkipHidden, junk: opts.SkipJunk}
+	entries := make(map[string]diffEntry)
+	lower := strings.TrimSuffix(strings.ToLower(path), FirstPartSuffix)
+	if !strings.HasSuffix(lower, ".gz") && !strings.HasSuffix(lower, ".tgz") {
+		reader, err := zip.NewReader(archive, archive.Size())
+		if err != nil {
+			return nil, err
+		}
+		decodeZipNames(reader.File, NameEncodingAuto)
+		for _, f := range reader.File {
+			if f.FileInfo().IsDir() || isZipSymlink(f) || diffSkips(f.Name, f.ExternalAttrs, filter, opts.Exclude) {
+				continue
+			}
+			entries[f.Name] = diffEntry{size: int64(f.UncompressedSize64), modTime: f.Modified, crc: f.CRC32}
+		}
+		return entries, nil
+	}
+
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(archive.reader(), 256<<10))
+	if err != nil {
+		return nil, err
+	}
+	defer gzReader.Close()
+	tarReader := tar.NewReader(gzReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, gzipStreamError(filepath.Base(path), err)
+		}
+		if diffSkips(header.Name, 0, filter, opts.Exclude) {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeGNUSparse:
+			entry := diffEntry{size: header.Size, modTime: header.ModTime}
+			if opts.Hash {
+				h := crc32.NewIEEE()
+				if _, err := copyBuffered(h, contextReader{ctx: ctx, r: tarReader}, 0); err != nil {
+					return nil, gzipStreamError(filepath.Base(path), err)
+				}
+				entry.crc = h.Sum32()
+			}
+			entries[header.Name] = entry
+		case tar.TypeLink:
+			// A hard link has the contents of the file it links to
+			if target, ok := entries[header.Linkname]; ok {
+				target.modTime = header.ModTime
+				entries[header.Name] = target
+			}
+		}
+	}
+}
+
+// diffFolderEntries lists the files under dir as creating an archive of it
+// would, leaving out those that cannot be read.
+func diffFolderEntries(dir string, opts DiffOptions) (map[string]diffEntry, error) {
+	createOpts := CreateOptions{Exclude: opts.Exclude, SkipHidden: opts.SkipHidden, SkipJunk: opts.SkipJunk, ErrorPolicy: ErrorSkip}
+	files, err := collectFiles(dir, "", createOpts, newSkipList(createOpts))
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]diffEntry, len(files))
+	for _, job := range files {
+		if !job.isDir {
+			entries[filepath.ToSlash(job.rel)] = diffEntry{size: job.info.Size(), modTime: job.info.ModTime(), path: job.path}
+		}
+	}
+	return entries, nil
+}
+
+// trimDiffPrefix strips prefix from the names of entries when every one
+// has it and current has no folder by that name, for archives that store
+// a folder under its own name.
+func trimDiffPrefix(entries map[string]diffEntry, prefix string, current map[string]diffEntry) map[string]diffEntry {
+	if len(entries) == 0 {
+		return entries
+	}
+	for name := range entries {
+		if !strings.HasPrefix(name, prefix) {
+			return entries
+		}
+	}
+	for name := range current {
+		if strings.HasPrefix(name, prefix) {
+			return entries
+		}
+	}
+	trimmed := make(map[string]diffEntry, len(entries))
+	for name, e := range entries {
+		trimmed[strings.TrimPrefix(name, prefix)] = e
+	}
+	return trimmed
+}
@@ -0,0 +1,139 @@
+package zipper
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrimDiffPrefix(t *testing.T) {
+	entries := func(names ...string) map[string]diffEntry {
+		m := make(map[string]diffEntry)
+		for _, name := range names {
+			m[name] = diffEntry{}
+		}
+		return m
+	}
+	tests := []struct {
+		name    string
+		entries map[string]diffEntry
+		current map[string]diffEntry
+		want    map[string]diffEntry
+	}{
+		{"all under the folder", entries("src/a.txt", "src/b/c.txt"), entries("a.txt"), entries("a.txt", "b/c.txt")},
+		{"one outside the folder", entries("src/a.txt", "b.txt"), entries("a.txt"), entries("src/a.txt", "b.txt")},
+		{"folder of that name in current", entries("src/a.txt"), entries("src/a.txt"), entries("src/a.txt")},
+		{"similar name is not the folder", entries("srcs/a.txt"), entries("a.txt"), entries("srcs/a.txt")},
+		{"empty", entries(), entries("a.txt"), entries()},
+	}
+	for _, tt := range tests {
+		if got := trimDiffPrefix(tt.entries, "src/", tt.current); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// writeDiffFile writes a file modified at modTime.
+func writeDiffFile(t *testing.T, path, data string, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffFolder(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeDiffFile(t, filepath.Join(src, "same.txt"), "same", modTime)
+	writeDiffFile(t, filepath.Join(src, "touched.txt"), "touched", modTime)
+	writeDiffFile(t, filepath.Join(src, "edited.txt"), "edited", modTime)
+	writeDiffFile(t, filepath.Join(src, "grown.txt"), "grown", modTime)
+	writeDiffFile(t, filepath.Join(src, "gone.txt"), "gone", modTime)
+	archives := map[string]string{"zip": filepath.Join(dir, "src.zip"), "tar.gz": filepath.Join(dir, "src.tar.gz")}
+	if _, err := ZipWithOptions(src, archives["zip"], CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GzipWithOptions(src, archives["tar.gz"], CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new time with the same contents, new contents of the same size at
+	// the same time, and a new size
+	writeDiffFile(t, filepath.Join(src, "touched.txt"), "touched", modTime.Add(time.Hour))
+	writeDiffFile(t, filepath.Join(src, "edited.txt"), "EDITED", modTime)
+	writeDiffFile(t, filepath.Join(src, "grown.txt"), "grown more", modTime)
+	if err := os.Remove(filepath.Join(src, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeDiffFile(t, filepath.Join(src, "new", "added.txt"), "added", modTime)
+
+	for format, archivePath := range archives {
+		for _, hash := range []bool{false, true} {
+			report, err := DiffWithOptions(archivePath, src, DiffOptions{Hash: hash})
+			if err != nil {
+				t.Fatalf("%s, hash %v: %v", format, hash, err)
+			}
+			want := DiffReport{
+				Added:     []string{"new/added.txt"},
+				Removed:   []string{"gone.txt"},
+				Modified:  []string{"grown.txt", "touched.txt"},
+				Unchanged: 2,
+			}
+			if hash {
+				want.Modified = []string{"edited.txt", "grown.txt"}
+			}
+			if !reflect.DeepEqual(report, want) {
+				t.Errorf("%s, hash %v: got %+v; want %+v", format, hash, report, want)
+			}
+		}
+	}
+}
+
+func TestDiffTarHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	gzPath := filepath.Join(dir, "links.tar.gz")
+	f, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	data := []byte("shared content")
+	tw.WriteHeader(&tar.Header{Name: "data.txt", Mode: 0644, Size: int64(len(data)), ModTime: modTime})
+	tw.Write(data)
+	tw.WriteHeader(&tar.Header{Name: "copy.txt", Typeflag: tar.TypeLink, Linkname: "data.txt", Mode: 0644, ModTime: modTime})
+	tw.WriteHeader(&tar.Header{Name: "broken.txt", Typeflag: tar.TypeLink, Linkname: "missing.txt", Mode: 0644, ModTime: modTime})
+	tw.Close()
+	gw.Close()
+	f.Close()
+
+	folder := filepath.Join(dir, "folder")
+	writeDiffFile(t, filepath.Join(folder, "data.txt"), string(data), modTime)
+	writeDiffFile(t, filepath.Join(folder, "copy.txt"), "shared CONTENT", modTime)
+	for _, hash := range []bool{false, true} {
+		report, err := DiffWithOptions(gzPath, folder, DiffOptions{Hash: hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A link to an entry that is not there has no contents to compare
+		want := DiffReport{Unchanged: 2}
+		if hash {
+			want = DiffReport{Modified: []string{"copy.txt"}, Unchanged: 1}
+		}
+		if !reflect.DeepEqual(report, want) {
+			t.Errorf("hash %v: got %+v; want %+v", hash, report, want)
+		}
+	}
+}
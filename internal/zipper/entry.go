@@ -0,0 +1,132 @@
+package zipper
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// errIsDir is returned by ReadEntry for folders.
+var errIsDir = errors.New("is a directory")
+
+// ReadEntry opens the file named name in the zip or tar.gz archive at
+// archivePath, which may name the first part of a split archive, and returns
+// a reader over its contents. name is the entry's path in the archive;
+// backslashes and a leading "./" or "/" are ignored. Symbolic links within
+// the archive are followed.
+//
+// A tar.gz archive is decompressed only up to the file, and the first entry
+// of that name is read. Zip entries are checked against their CRC-32 as the
+// reader reaches their end. Closing the reader closes the archive.
+func ReadEntry(archivePath, name string) (io.ReadCloser, error) {
+	entryName := cleanEntryName(name)
+	if entryName == "" || entryName == "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if isGzipName(archivePath) {
+		rc, err := readTarEntry(archivePath, entryName)
+		if rc != nil || err != nil {
+			return rc, err
+		}
+		// Links are resolved with the index of the whole archive
+	}
+
+	fsys, err := OpenArchiveFS(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fsys.Open(entryName)
+	if err == nil {
+		if info, _ := f.Stat(); info.IsDir() {
+			f.Close()
+			err = &fs.PathError{Op: "open", Path: entryName, Err: errIsDir}
+		}
+	}
+	if err != nil {
+		fsys.Close()
+		return nil, err
+	}
+	return &entryFile{File: f, fsys: fsys}, nil
+}
+
+// readTarEntry streams the tar.gz archive at archivePath up to the entry
+// named entryName and returns a reader over its data. It returns nil and no
+// error when the entry is a link, which needs the rest of the archive to
+// resolve.
+func readTarEntry(archivePath, entryName string) (io.ReadCloser, error) {
+	file, err := openArchive(longPath(archivePath))
+	if err != nil {
+		return nil, err
+	}
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(file.reader(), 256<<10))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	fail := func(err error) (io.ReadCloser, error) {
+		gzReader.Close()
+		file.Close()
+		return nil, err
+	}
+
+	tarReader := tar.NewReader(gzReader)
+	isDir := false
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(gzipStreamError(archivePath, err))
+		}
+		headerName := cleanEntryName(header.Name)
+		if strings.HasPrefix(headerName, entryName+"/") {
+			// A folder need not have an entry of its own
+			isDir = true
+			continue
+		}
+		if headerName != entryName {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeGNUSparse:
+			return &tarEntryReader{Reader: tarReader, gzReader: gzReader, file: file}, nil
+		case tar.TypeDir:
+			isDir = true
+		case tar.TypeSymlink, tar.TypeLink:
+			return fail(nil)
+		}
+	}
+	if isDir {
+		return fail(&fs.PathError{Op: "open", Path: entryName, Err: errIsDir})
+	}
+	return fail(&fs.PathError{Op: "open", Path: entryName, Err: fs.ErrNotExist})
+}
+
+// tarEntryReader reads one entry of a tar.gz archive and closes the archive
+// when closed.
+type tarEntryReader struct {
+	io.Reader
+	gzReader *gzip.Reader
+	file     *archiveFile
+}
+
+func (r *tarEntryReader) Close() error {
+	r.gzReader.Close()
+	return r.file.Close()
+}
+
+// entryFile is a file of an ArchiveFS that closes the archive when closed.
+type entryFile struct {
+	fs.File
+	fsys *ArchiveFS
+}
+
+func (f *entryFile) Close() error {
+	f.File.Close()
+	return f.fsys.Close()
+}
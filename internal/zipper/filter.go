@@ -0,0 +1,57 @@
+package zipper
+
+import "strings"
+
+// junkNames are files and folders operating systems leave behind that are
+// of no use to anyone receiving an archive, in lower case.
+var junkNames = map[string]bool{
+	".ds_store":   true,
+	"thumbs.db":   true,
+	"desktop.ini": true,
+	"__macosx":    true, // resource forks added to zips by macOS
+}
+
+// isJunk reports whether the file or folder name is operating system junk.
+// AppleDouble files ("._name"), the tar counterpart of __MACOSX, count too.
+func isJunk(name string) bool {
+	return junkNames[strings.ToLower(name)] || strings.HasPrefix(name, "._")
+}
+
+// entryFilter leaves out hidden and junk files and folders.
+type entryFilter struct {
+	hidden bool
+	junk   bool
+}
+
+func createFilter(opts CreateOptions) entryFilter {
+	return entryFilter{hidden: opts.SkipHidden, junk: opts.SkipJunk}
+}
+
+func extractFilter(opts ExtractOptions) entryFilter {
+	return entryFilter{hidden: opts.SkipHidden, junk: opts.SkipJunk}
+}
+
+func (f entryFilter) active() bool {
+	return f.hidden || f.junk
+}
+
+// skip reports whether the file or folder name, with the given Windows
+// attributes, is left out.
+func (f entryFilter) skip(name string, attrs uint32) bool {
+	if f.hidden && (strings.HasPrefix(name, ".") || attrs&dosHidden != 0) {
+		return true
+	}
+	return f.junk && isJunk(name)
+}
+
+// skipEntry reports whether the archive entry name, with the given external
+// attributes, is left out, either itself or as part of a folder that is.
+func (f entryFilter) skipEntry(name string, attrs uint32) bool {
+	segments := strings.Split(strings.Trim(name, "/"), "/")
+	for i, segment := range segments {
+		if i < len(segments)-1 && f.skip(segment, 0) {
+			return true
+		}
+	}
+	return f.skip(segments[len(segments)-1], attrs)
+}
@@ -0,0 +1,101 @@
+package zipper
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// CollisionPolicy decides what happens when ExtractOptions.Flatten gives two
+// files the same name.
+type CollisionPolicy int
+
+const (
+	// CollisionFail aborts extraction.
+	CollisionFail CollisionPolicy = iota
+	// CollisionRename writes later files under numbered names such as
+	// "file (1).txt".
+	CollisionRename
+	// CollisionOverwrite keeps the file that comes last in the archive.
+	CollisionOverwrite
+)
+
+// ParseCollisionPolicy parses the name of a collision policy as used on the
+// command line.
+func ParseCollisionPolicy(s string) (CollisionPolicy, error) {
+	switch s {
+	case "error", "fail":
+		return CollisionFail, nil
+	case "rename":
+		return CollisionRename, nil
+	case "overwrite":
+		return CollisionOverwrite, nil
+	}
+	return 0, fmt.Errorf("unknown collision policy: %s (use error, rename or overwrite)", s)
+}
+
+// flattener gives entries their flattened names in archive order, applying
+// the collision policy. It is not safe for concurrent use.
+type flattener struct {
+	policy   CollisionPolicy
+	claimed  map[string]string // flattened name -> entry given it
+	warnings *warningList
+}
+
+func newFlattener(policy CollisionPolicy, warnings *warningList) *flattener {
+	return &flattener{policy: policy, claimed: make(map[string]string), warnings: warnings}
+}
+
+// name returns the name entry is extracted under when flattened.
+func (f *flattener) name(entry string) (string, error) {
+	base := path.Base(entry)
+	first, taken := f.claimed[base]
+	if !taken {
+		f.claimed[base] = entry
+		return base, nil
+	}
+
+	switch f.policy {
+	case CollisionRename:
+		ext := path.Ext(base)
+		stem := strings.TrimSuffix(base, ext)
+		for n := 1; ; n++ {
+			candidate := fmt.Sprintf("%s (%d)%s", stem, n, ext)
+			if _, taken := f.claimed[candidate]; !taken {
+				f.claimed[candidate] = entry
+				f.warnings.add(WarningFlattenCollision, entry, "%s is taken by %s; written as %s", base, first, candidate)
+				return candidate, nil
+			}
+		}
+	case CollisionOverwrite:
+		f.claimed[base] = entry
+		f.warnings.add(WarningFlattenCollision, entry, "replaces %s, which has the same name", first)
+		return base, nil
+	}
+	return "", fmt.Errorf("flattening %s and %s gives both the name %s", first, entry, base)
+}
+
+// flattenZipNames maps the name of each file in files to its flattened
+// name. Files replaced by a later one under CollisionOverwrite map to "",
+// so that each name is written once.
+func flattenZipNames(files []*zip.File, policy CollisionPolicy, warnings *warningList) (map[string]string, error) {
+	f := newFlattener(policy, warnings)
+	names := make(map[string]string, len(files))
+	for _, file := range files {
+		if file.FileInfo().IsDir() || isZipSymlink(file) {
+			continue
+		}
+		flat, err := f.name(file.Name)
+		if err != nil {
+			return nil, err
+		}
+		names[file.Name] = flat
+	}
+	for entry, flat := range names {
+		if f.claimed[flat] != entry {
+			names[entry] = ""
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,84 @@
+package zipper
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// validateGlob reports a malformed pattern before it is used with matchGlob.
+func validateGlob(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// validateGlobs reports the first malformed pattern.
+func validateGlobs(patterns []string) error {
+	for _, pattern := range patterns {
+		if err := validateGlob(pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the slash-separated entry name matches pattern.
+// Besides the path.Match syntax, a "**" segment matches any number of
+// segments, and a pattern without a slash matches names at any depth.
+func matchGlob(pattern, name string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(strings.Trim(name, "/"), "/"))
+}
+
+// matchAnyGlob reports whether name matches any of patterns.
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// excluded reports whether the entry d, at rel below the folder being
+// walked, matches any of the exclude patterns. Excluded folders return
+// filepath.SkipDir so their contents are not visited.
+func excluded(rel string, d fs.DirEntry, exclude []string) (bool, error) {
+	if !matchAnyGlob(exclude, filepath.ToSlash(rel)) {
+		return false, nil
+	}
+	if d.IsDir() {
+		return true, filepath.SkipDir
+	}
+	return true, nil
+}
@@ -0,0 +1,61 @@
+package zipper
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LimitError is returned when extraction would exceed one of the resource
+// limits configured in ExtractOptions.
+type LimitError struct {
+	Limit string // ExtractOptions field that was exceeded, e.g. "MaxEntries"
+	Name  string // entry being processed when the limit was hit
+	Value int64  // value that exceeded the limit
+	Max   int64  // configured limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("extraction limit exceeded: %s at %s (%d > %d)", e.Limit, e.Name, e.Value, e.Max)
+}
+
+// extractLimits enforces the resource limits of ExtractOptions as entries
+// are encountered. A zero limit is unlimited.
+type extractLimits struct {
+	opts       ExtractOptions
+	entries    int64
+	totalBytes int64
+}
+
+// check accounts for an entry of the given size and returns a *LimitError if
+// any limit is exceeded.
+func (l *extractLimits) check(name string, size int64, isDir bool) error {
+	l.entries++
+	if max := int64(l.opts.MaxEntries); max > 0 && l.entries > max {
+		return &LimitError{Limit: "MaxEntries", Name: name, Value: l.entries, Max: max}
+	}
+	if max := int64(l.opts.MaxPathDepth); max > 0 {
+		if depth := int64(pathDepth(name)); depth > max {
+			return &LimitError{Limit: "MaxPathDepth", Name: name, Value: depth, Max: max}
+		}
+	}
+	if isDir {
+		return nil
+	}
+	if max := l.opts.MaxFileSize; max > 0 && size > max {
+		return &LimitError{Limit: "MaxFileSize", Name: name, Value: size, Max: max}
+	}
+	l.totalBytes += size
+	if max := l.opts.MaxTotalBytes; max > 0 && l.totalBytes > max {
+		return &LimitError{Limit: "MaxTotalBytes", Name: name, Value: l.totalBytes, Max: max}
+	}
+	return nil
+}
+
+// pathDepth returns the number of components in a slash-separated entry name.
+func pathDepth(name string) int {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return 0
+	}
+	return strings.Count(name, "/") + 1
+}
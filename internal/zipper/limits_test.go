@@ -0,0 +1,92 @@
+package zipper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractLimits(t *testing.T) {
+	type entry struct {
+		name  string
+		size  int64
+		isDir bool
+	}
+	tests := []struct {
+		name    string
+		opts    ExtractOptions
+		entries []entry
+		limit   string // "" when every entry is accepted
+	}{
+		{"unlimited", ExtractOptions{}, []entry{{"a/b/c/d.txt", 1 << 40, false}, {"e.txt", 1 << 40, false}}, ""},
+		{"entries within MaxEntries", ExtractOptions{MaxEntries: 2}, []entry{{"a/", 0, true}, {"a/b.txt", 1, false}}, ""},
+		{"entries over MaxEntries", ExtractOptions{MaxEntries: 2}, []entry{{"a/", 0, true}, {"a/b.txt", 1, false}, {"a/c.txt", 1, false}}, "MaxEntries"},
+		{"path at MaxPathDepth", ExtractOptions{MaxPathDepth: 3}, []entry{{"a/b/c.txt", 1, false}, {"a/b/c/", 0, true}}, ""},
+		{"path over MaxPathDepth", ExtractOptions{MaxPathDepth: 3}, []entry{{"a/b/c/d.txt", 1, false}}, "MaxPathDepth"},
+		{"folder over MaxPathDepth", ExtractOptions{MaxPathDepth: 1}, []entry{{"a/b/", 0, true}}, "MaxPathDepth"},
+		{"file at MaxFileSize", ExtractOptions{MaxFileSize: 10}, []entry{{"a.txt", 10, false}}, ""},
+		{"file over MaxFileSize", ExtractOptions{MaxFileSize: 10}, []entry{{"a.txt", 11, false}}, "MaxFileSize"},
+		{"total at MaxTotalBytes", ExtractOptions{MaxTotalBytes: 10}, []entry{{"a.txt", 4, false}, {"b.txt", 6, false}}, ""},
+		{"total over MaxTotalBytes", ExtractOptions{MaxTotalBytes: 10}, []entry{{"a.txt", 4, false}, {"b.txt", 7, false}}, "MaxTotalBytes"},
+		{"folders not counted in MaxTotalBytes", ExtractOptions{MaxTotalBytes: 1}, []entry{{"a/", 100, true}}, ""},
+	}
+	for _, tt := range tests {
+		limits := &extractLimits{opts: tt.opts}
+		var err error
+		for _, e := range tt.entries {
+			if err = limits.check(e.name, e.size, e.isDir); err != nil {
+				break
+			}
+		}
+		var limitErr *LimitError
+		switch {
+		case tt.limit == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.limit != "" && !errors.As(err, &limitErr):
+			t.Errorf("%s: check = %v; want a %s LimitError", tt.name, err, tt.limit)
+		case tt.limit != "" && limitErr.Limit != tt.limit:
+			t.Errorf("%s: exceeded %s; want %s", tt.name, limitErr.Limit, tt.limit)
+		}
+	}
+}
+
+func TestExtractStopsAtLimits(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"one.txt", "two.txt", filepath.Join("a", "b", "deep.txt")} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte("0123456789"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zipPath := filepath.Join(dir, "in.zip")
+	if _, err := ZipWithOptions(src, zipPath, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	gzPath := filepath.Join(dir, "in.tar.gz")
+	if _, err := GzipWithOptions(src, gzPath, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		limit string
+		opts  ExtractOptions
+	}{
+		{"MaxEntries", ExtractOptions{MaxEntries: 2}},
+		{"MaxPathDepth", ExtractOptions{MaxPathDepth: 2}},
+		{"MaxFileSize", ExtractOptions{MaxFileSize: 9}},
+		{"MaxTotalBytes", ExtractOptions{MaxTotalBytes: 25}},
+	} {
+		_, zipErr := ExtractWithOptions(zipPath, filepath.Join(dir, "zip-"+tt.limit), tt.opts)
+		_, gzErr := ExtractGzipWithOptions(gzPath, filepath.Join(dir, "gz-"+tt.limit), tt.opts)
+		for format, err := range map[string]error{"zip": zipErr, "tar.gz": gzErr} {
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != tt.limit {
+				t.Errorf("%s with %s: extract = %v; want a %s LimitError", format, tt.limit, err, tt.limit)
+			}
+		}
+	}
+}
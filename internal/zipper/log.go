@@ -0,0 +1,94 @@
+package zipper
+
+import (
+	"log/slog"
+	"time"
+)
+
+// operationLog records an operation to the Logger of its options: each entry
+// at debug level, skipped files and warnings as warnings, and the outcome
+// with its duration. A nil *operationLog logs nothing.
+type operationLog struct {
+	logger *slog.Logger
+	op     string // such as "create" or "extract"
+	path   string // archive operated on
+	start  time.Time
+}
+
+// logCreate starts logging the operation op on the archive at path, if
+// opts has a Logger, and routes the entries and skipped files reported to
+// opts through it. The Logger is cleared so that operations opts is passed
+// on to do not log the same entries again.
+func logCreate(opts *CreateOptions, op, path string) *operationLog {
+	l := startLog(opts.Logger, op, path, "workers", WorkerCount(opts.Workers))
+	if l == nil {
+		return nil
+	}
+	opts.Logger = nil
+	onEntry, onSkip := opts.OnEntry, opts.OnSkip
+	opts.OnEntry = func(e EntryEvent) {
+		l.entry(e)
+		if onEntry != nil {
+			onEntry(e)
+		}
+	}
+	opts.OnSkip = func(name string, err error) {
+		l.logger.Warn("skipped", "name", name, "error", err)
+		if onSkip != nil {
+			onSkip(name, err)
+		}
+	}
+	return l
+}
+
+// logExtract is logCreate for extraction to destDir.
+func logExtract(opts *ExtractOptions, op, path, destDir string) *operationLog {
+	l := startLog(opts.Logger, op, path, "dest", destDir, "workers", WorkerCount(opts.Workers))
+	if l == nil {
+		return nil
+	}
+	opts.Logger = nil
+	onEntry := opts.OnEntry
+	opts.OnEntry = func(e EntryEvent) {
+		l.entry(e)
+		if onEntry != nil {
+			onEntry(e)
+		}
+	}
+	return l
+}
+
+func startLog(logger *slog.Logger, op, path string, args ...any) *operationLog {
+	if logger == nil {
+		return nil
+	}
+	logger.Debug(op+" started", append([]any{"archive", path}, args...)...)
+	return &operationLog{logger: logger, op: op, path: path, start: time.Now()}
+}
+
+func (l *operationLog) entry(e EntryEvent) {
+	args := []any{"name", e.Name}
+	if !e.IsDir {
+		args = append(args, "size", e.Size)
+		if e.CompressedSize >= 0 {
+			args = append(args, "compressed", e.CompressedSize)
+		}
+	}
+	l.logger.Debug("entry", args...)
+}
+
+// finish logs the outcome of the operation and the warnings it produced.
+func (l *operationLog) finish(files int, bytes int64, warnings []Warning, err error) {
+	if l == nil {
+		return
+	}
+	for _, w := range warnings {
+		l.logger.Warn("warning", "code", w.Code, "name", w.Name, "message", w.Message)
+	}
+	elapsed := time.Since(l.start)
+	if err != nil {
+		l.logger.Error(l.op+" failed", "archive", l.path, "elapsed", elapsed, "error", err)
+		return
+	}
+	l.logger.Info(l.op+" finished", "archive", l.path, "files", files, "bytes", bytes, "elapsed", elapsed)
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package zipper
+
+// longPath returns path unchanged; only Windows limits path length.
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package zipper
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPath converts path to an extended-length path (\\?\ prefix) so that
+// files nested beyond the legacy 260 character MAX_PATH limit can be created
+// and walked. Extended-length paths bypass normalization, so the path is made
+// absolute and cleaned first.
+func longPath(path string) string {
+	if path == "" || strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		// UNC share: \\server\share -> \\?\UNC\server\share
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
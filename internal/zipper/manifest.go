@@ -0,0 +1,314 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ManifestName is the archive entry holding per-file SHA-256 digests when
+// CreateOptions.Manifest is set. It uses the sha256sum format, so an
+// extracted copy can also be checked with `sha256sum -c`.
+const ManifestName = "MANIFEST.sha256"
+
+// manifest collects the digests of the files written to an archive.
+type manifest struct {
+	names   []string
+	digests map[string]string
+}
+
+func newManifest() *manifest {
+	return &manifest{digests: make(map[string]string)}
+}
+
+func (m *manifest) add(name string, sum []byte) {
+	m.set(name, hex.EncodeToString(sum))
+}
+
+// set records the hex digest of name, keeping its original position if it
+// is already listed.
+func (m *manifest) set(name, sum string) {
+	if _, ok := m.digests[name]; !ok {
+		m.names = append(m.names, name)
+	}
+	m.digests[name] = sum
+}
+
+func (m *manifest) bytes() []byte {
+	var buf bytes.Buffer
+	for _, name := range m.names {
+		fmt.Fprintf(&buf, "%s  %s\n", m.digests[name], name)
+	}
+	return buf.Bytes()
+}
+
+// writeZip appends the manifest as the last entry of a zip archive, modified
+// at modTime.
+func (m *manifest) writeZip(w *zip.Writer, modTime time.Time) error {
+	fw, err := w.CreateHeader(&zip.FileHeader{Name: ManifestName, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(m.bytes())
+	return err
+}
+
+// writeTar appends the manifest as the last entry of a tar archive, modified
+// at modTime, with a header in format.
+func (m *manifest) writeTar(tw *tar.Writer, modTime time.Time, format TarFormat) error {
+	data := m.bytes()
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ManifestName,
+		Size:     int64(len(data)),
+		Mode:     0644,
+		ModTime:  modTime,
+	}
+	format.apply(header)
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// lookup returns the hex digest recorded for name. It is safe to call on a
+// nil manifest.
+func (m *manifest) lookup(name string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	sum, ok := m.digests[name]
+	return sum, ok
+}
+
+// parseManifest reads digests in sha256sum format.
+func parseManifest(r io.Reader) (*manifest, error) {
+	m := newManifest()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok || len(sum) != sha256.Size*2 || len(name) < 2 {
+			return nil, fmt.Errorf("invalid manifest line: %q", line)
+		}
+		// sha256sum marks binary mode with '*' and text mode with ' '
+		m.set(name[1:], strings.ToLower(sum))
+	}
+	return m, scanner.Err()
+}
+
+// VerifyManifest compares the digests recorded in an archive's manifest
+// with the archive contents, or with the files under dir when dir is not
+// empty, such as after extraction. Mismatched and missing files are recorded
+// in the returned stats and reported together with a *ChecksumError.
+func VerifyManifest(archivePath, dir string) (stats CheckStats, err error) {
+	archivePath = longPath(archivePath)
+	isGzip := strings.HasSuffix(strings.ToLower(archivePath), ".gz") || strings.HasSuffix(strings.ToLower(archivePath), ".tgz")
+
+	var m *manifest
+	var actual map[string]digest
+	switch {
+	case dir != "":
+		if isGzip {
+			m, _, err = readGzipManifest(archivePath, false)
+		} else {
+			m, err = readZipManifest(archivePath)
+		}
+		if err == nil {
+			actual = digestDir(longPath(dir), m)
+		}
+	case isGzip:
+		m, actual, err = readGzipManifest(archivePath, true)
+	default:
+		m, actual, err = digestZip(archivePath)
+	}
+	if err != nil {
+		return stats, err
+	}
+	if !isGzip {
+		stats.Comment, _ = ZipComment(archivePath)
+	}
+
+	var failed []string
+	for _, name := range m.names {
+		result := CheckResult{Name: name}
+		got, ok := actual[name]
+		result.Size = got.size
+		switch {
+		case !ok:
+			result.Err = errors.New("missing")
+		case got.err != nil:
+			result.Err = got.err
+		case got.sum != m.digests[name]:
+			result.Err = errors.New("SHA-256 mismatch")
+		}
+		stats.TotalBytes += got.size
+		stats.Entries = append(stats.Entries, result)
+		stats.FileCount++
+		if result.Err != nil {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return stats, &ChecksumError{Archive: filepath.Base(archivePath), Entries: failed}
+	}
+	return stats, nil
+}
+
+// readZipManifest returns the manifest stored in a zip archive.
+func readZipManifest(zipPath string) (*manifest, error) {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	rc, err := reader.Open(ManifestName)
+	if err != nil {
+		return nil, fmt.Errorf("no manifest found in archive: %w", err)
+	}
+	defer rc.Close()
+	return parseManifest(rc)
+}
+
+// digestZip returns the manifest of a zip archive along with the digests of
+// the entries it lists.
+func digestZip(zipPath string) (*manifest, map[string]digest, error) {
+	m, err := readZipManifest(zipPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	actual := make(map[string]digest)
+	for _, f := range reader.File {
+		if _, ok := m.digests[f.Name]; !ok {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			actual[f.Name] = digest{err: err}
+			continue
+		}
+		actual[f.Name] = digestReader(rc)
+		rc.Close()
+	}
+	return m, actual, nil
+}
+
+// readGzipManifest finds the manifest of a tar.gz archive. When digestEntries
+// is set the other entries are hashed on the way, since the manifest is only
+// reached at the end of the stream.
+func readGzipManifest(gzipPath string, digestEntries bool) (*manifest, map[string]digest, error) {
+	f, err := os.Open(gzipPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(f, 256<<10))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gzReader.Close()
+
+	var m *manifest
+	actual := make(map[string]digest)
+	tarReader := tar.NewReader(gzReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, gzipStreamError(gzipPath, err)
+		}
+		switch {
+		case header.Name == ManifestName:
+			if m, err = parseManifest(tarReader); err != nil {
+				return nil, nil, err
+			}
+		case digestEntries && (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeGNUSparse):
+			actual[header.Name] = digestReader(tarReader)
+		case digestEntries && header.Typeflag == tar.TypeLink:
+			// A hard link holds the data of the file it links to
+			if d, ok := actual[header.Linkname]; ok {
+				actual[header.Name] = d
+			}
+		}
+	}
+	if m == nil {
+		return nil, nil, fmt.Errorf("no manifest found in archive")
+	}
+	return m, actual, nil
+}
+
+// digestDir hashes the files under dir that are listed in m. Names leading
+// outside dir are reported as invalid rather than opened, as the manifest
+// comes from the archive.
+func digestDir(dir string, m *manifest) map[string]digest {
+	actual := make(map[string]digest)
+	for _, name := range m.names {
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			actual[name] = digest{err: fmt.Errorf("invalid file path: %s", name)}
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				actual[name] = digest{err: err}
+			}
+			continue
+		}
+		actual[name] = digestReader(f)
+		f.Close()
+	}
+	return actual
+}
+
+// sumFile returns the SHA-256 of the file at path.
+func sumFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := copyBuffered(h, f, 0); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// digest is the SHA-256 of a file recomputed during verification.
+type digest struct {
+	sum  string
+	size int64
+	err  error
+}
+
+// digestReader hashes everything read from r.
+func digestReader(r io.Reader) digest {
+	h := sha256.New()
+	n, err := copyBuffered(h, r, 0)
+	return digest{sum: hex.EncodeToString(h.Sum(nil)), size: n, err: err}
+}
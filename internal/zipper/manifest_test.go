@@ -0,0 +1,64 @@
+package zipper
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestVerifyDirRejectsOutsideNames verifies a folder against a manifest
+// naming a file outside it; the file must be reported as invalid instead
+// of being hashed.
+func TestVerifyDirRejectsOutsideNames(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("outside the folder")
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), secret, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "out")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "a.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(dir, "crafted.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create(ManifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inside, outside := sha256.Sum256([]byte("inside")), sha256.Sum256(secret)
+	fmt.Fprintf(w, "%s  a.txt\n%s  ../secret.txt\n", hex.EncodeToString(inside[:]), hex.EncodeToString(outside[:]))
+	zw.Close()
+	f.Close()
+
+	stats, err := VerifyManifest(archivePath, dest)
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) || len(checksumErr.Entries) != 1 || checksumErr.Entries[0] != "../secret.txt" {
+		t.Fatalf("verify = %v; want ../secret.txt reported", err)
+	}
+	for _, e := range stats.Entries {
+		switch e.Name {
+		case "a.txt":
+			if e.Err != nil {
+				t.Errorf("a.txt: %v", e.Err)
+			}
+		case "../secret.txt":
+			if e.Err == nil || !strings.Contains(e.Err.Error(), "invalid file path") || e.Size != 0 {
+				t.Errorf("../secret.txt: %v, %d bytes; want an invalid path, unread", e.Err, e.Size)
+			}
+		}
+	}
+}
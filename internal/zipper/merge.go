@@ -0,0 +1,661 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MergeOptions configures MergeArchives.
+type MergeOptions struct {
+	// Context, the progress callbacks, OnEntry, Level, Store, Workers and
+	// Comment apply as when creating an archive; the options that choose
+	// source files do not.
+	CreateOptions
+	// Collisions decides what happens when several archives hold a file of
+	// the same name: CollisionFail aborts before anything is written,
+	// CollisionRename numbers later files and CollisionOverwrite keeps the
+	// file from the last archive. Folders are merged rather than colliding.
+	Collisions CollisionPolicy
+}
+
+// mergeInput is an archive being merged.
+type mergeInput struct {
+	path    string
+	gzip    bool
+	zip     *zip.Reader
+	file    *archiveFile
+	entries []*mergeEntry // in archive order, for tar.gz every header
+}
+
+// mergeEntry is an entry of an archive being merged.
+type mergeEntry struct {
+	name  string
+	isDir bool
+	size  int64
+	// isLink marks a tar.gz hard link to the entry named linkname.
+	isLink   bool
+	linkname string
+	// out is the name the entry is written under; empty leaves it out.
+	out string
+	// links counts the hard links to the entry kept in the merged archive.
+	links int
+}
+
+// MergeArchives combines the entries of several zip and tar.gz archives, in
+// order, into a new archive at outPath, a tar.gz when its name ends in
+// .tar.gz, .tgz or .gz and a zip when it ends in .zip; other names are
+// rejected rather than written in a format they do not name. Zip entries
+// merged into a zip are copied without recompression; other entries are
+// recompressed. Manifests in the inputs are left out, as their digests no
+// longer cover the merged archive. The checksum is stored as when creating
+// an archive.
+func MergeArchives(inputs []string, outPath string, opts MergeOptions) (stats ArchiveStats, err error) {
+	if len(inputs) == 0 {
+		return stats, fmt.Errorf("no archives to merge")
+	}
+	log := logCreate(&opts.CreateOptions, "merge", outPath)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+	return mergeArchives(inputs, outPath, opts, false)
+}
+
+// mergeArchives writes the entries of inputs to outPath as MergeArchives
+// does, keeping manifests and backup information when keepMetadata is set.
+func mergeArchives(inputs []string, outPath string, opts MergeOptions, keepMetadata bool) (stats ArchiveStats, err error) {
+	ctx := optionsContext(opts.Context)
+	warnings := &warningList{}
+
+	var archives []*mergeInput
+	defer func() {
+		for _, in := range archives {
+			in.file.Close()
+		}
+	}()
+	outPath = longPath(outPath)
+	isGzip, err := outputIsGzip(outPath)
+	if err != nil {
+		return stats, err
+	}
+	// The output replaces the file at outPath, so it must not be an input
+	outInfo, outErr := os.Stat(outPath)
+	for _, p := range inputs {
+		p = longPath(p)
+		if info, err := os.Stat(p); outErr == nil && err == nil && os.SameFile(info, outInfo) {
+			return stats, fmt.Errorf("output %s is also an input", filepath.Base(outPath))
+		}
+		in, err := listMergeInput(p, !isGzip, keepMetadata)
+		if err != nil {
+			return stats, err
+		}
+		archives = append(archives, in)
+	}
+	if err := resolveMergeNames(archives, opts.Collisions, warnings); err != nil {
+		return stats, err
+	}
+	for _, in := range archives {
+		for _, e := range in.entries {
+			if e.out != "" && !e.isDir {
+				stats.TotalBytes += e.size
+				stats.FileCount++
+			}
+		}
+	}
+
+	level, err := compressionLevel(opts.CreateOptions, stats.TotalBytes)
+	if err != nil {
+		return stats, err
+	}
+	// The archive is written beside outPath and renamed over it once
+	// complete, so a failure leaves any earlier file there intact
+	outFile, err := tempFileFor(outPath)
+	if err != nil {
+		return stats, err
+	}
+	tempPath := outFile.Name()
+	defer func() {
+		if err != nil {
+			outFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	tracker := newProgressTracker(opts.Progress, opts.ProgressEvents, opts.ProgressInterval, stats.TotalBytes, stats.FileCount)
+	tracker.update()
+	m := &merger{opts: opts, tracker: tracker, level: level}
+	var gzWriter io.WriteCloser
+	if isGzip {
+		if workers := WorkerCount(opts.Workers); workers > 1 {
+			gzWriter, err = newParallelGzipWriter(outFile, level, workers)
+		} else {
+			gzWriter, err = gzip.NewWriterLevel(outFile, level)
+		}
+		if err != nil {
+			return stats, err
+		}
+		defer func() {
+			if err != nil {
+				abortGzip(gzWriter)
+			}
+		}()
+		m.tw = tar.NewWriter(gzWriter)
+	} else {
+		m.zw = zip.NewWriter(outFile)
+		m.zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+
+	for _, in := range archives {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		if in.gzip {
+			err = m.mergeTar(in)
+		} else {
+			err = m.mergeZip(in)
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+	stats.Warnings = warnings.list()
+	tracker.update()
+
+	if !isGzip {
+		if stats.Checksum, err = finishZip(m.zw, outFile, opts.Comment); err != nil {
+			return stats, err
+		}
+		if err = os.Rename(tempPath, outPath); err != nil {
+			return stats, err
+		}
+		if info, err := os.Stat(outPath); err == nil {
+			stats.ArchiveSize = info.Size()
+		}
+		return stats, nil
+	}
+	if err = m.tw.Close(); err == nil {
+		if err = gzWriter.Close(); err == nil {
+			if err = outFile.Close(); err == nil {
+				err = os.Rename(tempPath, outPath)
+			}
+		}
+	}
+	if err != nil {
+		return stats, err
+	}
+	if stats.Checksum, err = calculateFileChecksum(outPath); err != nil {
+		return stats, fmt.Errorf("checksum calculation failed: %w", err)
+	}
+	if info, err := os.Stat(outPath); err == nil {
+		stats.ArchiveSize = info.Size()
+	}
+	if err := writeChecksumFile(outPath, stats.Checksum); err != nil {
+		return stats, fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return stats, nil
+}
+
+// outputIsGzip reports whether the archive written to path is a tar.gz
+// rather than a zip, going by its extension, and fails for names of other
+// formats.
+func outputIsGzip(path string) (bool, error) {
+	if isGzipName(path) {
+		return true, nil
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		return false, fmt.Errorf("unsupported output format: %s (use .zip, .tar.gz or .tgz)", filepath.Base(path))
+	}
+	return false, nil
+}
+
+// isGzipName reports whether path names a tar.gz archive.
+func isGzipName(path string) bool {
+	lower := strings.TrimSuffix(strings.ToLower(path), FirstPartSuffix)
+	return strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// listMergeInput opens the archive at p and lists its entries, each under
+// its own name until resolveMergeNames has run. Entries are sized as they
+// are written to a zip when toZip is set, or to a tar.gz.
+func listMergeInput(p string, toZip, keepMetadata bool) (*mergeInput, error) {
+	file, err := openArchive(p)
+	if err != nil {
+		return nil, err
+	}
+	in := &mergeInput{path: p, gzip: isGzipName(p), file: file}
+	keep := func(name string) string {
+		if !keepMetadata && (name == ManifestName || name == backupInfoName) {
+			return ""
+		}
+		return name
+	}
+
+	if !in.gzip {
+		if in.zip, err = zip.NewReader(file, file.Size()); err != nil {
+			file.Close()
+			return nil, err
+		}
+		decodeZipNames(in.zip.File, NameEncodingAuto)
+		for _, f := range in.zip.File {
+			isDir := f.FileInfo().IsDir()
+			in.entries = append(in.entries, &mergeEntry{name: f.Name, isDir: isDir, size: int64(f.UncompressedSize64), out: keep(f.Name)})
+		}
+		return in, nil
+	}
+
+	err = readTarHeaders(file, p, func(header *tar.Header) {
+		e := &mergeEntry{name: header.Name, size: header.Size, out: keep(header.Name)}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			e.isDir = true
+		case tar.TypeReg, tar.TypeGNUSparse, tar.TypeSymlink:
+		case tar.TypeLink:
+			e.isLink, e.linkname = true, header.Linkname
+			// A zip holds a copy of the file linked to
+			e.size = 0
+			for _, target := range in.entries {
+				if toZip && target.name == header.Linkname && !target.isDir {
+					e.size = target.size
+				}
+			}
+		default:
+			e.out = ""
+		}
+		in.entries = append(in.entries, e)
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return in, nil
+}
+
+// readTarHeaders calls fn with each header of the tar.gz archive read from
+// file, called name in errors.
+func readTarHeaders(file *archiveFile, name string, fn func(*tar.Header)) error {
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(file.reader(), 256<<10))
+	if err != nil {
+		return err
+	}
+	defer gzReader.Close()
+	tarReader := tar.NewReader(gzReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return gzipStreamError(filepath.Base(name), err)
+		}
+		fn(header)
+	}
+}
+
+// resolveMergeNames gives each entry the name it is merged under, applying
+// the collision policy to files that several archives hold.
+func resolveMergeNames(archives []*mergeInput, policy CollisionPolicy, warnings *warningList) error {
+	type claim struct {
+		entry *mergeEntry
+		from  string
+	}
+	claimed := make(map[string]claim)
+	dirs := make(map[string]bool)
+	for _, in := range archives {
+		from := filepath.Base(in.path)
+		for _, e := range in.entries {
+			if e.out == "" {
+				continue
+			}
+			if e.isDir {
+				dir := strings.TrimSuffix(e.out, "/")
+				if dirs[dir] {
+					e.out = ""
+				}
+				dirs[dir] = true
+				continue
+			}
+			first, taken := claimed[e.name]
+			if !taken {
+				claimed[e.name] = claim{e, from}
+				continue
+			}
+
+			switch policy {
+			case CollisionRename:
+				ext := path.Ext(e.name)
+				stem := strings.TrimSuffix(e.name, ext)
+				for n := 1; ; n++ {
+					candidate := fmt.Sprintf("%s (%d)%s", stem, n, ext)
+					if _, taken := claimed[candidate]; !taken {
+						e.out = candidate
+						claimed[candidate] = claim{e, from}
+						warnings.add(WarningMergeCollision, e.name, "also in %s; merged from %s as %s", first.from, from, candidate)
+						break
+					}
+				}
+			case CollisionOverwrite:
+				first.entry.out = ""
+				claimed[e.name] = claim{e, from}
+				warnings.add(WarningMergeCollision, e.name, "from %s replaces the file from %s", from, first.from)
+			default:
+				return fmt.Errorf("%s is in both %s and %s", e.name, first.from, from)
+			}
+		}
+	}
+
+	// Hard links in tar.gz archives follow their target's name, and are
+	// left out with it
+	for _, in := range archives {
+		if !in.gzip {
+			continue
+		}
+		outNames := make(map[string]*mergeEntry)
+		for _, e := range in.entries {
+			if !e.isDir {
+				outNames[e.name] = e
+			}
+		}
+		for _, e := range in.entries {
+			if !e.isLink || e.out == "" {
+				continue
+			}
+			target := outNames[e.linkname]
+			if target == nil || target.out == "" {
+				warnings.add(WarningLinkTargetMissing, e.name, "hard link to %s not merged, as that file was not", e.linkname)
+				e.out = ""
+				continue
+			}
+			target.links++
+		}
+	}
+	return nil
+}
+
+// merger writes the entries of the archives being merged to a zip or tar
+// writer, whichever is set.
+type merger struct {
+	opts    MergeOptions
+	tracker *progressTracker
+	level   int
+	zw      *zip.Writer
+	tw      *tar.Writer
+}
+
+// mergeZip writes the entries of a zip input.
+func (m *merger) mergeZip(in *mergeInput) error {
+	for i, f := range in.zip.File {
+		e := in.entries[i]
+		if e.out == "" {
+			continue
+		}
+		if m.zw != nil {
+			if err := m.copyZipEntry(f, e.out); err != nil {
+				return err
+			}
+			continue
+		}
+
+		header, err := tar.FileInfoHeader(f.FileInfo(), "")
+		if err != nil {
+			return err
+		}
+		header.Name = e.out
+		var data io.Reader
+		switch {
+		case e.isDir:
+			header.Name = strings.TrimSuffix(e.out, "/") + "/"
+		case isZipSymlink(f):
+			target, err := readZipEntry(f)
+			if err != nil {
+				return err
+			}
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, string(target), 0
+		default:
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = m.writeTar(header, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := m.writeTar(header, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyZipEntry copies a zip entry into the merged zip without
+// recompression, under the name out.
+func (m *merger) copyZipEntry(f *zip.File, out string) error {
+	header := f.FileHeader
+	header.Name = out
+	if !isASCII(out) {
+		header.Flags |= 0x800
+		header.NonUTF8 = false
+	}
+	w, err := m.zw.CreateRaw(&header)
+	if err != nil {
+		return err
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, contextReader{ctx: optionsContext(m.opts.Context), r: raw}); err != nil {
+		return err
+	}
+	if header.FileInfo().IsDir() {
+		m.entryDone(EntryEvent{Name: out, IsDir: true})
+		return nil
+	}
+	size := int64(header.UncompressedSize64)
+	m.tracker.read(out, size, size)
+	m.entryDone(EntryEvent{Name: out, Size: size, CompressedSize: int64(header.CompressedSize64), Method: header.Method})
+	return nil
+}
+
+// mergeTar writes the entries of a tar.gz input, reading it again.
+func (m *merger) mergeTar(in *mergeInput) error {
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(in.file.reader(), 256<<10))
+	if err != nil {
+		return err
+	}
+	defer gzReader.Close()
+	tarReader := tar.NewReader(gzReader)
+
+	// Zips cannot share data, so files with hard links to them are kept in
+	// a temporary file until the links have been written as copies
+	targets := make(map[string]string)
+	defer func() {
+		for _, tmp := range targets {
+			os.Remove(tmp)
+		}
+	}()
+	outNames := make(map[string]string)
+
+	for i := 0; ; i++ {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return gzipStreamError(filepath.Base(in.path), err)
+		}
+		e := in.entries[i]
+		if e.out == "" {
+			continue
+		}
+		outNames[header.Name] = e.out
+		data := io.Reader(tarReader)
+
+		// tmp is closed once the entry is written, rather than when the
+		// whole input is, so links do not hold a file open each
+		var tmp *os.File
+		if header.Typeflag == tar.TypeLink {
+			if m.tw != nil {
+				header.Linkname = outNames[header.Linkname]
+				data = nil
+			} else {
+				if tmp, err = os.Open(targets[header.Linkname]); err != nil {
+					return err
+				}
+				header.Typeflag, header.Size, data = tar.TypeReg, e.size, tmp
+			}
+		} else if e.links > 0 && m.zw != nil {
+			if tmp, err = os.CreateTemp("", ".pzip-merge-*"); err != nil {
+				return err
+			}
+			targets[header.Name] = tmp.Name()
+			data = io.TeeReader(tarReader, tmp)
+		}
+
+		header.Name = e.out
+		if header.Typeflag == tar.TypeDir {
+			header.Name = strings.TrimSuffix(e.out, "/") + "/"
+		}
+		if m.tw != nil {
+			err = m.writeTar(header, data)
+		} else {
+			err = m.writeZipFromTar(header, data)
+		}
+		if tmp != nil {
+			if closeErr := tmp.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			return gzipStreamError(filepath.Base(in.path), err)
+		}
+	}
+}
+
+// writeTar writes an entry to the merged tar.gz, copying its data from r.
+func (m *merger) writeTar(header *tar.Header, r io.Reader) error {
+	if header.Typeflag == tar.TypeGNUSparse || isSparseHeader(header) {
+		// The reader expands sparse files; they are stored in full
+		header.Typeflag = tar.TypeReg
+		for key := range header.PAXRecords {
+			if strings.HasPrefix(key, "GNU.sparse.") {
+				delete(header.PAXRecords, key)
+			}
+		}
+	}
+	m.opts.TarFormat.apply(header)
+	if err := m.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeReg {
+		m.entryDone(EntryEvent{Name: header.Name, IsDir: header.Typeflag == tar.TypeDir, CompressedSize: -1})
+		return nil
+	}
+	if err := m.copyData(m.tw, header.Name, header.Size, r); err != nil {
+		return err
+	}
+	m.entryDone(EntryEvent{Name: header.Name, Size: header.Size, CompressedSize: -1})
+	return nil
+}
+
+// writeZipFromTar writes a tar entry to the merged zip, compressing its
+// data from r.
+func (m *merger) writeZipFromTar(header *tar.Header, r io.Reader) error {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		fh, err := zip.FileInfoHeader(header.FileInfo())
+		if err != nil {
+			return err
+		}
+		fh.Name = header.Name
+		if _, err := m.zw.CreateHeader(fh); err != nil {
+			return err
+		}
+		m.entryDone(EntryEvent{Name: header.Name, IsDir: true})
+		return nil
+	case tar.TypeSymlink:
+		// Stored as zip tools store links: the target is the data
+		fh, err := zip.FileInfoHeader(header.FileInfo())
+		if err != nil {
+			return err
+		}
+		fh.Name = header.Name
+		fh.Method = zip.Store
+		w, err := m.zw.CreateHeader(fh)
+		if err == nil {
+			_, err = io.WriteString(w, header.Linkname)
+		}
+		if err != nil {
+			return err
+		}
+		m.entryDone(EntryEvent{Name: header.Name, Method: zip.Store})
+		return nil
+	case tar.TypeReg, tar.TypeGNUSparse:
+	default:
+		return nil
+	}
+
+	fh, err := zip.FileInfoHeader(header.FileInfo())
+	if err != nil {
+		return err
+	}
+	fh.Name = header.Name
+	fh.Modified = header.ModTime
+	if m.level == flate.NoCompression {
+		fh.Method = zip.Store
+	} else if fh.Method, r, err = sampleMethod(header.Name, header.Size, r); err != nil {
+		return err
+	}
+	w, err := m.zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	if err := m.copyData(w, header.Name, header.Size, r); err != nil {
+		return err
+	}
+	m.entryDone(EntryEvent{Name: header.Name, Size: header.Size, CompressedSize: -1, Method: fh.Method})
+	return nil
+}
+
+// copyData copies an entry's data, reporting progress.
+func (m *merger) copyData(w io.Writer, name string, size int64, r io.Reader) error {
+	src := &countingReader{r: contextReader{ctx: optionsContext(m.opts.Context), r: r}, onRead: func(n int64) {
+		m.tracker.read(name, size, n)
+	}}
+	_, err := copyBuffered(w, src, m.opts.BufferSize)
+	return err
+}
+
+// entryDone reports an entry written to the merged archive.
+func (m *merger) entryDone(e EntryEvent) {
+	if !e.IsDir {
+		m.tracker.fileDone(e.Name)
+	}
+	if m.opts.OnEntry != nil {
+		m.tracker.locked(func() { m.opts.OnEntry(e) })
+	}
+}
+
+// readZipEntry returns the contents of a small zip entry, such as the
+// target of a symbolic link.
+func readZipEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, 64<<10))
+}
@@ -0,0 +1,145 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeRejectsInputAsOutput(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "in.zip")
+	if _, err := ZipWithOptions(src, zipPath, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := filepath.Join(dir, "other.zip")
+	if _, err := ZipWithOptions(src, other, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MergeArchives([]string{other, zipPath}, zipPath, MergeOptions{Collisions: CollisionRename}); err == nil {
+		t.Error("merging into one of the inputs succeeded")
+	}
+	if after, err := os.ReadFile(zipPath); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("input changed by the merge: %v", err)
+	}
+}
+
+func TestFailedMergeKeepsOutput(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "in.zip")
+	if _, err := ZipWithOptions(src, input, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, name := range []string{"out.zip", "out.tar.gz"} {
+		outPath := filepath.Join(dir, name)
+		if err := os.WriteFile(outPath, []byte("earlier"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		opts := MergeOptions{CreateOptions: CreateOptions{Context: ctx, Workers: 4}}
+		if _, err := MergeArchives([]string{input}, outPath, opts); err == nil {
+			t.Errorf("%s: canceled merge succeeded", name)
+		}
+		if data, err := os.ReadFile(outPath); err != nil || string(data) != "earlier" {
+			t.Errorf("%s: output changed by a failed merge: %q, %v", name, data, err)
+		}
+	}
+	if temps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(temps) > 0 {
+		t.Errorf("failed merges left %v", temps)
+	}
+}
+
+func TestMergeHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	gzPath := filepath.Join(dir, "links.tar.gz")
+	f, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	data := []byte("shared content")
+	tw.WriteHeader(&tar.Header{Name: "data.txt", Mode: 0644, Size: int64(len(data))})
+	tw.Write(data)
+	for _, name := range []string{"copy1.txt", "copy2.txt", "copy3.txt"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeLink, Linkname: "data.txt", Mode: 0644})
+	}
+	tw.WriteHeader(&tar.Header{Name: "broken.txt", Typeflag: tar.TypeLink, Linkname: "missing.txt", Mode: 0644})
+	tw.Close()
+	gw.Close()
+	f.Close()
+
+	zipPath := filepath.Join(dir, "merged.zip")
+	stats, err := MergeArchives([]string{gzPath}, zipPath, MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Warnings) != 1 || stats.Warnings[0].Name != "broken.txt" {
+		t.Errorf("warnings = %+v; want broken.txt reported", stats.Warnings)
+	}
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.File) != 4 {
+		t.Fatalf("merged zip holds %d entries; want data.txt and three copies", len(r.File))
+	}
+	for _, zf := range r.File {
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s = %q, %v; want %q", zf.Name, got, err, data)
+		}
+	}
+
+	// Into a tar.gz the links stay links
+	outGz := filepath.Join(dir, "merged.tar.gz")
+	if _, err := MergeArchives([]string{gzPath}, outGz, MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	links := 0
+	err = WalkArchive(outGz, func(e Entry, r io.Reader) error {
+		if e.LinkTarget == "data.txt" {
+			links++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if links != 3 {
+		t.Errorf("merged tar.gz holds %d links to data.txt; want 3", links)
+	}
+}
@@ -0,0 +1,156 @@
+package zipper
+
+import (
+	"archive/zip"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// NameEncoding is the legacy code page used to decode zip entry names that
+// are not marked as UTF-8, as written by old Windows tools.
+type NameEncoding int
+
+const (
+	// NameEncodingAuto decodes legacy names as Shift-JIS or GBK when every
+	// name is valid in one of them, and otherwise as CP437.
+	NameEncodingAuto NameEncoding = iota
+	// NameEncodingUTF8 leaves names as they are stored.
+	NameEncodingUTF8
+	// NameEncodingCP437 is the original IBM PC code page, which the zip
+	// format names as its default.
+	NameEncodingCP437
+	// NameEncodingGBK is code page 936, simplified Chinese.
+	NameEncodingGBK
+	// NameEncodingShiftJIS is code page 932, Japanese.
+	NameEncodingShiftJIS
+)
+
+// ParseNameEncoding parses the name of a code page as used on the command
+// line.
+func ParseNameEncoding(s string) (NameEncoding, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return NameEncodingAuto, nil
+	case "utf-8", "utf8", "none":
+		return NameEncodingUTF8, nil
+	case "cp437", "437":
+		return NameEncodingCP437, nil
+	case "gbk", "cp936", "936":
+		return NameEncodingGBK, nil
+	case "shift-jis", "shift_jis", "sjis", "cp932", "932":
+		return NameEncodingShiftJIS, nil
+	}
+	return 0, fmt.Errorf("unknown name encoding: %s (use auto, utf-8, cp437, gbk or shift-jis)", s)
+}
+
+func (e NameEncoding) String() string {
+	switch e {
+	case NameEncodingUTF8:
+		return "UTF-8"
+	case NameEncodingCP437:
+		return "CP437"
+	case NameEncodingGBK:
+		return "GBK"
+	case NameEncodingShiftJIS:
+		return "Shift-JIS"
+	}
+	return "auto"
+}
+
+func (e NameEncoding) decoder() *encoding.Decoder {
+	switch e {
+	case NameEncodingCP437:
+		return charmap.CodePage437.NewDecoder()
+	case NameEncodingGBK:
+		return simplifiedchinese.GBK.NewDecoder()
+	case NameEncodingShiftJIS:
+		return japanese.ShiftJIS.NewDecoder()
+	}
+	return nil
+}
+
+// decodeZipNames rewrites the names of files that are not marked as UTF-8
+// from the legacy code page enc, detecting it under NameEncodingAuto, so
+// that filters, path checks and the files written all see the decoded
+// names. Names without the UTF-8 flag that are valid UTF-8 anyway, as many
+// Unix tools write them, are left alone. It returns the code page used, or
+// NameEncodingUTF8 if no name needed decoding.
+func decodeZipNames(files []*zip.File, enc NameEncoding) NameEncoding {
+	var legacy []*zip.File
+	for _, f := range files {
+		if f.NonUTF8 && !utf8.ValidString(f.Name) {
+			legacy = append(legacy, f)
+		}
+	}
+	if len(legacy) == 0 || enc == NameEncodingUTF8 {
+		return NameEncodingUTF8
+	}
+	if enc == NameEncodingAuto {
+		enc = detectNameEncoding(legacy)
+	}
+
+	dec := enc.decoder()
+	for _, f := range legacy {
+		// Invalid sequences become U+FFFD rather than failing extraction
+		if name, err := dec.String(f.Name); err == nil {
+			f.Name = name
+		}
+	}
+	return enc
+}
+
+// detectNameEncoding picks the code page legacy names were most likely
+// written in. Shift-JIS and GBK byte ranges overlap, so when names are
+// valid in both, kana in the Shift-JIS reading, which GBK text almost never
+// yields, decides for Shift-JIS, and otherwise the system's code page
+// decides, with GBK as the default.
+func detectNameEncoding(files []*zip.File) NameEncoding {
+	sjis := decodesAll(files, NameEncodingShiftJIS)
+	gbk := decodesAll(files, NameEncodingGBK)
+	switch {
+	case sjis && gbk:
+		if hasKana(files) || systemNameEncoding() == NameEncodingShiftJIS {
+			return NameEncodingShiftJIS
+		}
+		return NameEncodingGBK
+	case sjis:
+		return NameEncodingShiftJIS
+	case gbk:
+		return NameEncodingGBK
+	}
+	return NameEncodingCP437
+}
+
+// decodesAll reports whether every name decodes under enc without invalid
+// sequences.
+func decodesAll(files []*zip.File, enc NameEncoding) bool {
+	dec := enc.decoder()
+	for _, f := range files {
+		name, err := dec.String(f.Name)
+		if err != nil || strings.ContainsRune(name, utf8.RuneError) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasKana reports whether any name read as Shift-JIS contains hiragana or
+// katakana.
+func hasKana(files []*zip.File) bool {
+	dec := NameEncodingShiftJIS.decoder()
+	for _, f := range files {
+		name, _ := dec.String(f.Name)
+		for _, r := range name {
+			if r >= 0x3040 && r <= 0x30FF || r >= 0xFF66 && r <= 0xFF9F {
+				return true
+			}
+		}
+	}
+	return false
+}
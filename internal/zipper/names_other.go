@@ -0,0 +1,19 @@
+//go:build !windows
+
+package zipper
+
+import "strings"
+
+// systemNameEncoding returns the legacy code page matching the locale, such
+// as Shift-JIS for ja_JP.UTF-8, as Windows tools in that locale would have
+// written names.
+func systemNameEncoding() NameEncoding {
+	locale := firstEnv("LC_ALL", "LC_CTYPE", "LANG")
+	switch {
+	case strings.HasPrefix(locale, "ja"):
+		return NameEncodingShiftJIS
+	case strings.HasPrefix(locale, "zh_CN"), strings.HasPrefix(locale, "zh_SG"):
+		return NameEncodingGBK
+	}
+	return NameEncodingCP437
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package zipper
+
+import "golang.org/x/sys/windows"
+
+// systemNameEncoding returns the legacy code page Windows uses for
+// non-Unicode programs, which zip tools wrote names in.
+func systemNameEncoding() NameEncoding {
+	switch windows.GetACP() {
+	case 932:
+		return NameEncodingShiftJIS
+	case 936:
+		return NameEncodingGBK
+	}
+	return NameEncodingCP437
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // NextArchiveName determines a unique zip filename for baseName within dir.
@@ -21,12 +24,13 @@ func NextArchiveName(dir, baseName string) (string, error) {
 
 	for version := 0; ; version++ {
 		candidate := tryName(version)
-		if _, err := os.Stat(candidate); err != nil {
-			if os.IsNotExist(err) {
-				return candidate, nil
-			}
+		free, err := nameIsFree(candidate)
+		if err != nil {
 			return "", err
 		}
+		if free {
+			return candidate, nil
+		}
 	}
 }
 
@@ -45,7 +49,39 @@ func NextGzipArchiveName(dir, baseName string) (string, error) {
 
 	for version := 0; ; version++ {
 		candidate := tryName(version)
-		if _, err := os.Stat(candidate); err != nil {
+		free, err := nameIsFree(candidate)
+		if err != nil {
+			return "", err
+		}
+		if free {
+			return candidate, nil
+		}
+	}
+}
+
+// nameIsFree reports whether neither the archive candidate nor the first
+// part of a split archive by that name exists.
+func nameIsFree(candidate string) (bool, error) {
+	for _, name := range []string{candidate, candidate + FirstPartSuffix} {
+		if _, err := os.Stat(name); err == nil || !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// nextFreeName returns path, or the first numbered variant such as
+// "file (1).txt" that does not exist yet.
+func nextFreeName(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	for version := 0; ; version++ {
+		candidate := path
+		if version > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, version, ext)
+		}
+		if _, err := os.Lstat(candidate); err != nil {
 			if os.IsNotExist(err) {
 				return candidate, nil
 			}
@@ -53,3 +89,134 @@ func NextGzipArchiveName(dir, baseName string) (string, error) {
 		}
 	}
 }
+
+// NameFields are the values a naming template can refer to.
+type NameFields struct {
+	Base string    // {base}: the name of the folder or file archived
+	Time time.Time // {date}, {time} and {date:LAYOUT}
+	Hash string    // {hash} and {hash:N}: the archive's SHA-256 checksum
+}
+
+// NextTemplateName returns a name within dir, not yet taken, for an archive
+// with extension ext (".zip" or ".tar.gz") named from template, such as
+// "{base}-{date:2006-01-02}-{n}.zip". The placeholders are:
+//
+//	{base}           fields.Base
+//	{date}, {time}   fields.Time as 2006-01-02 and 150405
+//	{date:LAYOUT}    fields.Time in a Go time layout, such as {date:20060102-1504}
+//	{hash}, {hash:N} fields.Hash, or its first N characters
+//	{n}              1, 2, ...: the first number giving a name not taken
+//
+// ext is added unless the template ends with it. A name without {n} that is
+// already taken gets a -v1, -v2, ... suffix, as with NextArchiveName.
+func NextTemplateName(dir, template, ext string, fields NameFields) (string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	numbered := strings.Contains(template, "{n}")
+	for n := 1; ; n++ {
+		name, err := expandNameTemplate(template, fields, n)
+		if err != nil {
+			return "", err
+		}
+		name = strings.TrimSuffix(name, ext)
+		if !numbered && n > 1 {
+			name = fmt.Sprintf("%s-v%d", name, n-1)
+		}
+		candidate := filepath.Join(dir, name+ext)
+		free, err := nameIsFree(candidate)
+		if err != nil {
+			return "", err
+		}
+		if free {
+			return candidate, nil
+		}
+	}
+}
+
+// TemplateNeedsHash reports whether template refers to the archive's
+// checksum, which is only known once the archive has been written.
+func TemplateNeedsHash(template string) bool {
+	return strings.Contains(template, "{hash}") || strings.Contains(template, "{hash:")
+}
+
+// ValidateNameTemplate reports the first placeholder of template that
+// NextTemplateName does not know.
+func ValidateNameTemplate(template string) error {
+	fields := NameFields{Base: "base", Time: time.Now(), Hash: strings.Repeat("0", 64)}
+	_, err := expandNameTemplate(template, fields, 1)
+	return err
+}
+
+// expandNameTemplate returns template with its placeholders replaced, using
+// n for {n}.
+func expandNameTemplate(template string, fields NameFields, n int) (string, error) {
+	var b strings.Builder
+	for rest := template; rest != ""; {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			b.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("name template %q has an unclosed {", template)
+		}
+		b.WriteString(rest[:start])
+		placeholder := rest[start+1 : start+end]
+		rest = rest[start+end+1:]
+
+		key, arg, hasArg := strings.Cut(placeholder, ":")
+		switch {
+		case key == "base" && !hasArg:
+			b.WriteString(fields.Base)
+		case key == "date" && !hasArg:
+			b.WriteString(fields.Time.Format("2006-01-02"))
+		case key == "time" && !hasArg:
+			b.WriteString(fields.Time.Format("150405"))
+		case key == "date" && arg != "":
+			b.WriteString(fields.Time.Format(arg))
+		case key == "n" && !hasArg:
+			fmt.Fprint(&b, n)
+		case key == "hash":
+			if fields.Hash == "" {
+				return "", fmt.Errorf("{%s} needs the archive's checksum", placeholder)
+			}
+			hash := fields.Hash
+			if hasArg {
+				length, err := strconv.Atoi(arg)
+				if err != nil || length < 1 {
+					return "", fmt.Errorf("invalid length in {%s}", placeholder)
+				}
+				hash = hash[:min(length, len(hash))]
+			}
+			b.WriteString(hash)
+		default:
+			return "", fmt.Errorf("unknown placeholder {%s} in name template", placeholder)
+		}
+	}
+	name := b.String()
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("name template %q gives %q, which is not a file name", template, name)
+	}
+	return name, nil
+}
+
+// RenameArchive moves the archive at oldPath to newPath, along with the
+// .sha256 file written beside a tar.gz, which names the archive.
+func RenameArchive(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(oldPath + ".sha256")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	checksum, _, _ := strings.Cut(string(data), " ")
+	if err := writeChecksumFile(newPath, checksum); err != nil {
+		return err
+	}
+	return os.Remove(oldPath + ".sha256")
+}
@@ -0,0 +1,101 @@
+package zipper
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// OverwritePolicy decides what happens when an extracted file already exists.
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways replaces existing files.
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteNever keeps existing files and skips the entry.
+	OverwriteNever
+	// OverwriteIfNewer replaces existing files only when the entry has a
+	// later modification time.
+	OverwriteIfNewer
+	// OverwriteFail aborts extraction.
+	OverwriteFail
+	// OverwritePrompt asks ExtractOptions.ConfirmOverwrite for each file.
+	OverwritePrompt
+	// OverwriteRename keeps existing files and writes the entry under a
+	// numbered name such as "file (1).txt".
+	OverwriteRename
+)
+
+// ParseOverwritePolicy parses the name of an overwrite policy as used on the
+// command line.
+func ParseOverwritePolicy(s string) (OverwritePolicy, error) {
+	switch s {
+	case "always", "force":
+		return OverwriteAlways, nil
+	case "never", "skip":
+		return OverwriteNever, nil
+	case "newer":
+		return OverwriteIfNewer, nil
+	case "fail":
+		return OverwriteFail, nil
+	case "prompt":
+		return OverwritePrompt, nil
+	case "rename":
+		return OverwriteRename, nil
+	}
+	return 0, fmt.Errorf("unknown overwrite policy: %s (use always, skip, newer, fail, prompt or rename)", s)
+}
+
+// conflictResolver applies the overwrite policy to files that already exist
+// and counts the outcomes. It is not safe for concurrent use.
+type conflictResolver struct {
+	policy      OverwritePolicy
+	confirm     func(path string) bool
+	skipped     int
+	overwritten int
+	renamed     map[string]string
+	warnings    *warningList
+}
+
+// resolve returns the path an entry modified at modified should be written
+// to in place of destPath, reporting false if it should be skipped.
+func (c *conflictResolver) resolve(name, destPath string, modified time.Time) (string, bool, error) {
+	info, err := os.Lstat(destPath)
+	if os.IsNotExist(err) {
+		return destPath, true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	write := true
+	switch c.policy {
+	case OverwriteNever:
+		write = false
+	case OverwriteIfNewer:
+		write = modified.After(info.ModTime())
+	case OverwriteFail:
+		return "", false, &fs.PathError{Op: "extract", Path: destPath, Err: fs.ErrExist}
+	case OverwritePrompt:
+		write = c.confirm != nil && c.confirm(destPath)
+	case OverwriteRename:
+		renamed, err := nextFreeName(destPath)
+		if err != nil {
+			return "", false, err
+		}
+		if c.renamed == nil {
+			c.renamed = make(map[string]string)
+		}
+		c.renamed[name] = renamed
+		c.warnings.add(WarningEntryRenamed, name, "existing file kept; written as %s", filepath.Base(renamed))
+		return renamed, true, nil
+	}
+	if write {
+		c.overwritten++
+	} else {
+		c.skipped++
+	}
+	return destPath, write, nil
+}
@@ -0,0 +1,166 @@
+package zipper
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseOverwritePolicy(t *testing.T) {
+	for name, want := range map[string]OverwritePolicy{
+		"always": OverwriteAlways, "force": OverwriteAlways,
+		"never": OverwriteNever, "skip": OverwriteNever,
+		"newer": OverwriteIfNewer, "fail": OverwriteFail,
+		"prompt": OverwritePrompt, "rename": OverwriteRename,
+	} {
+		if got, err := ParseOverwritePolicy(name); err != nil || got != want {
+			t.Errorf("ParseOverwritePolicy(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseOverwritePolicy("sometimes"); err == nil {
+		t.Error("ParseOverwritePolicy accepted an unknown policy")
+	}
+}
+
+func TestOverwritePolicies(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	entryTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("archived"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(src, "a.txt"), entryTime, entryTime); err != nil {
+		t.Fatal(err)
+	}
+	archives := map[string]string{"zip": filepath.Join(dir, "in.zip"), "tar.gz": filepath.Join(dir, "in.tar.gz")}
+	if _, err := ZipWithOptions(src, archives["zip"], CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GzipWithOptions(src, archives["tar.gz"], CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	older, newer := entryTime.AddDate(-10, 0, 0), entryTime.AddDate(10, 0, 0)
+	yes := func(string) bool { return true }
+	no := func(string) bool { return false }
+	tests := []struct {
+		name     string
+		policy   OverwritePolicy
+		confirm  func(string) bool
+		existing time.Time // modification time of the file already there
+		want     string    // content of a.txt afterwards
+		renamed  bool      // the entry went to "a (1).txt"
+		fail     bool
+	}{
+		{name: "always", policy: OverwriteAlways, existing: newer, want: "archived"},
+		{name: "never", policy: OverwriteNever, existing: older, want: "existing"},
+		{name: "newer entry", policy: OverwriteIfNewer, existing: older, want: "archived"},
+		{name: "older entry", policy: OverwriteIfNewer, existing: newer, want: "existing"},
+		{name: "fail", policy: OverwriteFail, existing: older, want: "existing", fail: true},
+		{name: "prompt yes", policy: OverwritePrompt, confirm: yes, existing: older, want: "archived"},
+		{name: "prompt no", policy: OverwritePrompt, confirm: no, existing: older, want: "existing"},
+		{name: "prompt without confirm", policy: OverwritePrompt, existing: older, want: "existing"},
+		{name: "rename", policy: OverwriteRename, existing: older, want: "existing", renamed: true},
+	}
+	for format, archivePath := range archives {
+		for _, tt := range tests {
+			dest := filepath.Join(dir, format+"-"+tt.name)
+			if err := os.Mkdir(dest, 0755); err != nil {
+				t.Fatal(err)
+			}
+			existing := filepath.Join(dest, "a.txt")
+			if err := os.WriteFile(existing, []byte("existing"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(existing, tt.existing, tt.existing); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := ExtractOptions{Overwrite: tt.policy, ConfirmOverwrite: tt.confirm}
+			var stats ExtractStats
+			var err error
+			if format == "zip" {
+				stats, err = ExtractWithOptions(archivePath, dest, opts)
+			} else {
+				stats, err = ExtractGzipWithOptions(archivePath, dest, opts)
+			}
+			if tt.fail {
+				if !errors.Is(err, fs.ErrExist) {
+					t.Errorf("%s %s: extract = %v; want %v", format, tt.name, err, fs.ErrExist)
+				}
+			} else if err != nil {
+				t.Errorf("%s %s: %v", format, tt.name, err)
+				continue
+			}
+
+			if data, _ := os.ReadFile(existing); string(data) != tt.want {
+				t.Errorf("%s %s: a.txt = %q; want %q", format, tt.name, data, tt.want)
+			}
+			renamedPath := filepath.Join(dest, "a (1).txt")
+			data, err := os.ReadFile(renamedPath)
+			if tt.renamed {
+				if string(data) != "archived" || stats.Renamed["a.txt"] != renamedPath {
+					t.Errorf("%s %s: a (1).txt = %q, renamed %v; want the entry written there", format, tt.name, data, stats.Renamed)
+				}
+			} else if err == nil {
+				t.Errorf("%s %s: a (1).txt was written", format, tt.name)
+			}
+			if tt.fail || tt.renamed {
+				continue
+			}
+			if written := tt.want == "archived"; written && stats.Overwritten != 1 || !written && stats.Skipped != 1 {
+				t.Errorf("%s %s: overwritten %d, skipped %d", format, tt.name, stats.Overwritten, stats.Skipped)
+			}
+		}
+	}
+}
+
+// TestOverwritePromptWorkerFailure fails the only worker while the user is
+// being asked about an existing file; extraction must stop asking and
+// return the worker's error once the answer is in.
+func TestOverwritePromptWorkerFailure(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "in.zip")
+	names := []string{"b.txt", "c.txt", "d.txt"}
+	writeUnreadableZip(t, archivePath, names...)
+	dest := filepath.Join(dir, "out")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("existing"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var asked atomic.Int32
+	opts := ExtractOptions{
+		Workers:   1,
+		Overwrite: OverwritePrompt,
+		ConfirmOverwrite: func(string) bool {
+			asked.Add(1)
+			// The worker fails on the first entry meanwhile
+			time.Sleep(50 * time.Millisecond)
+			return true
+		},
+	}
+	stats, err := ExtractWithOptions(archivePath, dest, opts)
+	if err == nil {
+		t.Fatal("extracting an unreadable entry succeeded")
+	}
+	after := asked.Load()
+	time.Sleep(100 * time.Millisecond)
+	if n := asked.Load(); n != 1 || n != after {
+		t.Errorf("asked %d times, %d of them after extraction returned; want 1 and none", n, n-after)
+	}
+	if stats.Overwritten != 0 || stats.Skipped != 0 {
+		t.Errorf("overwritten %d, skipped %d reported for a failed extraction", stats.Overwritten, stats.Skipped)
+	}
+}
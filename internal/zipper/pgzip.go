@@ -0,0 +1,211 @@
+package zipper
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// errGzipAborted is the error writes to an aborted writer return.
+var errGzipAborted = errors.New("gzip: writer aborted")
+
+const (
+	// gzipBlockSize is the amount of input compressed by each job.
+	gzipBlockSize = 1 << 20
+	// gzipDictSize is the deflate window carried over between blocks.
+	gzipDictSize = 32 << 10
+)
+
+// parallelGzipWriter produces a single-member gzip stream whose input is
+// split into blocks compressed concurrently, in the style of pigz/pgzip.
+// Each block is primed with the last 32 KB of the previous one so the ratio
+// stays close to a serial stream, and is ended with a sync flush so the
+// deflate streams concatenate; only the last block is marked final.
+type parallelGzipWriter struct {
+	w     io.Writer
+	level int
+
+	block []byte // input not yet dispatched
+	dict  []byte // tail of the previous block
+	crc   uint32
+	size  uint32 // input size modulo 2^32, as stored in the trailer
+
+	queue  chan chan gzipBlock // results in stream order
+	done   chan error
+	closed bool
+
+	mu  sync.Mutex
+	err error // first error from compressing or writing a block
+}
+
+type gzipBlock struct {
+	data []byte
+	err  error
+}
+
+// newParallelGzipWriter writes the gzip header to w and starts the ordered
+// output goroutine. At most workers blocks are compressed at once.
+func newParallelGzipWriter(w io.Writer, level, workers int) (*parallelGzipWriter, error) {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, errors.New("gzip: invalid compression level")
+	}
+	header := [10]byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	switch level {
+	case flate.BestCompression:
+		header[8] = 2
+	case flate.BestSpeed:
+		header[8] = 4
+	}
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, err
+	}
+
+	z := &parallelGzipWriter{
+		w:     w,
+		level: level,
+		block: make([]byte, 0, gzipBlockSize),
+		queue: make(chan chan gzipBlock, workers),
+		done:  make(chan error, 1),
+	}
+	go z.writeBlocks()
+	return z, nil
+}
+
+// writeBlocks writes compressed blocks to the underlying writer in order.
+// After the first error the remaining blocks are drained without writing.
+func (z *parallelGzipWriter) writeBlocks() {
+	for result := range z.queue {
+		b := <-result
+		err := z.failed()
+		if err == nil {
+			err = b.err
+		}
+		if err == nil {
+			_, err = z.w.Write(b.data)
+		}
+		if err != nil {
+			z.fail(err)
+		}
+	}
+	z.done <- z.failed()
+}
+
+// fail records err unless an earlier error was recorded.
+func (z *parallelGzipWriter) fail(err error) {
+	z.mu.Lock()
+	if z.err == nil {
+		z.err = err
+	}
+	z.mu.Unlock()
+}
+
+// failed returns the first error recorded by writeBlocks, if any.
+func (z *parallelGzipWriter) failed() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.err
+}
+
+func (z *parallelGzipWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("gzip: write to closed writer")
+	}
+	if err := z.failed(); err != nil {
+		return 0, err
+	}
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+
+	n := len(p)
+	for len(p) > 0 {
+		k := copy(z.block[len(z.block):cap(z.block)], p)
+		z.block = z.block[:len(z.block)+k]
+		p = p[k:]
+		if len(z.block) == cap(z.block) {
+			if err := z.dispatch(false); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// dispatch hands the current block to a compression goroutine. It returns
+// the first error of an earlier block instead, since output has stopped.
+func (z *parallelGzipWriter) dispatch(final bool) error {
+	if err := z.failed(); err != nil {
+		return err
+	}
+	block, dict := z.block, z.dict
+	result := make(chan gzipBlock, 1)
+	z.queue <- result // blocks while workers blocks are in flight
+
+	go func() {
+		var out bytes.Buffer
+		fw, err := flate.NewWriterDict(&out, z.level, dict)
+		if err == nil {
+			if _, err = fw.Write(block); err == nil {
+				if final {
+					err = fw.Close()
+				} else {
+					err = fw.Flush()
+				}
+			}
+		}
+		result <- gzipBlock{data: out.Bytes(), err: err}
+	}()
+
+	// Only full blocks are dispatched before the final one, so the tail of
+	// the block always covers the whole window
+	if len(block) >= gzipDictSize {
+		z.dict = block[len(block)-gzipDictSize:]
+	}
+	z.block = make([]byte, 0, gzipBlockSize)
+	return nil
+}
+
+// Close compresses the remaining input, waits for all blocks to be written
+// and appends the gzip trailer. It does not close the underlying writer.
+func (z *parallelGzipWriter) Close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	z.dispatch(true) // an earlier error is reported by writeBlocks below
+	close(z.queue)
+	if err := <-z.done; err != nil {
+		return err
+	}
+
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[0:], z.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], z.size)
+	_, err := z.w.Write(trailer[:])
+	return err
+}
+
+// abort stops the writer without finishing the stream, for when the archive
+// it writes has failed: blocks in flight are drained without writing and
+// the output goroutine exits. It does nothing after Close.
+func (z *parallelGzipWriter) abort() {
+	if z.closed {
+		return
+	}
+	z.closed = true
+	z.fail(errGzipAborted)
+	close(z.queue)
+	<-z.done
+	z.block, z.dict = nil, nil
+}
+
+// abortGzip aborts w if it is a parallel writer. A serial gzip.Writer runs
+// no goroutines, so it is left for the garbage collector.
+func abortGzip(w io.Writer) {
+	if z, ok := w.(*parallelGzipWriter); ok {
+		z.abort()
+	}
+}
@@ -0,0 +1,100 @@
+package zipper
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestParallelGzipRoundTrip(t *testing.T) {
+	data := make([]byte, 3*gzipBlockSize+12345)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range data {
+		data[i] = byte('a' + rnd.Intn(4))
+	}
+	var out bytes.Buffer
+	z, err := newParallelGzipWriter(&out, flate.DefaultCompression, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := z.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("round trip returned %d bytes; want the %d written", len(got), len(data))
+	}
+}
+
+// failingWriter accepts n writes and fails every later one.
+type failingWriter struct{ n int }
+
+var errDiskFull = errors.New("disk full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errDiskFull
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestParallelGzipWriteError(t *testing.T) {
+	// The header is written, then the first block fails
+	z, err := newParallelGzipWriter(&failingWriter{n: 1}, flate.BestSpeed, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := make([]byte, gzipBlockSize)
+	var writeErr error
+	for i := 0; i < 8 && writeErr == nil; i++ {
+		_, writeErr = z.Write(block)
+	}
+	if !errors.Is(writeErr, errDiskFull) {
+		t.Errorf("Write after a failed block = %v; want %v", writeErr, errDiskFull)
+	}
+	if err := z.Close(); !errors.Is(err, errDiskFull) {
+		t.Errorf("Close = %v; want %v", err, errDiskFull)
+	}
+}
+
+func TestParallelGzipAbort(t *testing.T) {
+	var out bytes.Buffer
+	z, err := newParallelGzipWriter(&out, flate.BestSpeed, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := make([]byte, gzipBlockSize)
+	for i := 0; i < 6; i++ {
+		if _, err := z.Write(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Returns only once the output goroutine has exited
+	z.abort()
+	size := out.Len()
+	if _, err := z.Write(block); err == nil {
+		t.Error("Write to an aborted writer succeeded")
+	}
+	if err := z.Close(); err != nil {
+		t.Errorf("Close after abort = %v", err)
+	}
+	if out.Len() != size {
+		t.Error("aborted writer wrote more output")
+	}
+}
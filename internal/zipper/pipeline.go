@@ -0,0 +1,551 @@
+package zipper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxMemory is the default ceiling on file data buffered in memory by
+// the parallel readers while creating an archive.
+const DefaultMaxMemory = 256 << 20
+
+// fileData is a file ready to be written to an archive. Files that fit in a
+// worker's share of the memory ceiling are read into data up front; larger
+// files are marked streamed and copied from disk by the writer.
+type fileData struct {
+	job      fileJob
+	data     []byte
+	held     int64        // bytes of the memory budget reserved for data
+	regions  []dataRegion // non-nil for sparse files
+	streamed bool
+
+	// Set for zip entries compressed by the workers; data holds the
+	// compressed bytes unless they were spilled to disk.
+	compressed     bool
+	method         uint16
+	crc32          uint32
+	rawSize        int64
+	compressedSize int64
+	spill          *os.File
+	sha256         []byte // digest of the source data, if requested
+
+	err error // set when the file could not be read
+}
+
+// fileLoader prepares a file on a worker goroutine before it is handed to
+// the writer.
+type fileLoader func(p *readPipeline, fd *fileData) error
+
+// collectFiles walks srcDir and returns every entry below it that is not
+// excluded by opts or left out by its filters, named under prefix. Entries
+// that cannot be read are passed to skips. Symbolic links are followed with
+// opts.Dereference and otherwise left out.
+func collectFiles(srcDir, prefix string, opts CreateOptions, skips *skipList) ([]fileJob, error) {
+	if err := validateGlobs(opts.Exclude); err != nil {
+		return nil, err
+	}
+	root := srcDir
+	if opts.Dereference {
+		// Walk the real folder, so that links can be compared with it
+		real, err := filepath.EvalSymlinks(srcDir)
+		if err != nil {
+			return nil, err
+		}
+		root = real
+	}
+	w := &sourceWalker{exclude: opts.Exclude, filter: createFilter(opts), dereference: opts.Dereference, skips: skips}
+	err := w.walk(root, prefix, nil)
+	return w.files, err
+}
+
+// collectRoot returns the entries to archive for src: the contents of a
+// folder, or a file by itself under its own name.
+func collectRoot(src string, opts CreateOptions, skips *skipList) ([]fileJob, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []fileJob{{path: src, rel: filepath.Base(src), info: info}}, nil
+	}
+	files, err := collectFiles(src, "", opts, skips)
+	if err == nil && opts.Reproducible {
+		sortFiles(files)
+	}
+	return files, err
+}
+
+// collectSources returns the entries of several files and folders, each
+// named under its base name, with the contents of folders collected as by
+// collectFiles.
+func collectSources(sources []string, opts CreateOptions, skips *skipList) ([]fileJob, error) {
+	var files []fileJob
+	names := make(map[string]string, len(sources))
+	for _, source := range sources {
+		if !filepath.IsAbs(source) {
+			abs, err := filepath.Abs(source)
+			if err != nil {
+				return nil, err
+			}
+			source = abs
+		}
+		source = longPath(source)
+		info, err := os.Stat(source)
+		if err != nil {
+			return nil, err
+		}
+
+		base := filepath.Base(source)
+		if other, ok := names[base]; ok {
+			return nil, fmt.Errorf("%s and %s would both be stored as %s", other, source, base)
+		}
+		names[base] = source
+
+		files = append(files, fileJob{path: source, rel: base, info: info, isDir: info.IsDir()})
+		if info.IsDir() {
+			contents, err := collectFiles(source, base, opts, skips)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, contents...)
+		}
+	}
+	if opts.Reproducible {
+		sortFiles(files)
+	}
+	return files, nil
+}
+
+// sourceWalker collects the entries below a source folder.
+type sourceWalker struct {
+	exclude     []string
+	filter      entryFilter
+	dereference bool
+	skips       *skipList
+	files       []fileJob
+}
+
+// walk adds the entries below dir, naming them under prefix. parents holds
+// the folders containing the links followed to reach dir, which a link must
+// not lead back to.
+func (w *sourceWalker) walk(dir, prefix string, parents []string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, walkErr error) error {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			if walkErr != nil && prefix != "" {
+				// The target of a followed link
+				return w.skips.skip(prefix, walkErr)
+			}
+			return walkErr
+		}
+		rel = filepath.Join(prefix, rel)
+
+		if walkErr != nil {
+			// An unreadable folder is reported after its own entry;
+			// returning nil leaves out its contents
+			return w.skips.skip(rel, walkErr)
+		}
+		if skip, err := excluded(rel, d, w.exclude); skip || err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return w.skips.skip(rel, err)
+		}
+		if w.filter.skip(d.Name(), fileAttributes(info)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			if !w.dereference {
+				w.skips.skipLink(rel, path)
+				return nil
+			}
+			return w.follow(path, rel, parents)
+		}
+
+		w.files = append(w.files, fileJob{
+			path:  path,
+			rel:   rel,
+			info:  info,
+			isDir: d.IsDir(),
+		})
+		return nil
+	})
+}
+
+// follow adds the target of the symbolic link at path under the link's
+// name. Links to a folder containing the link itself, or any link followed
+// on the way to it, are left out since they would never end.
+func (w *sourceWalker) follow(path, rel string, parents []string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		// A dangling link
+		return w.skips.skip(rel, err)
+	}
+	if !info.IsDir() {
+		w.files = append(w.files, fileJob{path: path, rel: rel, info: info})
+		return nil
+	}
+
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return w.skips.skip(rel, err)
+	}
+	parents = append(parents[:len(parents):len(parents)], filepath.Dir(path))
+	for _, parent := range parents {
+		if parent == target || strings.HasPrefix(parent, target+string(filepath.Separator)) {
+			w.skips.skipLoop(rel, target)
+			return nil
+		}
+	}
+
+	w.files = append(w.files, fileJob{path: path, rel: rel, info: info, isDir: true})
+	return w.walk(target, rel, parents)
+}
+
+// readPipeline reads files with a pool of workers and delivers them in
+// completion order, or in the order given when turns is set. The bytes held
+// in memory at any time never exceed the configured ceiling; the writer
+// returns each file's share with release.
+type readPipeline struct {
+	out    chan fileData
+	quit   chan struct{}
+	budget *memoryBudget
+	turns  *turnOrder
+	once   sync.Once
+
+	// inlineLimit is the largest file a worker may buffer in memory; each
+	// worker gets an equal share of the ceiling.
+	inlineLimit int64
+}
+
+// startReadPipeline starts workerCount workers running load over files,
+// delivering them in the order of files when ordered is set.
+func startReadPipeline(files []fileJob, workerCount int, maxMemory int64, ordered bool, load fileLoader) *readPipeline {
+	if maxMemory <= 0 {
+		maxMemory = DefaultMaxMemory
+	}
+	p := &readPipeline{
+		out:         make(chan fileData, workerCount),
+		quit:        make(chan struct{}),
+		budget:      newMemoryBudget(maxMemory),
+		inlineLimit: maxMemory / int64(workerCount),
+	}
+	if ordered {
+		// A worker waiting for its turn holds at most one file, so the
+		// others can never take the whole ceiling from the one whose turn
+		// it is
+		p.turns = newTurnOrder()
+	}
+
+	jobChan := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobChan {
+				fd := fileData{job: files[i]}
+				if !fd.job.isDir {
+					if err := load(p, &fd); err != nil {
+						if err == errPipelineStopped || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+							// The writer reports cancellation; later
+							// files must not wait for this one
+							p.turns.stop()
+							return
+						}
+						// The writer skips the file or aborts, as the
+						// error policy says
+						fd.err = err
+					}
+				}
+				if !p.turns.wait(i) {
+					p.release(fd)
+					return
+				}
+				select {
+				case p.out <- fd:
+					p.turns.pass()
+				case <-p.quit:
+					p.release(fd)
+					return
+				}
+			}
+		}()
+	}
+
+	// Send jobs to workers
+	go func() {
+		defer close(jobChan)
+		for i := range files {
+			select {
+			case jobChan <- i:
+			case <-p.quit:
+				return
+			}
+		}
+	}()
+
+	// Close the output channel when all workers finish
+	go func() {
+		wg.Wait()
+		close(p.out)
+	}()
+
+	return p
+}
+
+// errPipelineStopped is returned by loaders when the pipeline is abandoned.
+var errPipelineStopped = fmt.Errorf("read pipeline stopped")
+
+// loadFile returns a fileLoader that reads the file described by fd.job
+// into memory, at the pace rate allows, or marks it streamed when its data
+// exceeds the inline limit. With sparse set, holes in sparse files are
+// detected so only their data regions are read.
+func loadFile(rate *rateLimiter, sparse bool) fileLoader {
+	return func(p *readPipeline, fd *fileData) error {
+		f, err := os.Open(fd.job.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		// The entry's header carries the size the walk found, so no more
+		// than that is read; a file that has shrunk since fails below. Holes
+		// are only looked for while the size still matches
+		size := fd.job.info.Size()
+		if sparse && info.Size() == size {
+			fd.regions = fileDataRegions(f, info)
+		}
+		if fd.regions != nil {
+			size = regionsLength(fd.regions)
+		}
+		if size > p.inlineLimit {
+			fd.streamed = true
+			return nil
+		}
+
+		if !p.budget.acquire(size) {
+			return errPipelineStopped
+		}
+		var r io.Reader = f
+		if fd.regions != nil {
+			r = regionReader(f, fd.regions)
+		}
+		data := make([]byte, size)
+		n, err := io.ReadFull(rate.reader(r), data)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = shrankError(fd.job.path, size, int64(n))
+		}
+		if err != nil {
+			p.budget.release(size)
+			return err
+		}
+		fd.data, fd.held = data, size
+		return nil
+	}
+}
+
+// shrankError reports a file that ended after n of the size bytes it had
+// when opened, as happens when it is truncated while being archived. Like
+// any read error it aborts or skips the file as the error policy says.
+func shrankError(path string, size, n int64) error {
+	return fmt.Errorf("%s shrank from %d to %d bytes while being read", path, size, n)
+}
+
+// release returns the memory held by fd to the budget and removes any
+// spill file.
+func (p *readPipeline) release(fd fileData) {
+	if fd.held > 0 {
+		p.budget.release(fd.held)
+	}
+	if fd.spill != nil {
+		fd.spill.Close()
+		os.Remove(fd.spill.Name())
+	}
+}
+
+// stop abandons the pipeline, unblocking any workers still running.
+func (p *readPipeline) stop() {
+	p.once.Do(func() {
+		close(p.quit)
+		p.budget.close()
+		p.turns.stop()
+		// Drain delivered files so their memory is returned
+		go func() {
+			for fd := range p.out {
+				p.release(fd)
+			}
+		}()
+	})
+}
+
+// open returns a reader over the contents of a streamed file, limited by
+// rate. For sparse files only the data regions are read.
+func (fd fileData) open(rate *rateLimiter) (io.ReadCloser, error) {
+	f, err := os.Open(fd.job.path)
+	if err != nil {
+		return nil, err
+	}
+	if fd.regions == nil && rate == nil {
+		return f, nil
+	}
+	var r io.Reader = f
+	if fd.regions != nil {
+		r = regionReader(f, fd.regions)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{rate.reader(r), f}, nil
+}
+
+// memoryBudget limits the number of bytes held in memory at once.
+type memoryBudget struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	avail  int64
+	closed bool
+}
+
+func newMemoryBudget(n int64) *memoryBudget {
+	b := &memoryBudget{avail: n}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until n bytes are available. It reports false if the
+// budget was closed while waiting.
+func (b *memoryBudget) acquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.avail < n && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return false
+	}
+	b.avail -= n
+	return true
+}
+
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	b.avail += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+func (b *memoryBudget) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// turnOrder lets the workers of a readPipeline deliver files one at a time in
+// the order they were collected. Its methods do nothing on a nil turnOrder.
+type turnOrder struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	next    int
+	stopped bool
+}
+
+func newTurnOrder() *turnOrder {
+	t := &turnOrder{}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// wait blocks until the file at index i is next. It reports false if the
+// pipeline was stopped while waiting.
+func (t *turnOrder) wait(i int) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.next != i && !t.stopped {
+		t.cond.Wait()
+	}
+	return !t.stopped
+}
+
+// pass makes the following file next.
+func (t *turnOrder) pass() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.next++
+	t.mu.Unlock()
+	t.cond.Broadcast()
+}
+
+func (t *turnOrder) stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.stopped = true
+	t.mu.Unlock()
+	t.cond.Broadcast()
+}
+
+// contextReader fails reads once ctx is done, so that cancellation also
+// interrupts copying a large file.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// optionsContext returns the Context of a set of options, which may be nil.
+func optionsContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// countingReader reports every read through onRead so progress can advance
+// while a large file is streamed.
+type countingReader struct {
+	r      io.Reader
+	onRead func(n int64)
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.onRead(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,44 @@
+package zipper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadFileSizeSinceWalk changes a file between the walk and the read;
+// the data loaded must match the size its tar header was made from.
+func TestLoadFileSizeSinceWalk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := fileJob{path: path, rel: "a.txt", info: info}
+	p := &readPipeline{budget: newMemoryBudget(1 << 20), inlineLimit: 1 << 20}
+	load := loadFile(nil, false)
+
+	if err := os.WriteFile(path, []byte("0123456789 and more"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fd := fileData{job: job}
+	if err := load(p, &fd); err != nil {
+		t.Fatalf("grown file: %v", err)
+	}
+	if string(fd.data) != "0123456789" {
+		t.Errorf("grown file loaded as %q; want the size seen by the walk", fd.data)
+	}
+	p.release(fd)
+
+	if err := os.WriteFile(path, []byte("01234"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fd = fileData{job: job}
+	if err := load(p, &fd); err == nil || !strings.Contains(err.Error(), "shrank") {
+		t.Errorf("shrunk file: %v; want it reported as shrunk", err)
+	}
+}
@@ -0,0 +1,278 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PlanAction is what an archive operation would do with an entry.
+type PlanAction string
+
+const (
+	ActionAdd       PlanAction = "add"       // archived
+	ActionCreate    PlanAction = "create"    // extracted to a new file or folder
+	ActionOverwrite PlanAction = "overwrite" // extracted over an existing file
+	ActionSkip      PlanAction = "skip"      // left out, keeping an existing file
+	ActionKeep      PlanAction = "keep"      // already extracted in full, under Resume
+	ActionRename    PlanAction = "rename"    // extracted under a numbered name
+	ActionFail      PlanAction = "fail"      // would abort, under OverwriteFail
+	ActionAsk       PlanAction = "ask"       // left to ConfirmOverwrite
+	ActionUnsafe    PlanAction = "unsafe"    // outside the destination; aborts extraction
+)
+
+// PlanEntry is an entry an archive operation would process.
+type PlanEntry struct {
+	Name   string     `json:"name"`
+	IsDir  bool       `json:"dir,omitempty"`
+	Size   int64      `json:"size"`
+	Action PlanAction `json:"action"`
+}
+
+// Plan describes what creating or extracting an archive would do, without
+// anything having been written.
+type Plan struct {
+	Entries []PlanEntry `json:"entries"`
+	// TotalBytes and FileCount cover the files that would be written.
+	TotalBytes int64 `json:"total_bytes"`
+	FileCount  int   `json:"file_count"`
+	// Overwritten counts existing files that would be replaced.
+	Overwritten int `json:"overwritten,omitempty"`
+	// Unsafe lists entries whose paths lead outside the destination, which
+	// make extraction fail before they are written.
+	Unsafe []string `json:"unsafe,omitempty"`
+	// SkippedFiles lists unreadable files and folders that would be left
+	// out under ErrorSkip.
+	SkippedFiles []string `json:"skipped_files,omitempty"`
+	// Warnings lists problems found, such as symbolic links that would be
+	// left out.
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+func (p *Plan) add(e PlanEntry) {
+	p.Entries = append(p.Entries, e)
+	switch e.Action {
+	case ActionUnsafe:
+		p.Unsafe = append(p.Unsafe, e.Name)
+		return
+	case ActionOverwrite:
+		p.Overwritten++
+	case ActionSkip, ActionKeep, ActionFail:
+		return
+	}
+	if !e.IsDir {
+		p.TotalBytes += e.Size
+		p.FileCount++
+	}
+}
+
+// PlanCreate walks sources as ZipToDestination would and returns the
+// entries an archive of them would hold, applying the same filters and
+// error policy. OnSkip is called for unreadable files under ErrorSkip.
+func PlanCreate(sources []string, opts CreateOptions) (plan Plan, err error) {
+	skips := newSkipList(opts)
+	files, err := collectAll(sources, opts, skips)
+	if err != nil {
+		return plan, err
+	}
+	for _, job := range files {
+		name := filepath.ToSlash(job.rel)
+		if job.isDir {
+			plan.add(PlanEntry{Name: name + "/", IsDir: true, Action: ActionAdd})
+			continue
+		}
+		plan.add(PlanEntry{Name: name, Size: job.info.Size(), Action: ActionAdd})
+	}
+	plan.SkippedFiles = skips.names
+	plan.Warnings = skips.warnings.list()
+	return plan, nil
+}
+
+// PlanExtract reads the zip archive at zipPath and returns what extracting
+// it into destDir with opts would do to each entry. Unsafe paths are listed
+// rather than reported as an error, and the destination is only read. Under
+// Flatten entries are listed by the names they would be written as, and
+// files replaced by a later one as skipped.
+func PlanExtract(zipPath, destDir string, opts ExtractOptions) (plan Plan, err error) {
+	archive, err := openArchive(longPath(zipPath))
+	if err != nil {
+		return plan, err
+	}
+	defer archive.Close()
+	reader, err := zip.NewReader(archive, archive.Size())
+	if err != nil {
+		return plan, err
+	}
+	decodeZipNames(reader.File, opts.NameEncoding)
+
+	filter := extractFilter(opts)
+	warnings := &warningList{}
+	var files []*zip.File
+	for _, f := range reader.File {
+		if !filter.skipEntry(f.Name, f.ExternalAttrs) {
+			files = append(files, f)
+		}
+	}
+	var flatNames map[string]string
+	if opts.Flatten {
+		if flatNames, err = flattenZipNames(files, opts.FlattenCollisions, warnings); err != nil {
+			return plan, err
+		}
+	}
+
+	for _, f := range files {
+		if isZipSymlink(f) {
+			warnings.add(WarningSymlinkSkipped, f.Name, "symbolic link not extracted")
+			continue
+		}
+		entry := PlanEntry{Name: f.Name, IsDir: f.FileInfo().IsDir(), Size: int64(f.UncompressedSize64)}
+		if opts.Flatten {
+			if entry.IsDir {
+				continue
+			}
+			if flat := flatNames[f.Name]; flat != "" {
+				entry.Name = flat
+			} else {
+				entry.Action = ActionSkip
+				plan.add(entry)
+				continue
+			}
+		}
+		destPath := filepath.Join(longPath(destDir), filepath.FromSlash(entry.Name))
+		switch {
+		case !filepath.IsLocal(entry.Name):
+			entry.Action = ActionUnsafe
+		case entry.IsDir:
+			entry.Action = ActionCreate
+		case opts.Resume && zipEntryDone(destPath, f):
+			entry.Action = ActionKeep
+		default:
+			entry.Action = planConflict(opts.Overwrite, destPath, f.Modified)
+		}
+		plan.add(entry)
+	}
+	plan.Warnings = warnings.list()
+	return plan, nil
+}
+
+// PlanExtractGzip is PlanExtract for a tar.gz archive, which is read in
+// full to list its entries.
+func PlanExtractGzip(gzipPath, destDir string, opts ExtractOptions) (plan Plan, err error) {
+	archive, err := openArchive(longPath(gzipPath))
+	if err != nil {
+		return plan, err
+	}
+	defer archive.Close()
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(archive.reader(), 256<<10))
+	if err != nil {
+		return plan, err
+	}
+	defer gzReader.Close()
+	tarReader := tar.NewReader(gzReader)
+
+	ctx := optionsContext(opts.Context)
+	filter := extractFilter(opts)
+	warnings := &warningList{}
+	var flat *flattener
+	if opts.Flatten {
+		flat = newFlattener(opts.FlattenCollisions, warnings)
+	}
+	sizes := make(map[string]int64) // of files that hard links may copy
+	for {
+		if err := ctx.Err(); err != nil {
+			return plan, err
+		}
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return plan, gzipStreamError(filepath.Base(gzipPath), err)
+		}
+		if filter.skipEntry(header.Name, 0) {
+			continue
+		}
+
+		entry := PlanEntry{Name: header.Name, Size: header.Size}
+		if flat != nil && (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeGNUSparse || header.Typeflag == tar.TypeLink) {
+			if entry.Name, err = flat.name(header.Name); err != nil {
+				return plan, err
+			}
+		}
+		destPath := filepath.Join(longPath(destDir), filepath.FromSlash(entry.Name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if flat != nil {
+				continue
+			}
+			entry.IsDir, entry.Size = true, 0
+			entry.Action = ActionCreate
+		case tar.TypeReg, tar.TypeGNUSparse:
+			sizes[header.Name] = header.Size
+			if opts.Resume && tarEntryDone(destPath, header) {
+				entry.Action = ActionKeep
+			} else {
+				entry.Action = planConflict(opts.Overwrite, destPath, header.ModTime)
+			}
+		case tar.TypeLink:
+			size, ok := sizes[header.Linkname]
+			if !ok {
+				warnings.add(WarningLinkTargetMissing, header.Name, "hard link to %s not extracted, as that file was not", header.Linkname)
+				continue
+			}
+			sizes[header.Name], entry.Size = size, size
+			linkHeader := *header
+			linkHeader.Size = size
+			if opts.Resume && tarEntryDone(destPath, &linkHeader) {
+				entry.Action = ActionKeep
+			} else {
+				entry.Action = planConflict(opts.Overwrite, destPath, header.ModTime)
+			}
+		case tar.TypeSymlink:
+			warnings.add(WarningSymlinkSkipped, header.Name, "symbolic link to %s not extracted", header.Linkname)
+			continue
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			warnings.add(WarningUnsupportedEntry, header.Name, "entry of type %q not extracted", header.Typeflag)
+			continue
+		}
+		if !filepath.IsLocal(entry.Name) {
+			entry.Action = ActionUnsafe
+		}
+		plan.add(entry)
+	}
+	plan.Warnings = warnings.list()
+	return plan, nil
+}
+
+// planConflict returns what the overwrite policy would do with a file
+// modified at modified and extracted to destPath, as conflictResolver
+// decides.
+func planConflict(policy OverwritePolicy, destPath string, modified time.Time) PlanAction {
+	info, err := os.Lstat(destPath)
+	if err != nil {
+		return ActionCreate
+	}
+	switch policy {
+	case OverwriteNever:
+		return ActionSkip
+	case OverwriteIfNewer:
+		if modified.After(info.ModTime()) {
+			return ActionOverwrite
+		}
+		return ActionSkip
+	case OverwriteFail:
+		return ActionFail
+	case OverwritePrompt:
+		return ActionAsk
+	case OverwriteRename:
+		return ActionRename
+	}
+	return ActionOverwrite
+}
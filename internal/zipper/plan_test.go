@@ -0,0 +1,156 @@
+package zipper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// planArchives creates a zip and a tar.gz archive of src, keyed by format.
+func planArchives(t *testing.T, dir, src string) map[string]string {
+	t.Helper()
+	archives := map[string]string{"zip": filepath.Join(dir, "in.zip"), "tar.gz": filepath.Join(dir, "in.tar.gz")}
+	if _, err := ZipWithOptions(src, archives["zip"], CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GzipWithOptions(src, archives["tar.gz"], CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return archives
+}
+
+// planExtract plans extracting archivePath of the given format.
+func planExtract(format, archivePath, dest string, opts ExtractOptions) (Plan, error) {
+	if format == "zip" {
+		return PlanExtract(archivePath, dest, opts)
+	}
+	return PlanExtractGzip(archivePath, dest, opts)
+}
+
+// planActions returns the action planned for each entry, by name without
+// the slash zip folder names end in.
+func planActions(plan Plan) map[string]PlanAction {
+	actions := make(map[string]PlanAction)
+	for _, e := range plan.Entries {
+		actions[strings.TrimSuffix(e.Name, "/")] = e.Action
+	}
+	return actions
+}
+
+func TestPlanExtractPolicies(t *testing.T) {
+	dir := t.TempDir()
+	entryTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	src := filepath.Join(dir, "src")
+	writeDiffFile(t, filepath.Join(src, "a.txt"), "archived", entryTime)
+	writeDiffFile(t, filepath.Join(src, "sub", "b.txt"), "new", entryTime)
+	archives := planArchives(t, dir, src)
+
+	older, newer := entryTime.AddDate(-10, 0, 0), entryTime.AddDate(10, 0, 0)
+	tests := []struct {
+		name     string
+		opts     ExtractOptions
+		existing string    // content of a.txt already in the destination
+		modTime  time.Time // and its modification time
+		want     PlanAction
+	}{
+		{"always", ExtractOptions{Overwrite: OverwriteAlways}, "existing", newer, ActionOverwrite},
+		{"never", ExtractOptions{Overwrite: OverwriteNever}, "existing", older, ActionSkip},
+		{"newer entry", ExtractOptions{Overwrite: OverwriteIfNewer}, "existing", older, ActionOverwrite},
+		{"older entry", ExtractOptions{Overwrite: OverwriteIfNewer}, "existing", newer, ActionSkip},
+		{"fail", ExtractOptions{Overwrite: OverwriteFail}, "existing", older, ActionFail},
+		{"prompt", ExtractOptions{Overwrite: OverwritePrompt}, "existing", older, ActionAsk},
+		{"rename", ExtractOptions{Overwrite: OverwriteRename}, "existing", older, ActionRename},
+		{"resume complete", ExtractOptions{Resume: true}, "archived", entryTime, ActionKeep},
+		{"resume partial", ExtractOptions{Resume: true}, "arch", entryTime, ActionOverwrite},
+	}
+	for format, archivePath := range archives {
+		for _, tt := range tests {
+			dest := filepath.Join(dir, format+"-"+tt.name)
+			writeDiffFile(t, filepath.Join(dest, "a.txt"), tt.existing, tt.modTime)
+			plan, err := planExtract(format, archivePath, dest, tt.opts)
+			if err != nil {
+				t.Errorf("%s %s: %v", format, tt.name, err)
+				continue
+			}
+			want := map[string]PlanAction{"a.txt": tt.want, "sub": ActionCreate, "sub/b.txt": ActionCreate}
+			if got := planActions(plan); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %s: actions %v; want %v", format, tt.name, got, want)
+			}
+
+			// Only files that would be written are counted
+			files, bytes := 1, int64(len("new"))
+			switch tt.want {
+			case ActionOverwrite, ActionRename, ActionAsk:
+				files, bytes = 2, bytes+int64(len("archived"))
+			}
+			if plan.FileCount != files || plan.TotalBytes != bytes {
+				t.Errorf("%s %s: %d files of %d bytes; want %d of %d", format, tt.name, plan.FileCount, plan.TotalBytes, files, bytes)
+			}
+			if overwritten := tt.want == ActionOverwrite; overwritten != (plan.Overwritten == 1) {
+				t.Errorf("%s %s: %d overwritten", format, tt.name, plan.Overwritten)
+			}
+			if entries, _ := os.ReadDir(dest); len(entries) != 1 {
+				t.Errorf("%s %s: planning wrote to the destination", format, tt.name)
+			}
+		}
+	}
+}
+
+func TestPlanExtractFlatten(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeDiffFile(t, filepath.Join(src, "x", "a.txt"), "first", modTime)
+	writeDiffFile(t, filepath.Join(src, "y", "a.txt"), "second", modTime)
+	writeDiffFile(t, filepath.Join(src, "z", "b.txt"), "only", modTime)
+	archives := planArchives(t, dir, src)
+
+	for format, archivePath := range archives {
+		dest := filepath.Join(dir, format)
+		writeDiffFile(t, filepath.Join(dest, "b.txt"), "existing", modTime)
+
+		plan, err := planExtract(format, archivePath, dest, ExtractOptions{Flatten: true, FlattenCollisions: CollisionRename, Overwrite: OverwriteNever})
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		// Folders are not created, and files are listed by the names they
+		// would be written as
+		var got []PlanEntry
+		for _, e := range plan.Entries {
+			got = append(got, PlanEntry{Name: e.Name, Action: e.Action})
+		}
+		want := []PlanEntry{{Name: "a.txt", Action: ActionCreate}, {Name: "a (1).txt", Action: ActionCreate}, {Name: "b.txt", Action: ActionSkip}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s, rename: %v; want %v", format, got, want)
+		}
+
+		if _, err := planExtract(format, archivePath, dest, ExtractOptions{Flatten: true, FlattenCollisions: CollisionFail}); err == nil {
+			t.Errorf("%s: colliding names planned under CollisionFail", format)
+		}
+	}
+
+	// The whole zip directory is known up front, so the file a later one
+	// replaces is skipped
+	plan, err := PlanExtract(archives["zip"], filepath.Join(dir, "overwrite"), ExtractOptions{Flatten: true, FlattenCollisions: CollisionOverwrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []PlanEntry
+	for _, e := range plan.Entries {
+		got = append(got, PlanEntry{Name: e.Name, Size: e.Size, Action: e.Action})
+	}
+	want := []PlanEntry{
+		{Name: "x/a.txt", Size: int64(len("first")), Action: ActionSkip},
+		{Name: "a.txt", Size: int64(len("second")), Action: ActionCreate},
+		{Name: "b.txt", Size: int64(len("only")), Action: ActionCreate},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("zip, overwrite: %v; want %v", got, want)
+	}
+	if plan.FileCount != 2 {
+		t.Errorf("zip, overwrite: %d files; want 2", plan.FileCount)
+	}
+}
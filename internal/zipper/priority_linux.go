@@ -0,0 +1,45 @@
+//go:build linux
+
+package zipper
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// backgroundNice is the nice value LowerPriority gives the process.
+const backgroundNice = 10
+
+// LowerPriority lowers the scheduling priority of the current process, so
+// that long archiving jobs leave the machine responsive. Every thread is
+// reniced to 10, since Linux keeps a nice value per thread, and the I/O
+// priority of threads without an I/O class of their own follows it. An
+// unprivileged process cannot undo it.
+func LowerPriority() error {
+	reniced := make(map[int]bool)
+	for {
+		tasks, err := os.ReadDir("/proc/self/task")
+		if err != nil {
+			return syscall.Setpriority(syscall.PRIO_PROCESS, 0, backgroundNice)
+		}
+		// Threads started meanwhile by one not yet reniced keep the old
+		// value, so repeat until a pass finds none
+		found := false
+		for _, task := range tasks {
+			tid, err := strconv.Atoi(task.Name())
+			if err != nil || reniced[tid] {
+				continue
+			}
+			found = true
+			reniced[tid] = true
+			if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, backgroundNice); err != nil && !errors.Is(err, syscall.ESRCH) {
+				return err
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package zipper
+
+import "errors"
+
+// LowerPriority lowers the scheduling priority of the current process. It
+// is not supported on this platform.
+func LowerPriority() error {
+	return errors.New("lowering the process priority is not supported on this platform")
+}
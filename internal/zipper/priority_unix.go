@@ -0,0 +1,15 @@
+//go:build darwin || freebsd
+
+package zipper
+
+import "syscall"
+
+// backgroundNice is the nice value LowerPriority gives the process.
+const backgroundNice = 10
+
+// LowerPriority lowers the scheduling priority of the current process, so
+// that long archiving jobs leave the machine responsive. The process is
+// reniced to 10, which an unprivileged process cannot undo.
+func LowerPriority() error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, backgroundNice)
+}
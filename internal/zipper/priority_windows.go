@@ -0,0 +1,17 @@
+//go:build windows
+
+package zipper
+
+import "golang.org/x/sys/windows"
+
+// LowerPriority lowers the scheduling priority of the current process, so
+// that long archiving jobs leave the machine responsive. On Windows the
+// process enters background mode, which lowers its CPU, I/O and memory
+// priority, or failing that the below normal priority class.
+func LowerPriority() error {
+	process := windows.CurrentProcess()
+	if err := windows.SetPriorityClass(process, windows.PROCESS_MODE_BACKGROUND_BEGIN); err == nil {
+		return nil
+	}
+	return windows.SetPriorityClass(process, windows.BELOW_NORMAL_PRIORITY_CLASS)
+}
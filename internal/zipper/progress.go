@@ -0,0 +1,226 @@
+package zipper
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultProgressInterval is the least time between progress reports when
+// the options leave it unset.
+const DefaultProgressInterval = 50 * time.Millisecond
+
+// ProgressEvent is a detailed progress report for UIs, passed to a
+// ProgressEventFunc alongside the byte-count callbacks.
+type ProgressEvent struct {
+	BytesDone  int64 // same measure as the operation's Progress callback
+	BytesTotal int64
+	FilesDone  int
+	FilesTotal int // zero when not known up front, as for tar.gz extraction
+
+	// File is the entry name of the file most recently read or written,
+	// with its own byte progress. With several workers it moves between the
+	// files in flight.
+	File           string
+	FileBytesDone  int64
+	FileBytesTotal int64
+
+	// Active lists the files in flight, in the order they were started,
+	// with their own byte progress. It is a copy the callback may keep.
+	Active []FileProgress
+
+	// CompressedBytes counts the compressed data produced so far when
+	// creating, for a live compression ratio against BytesDone.
+	CompressedBytes int64
+}
+
+// FileProgress is the progress of one file in flight.
+type FileProgress struct {
+	Name       string
+	BytesDone  int64
+	BytesTotal int64
+}
+
+// ProgressEventFunc receives detailed progress reports.
+type ProgressEventFunc func(ProgressEvent)
+
+// progressTracker accumulates the progress of an operation and reports it
+// to its callbacks, one call at a time. It is safe for concurrent use.
+type progressTracker struct {
+	mu       sync.Mutex
+	event    ProgressEvent
+	progress ProgressWithFileFunc
+	events   ProgressEventFunc
+	active   []FileProgress
+	interval time.Duration // least time between reports
+	last     time.Time     // time of the last report
+}
+
+// newProgressTracker returns a tracker reporting at most once per interval,
+// where zero means DefaultProgressInterval and a negative interval reports
+// every change.
+func newProgressTracker(progress ProgressWithFileFunc, events ProgressEventFunc, interval time.Duration, totalBytes int64, totalFiles int) *progressTracker {
+	if interval == 0 {
+		interval = DefaultProgressInterval
+	}
+	return &progressTracker{
+		event:    ProgressEvent{BytesTotal: totalBytes, FilesTotal: totalFiles},
+		progress: progress,
+		events:   events,
+		interval: interval,
+	}
+}
+
+// withoutFile adapts a ProgressFunc for a tracker.
+func withoutFile(progress ProgressFunc) ProgressWithFileFunc {
+	if progress == nil {
+		return nil
+	}
+	return func(done, total int64, _ string) { progress(done, total) }
+}
+
+// read records n more bytes of file name, of the given size, and adds them
+// to the overall progress.
+func (t *progressTracker) read(name string, size, n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fileBytes(name, size, n)
+	t.event.BytesDone += n
+	t.report()
+}
+
+// readFile records n more bytes of file name without touching the overall
+// progress, for operations that measure it in other units.
+func (t *progressTracker) readFile(name string, size, n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fileBytes(name, size, n)
+	t.report()
+}
+
+func (t *progressTracker) fileBytes(name string, size, n int64) {
+	if name != t.event.File {
+		t.event.File, t.event.FileBytesDone = name, 0
+	}
+	t.event.FileBytesTotal = size
+	t.event.FileBytesDone += n
+
+	// A file leaves the active list once all of it has been read, or when
+	// it is finished or dropped earlier
+	i := 0
+	for i < len(t.active) && t.active[i].Name != name {
+		i++
+	}
+	if i == len(t.active) {
+		t.active = append(t.active, FileProgress{Name: name, BytesTotal: size})
+	}
+	t.active[i].BytesDone += n
+	if t.active[i].BytesDone >= size {
+		t.active = append(t.active[:i], t.active[i+1:]...)
+	}
+}
+
+// wrote adds n bytes to the compressed output. It does not report; the
+// next read does.
+func (t *progressTracker) wrote(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.event.CompressedBytes += n
+}
+
+// advance adds n bytes to the overall progress.
+func (t *progressTracker) advance(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.event.BytesDone += n
+	t.report()
+}
+
+// setDone sets the overall progress.
+func (t *progressTracker) setDone(done int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.event.BytesDone = done
+	t.report()
+}
+
+// fileDone counts name as finished, taking it off the active list.
+func (t *progressTracker) fileDone(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.drop(name)
+	t.event.FilesDone++
+	t.report()
+}
+
+// finish takes name off the active list without counting it, for a file
+// skipped or failed partway through.
+func (t *progressTracker) finish(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.drop(name)
+}
+
+func (t *progressTracker) drop(name string) {
+	for i := range t.active {
+		if t.active[i].Name == name {
+			t.active = append(t.active[:i], t.active[i+1:]...)
+			return
+		}
+	}
+}
+
+// update reports the current progress again, however recently it was
+// reported, so that the first and last states always reach the callbacks.
+func (t *progressTracker) update() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deliver()
+}
+
+// locked runs fn between reports, so that other callbacks such as OnEntry
+// are never called concurrently with them.
+func (t *progressTracker) locked(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn()
+}
+
+// report passes the progress to the callbacks unless it was passed less
+// than an interval ago. Progress at 100% is always passed.
+func (t *progressTracker) report() {
+	if t.progress == nil && t.events == nil {
+		return
+	}
+	if t.interval > 0 && (t.event.BytesTotal <= 0 || t.event.BytesDone < t.event.BytesTotal) {
+		if time.Since(t.last) < t.interval {
+			return
+		}
+	}
+	t.deliver()
+}
+
+func (t *progressTracker) deliver() {
+	t.last = time.Now()
+	if t.progress != nil {
+		t.progress(t.event.BytesDone, t.event.BytesTotal, t.event.File)
+	}
+	if t.events != nil {
+		event := t.event
+		event.Active = append([]FileProgress(nil), t.active...)
+		t.events(event)
+	}
+}
+
+// outputWriter counts the archive data written through it as compressed
+// output.
+type outputWriter struct {
+	w       io.Writer
+	tracker *progressTracker
+}
+
+func (ow outputWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.Write(p)
+	ow.tracker.wrote(int64(n))
+	return n, err
+}
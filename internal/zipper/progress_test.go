@@ -0,0 +1,82 @@
+package zipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgressTrackerActive(t *testing.T) {
+	var last ProgressEvent
+	tracker := newProgressTracker(nil, func(e ProgressEvent) { last = e }, -1, 300, 3)
+	tracker.read("a", 100, 40)
+	tracker.read("b", 100, 40)
+	tracker.read("c", 100, 100)
+	if len(last.Active) != 2 {
+		t.Fatalf("active %v; want a and b, as c was read in full", last.Active)
+	}
+	tracker.fileDone("a")
+	if len(last.Active) != 1 || last.Active[0].Name != "b" || last.FilesDone != 1 {
+		t.Errorf("after a finished early: active %v, %d files done", last.Active, last.FilesDone)
+	}
+	tracker.finish("b")
+	tracker.update()
+	if len(last.Active) != 0 || last.FilesDone != 1 {
+		t.Errorf("after b was dropped: active %v, %d files done", last.Active, last.FilesDone)
+	}
+}
+
+// TestProgressCorruptEntryLeavesActive extracts a zip entry whose deflate
+// data breaks off early; once it is removed it must no longer be reported
+// in flight.
+func TestProgressCorruptEntryLeavesActive(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, 200000)
+	for i := range data {
+		data[i] = byte('a' + rnd.Intn(20))
+	}
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.DefaultCompression)
+	fw.Write(data)
+	fw.Close()
+	corrupt := compressed.Bytes()
+	copy(corrupt[100:], bytes.Repeat([]byte{0xff}, 8))
+
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "in.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "bad.txt",
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(len(corrupt)),
+		UncompressedSize64: uint64(len(data)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(corrupt)
+	zw.Close()
+	f.Close()
+
+	var last ProgressEvent
+	opts := ExtractOptions{Workers: 1, ProgressInterval: -1, ProgressEvents: func(e ProgressEvent) { last = e }}
+	_, err = ExtractWithOptions(archivePath, filepath.Join(dir, "out"), opts)
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("extract = %v; want a checksum error", err)
+	}
+	if len(last.Active) != 0 {
+		t.Errorf("removed entry still in flight: %v", last.Active)
+	}
+}
@@ -0,0 +1,102 @@
+package zipper
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateBurst is the fraction of a second of traffic a rate limiter lets
+// through at once, which also caps each read so that workers sharing it
+// take turns.
+const rateBurst = 10
+
+// rateLimiter is a token bucket shared by the readers of an operation,
+// capping the bytes they pass per second in total. Tokens go negative when
+// readers take more than there are, and later readers wait for the debt to
+// be repaid, so workers are served in turn. A nil *rateLimiter does not
+// limit.
+type rateLimiter struct {
+	ctx    context.Context
+	rate   float64 // bytes per second
+	burst  float64 // most tokens saved up while idle
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter of bytesPerSecond, or nil when it is not
+// positive. Waits end early with an error once ctx is done.
+func newRateLimiter(ctx context.Context, bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	burst := max(rate/rateBurst, 1)
+	return &rateLimiter{ctx: optionsContext(ctx), rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait takes n tokens, blocking until the bucket has paid for them.
+func (l *rateLimiter) wait(n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-l.ctx.Done():
+		return l.ctx.Err()
+	}
+}
+
+// reader returns r limited by l, or r itself when l is nil.
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &rateReader{r: r, limiter: l, chunk: int(l.burst)}
+}
+
+// rateReader reads at most chunk bytes at a time and waits for each read to
+// be paid for before returning it.
+type rateReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+	chunk   int
+}
+
+func (r *rateReader) Read(p []byte) (int, error) {
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// pause waits for d, or until ctx is done.
+func pause(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,175 @@
+package zipper
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// nestedArchiveExt returns the extension of name if ExtractOptions.Recursive
+// extracts files of that name, and "" otherwise.
+func nestedArchiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[len(name)-len(ext):]
+		}
+	}
+	return ""
+}
+
+// extractRecursive runs extract, which extracts the archive called name of
+// size bytes to destDir, then extracts the archives among the files it wrote
+// as ExtractOptions.Recursive describes.
+func extractRecursive(name string, size int64, destDir string, opts ExtractOptions, extract func(ExtractOptions) (ExtractStats, error)) (ExtractStats, error) {
+	if opts.Flatten {
+		return ExtractStats{}, errors.New("Recursive and Flatten cannot be combined")
+	}
+	r := &nestedExtraction{name: name, size: size, destDir: destDir, opts: opts}
+	outer := opts
+	outer.Recursive = 0
+	outer.OnEntry = func(e EntryEvent) {
+		r.entries++
+		if !e.IsDir && nestedArchiveExt(e.Name) != "" {
+			r.nested = append(r.nested, e.Name)
+		}
+		if opts.OnEntry != nil {
+			opts.OnEntry(e)
+		}
+	}
+	stats, err := extract(outer)
+	if err != nil {
+		return stats, err
+	}
+	r.entries += stats.Skipped + stats.Resumed
+
+	sort.Strings(r.nested)
+	for _, entry := range r.nested {
+		if err := r.extract(entry, &stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// nestedExtraction tracks the archives found while extracting one, so that
+// the limits of ExtractOptions apply to them together.
+type nestedExtraction struct {
+	name    string
+	size    int64
+	destDir string
+	opts    ExtractOptions
+	nested  []string // entry names of the archives found
+	entries int      // entries extracted so far, at every level
+}
+
+// extract extracts the archive extracted from entry into a folder of the
+// same name without its extension, then removes it. Files that turn out
+// not to be archives are kept and reported with a warning.
+func (r *nestedExtraction) extract(entry string, stats *ExtractStats) error {
+	archivePath := filepath.Join(r.destDir, filepath.FromSlash(entry))
+	if renamed, ok := stats.Renamed[entry]; ok {
+		archivePath = renamed
+	}
+	info, err := os.Stat(archivePath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	ext := nestedArchiveExt(archivePath)
+	dir := strings.TrimSuffix(archivePath, ext)
+	prefix := strings.TrimSuffix(entry, nestedArchiveExt(entry)) + "/"
+
+	opts := r.opts
+	opts.Recursive--
+	opts.OnEntry = func(e EntryEvent) {
+		r.entries++
+		if r.opts.OnEntry != nil {
+			e.Name = prefix + e.Name
+			r.opts.OnEntry(e)
+		}
+	}
+	if max := r.opts.MaxEntries; max > 0 {
+		if opts.MaxEntries = max - r.entries; opts.MaxEntries < 1 {
+			return &LimitError{Limit: "MaxEntries", Name: entry, Value: int64(r.entries + 1), Max: int64(max)}
+		}
+	}
+	if max := r.opts.MaxPathDepth; max > 0 {
+		depth := pathDepth(prefix)
+		if opts.MaxPathDepth = max - depth; opts.MaxPathDepth < 1 {
+			return &LimitError{Limit: "MaxPathDepth", Name: prefix, Value: int64(depth + 1), Max: int64(max)}
+		}
+	}
+
+	// What the archives inside expand to counts towards the ratio of this
+	// one, so they get what is left of its allowance as a size limit
+	maxRatio := maxRatioOrDefault(r.opts.MaxRatio)
+	ratioLimited := false
+	if max := r.opts.MaxTotalBytes; max > 0 {
+		if opts.MaxTotalBytes = max - stats.TotalBytes; opts.MaxTotalBytes < 1 {
+			return &LimitError{Limit: "MaxTotalBytes", Name: entry, Value: stats.TotalBytes + info.Size(), Max: max}
+		}
+	}
+	if maxRatio >= 0 {
+		allowed := max(int64(maxRatio*float64(r.size)), bombCheckMinSize) - stats.TotalBytes
+		if allowed < 1 {
+			return fmt.Errorf("%w: %s expands beyond %.0f:1 with the archives inside it", ErrArchiveBomb, r.name, maxRatio)
+		}
+		if opts.MaxTotalBytes == 0 || allowed < opts.MaxTotalBytes {
+			opts.MaxTotalBytes, ratioLimited = allowed, true
+		}
+	}
+
+	var nested ExtractStats
+	if strings.EqualFold(ext, ".zip") {
+		nested, err = ExtractWithOptions(archivePath, dir, opts)
+	} else {
+		nested, err = ExtractGzipWithOptions(archivePath, dir, opts)
+	}
+	var limit *LimitError
+	switch {
+	case errors.Is(err, zip.ErrFormat), errors.Is(err, gzip.ErrHeader):
+		stats.Warnings = append(stats.Warnings, Warning{Code: WarningNestedArchive, Name: entry, Message: fmt.Sprintf("not extracted: %v", err)})
+		return nil
+	case errors.As(err, &limit) && limit.Limit == "MaxTotalBytes" && ratioLimited:
+		return fmt.Errorf("%w: %s expands beyond %.0f:1 with the archives inside it", ErrArchiveBomb, r.name, maxRatio)
+	case errors.As(err, &limit):
+		// Reported against the limits as given, from this archive's root
+		used := map[string]int64{
+			"MaxEntries":    int64(r.opts.MaxEntries - opts.MaxEntries),
+			"MaxPathDepth":  int64(r.opts.MaxPathDepth - opts.MaxPathDepth),
+			"MaxTotalBytes": r.opts.MaxTotalBytes - opts.MaxTotalBytes,
+		}[limit.Limit]
+		return &LimitError{Limit: limit.Limit, Name: prefix + limit.Name, Value: limit.Value + used, Max: limit.Max + used}
+	case err != nil:
+		return fmt.Errorf("%s: %w", entry, err)
+	}
+	if err := os.Remove(archivePath); err != nil {
+		return err
+	}
+
+	stats.Nested += 1 + nested.Nested
+	stats.TotalBytes += nested.TotalBytes
+	stats.FileCount += nested.FileCount
+	stats.Skipped += nested.Skipped
+	stats.Overwritten += nested.Overwritten
+	stats.Resumed += nested.Resumed
+	for _, name := range nested.CorruptFiles {
+		stats.CorruptFiles = append(stats.CorruptFiles, prefix+name)
+	}
+	for name, path := range nested.Renamed {
+		if stats.Renamed == nil {
+			stats.Renamed = make(map[string]string)
+		}
+		stats.Renamed[prefix+name] = path
+	}
+	for _, w := range nested.Warnings {
+		w.Name = prefix + w.Name
+		stats.Warnings = append(stats.Warnings, w)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package zipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// zipEntry is a file written by writeTestZip.
+type zipEntry struct {
+	name string
+	data []byte
+}
+
+// writeTestZip writes the entries to a deflated zip archive at path.
+func writeTestZip(t *testing.T, path string, entries ...zipEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRecursiveBombAcrossLevels nests a zip of zeros inside another zip.
+// Each level alone stays within the ratio limit, but together they expand
+// past it, which must be caught before the inner archive is written out.
+func TestRecursiveBombAcrossLevels(t *testing.T) {
+	dir := t.TempDir()
+	innerPath := filepath.Join(dir, "inner.zip")
+	writeTestZip(t, innerPath, zipEntry{"zeros.bin", make([]byte, 70<<20)})
+	inner, err := os.ReadFile(innerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outerPath := filepath.Join(dir, "outer.zip")
+	writeTestZip(t, outerPath, zipEntry{"inner.zip", inner})
+	outerInfo, err := os.Stat(outerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Well above what the inner archive reaches alone
+	const maxRatio = 2000
+	if ratio := float64(70<<20) / float64(len(inner)); ratio >= maxRatio {
+		t.Fatalf("inner archive alone expands %.0f:1", ratio)
+	}
+	if combined := float64(70<<20) / float64(outerInfo.Size()); combined < maxRatio {
+		t.Fatalf("archives together expand only %.0f:1", combined)
+	}
+
+	dest := filepath.Join(dir, "out")
+	_, err = ExtractWithOptions(outerPath, dest, ExtractOptions{Recursive: 1, MaxRatio: maxRatio})
+	if !errors.Is(err, ErrArchiveBomb) || !strings.Contains(err.Error(), "with the archives inside it") {
+		t.Fatalf("extract = %v; want %v for the archives together", err, ErrArchiveBomb)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "inner", "zeros.bin")); err == nil {
+		t.Error("the inner archive was extracted")
+	}
+}
+
+func TestRecursiveMaxEntriesAcrossLevels(t *testing.T) {
+	dir := t.TempDir()
+	innerPath := filepath.Join(dir, "inner.zip")
+	writeTestZip(t, innerPath, zipEntry{"c.txt", []byte("c")}, zipEntry{"d.txt", []byte("d")})
+	inner, err := os.ReadFile(innerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outerPath := filepath.Join(dir, "outer.zip")
+	writeTestZip(t, outerPath, zipEntry{"a.txt", []byte("a")}, zipEntry{"b.txt", []byte("b")}, zipEntry{"inner.zip", inner})
+
+	// Three entries outside and two inside
+	var limit *LimitError
+	_, err = ExtractWithOptions(outerPath, filepath.Join(dir, "four"), ExtractOptions{Recursive: 1, MaxEntries: 4})
+	if !errors.As(err, &limit) || limit.Limit != "MaxEntries" || limit.Max != 4 {
+		t.Errorf("extract with MaxEntries 4 = %v; want MaxEntries exceeded", err)
+	} else if limit.Name != "inner/d.txt" {
+		t.Errorf("limit reported for %s; want inner/d.txt", limit.Name)
+	}
+
+	stats, err := ExtractWithOptions(outerPath, filepath.Join(dir, "five"), ExtractOptions{Recursive: 1, MaxEntries: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Nested != 1 {
+		t.Errorf("extracted %d nested archives; want 1", stats.Nested)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "inner/c.txt", "inner/d.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, "five", filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s not extracted: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "five", "inner.zip")); err == nil {
+		t.Error("nested archive kept after extraction")
+	}
+}
+
+func TestRecursiveNotAnArchive(t *testing.T) {
+	dir := t.TempDir()
+	outerPath := filepath.Join(dir, "outer.zip")
+	writeTestZip(t, outerPath, zipEntry{"fake.zip", []byte("not a zip")}, zipEntry{"notes.tgz", []byte("nor a tar.gz")})
+
+	dest := filepath.Join(dir, "out")
+	stats, err := ExtractWithOptions(outerPath, dest, ExtractOptions{Recursive: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	warned := map[string]bool{}
+	for _, w := range stats.Warnings {
+		if w.Code == WarningNestedArchive {
+			warned[w.Name] = true
+		}
+	}
+	for _, name := range []string{"fake.zip", "notes.tgz"} {
+		if !warned[name] {
+			t.Errorf("%s: no warning; warnings %v", name, stats.Warnings)
+		}
+		if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+			t.Errorf("%s not kept: %v", name, err)
+		}
+	}
+	if stats.Nested != 0 {
+		t.Errorf("%d nested archives extracted; want none", stats.Nested)
+	}
+}
@@ -0,0 +1,37 @@
+package zipper
+
+import (
+	"archive/zip"
+)
+
+// RemoveEntries rewrites a zip archive without the entries whose names match
+// any of patterns (see matchGlob for the syntax), copying the remaining
+// entries without recompression. It returns the names of the removed entries;
+// the archive is left untouched when nothing matches.
+func RemoveEntries(zipPath string, patterns []string) ([]string, error) {
+	zipPath = longPath(zipPath)
+	if err := validateGlobs(patterns); err != nil {
+		return nil, err
+	}
+
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, f := range reader.File {
+		if f.Name != ManifestName && matchAnyGlob(patterns, f.Name) {
+			removed = append(removed, f.Name)
+		}
+	}
+	reader.Close()
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	keep := func(f *zip.File) bool { return !matchAnyGlob(patterns, f.Name) }
+	if _, err := rewriteZip(zipPath, keep, nil, false, ""); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
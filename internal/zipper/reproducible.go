@@ -0,0 +1,88 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// reproducibleTime is the modification time of every entry written under
+// CreateOptions.Reproducible, the earliest a zip archive can record.
+var reproducibleTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// sortFiles orders files by entry name, which keeps every folder ahead of
+// its contents.
+func sortFiles(files []fileJob) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return filepath.ToSlash(files[i].rel) < filepath.ToSlash(files[j].rel)
+	})
+}
+
+// modTime returns the modification time to record for an entry last modified
+// at t, as CreateOptions.Reproducible and ClampModTime ask.
+func modTime(t time.Time, opts CreateOptions) time.Time {
+	clamp := opts.ClampModTime
+	switch {
+	case opts.Reproducible && !clamp.IsZero():
+		return clamp.UTC()
+	case opts.Reproducible:
+		return reproducibleTime
+	case !clamp.IsZero() && t.After(clamp):
+		// In the zone of the file's own time, which zip archives record
+		return clamp.In(t.Location())
+	}
+	return t
+}
+
+// entryTime returns the modification time of entries that are not read from
+// a file, such as the manifest.
+func entryTime(opts CreateOptions) time.Time {
+	return modTime(time.Now(), opts)
+}
+
+// normalizeZipHeader applies CreateOptions.Reproducible and ClampModTime to
+// h. The time goes in the MS-DOS fields, which CreateRaw writes as they are,
+// and in Modified, from which prepareRawHeader adds the extended timestamp
+// field.
+func normalizeZipHeader(h *zip.FileHeader, opts CreateOptions) {
+	t := modTime(h.Modified, opts)
+	if t.Equal(h.Modified) && t.Location() == h.Modified.Location() {
+		return
+	}
+	h.Modified = t
+	h.ModifiedDate, h.ModifiedTime = msDosTime(t)
+}
+
+// msDosTime returns t as an MS-DOS date and time, which cannot go back
+// before 1980.
+func msDosTime(t time.Time) (date, clock uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
+
+// normalizeTarHeader applies CreateOptions.Reproducible, ClampModTime and
+// TarFormat to h. Reproducible archives also leave out the owner and the
+// other times, which differ between machines and runs.
+func normalizeTarHeader(h *tar.Header, opts CreateOptions) {
+	h.ModTime = modTime(h.ModTime, opts)
+	if opts.Reproducible {
+		h.AccessTime = time.Time{}
+		h.ChangeTime = time.Time{}
+		h.Uid, h.Gid = 0, 0
+		h.Uname, h.Gname = "", ""
+	} else {
+		if !h.AccessTime.IsZero() {
+			h.AccessTime = modTime(h.AccessTime, opts)
+		}
+		if !h.ChangeTime.IsZero() {
+			h.ChangeTime = modTime(h.ChangeTime, opts)
+		}
+	}
+	opts.TarFormat.apply(h)
+}
@@ -0,0 +1,78 @@
+package zipper
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestReproducibleArchiveHash checks that Reproducible archives are byte for
+// byte the same whatever the number of workers and the times of the files.
+func TestReproducibleArchiveHash(t *testing.T) {
+	src := t.TempDir()
+	rng := rand.New(rand.NewSource(1))
+	for i, size := range []int{0, 100, 64 << 10, 3 << 20} {
+		dir := filepath.Join(src, fmt.Sprintf("dir%d", i%2))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, size)
+		if i%2 == 0 {
+			rng.Read(data)
+		} else {
+			for j := range data {
+				data[j] = byte(j % 61)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.bin", i)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archiveHash := func(name string, workers int) [sha256.Size]byte {
+		archivePath := filepath.Join(t.TempDir(), name)
+		opts := CreateOptions{Reproducible: true, Workers: workers}
+		var err error
+		if isGzipName(name) {
+			_, err = GzipWithOptions(src, archivePath, opts)
+		} else {
+			_, err = ZipWithOptions(src, archivePath, opts)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha256.Sum256(data)
+	}
+
+	for _, name := range []string{"out.zip", "out.tar.gz"} {
+		want := archiveHash(name, 1)
+		for _, workers := range []int{2, 4} {
+			if got := archiveHash(name, workers); got != want {
+				t.Errorf("%s with %d workers: hash %x; want %x", name, workers, got, want)
+			}
+		}
+
+		touched := time.Now().Add(-time.Hour)
+		err := filepath.WalkDir(src, func(p string, _ os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			touched = touched.Add(time.Minute)
+			return os.Chtimes(p, touched, touched)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := archiveHash(name, 4); got != want {
+			t.Errorf("%s after touching the files: hash %x; want %x", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// zipEntryDone reports whether path already holds the whole of f, written
+// by an earlier extraction: a regular file of the entry's size and CRC-32.
+func zipEntryDone(path string, f *zip.File) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != int64(f.UncompressedSize64) {
+		return false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, file); err != nil {
+		return false
+	}
+	return h.Sum32() == f.CRC32
+}
+
+// tarEntryDone reports whether path already holds the whole of the tar
+// entry hdr. Entries carry no checksum, so this goes by size and
+// modification time, which is only restored once a file is complete.
+func tarEntryDone(path string, hdr *tar.Header) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != hdr.Size {
+		return false
+	}
+	return info.ModTime().Truncate(time.Second).Equal(hdr.ModTime.Truncate(time.Second))
+}
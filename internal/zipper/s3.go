@@ -0,0 +1,311 @@
+package zipper
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3PartSize is the size of the first parts of an upload. S3 allows at most
+// 10,000 parts, so the size doubles every 1,000 parts to leave room for
+// archives of any size.
+const s3PartSize = 16 << 20
+
+// S3Destination uploads an archive to Amazon S3, or a store with an
+// S3-compatible API such as MinIO or Google Cloud Storage, as a multipart
+// upload. Only the part being filled is kept in memory.
+type S3Destination struct {
+	// RateLimit caps the bytes per second uploaded; zero is unlimited.
+	RateLimit int64
+
+	ctx       context.Context
+	objectURL *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	uploadID  string
+	part      []byte
+	etags     []string
+}
+
+// NewS3Destination starts a multipart upload to rawURL, of the form
+// s3://bucket/key. Credentials are read from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, the region from AWS_REGION
+// or AWS_DEFAULT_REGION, and AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL
+// replace the Amazon endpoint for other stores, such as
+// https://storage.googleapis.com with HMAC keys. ctx cancels the upload.
+func NewS3Destination(ctx context.Context, rawURL string) (*S3Destination, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 URL: %s (use s3://bucket/key)", rawURL)
+	}
+
+	d := &S3Destination{
+		ctx:       optionsContext(ctx),
+		region:    firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if d.accessKey == "" || d.secretKey == "" {
+		return nil, errors.New("S3 upload needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if d.region == "" {
+		d.region = "us-east-1"
+	}
+
+	// Custom endpoints and bucket names with dots, which do not match the
+	// wildcard certificate, are addressed by path
+	endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	switch {
+	case endpoint != "":
+		d.objectURL, err = url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + key)
+	case strings.Contains(bucket, "."):
+		d.objectURL, err = url.Parse(fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", d.region, bucket, key))
+	default:
+		d.objectURL, err = url.Parse(fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, d.region, key))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := d.request(http.MethodPost, url.Values{"uploads": {""}}, nil, &result); err != nil {
+		return nil, err
+	}
+	d.uploadID = result.UploadID
+	return d, nil
+}
+
+// firstEnv returns the first of the environment variables that is set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Write buffers p, uploading each part as it fills.
+func (d *S3Destination) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := s3PartSize << (len(d.etags) / 1000)
+		n := min(len(p), size-len(d.part))
+		if d.part == nil {
+			d.part = make([]byte, 0, size)
+		}
+		d.part = append(d.part, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(d.part) == size {
+			if err := d.uploadPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// uploadPart uploads the buffered data as the next part.
+func (d *S3Destination) uploadPart() error {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(len(d.etags) + 1)},
+		"uploadId":   {d.uploadID},
+	}
+	resp, err := d.send(http.MethodPut, query, d.part)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	d.etags = append(d.etags, resp.Header.Get("ETag"))
+	d.part = d.part[:0]
+	return nil
+}
+
+// Close uploads the last part and completes the upload.
+func (d *S3Destination) Close() error {
+	if len(d.part) > 0 || len(d.etags) == 0 {
+		if err := d.uploadPart(); err != nil {
+			return err
+		}
+	}
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range d.etags {
+		complete.Parts = append(complete.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	return d.request(http.MethodPost, url.Values{"uploadId": {d.uploadID}}, body, nil)
+}
+
+// Abort cancels the upload, discarding the parts already sent.
+func (d *S3Destination) Abort() error {
+	// The upload is aborted even when the context that cancelled archiving
+	// is done
+	d.ctx = context.WithoutCancel(d.ctx)
+	resp, err := d.send(http.MethodDelete, url.Values{"uploadId": {d.uploadID}}, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// request sends a request and decodes the XML response into result when it
+// is not nil. S3 may report a failure to complete an upload in the body of
+// a successful response, so that is checked too.
+func (d *S3Destination) request(method string, query url.Values, body []byte, result any) error {
+	resp, err := d.send(method, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := s3Error(method, data); err != nil {
+		return err
+	}
+	if result != nil {
+		return xml.Unmarshal(data, result)
+	}
+	return nil
+}
+
+// send signs and sends a request for the object, failing on an error
+// status.
+func (d *S3Destination) send(method string, query url.Values, body []byte) (*http.Response, error) {
+	u := *d.objectURL
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	req, err := http.NewRequestWithContext(d.ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if d.RateLimit > 0 && len(body) > 0 {
+		// The length NewRequest found is kept
+		req.Body = io.NopCloser(newRateLimiter(d.ctx, d.RateLimit).reader(bytes.NewReader(body)))
+	}
+	d.sign(req, body, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err := s3Error(method, data); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("s3 %s %s: %s", method, d.objectURL.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+// s3Error returns the error described by an S3 error document, or nil if
+// data is not one.
+func s3Error(method string, data []byte) error {
+	var doc struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(data, &doc) != nil || doc.XMLName.Local != "Error" {
+		return nil
+	}
+	return fmt.Errorf("s3 %s: %s: %s", method, doc.Code, doc.Message)
+}
+
+// sign adds an AWS Signature Version 4 authorization header to req.
+func (d *S3Destination) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	headers := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	if d.token != "" {
+		req.Header.Set("X-Amz-Security-Token", d.token)
+		headers += "x-amz-security-token:" + d.token + "\n"
+		signed += ";x-amz-security-token"
+	}
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers,
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + d.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := []byte("AWS4" + d.secretKey)
+	for _, part := range []string{day, d.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.accessKey, scope, signed, signature))
+}
+
+// s3EscapePath percent-encodes every byte of an object path but unreserved
+// characters and slashes, as signatures require.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,56 @@
+package zipper
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+)
+
+// MakeSelfExtracting writes outPath as the extraction stub at stubPath
+// followed by the zip archive at zipPath. Entry offsets are shifted past the
+// stub, so the result is still a valid zip for other tools, and the stub
+// finds its payload by opening itself with ExtractWithOptions.
+func MakeSelfExtracting(zipPath, stubPath, outPath string) (err error) {
+	zipPath, stubPath, outPath = longPath(zipPath), longPath(stubPath), longPath(outPath)
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	stub, err := os.Open(stubPath)
+	if err != nil {
+		return err
+	}
+	defer stub.Close()
+
+	out, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(outPath)
+		}
+	}()
+
+	stubSize, err := io.Copy(out, stub)
+	if err != nil {
+		return err
+	}
+
+	writer := zip.NewWriter(out)
+	writer.SetOffset(stubSize)
+	if err := writer.SetComment(reader.Comment); err != nil {
+		return err
+	}
+	for _, f := range reader.File {
+		if err := copyZipFile(writer, f); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
@@ -0,0 +1,114 @@
+package zipper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrorPolicy decides what happens when a source file or folder cannot be
+// read while creating an archive.
+type ErrorPolicy int
+
+const (
+	// ErrorAbort stops archiving with the error.
+	ErrorAbort ErrorPolicy = iota
+	// ErrorSkip leaves the file or folder out, reports it to
+	// CreateOptions.OnSkip and lists it in ArchiveStats.SkippedFiles.
+	ErrorSkip
+)
+
+// ParseErrorPolicy parses the name of an error policy as used on the
+// command line.
+func ParseErrorPolicy(s string) (ErrorPolicy, error) {
+	switch s {
+	case "abort":
+		return ErrorAbort, nil
+	case "skip":
+		return ErrorSkip, nil
+	}
+	return 0, fmt.Errorf("unknown error policy: %s (use abort or skip)", s)
+}
+
+// SkipFunc is called for each file or folder left out because it could not
+// be read, with its entry name and the error.
+type SkipFunc func(name string, err error)
+
+// skipList applies the error policy to unreadable files and records the
+// ones skipped, along with the warnings for other files left out of an
+// archive. It is not safe for concurrent use.
+type skipList struct {
+	policy   ErrorPolicy
+	onSkip   SkipFunc
+	names    []string
+	warnings warningList
+	// Size and count of skipped files that were already counted in the
+	// totals of the operation
+	bytes int64
+	files int
+}
+
+func newSkipList(opts CreateOptions) *skipList {
+	return &skipList{policy: opts.ErrorPolicy, onSkip: opts.OnSkip}
+}
+
+// skip returns err under ErrorAbort, and otherwise records the entry at rel
+// as skipped and returns nil.
+func (s *skipList) skip(rel string, err error) error {
+	if s.policy != ErrorSkip {
+		return err
+	}
+	name := filepath.ToSlash(rel)
+	s.names = append(s.names, name)
+	if s.onSkip != nil {
+		s.onSkip(name, err)
+	}
+	return nil
+}
+
+// skipFile is skip for a file that failed to load after it was collected.
+func (s *skipList) skipFile(job fileJob, err error) error {
+	if err := s.skip(job.rel, err); err != nil {
+		return err
+	}
+	s.bytes += job.info.Size()
+	s.files++
+	return nil
+}
+
+// skipLink records a warning for the symbolic link at path, which is not
+// archived.
+func (s *skipList) skipLink(rel, path string) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		target = "?"
+	}
+	s.warnings.add(WarningSymlinkSkipped, filepath.ToSlash(rel), "symbolic link to %s not archived", target)
+}
+
+// skipLoop records a warning for a symbolic link that leads back to a
+// folder containing it, which is not followed.
+func (s *skipList) skipLoop(rel, target string) {
+	s.warnings.add(WarningSymlinkSkipped, filepath.ToSlash(rel), "symbolic link to %s loops back to a parent folder; not followed", target)
+}
+
+// covers reports whether the entry name was skipped, itself or as part of
+// a skipped folder.
+func (s *skipList) covers(name string) bool {
+	for _, skipped := range s.names {
+		if name == skipped || strings.HasPrefix(name, skipped+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// apply lists the skipped files and warnings in stats and removes the files
+// from its totals.
+func (s *skipList) apply(stats *ArchiveStats) {
+	stats.SkippedFiles = s.names
+	stats.Warnings = s.warnings.list()
+	stats.TotalBytes -= s.bytes
+	stats.FileCount -= s.files
+}
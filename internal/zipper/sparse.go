@@ -0,0 +1,263 @@
+package zipper
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dataRegion is an allocated range of a sparse file. Everything outside the
+// data regions of a file reads back as zeros.
+type dataRegion struct {
+	offset int64
+	length int64
+}
+
+// tarBlockSize is the record alignment used by the tar format.
+const tarBlockSize = 512
+
+// sparseChunkSize is the granularity at which zero runs are skipped when
+// recreating sparse files.
+const sparseChunkSize = 4096
+
+// regionsLength returns the number of data bytes in regions.
+func regionsLength(regions []dataRegion) int64 {
+	var n int64
+	for _, r := range regions {
+		n += r.length
+	}
+	return n
+}
+
+// regionReader returns a reader over the concatenated data regions of f.
+func regionReader(f io.ReaderAt, regions []dataRegion) io.Reader {
+	readers := make([]io.Reader, len(regions))
+	for i, r := range regions {
+		readers[i] = io.NewSectionReader(f, r.offset, r.length)
+	}
+	return io.MultiReader(readers...)
+}
+
+// writeSparseEntry writes hdr as a GNU PAX 1.0 sparse entry. data supplies
+// the concatenated contents of regions and hdr.Size is the logical file size.
+//
+// archive/tar cannot emit sparse entries itself, so after flushing tw the
+// entry is written by hand to w, the stream underlying tw: a PAX extended
+// header carrying the GNU.sparse records, then a USTAR header whose data
+// section starts with the sparse map followed by the data regions.
+func writeSparseEntry(tw *tar.Writer, w io.Writer, hdr *tar.Header, regions []dataRegion, data io.Reader) error {
+	entries := regions
+	if n := len(regions); n == 0 || regions[n-1].offset+regions[n-1].length < hdr.Size {
+		// A trailing hole is recorded as an empty region at the end of the file
+		entries = append(append([]dataRegion(nil), regions...), dataRegion{offset: hdr.Size})
+	}
+
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(entries))
+	for _, e := range entries {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", e.offset, e.length)
+	}
+	padBlock(&sparseMap)
+	dataLen := regionsLength(regions)
+	size := int64(sparseMap.Len()) + dataLen
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     hdr.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(hdr.Size, 10),
+	}
+	if size > maxOctal(12) {
+		records["size"] = strconv.FormatInt(size, 10)
+	}
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pax bytes.Buffer
+	for _, k := range keys {
+		pax.WriteString(formatPAXRecord(k, records[k]))
+	}
+	paxSize := int64(pax.Len())
+	padBlock(&pax)
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	dir, base := path.Split(hdr.Name)
+	base = truncateName(base, 80)
+	blocks := [][]byte{
+		ustarBlock(&tar.Header{
+			Typeflag: tar.TypeXHeader,
+			Name:     "PaxHeaders.0/" + base,
+			Mode:     0644,
+			ModTime:  hdr.ModTime,
+			Size:     paxSize,
+		}),
+		pax.Bytes(),
+		ustarBlock(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     truncateName(dir, 139) + "GNUSparseFile.0/" + base,
+			Mode:     hdr.Mode,
+			Uid:      hdr.Uid,
+			Gid:      hdr.Gid,
+			Uname:    hdr.Uname,
+			Gname:    hdr.Gname,
+			ModTime:  hdr.ModTime,
+			Size:     size,
+		}),
+		sparseMap.Bytes(),
+	}
+	for _, b := range blocks {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	if _, err := io.CopyN(w, data, dataLen); err != nil {
+		return err
+	}
+	if rem := dataLen % tarBlockSize; rem != 0 {
+		if _, err := w.Write(make([]byte, tarBlockSize-rem)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ustarBlock builds a USTAR header block for hdr. Numeric fields that do not
+// fit are left zero and must be carried in a preceding PAX header. Names
+// longer than 100 bytes are split into the prefix field at a slash.
+func ustarBlock(hdr *tar.Header) []byte {
+	blk := make([]byte, tarBlockSize)
+	name, prefix := hdr.Name, ""
+	if len(name) > 100 {
+		if i := strings.LastIndex(name[:min(len(name), 156)], "/"); i > 0 && len(name)-i-1 <= 100 {
+			prefix, name = name[:i], name[i+1:]
+		}
+	}
+	copy(blk[0:100], truncateName(name, 100))
+	formatOctal(blk[100:108], hdr.Mode)
+	formatOctal(blk[108:116], int64(hdr.Uid))
+	formatOctal(blk[116:124], int64(hdr.Gid))
+	formatOctal(blk[124:136], hdr.Size)
+	formatOctal(blk[136:148], hdr.ModTime.Unix())
+	blk[156] = hdr.Typeflag
+	copy(blk[257:265], "ustar\x0000")
+	copy(blk[265:297], truncateName(hdr.Uname, 31))
+	copy(blk[297:329], truncateName(hdr.Gname, 31))
+	copy(blk[345:500], prefix)
+
+	// The checksum is computed with the checksum field filled with spaces
+	copy(blk[148:156], "        ")
+	var sum int64
+	for _, b := range blk {
+		sum += int64(b)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return blk
+}
+
+// formatOctal writes v as a NUL-terminated octal number filling field, or
+// zeros if v is negative or too large.
+func formatOctal(field []byte, v int64) {
+	if v < 0 || v > maxOctal(len(field)) {
+		v = 0
+	}
+	copy(field, fmt.Sprintf("%0*o\x00", len(field)-1, v))
+}
+
+// maxOctal returns the largest value representable in an octal field of n bytes.
+func maxOctal(n int) int64 {
+	return 1<<(3*(n-1)) - 1
+}
+
+// padBlock pads buf with zeros to a multiple of the tar block size.
+func padBlock(buf *bytes.Buffer) {
+	if rem := buf.Len() % tarBlockSize; rem != 0 {
+		buf.Write(make([]byte, tarBlockSize-rem))
+	}
+}
+
+// formatPAXRecord formats a single PAX record, prefixed with its own length.
+func formatPAXRecord(k, v string) string {
+	const padding = 3 // ' ', '=' and '\n'
+	size := len(k) + len(v) + padding
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	if len(record) != size {
+		// Adding the length pushed it over a power of ten
+		size = len(record)
+		record = strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	}
+	return record
+}
+
+// truncateName shortens name to at most n bytes.
+func truncateName(name string, n int) string {
+	if len(name) > n {
+		return name[:n]
+	}
+	return name
+}
+
+// isSparseHeader reports whether hdr describes a GNU sparse file in either the
+// old GNU or the PAX encodings.
+func isSparseHeader(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for _, k := range []string{"GNU.sparse.major", "GNU.sparse.map", "GNU.sparse.size", "GNU.sparse.realsize"} {
+		if _, ok := hdr.PAXRecords[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sparseWriter writes sequentially to f, skipping over runs of zeros so the
+// filesystem can leave them unallocated. finish must be called to extend the
+// file over any trailing hole.
+type sparseWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > sparseChunkSize {
+			chunk = chunk[:sparseChunkSize]
+		}
+		if !isZero(chunk) {
+			if _, err := w.f.WriteAt(chunk, w.offset); err != nil {
+				return n, err
+			}
+		}
+		w.offset += int64(len(chunk))
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+func (w *sparseWriter) finish() error {
+	return w.f.Truncate(w.offset)
+}
+
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+
+package zipper
+
+import (
+	"io/fs"
+	"os"
+)
+
+// fileDataRegions reports no holes; hole detection is not supported here.
+func fileDataRegions(f *os.File, info fs.FileInfo) []dataRegion {
+	return nil
+}
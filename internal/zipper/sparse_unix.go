@@ -0,0 +1,54 @@
+//go:build linux || darwin || freebsd
+
+package zipper
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileDataRegions returns the data regions of f using SEEK_DATA/SEEK_HOLE,
+// or nil when the file has no holes or the filesystem cannot report them.
+func fileDataRegions(f *os.File, info fs.FileInfo) []dataRegion {
+	size := info.Size()
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || size == 0 || int64(st.Blocks)*512 >= size {
+		// Fully allocated; no need to probe for holes
+		return nil
+	}
+	defer f.Seek(0, io.SeekStart)
+
+	var regions []dataRegion
+	var total int64
+	for off := int64(0); off < size; {
+		data, err := f.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, syscall.ENXIO) {
+			break // Only a hole remains
+		}
+		if err != nil {
+			return nil
+		}
+		hole, err := f.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil
+		}
+		if hole > size {
+			hole = size
+		}
+		regions = append(regions, dataRegion{offset: data, length: hole - data})
+		total += hole - data
+		off = hole
+	}
+	if total >= size {
+		return nil
+	}
+	if regions == nil {
+		regions = []dataRegion{}
+	}
+	return regions
+}
@@ -0,0 +1,159 @@
+package zipper
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// FirstPartSuffix is the extension of the first part of a split archive.
+// Paths ending in it are reassembled from all numbered parts when read.
+const FirstPartSuffix = ".001"
+
+// partName returns the name of part n (counting from 1) of a split archive.
+func partName(path string, n int) string {
+	return fmt.Sprintf("%s.%03d", path, n)
+}
+
+// SplitArchive splits the archive at path into numbered parts of at most
+// partSize bytes, named path.001, path.002 and so on, and removes the
+// original. It returns the part names in order.
+func SplitArchive(path string, partSize int64) ([]string, error) {
+	if partSize <= 0 {
+		return nil, fmt.Errorf("invalid part size: %d", partSize)
+	}
+	path = longPath(path)
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+	count := int((info.Size() + partSize - 1) / partSize)
+	if count == 0 {
+		count = 1
+	}
+
+	parts := make([]string, 0, count)
+	for i := 1; i <= count; i++ {
+		name := partName(path, i)
+		part, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, name)
+		_, err = copyBuffered(part, io.LimitReader(src, partSize), 0)
+		if cerr := part.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return parts, err
+		}
+	}
+
+	src.Close()
+	return parts, os.Remove(path)
+}
+
+// archiveReader is an archive opened for reading: from disk, or from a URL
+// by ExtractFromURL.
+type archiveReader interface {
+	io.ReaderAt
+	Size() int64
+	// reader returns a sequential reader over the whole archive.
+	reader() io.Reader
+}
+
+// archiveFile is an archive opened for reading: a single file, or the parts
+// of a split archive read as one.
+type archiveFile struct {
+	parts   []*os.File
+	offsets []int64 // offset of the start of each part
+	size    int64
+}
+
+// openArchive opens the archive at path, gathering every part when path
+// names the first part of a split archive.
+func openArchive(path string) (*archiveFile, error) {
+	names := []string{path}
+	if strings.HasSuffix(path, FirstPartSuffix) {
+		base := strings.TrimSuffix(path, FirstPartSuffix)
+		for n := 2; ; n++ {
+			name := partName(base, n)
+			if _, err := os.Stat(name); err != nil {
+				break
+			}
+			names = append(names, name)
+		}
+	}
+
+	a := &archiveFile{}
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			a.Close()
+			return nil, err
+		}
+		a.parts = append(a.parts, f)
+		a.offsets = append(a.offsets, a.size)
+		a.size += info.Size()
+	}
+	return a, nil
+}
+
+// Size returns the combined size of all parts.
+func (a *archiveFile) Size() int64 {
+	return a.size
+}
+
+// ReadAt reads from the parts spanning off.
+func (a *archiveFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= a.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(a.offsets), func(i int) bool { return a.offsets[i] > off }) - 1
+	read := 0
+	for read < len(p) && i < len(a.parts) {
+		n, err := a.parts[i].ReadAt(p[read:], off-a.offsets[i])
+		read += n
+		off += int64(n)
+		if err == io.EOF {
+			i++
+			continue
+		}
+		if err != nil {
+			return read, err
+		}
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// reader returns a sequential reader over the whole archive.
+func (a *archiveFile) reader() io.Reader {
+	return io.NewSectionReader(a, 0, a.size)
+}
+
+func (a *archiveFile) Close() error {
+	var err error
+	for _, f := range a.parts {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package zipper
+
+import (
+	"archive/tar"
+	"fmt"
+	"time"
+)
+
+// TarFormat selects the header format of the entries of tar.gz archives.
+type TarFormat int
+
+const (
+	// TarFormatPAX writes POSIX.1-2001 headers, adding PAX records where
+	// the USTAR fields fall short: names of any length, files of 8 GB and
+	// more, large ids, and times to the nanosecond including access and
+	// change times. It is what GNU tar calls posix and is read by every
+	// current tar.
+	TarFormatPAX TarFormat = iota
+	// TarFormatGNU writes GNU headers, with GNU long name records and
+	// base-256 sizes, for consumers that predate PAX. Times are kept to the
+	// second and sparse files are stored in full.
+	TarFormatGNU
+	// TarFormatUSTAR writes plain POSIX.1-1988 headers, which the oldest
+	// tools read. Names longer than 256 bytes, or 100 bytes after the last
+	// slash, and files of 8 GB and more cannot be stored and fail the
+	// archive. Times are kept to the second and sparse files are stored in
+	// full.
+	TarFormatUSTAR
+)
+
+// ParseTarFormat parses the name of a tar format as used on the command
+// line.
+func ParseTarFormat(s string) (TarFormat, error) {
+	switch s {
+	case "pax", "posix":
+		return TarFormatPAX, nil
+	case "gnu":
+		return TarFormatGNU, nil
+	case "ustar":
+		return TarFormatUSTAR, nil
+	}
+	return 0, fmt.Errorf("unknown tar format: %s (use pax, gnu or ustar)", s)
+}
+
+func (f TarFormat) String() string {
+	switch f {
+	case TarFormatGNU:
+		return "gnu"
+	case TarFormatUSTAR:
+		return "ustar"
+	}
+	return "pax"
+}
+
+// sparse reports whether sparse files can be stored compactly, which this
+// package does with PAX records.
+func (f TarFormat) sparse() bool {
+	return f == TarFormatPAX
+}
+
+// apply sets h to be written in format f. Times and PAX records the format
+// cannot hold are truncated to the second or dropped rather than failing
+// the entry.
+func (f TarFormat) apply(h *tar.Header) {
+	if f != TarFormatPAX {
+		h.PAXRecords = nil
+	}
+	switch f {
+	case TarFormatGNU:
+		h.Format = tar.FormatGNU
+		h.ModTime = h.ModTime.Truncate(time.Second)
+		h.AccessTime = h.AccessTime.Truncate(time.Second)
+		h.ChangeTime = h.ChangeTime.Truncate(time.Second)
+	case TarFormatUSTAR:
+		h.Format = tar.FormatUSTAR
+		h.ModTime = h.ModTime.Truncate(time.Second)
+		h.AccessTime = time.Time{}
+		h.ChangeTime = time.Time{}
+	default:
+		h.Format = tar.FormatPAX
+	}
+}
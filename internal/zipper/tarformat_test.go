@@ -0,0 +1,141 @@
+package zipper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var tarFormats = []TarFormat{TarFormatPAX, TarFormatGNU, TarFormatUSTAR}
+
+// roundTripTar archives src as a tar.gz in format and extracts it again,
+// returning the folder it was extracted to. The ratio limit is lifted, as
+// sparse files compress far beyond it.
+func roundTripTar(t *testing.T, src string, format TarFormat) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "out.tar.gz")
+	if _, err := GzipWithOptions(src, archivePath, CreateOptions{TarFormat: format}); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, "dest")
+	if _, err := ExtractGzipWithOptions(archivePath, dest, ExtractOptions{MaxRatio: -1}); err != nil {
+		t.Fatalf("extract %s: %v", format, err)
+	}
+	return dest, nil
+}
+
+func TestTarFormatLongPath(t *testing.T) {
+	// Folders of 90 bytes and a 54 byte file name: one folder gives a path
+	// USTAR can still split into its prefix field, three one over 256 bytes
+	tests := []struct {
+		name      string
+		depth     int
+		ustarFits bool
+	}{
+		{"over 100 bytes", 1, true},
+		{"over 256 bytes", 3, false},
+	}
+	for _, tt := range tests {
+		src := t.TempDir()
+		rel := strings.Repeat(strings.Repeat("d", 90)+"/", tt.depth) + strings.Repeat("f", 50) + ".txt"
+		path := filepath.Join(src, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("long path"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, format := range tarFormats {
+			dest, err := roundTripTar(t, src, format)
+			if format == TarFormatUSTAR && !tt.ustarFits {
+				if err == nil {
+					t.Errorf("%s, %s: a %d byte path was archived", tt.name, format, len(rel))
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, %s: %v", tt.name, format, err)
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(rel)))
+			if err != nil || string(data) != "long path" {
+				t.Errorf("%s, %s: extracted %q, %v", tt.name, format, data, err)
+			}
+		}
+	}
+}
+
+// largeTestsEnv names the environment variable that, when set, runs the
+// tests reading and writing many gigabytes in full.
+const largeTestsEnv = "PZIP_LARGE_TESTS"
+
+func TestTarFormatLargeSparseFile(t *testing.T) {
+	const size = 9 << 30
+	src := t.TempDir()
+	path := filepath.Join(src, "disk.img")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marks := map[int64]string{1 << 20: "start", 8<<30 + 12345: "past 8 GB"}
+	for off, s := range marks {
+		if _, err := f.WriteAt([]byte(s), off); err != nil {
+			f.Close()
+			t.Fatal(err)
+		}
+	}
+	err = f.Truncate(size)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := true
+	if info, err := os.Stat(path); err == nil {
+		if sf, err := os.Open(path); err == nil {
+			stored = fileDataRegions(sf, info) == nil
+			sf.Close()
+		}
+	}
+
+	large := os.Getenv(largeTestsEnv) != ""
+	for _, format := range tarFormats {
+		// Formats or platforms that store the holes in full read and
+		// compress all 9 GB; USTAR fails at the header
+		if (stored || !format.sparse()) && format != TarFormatUSTAR && !large {
+			t.Logf("%s: skipped; set %s to run it", format, largeTestsEnv)
+			continue
+		}
+		dest, err := roundTripTar(t, src, format)
+		if format == TarFormatUSTAR {
+			if err == nil {
+				t.Errorf("%s: a file over 8 GB was archived", format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		out, err := os.Open(filepath.Join(dest, "disk.img"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info, err := out.Stat(); err != nil {
+			t.Error(err)
+		} else if info.Size() != size {
+			t.Errorf("%s: extracted %d bytes; want %d", format, info.Size(), int64(size))
+		}
+		for off, s := range marks {
+			buf := make([]byte, len(s))
+			if _, err := out.ReadAt(buf, off); err != nil || !bytes.Equal(buf, []byte(s)) {
+				t.Errorf("%s: read %q at %d, %v; want %q", format, buf, off, err, s)
+			}
+		}
+		out.Close()
+		os.RemoveAll(dest)
+	}
+}
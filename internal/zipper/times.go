@@ -0,0 +1,39 @@
+package zipper
+
+import (
+	"os"
+	"time"
+)
+
+// dirTimes records the archived times and attributes of a directory so they
+// can be applied once all of its contents have been written.
+type dirTimes struct {
+	path  string
+	name  string // entry name
+	mtime time.Time
+	atime time.Time
+	attrs uint32
+}
+
+// restoreTimes applies the archived modification and access times to path.
+// A zero access time falls back to the modification time. Failures are
+// reported as warnings for the entry name since the file contents were
+// extracted successfully.
+func restoreTimes(path, name string, mtime, atime time.Time, warnings *warningList) {
+	if mtime.IsZero() {
+		return
+	}
+	if atime.IsZero() {
+		atime = mtime
+	}
+	if err := os.Chtimes(path, atime, mtime); err != nil {
+		warnings.add(WarningTimesNotRestored, name, "cannot restore times: %v", err)
+	}
+}
+
+// restoreDirTimes applies the recorded times to each directory.
+func restoreDirTimes(dirs []dirTimes, warnings *warningList) {
+	for _, d := range dirs {
+		restoreTimes(d.path, d.name, d.mtime, d.atime, warnings)
+	}
+}
@@ -0,0 +1,239 @@
+package zipper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// httpBlockSize is the size of the blocks a remote zip archive is fetched
+// in. Entries are decompressed a few bytes at a time, so reads are served
+// from cached blocks rather than a request each.
+const httpBlockSize = 1 << 20
+
+// ExtractFromURL extracts a zip or tar.gz archive from an HTTP or HTTPS URL
+// into destDir; the format is taken from the URL path, with .tar.gz and .tgz
+// for tar.gz and zip otherwise. tar.gz archives are extracted as the
+// download streams in. Zip archives are read with range requests when the
+// server supports them, fetching the central directory and then each entry
+// as it is extracted, and are otherwise downloaded to a temporary file
+// first, reporting the download as progress. ctx cancels both the download
+// and extraction, in place of opts.Context.
+func ExtractFromURL(ctx context.Context, rawURL, destDir string, opts ExtractOptions) (stats ExtractStats, err error) {
+	log := logExtract(&opts, "extract", rawURL, destDir)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return stats, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return stats, fmt.Errorf("unsupported URL scheme: %s (use http or https)", u.Scheme)
+	}
+	ctx = optionsContext(ctx)
+	opts.Context = ctx
+	destDir = longPath(destDir)
+	name := path.Base(u.Path)
+
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") {
+		resp, err := httpGet(ctx, rawURL, "")
+		if err != nil {
+			return stats, err
+		}
+		defer resp.Body.Close()
+		return extractGzip(&httpStream{body: resp.Body, size: max(resp.ContentLength, 0)}, name, destDir, opts)
+	}
+
+	if size, ok := httpRangeSize(ctx, rawURL); ok {
+		archive := &httpArchive{
+			ctx:    ctx,
+			url:    rawURL,
+			size:   size,
+			blocks: make(map[int64][]byte),
+			// A block in flight for each worker, and the central directory
+			maxBlocks: 2*WorkerCount(opts.Workers) + 2,
+		}
+		return extractZip(archive, name, destDir, opts)
+	}
+
+	tempPath, err := downloadTemp(ctx, rawURL, name, opts)
+	if err != nil {
+		return stats, err
+	}
+	defer os.Remove(tempPath)
+	archive, err := openArchive(tempPath)
+	if err != nil {
+		return stats, err
+	}
+	defer archive.Close()
+	return extractZip(archive, name, destDir, opts)
+}
+
+// httpGet requests url, or the given byte range of it, failing unless the
+// server answers with its contents.
+func httpGet(ctx context.Context, url, byteRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// httpRangeSize returns the size of the file at url if the server accepts
+// range requests for it.
+func httpRangeSize(ctx context.Context, url string) (int64, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, false
+	}
+	resp.Body.Close()
+	ok := resp.StatusCode == http.StatusOK && resp.ContentLength > 0 && resp.Header.Get("Accept-Ranges") == "bytes"
+	return resp.ContentLength, ok
+}
+
+// downloadTemp downloads url to a temporary file, reporting progress to
+// opts and keeping to its RateLimit, and returns its path.
+func downloadTemp(ctx context.Context, url, name string, opts ExtractOptions) (string, error) {
+	resp, err := httpGet(ctx, url, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	temp, err := os.CreateTemp("", "pzip-download-*.zip")
+	if err != nil {
+		return "", err
+	}
+	size := max(resp.ContentLength, 0)
+	tracker := newProgressTracker(withoutFile(opts.Progress), opts.ProgressEvents, opts.ProgressInterval, size, 0)
+	tracker.update()
+	body := &countingReader{r: contextReader{ctx: ctx, r: newRateLimiter(ctx, opts.RateLimit).reader(resp.Body)}, onRead: func(n int64) {
+		tracker.read(name, size, n)
+	}}
+	_, err = copyBuffered(temp, body, opts.BufferSize)
+	tracker.update()
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", err
+	}
+	return temp.Name(), nil
+}
+
+// httpStream is a remote tar.gz archive read as it downloads. It supports
+// only sequential reads.
+type httpStream struct {
+	body io.Reader
+	size int64 // zero when the server does not say
+}
+
+func (s *httpStream) ReadAt(p []byte, off int64) (int, error) {
+	return 0, fmt.Errorf("random access to a streamed download")
+}
+
+func (s *httpStream) Size() int64 {
+	return s.size
+}
+
+func (s *httpStream) reader() io.Reader {
+	return s.body
+}
+
+// httpArchive reads a remote archive with range requests, keeping the
+// blocks most recently fetched. It is safe for concurrent use.
+type httpArchive struct {
+	ctx       context.Context
+	url       string
+	size      int64
+	maxBlocks int
+
+	mu     sync.Mutex
+	blocks map[int64][]byte
+	order  []int64 // cached block numbers, oldest first
+}
+
+func (a *httpArchive) Size() int64 {
+	return a.size
+}
+
+func (a *httpArchive) reader() io.Reader {
+	return io.NewSectionReader(a, 0, a.size)
+}
+
+func (a *httpArchive) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for read < len(p) && off < a.size {
+		block, err := a.block(off / httpBlockSize)
+		if err != nil {
+			return read, err
+		}
+		n := copy(p[read:], block[off%httpBlockSize:])
+		read += n
+		off += int64(n)
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// block returns block i of the archive, fetching it if it is not cached.
+func (a *httpArchive) block(i int64) ([]byte, error) {
+	a.mu.Lock()
+	data, ok := a.blocks[i]
+	a.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	start := i * httpBlockSize
+	end := min(start+httpBlockSize, a.size)
+	resp, err := httpGet(a.ctx, a.url, fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("GET %s: range request answered with %s", a.url, resp.Status)
+	}
+	data = make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.blocks[i]; !ok {
+		a.blocks[i] = data
+		a.order = append(a.order, i)
+		if len(a.order) > a.maxBlocks {
+			delete(a.blocks, a.order[0])
+			a.order = a.order[1:]
+		}
+	}
+	return data, nil
+}
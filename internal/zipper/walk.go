@@ -0,0 +1,146 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// Entry describes an archive entry passed to a WalkFunc.
+type Entry struct {
+	Name           string // slash-separated path as stored, ending in "/" for folders in zips
+	Size           int64  // uncompressed size; zero for folders and links
+	CompressedSize int64  // -1 in tar.gz archives, which compress entries as one stream
+	Mode           fs.FileMode
+	ModTime        time.Time
+	IsDir          bool
+	// LinkTarget is the target of a symbolic link, or for a tar hard link
+	// the name of the entry holding its data.
+	LinkTarget string
+}
+
+// WalkFunc is called by WalkArchive for each entry with a reader over its
+// content, decompressed as it is read.
+type WalkFunc func(e Entry, r io.Reader) error
+
+// maxLinkTarget caps the size of a zip symbolic link entry read for its
+// target.
+const maxLinkTarget = 4096
+
+// WalkArchive calls fn for each entry of the zip or tar.gz archive at path,
+// which may name the first part of a split archive, in the order they are
+// stored, streaming the content of each file without extracting anything to
+// disk. Folders and links get an empty reader. The reader is only valid
+// until fn returns and need not be read to the end. A zip entry whose data
+// fails its CRC-32 check, or that holds more than its declared size, gives
+// the reader an error rather than stopping the walk.
+//
+// An error returned by fn stops the walk and is returned by WalkArchive,
+// except for fs.SkipAll, which stops it without error.
+func WalkArchive(path string, fn WalkFunc) error {
+	archive, err := openArchive(longPath(path))
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	if isGzipName(path) {
+		err = walkTar(archive, path, fn)
+	} else {
+		err = walkZip(archive, fn)
+	}
+	if errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+func walkZip(archive *archiveFile, fn WalkFunc) error {
+	reader, err := zip.NewReader(archive, archive.Size())
+	if err != nil {
+		return err
+	}
+	decodeZipNames(reader.File, NameEncodingAuto)
+	for _, f := range reader.File {
+		mode := f.Mode()
+		e := Entry{
+			Name:           f.Name,
+			CompressedSize: int64(f.CompressedSize64),
+			Mode:           mode,
+			ModTime:        f.Modified,
+			IsDir:          mode.IsDir(),
+		}
+		if e.IsDir {
+			if err := fn(e, strings.NewReader("")); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		if mode&fs.ModeSymlink != 0 {
+			// Zips store the target of a link as its content
+			target, err := io.ReadAll(io.LimitReader(rc, maxLinkTarget))
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			e.LinkTarget = string(target)
+			if err := fn(e, strings.NewReader("")); err != nil {
+				return err
+			}
+			continue
+		}
+		e.Size = int64(f.UncompressedSize64)
+		err = fn(e, &declaredSizeReader{r: rc, name: f.Name, limit: f.UncompressedSize64})
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(archive *archiveFile, path string, fn WalkFunc) error {
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(archive.reader(), 256<<10))
+	if err != nil {
+		return err
+	}
+	defer gzReader.Close()
+	tarReader := tar.NewReader(gzReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return gzipStreamError(path, err)
+		}
+		info := header.FileInfo()
+		e := Entry{
+			Name:           header.Name,
+			CompressedSize: -1,
+			Mode:           info.Mode(),
+			ModTime:        header.ModTime,
+			IsDir:          info.IsDir(),
+		}
+		var content io.Reader = strings.NewReader("")
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeGNUSparse:
+			e.Size = header.Size
+			content = tarReader
+		case tar.TypeSymlink, tar.TypeLink:
+			e.LinkTarget = header.Linkname
+		}
+		if err := fn(e, content); err != nil {
+			return err
+		}
+	}
+}
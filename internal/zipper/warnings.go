@@ -0,0 +1,74 @@
+package zipper
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// WarningCode identifies the kind of problem a Warning reports.
+type WarningCode string
+
+const (
+	// WarningSymlinkSkipped reports a symbolic link that was left out of an
+	// archive, or not extracted from one.
+	WarningSymlinkSkipped WarningCode = "symlink_skipped"
+	// WarningUnsupportedEntry reports a tar entry that is neither a file,
+	// a folder nor a link, such as a device, which was not extracted.
+	WarningUnsupportedEntry WarningCode = "unsupported_entry"
+	// WarningTimesNotRestored reports an extracted file or folder whose
+	// modification time could not be set.
+	WarningTimesNotRestored WarningCode = "times_not_restored"
+	// WarningAttributesDropped reports an extracted file or folder whose
+	// Windows attributes could not be set.
+	WarningAttributesDropped WarningCode = "attributes_dropped"
+	// WarningEntryRenamed reports an entry written under a new name because
+	// the file already existed, under OverwriteRename.
+	WarningEntryRenamed WarningCode = "entry_renamed"
+	// WarningFlattenCollision reports a file renamed or replaced because
+	// ExtractOptions.Flatten gave it the name of another.
+	WarningFlattenCollision WarningCode = "flatten_collision"
+	// WarningLinkTargetMissing reports a tar hard link that was not
+	// extracted because the file it links to was not, having been filtered
+	// out, kept as it was or never stored.
+	WarningLinkTargetMissing WarningCode = "link_target_missing"
+	// WarningMergeCollision reports a file renamed or replaced because
+	// several archives being merged hold a file of that name.
+	WarningMergeCollision WarningCode = "merge_collision"
+	// WarningNestedArchive reports a file named like an archive that was
+	// not extracted under ExtractOptions.Recursive, as it is not one.
+	WarningNestedArchive WarningCode = "nested_archive"
+)
+
+// Warning is a problem that did not stop an archive from being created or
+// extracted.
+type Warning struct {
+	Code    WarningCode `json:"code"`
+	Name    string      `json:"name"` // entry name
+	Message string      `json:"message"`
+}
+
+func (w Warning) String() string {
+	return w.Name + ": " + w.Message
+}
+
+// warningList collects warnings. It is safe for concurrent use.
+type warningList struct {
+	mu       sync.Mutex
+	warnings []Warning
+}
+
+func (l *warningList) add(code WarningCode, name, format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, Warning{Code: code, Name: name, Message: fmt.Sprintf(format, args...)})
+}
+
+// list returns the warnings ordered by entry name, since workers add them
+// in no particular order.
+func (l *warningList) list() []Warning {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sort.SliceStable(l.warnings, func(i, j int) bool { return l.warnings[i].Name < l.warnings[j].Name })
+	return l.warnings
+}
@@ -0,0 +1,234 @@
+package zipper
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultWatchInterval is how often WatchTree scans for changes when it
+// polls.
+const DefaultWatchInterval = time.Second
+
+// DefaultWatchQuiet is how long a tree must stay unchanged after a change
+// before WatchTree reports it, so that a burst of saves leads to one run.
+const DefaultWatchQuiet = 2 * time.Second
+
+// WatchOptions configures WatchTree.
+type WatchOptions struct {
+	// Poll scans the tree every Interval instead of waiting for change
+	// notifications from the OS, for file systems that do not send them,
+	// such as some network shares.
+	Poll bool
+	// Interval between scans when polling. Zero uses DefaultWatchInterval.
+	Interval time.Duration
+	// Quiet is how long the tree must stay unchanged before a change is
+	// reported. Zero uses DefaultWatchQuiet.
+	Quiet time.Duration
+	// Ignore lists paths whose changes are not reported, along with any
+	// file named with one of them as a prefix: an archive written inside
+	// the watched folder, with its temporary and checksum files.
+	Ignore []string
+}
+
+// treeEntry is the state of a file or folder as WatchTree compares it.
+type treeEntry struct {
+	size    int64
+	modTime int64
+	mode    fs.FileMode
+}
+
+// WatchTree watches the tree at dir and calls onChange each time it has
+// changed and then stayed unchanged for the quiet period. Every folder is
+// watched through the OS's change notifications; once they go quiet the
+// tree is scanned, and onChange is only called if it differs from the last
+// time. Where notifications cannot be set up, such as past the inotify
+// limit on the number of watched folders, or when dir itself is replaced,
+// the tree is polled instead, as it is with opts.Poll. Changes made while
+// onChange runs lead to another call. It returns nil when ctx is done, or
+// an error if dir cannot be read at the start.
+func WatchTree(ctx context.Context, dir string, opts WatchOptions, onChange func()) error {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultWatchInterval
+	}
+	if opts.Quiet <= 0 {
+		opts.Quiet = DefaultWatchQuiet
+	}
+	ctx = optionsContext(ctx)
+
+	archived, err := scanTree(dir, opts.Ignore)
+	if err != nil {
+		return err
+	}
+	if !opts.Poll {
+		if w, err := fsnotify.NewWatcher(); err == nil {
+			defer w.Close()
+			if err := watchFolders(w, dir, opts.Ignore); err == nil {
+				return notifyTree(ctx, w, dir, archived, opts, onChange)
+			}
+		}
+	}
+	pollTree(ctx, dir, archived, opts, onChange)
+	return nil
+}
+
+// notifyTree runs WatchTree on the events of w, which watches every folder
+// under dir, falling back to pollTree if the watch breaks down.
+func notifyTree(ctx context.Context, w *fsnotify.Watcher, dir string, archived map[string]treeEntry, opts WatchOptions, onChange func()) error {
+	quiet := time.NewTimer(opts.Quiet)
+	quiet.Stop()
+	defer quiet.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				break
+			}
+			if ev.Name == dir && ev.Has(fsnotify.Remove|fsnotify.Rename) {
+				// Watches do not carry over to a folder put in its place
+				break
+			}
+			if ignored(ev.Name, opts.Ignore) {
+				continue
+			}
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Lstat(ev.Name); err == nil && info.IsDir() {
+					if err := watchFolders(w, ev.Name, opts.Ignore); err != nil && !errors.Is(err, fs.ErrNotExist) {
+						break
+					}
+				}
+			}
+			quiet.Reset(opts.Quiet)
+			continue
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				break
+			}
+			// Events were lost when the queue overflowed, so the tree
+			// may have changed in ways not reported
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				quiet.Reset(opts.Quiet)
+			}
+			continue
+
+		case <-quiet.C:
+			// The folder may be briefly missing while a tool replaces it
+			state, err := scanTree(dir, opts.Ignore)
+			if err != nil {
+				quiet.Reset(opts.Quiet)
+				continue
+			}
+			if !maps.Equal(state, archived) {
+				archived = state
+				onChange()
+			}
+			continue
+		}
+
+		// Reached through break when notifications can no longer be
+		// relied on
+		pollTree(ctx, dir, archived, opts, onChange)
+		return nil
+	}
+}
+
+// pollTree runs WatchTree by scanning the tree every opts.Interval, from
+// the state archived, until ctx is done.
+func pollTree(ctx context.Context, dir string, archived map[string]treeEntry, opts WatchOptions, onChange func()) {
+	last := archived
+	var changedAt time.Time
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// The folder may be briefly missing while a tool replaces it
+		state, err := scanTree(dir, opts.Ignore)
+		if err != nil {
+			continue
+		}
+		if !maps.Equal(state, last) {
+			last = state
+			changedAt = time.Now()
+			continue
+		}
+		if !maps.Equal(state, archived) && time.Since(changedAt) >= opts.Quiet {
+			archived = state
+			onChange()
+		}
+	}
+}
+
+// watchFolders adds dir and every folder under it to w, leaving out the
+// ignored ones.
+func watchFolders(w *fsnotify.Watcher, dir string, ignore []string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if ignored(path, ignore) {
+			return filepath.SkipDir
+		}
+		if err := w.Add(path); err != nil && (path == dir || !errors.Is(err, fs.ErrNotExist)) {
+			return err
+		}
+		return nil
+	})
+}
+
+// ignored reports whether path starts with one of the prefixes in ignore.
+func ignored(path string, ignore []string) bool {
+	for _, prefix := range ignore {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanTree records the size, modification time and mode of everything
+// under dir. Entries that vanish or cannot be read during the scan are left
+// out; they show up as changes once they settle.
+func scanTree(dir string, ignore []string) (map[string]treeEntry, error) {
+	state := make(map[string]treeEntry)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if ignored(path, ignore) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		state[path] = treeEntry{size: info.Size(), modTime: info.ModTime().UnixNano(), mode: info.Mode()}
+		return nil
+	})
+	return state, err
+}
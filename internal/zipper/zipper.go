@@ -3,18 +3,26 @@ package zipper
 import (
 	"archive/tar"
 	"archive/zip"
+	"bufio"
+	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // ProgressFunc reports the number of source bytes processed out of the total.
@@ -23,70 +31,150 @@ type ProgressFunc func(done, total int64)
 // ProgressWithFileFunc reports progress including the current file being processed.
 type ProgressWithFileFunc func(done, total int64, currentFile string)
 
-// ArchiveStats describes the payload processed while creating an archive.
-type ArchiveStats struct {
-	TotalBytes int64
-	FileCount  int
-	Checksum   string // SHA-256 checksum of the archive
+// EntryEvent describes an entry that has been written to or extracted from
+// an archive.
+type EntryEvent struct {
+	Name  string // slash-separated path within the archive
+	IsDir bool
+	Size  int64 // uncompressed size
+	// CompressedSize is the size of the entry's data in a zip archive, or -1
+	// for tar.gz entries, which are compressed as one stream.
+	CompressedSize int64
+	Method         uint16 // zip compression method
 }
 
-// shouldSkip determines if a file/directory should be excluded from archiving
-func shouldSkip(name string, isDir bool) bool {
-	lowerName := strings.ToLower(name)
-
-	// Skip hidden files/folders (starting with .)
-	if strings.HasPrefix(name, ".") {
-		return true
-	}
-
-	// Skip common development/cache directories
-	skipDirs := map[string]bool{
-		"node_modules": true,
-		"__pycache__":  true,
-		".git":         true,
-		".svn":         true,
-		".hg":          true,
-		".vscode":      true,
-		".idea":        true,
-		".vs":          true,
-		"bin":          true,
-		"obj":          true,
-		"target":       true,
-		"build":        true,
-		"dist":         true,
-		".cache":       true,
-		"temp":         true,
-		"tmp":          true,
-		".temp":        true,
-		".tmp":         true,
-		"thumbs.db":    true,
-		".ds_store":    true,
-	}
-
-	if isDir && skipDirs[lowerName] {
-		return true
-	}
-
-	// Skip temporary files
-	if strings.HasSuffix(lowerName, ".tmp") ||
-		strings.HasSuffix(lowerName, ".temp") ||
-		strings.HasSuffix(lowerName, "~") ||
-		strings.HasSuffix(lowerName, ".bak") ||
-		strings.HasSuffix(lowerName, ".swp") ||
-		strings.HasPrefix(lowerName, "~$") {
-		return true
-	}
-
-	// Skip system files
-	if lowerName == "thumbs.db" || lowerName == "desktop.ini" || lowerName == ".ds_store" {
-		return true
-	}
+// EntryFunc is called once for each entry processed. Calls are made one at a
+// time and never concurrently with the progress callback.
+type EntryFunc func(EntryEvent)
+
+// CreateOptions configures how an archive is created.
+type CreateOptions struct {
+	// Context cancels archiving when done; nil never cancels.
+	Context context.Context
+	// Progress receives byte progress and the file currently being written.
+	Progress ProgressWithFileFunc
+	// ProgressEvents receives the same progress with file counts and the
+	// progress of the current file.
+	ProgressEvents ProgressEventFunc
+	// ProgressInterval is the least time between progress reports, which
+	// would otherwise follow every buffer read. Zero uses
+	// DefaultProgressInterval and a negative value reports every change.
+	// The first report and the one at 100% are always made.
+	ProgressInterval time.Duration
+	// OnEntry is called after each entry is written to the archive.
+	OnEntry EntryFunc
+	// Workers is the number of files read and compressed in parallel, and
+	// of tar.gz blocks compressed at once. Zero uses WorkerCount's default.
+	Workers int
+	// Level is the deflate compression level, from 1 (fastest) to 9
+	// (smallest). Zero picks a level from the total size of the files. Files
+	// that are already compressed, such as JPEG or MP4, are stored in zip
+	// archives whatever the level.
+	Level int
+	// Store writes files without compression, overriding Level.
+	Store bool
+	// Exclude leaves out files and folders whose path relative to the
+	// source folder matches any of these patterns. "**" matches any number
+	// of folders and a pattern without a "/" matches at any depth, so
+	// "*.log" excludes log files anywhere and "build/**" one folder's
+	// contents.
+	Exclude []string
+	// SkipHidden leaves out hidden files and folders: those whose names
+	// start with a dot, and on Windows those with the hidden attribute.
+	SkipHidden bool
+	// SkipJunk leaves out files and folders operating systems leave behind,
+	// such as .DS_Store, Thumbs.db, desktop.ini and __MACOSX.
+	SkipJunk bool
+	// Dereference archives the targets of symbolic links, under the names
+	// of the links, instead of leaving the links out. Links back to a folder
+	// that contains them are not followed.
+	Dereference bool
+	// ErrorPolicy decides whether files and folders that cannot be read,
+	// such as those denied by permissions, abort archiving or are skipped.
+	ErrorPolicy ErrorPolicy
+	// OnSkip is called for each file or folder skipped under ErrorSkip.
+	// Calls are made one at a time and never concurrently with the progress
+	// callback.
+	OnSkip SkipFunc
+	// Logger, when set, receives each entry at debug level, skipped files
+	// and warnings as warnings, and the outcome and duration of the
+	// operation, for troubleshooting.
+	Logger *slog.Logger
+	// MaxMemory caps the bytes of file data buffered in memory by the
+	// parallel readers. Files larger than a worker's share of the ceiling are
+	// streamed from disk instead of being read up front. Zero uses
+	// DefaultMaxMemory.
+	MaxMemory int64
+	// BufferSize is the size of the buffers used to read source files.
+	// Zero uses DefaultBufferSize.
+	BufferSize int
+	// RateLimit caps the bytes per second read from source files, in total
+	// across workers, so that archiving in the background leaves the disk to
+	// other work. Zero is unlimited.
+	RateLimit int64
+	// EntryPause is a pause made before each file is written, which gives
+	// other programs turns at the disk during long background jobs. Zero does
+	// not pause.
+	EntryPause time.Duration
+	// Manifest adds a ManifestName entry listing the SHA-256 digest of every
+	// file, which VerifyManifest checks after extraction or in place.
+	Manifest bool
+	// Comment is stored in the zip comment, above the checksum line, and
+	// replaces the comment of an archive being appended to or updated.
+	// tar.gz archives have no comment and ignore it.
+	Comment string
+	// TarFormat is the header format of tar.gz entries, TarFormatPAX by
+	// default. Zip archives ignore it.
+	TarFormat TarFormat
+	// Dedupe stores the content of byte-identical files once. Later copies
+	// become hard links to the first in tar.gz archives; in zip archives,
+	// whose entries cannot share data, they reuse its compressed data, which
+	// saves compressing them again but not space. Files sharing their size
+	// with another are hashed before archiving, so they are read twice.
+	Dedupe bool
+	// Reproducible makes the archive depend only on the names, modes and
+	// contents of the files, so that archiving the same files again gives
+	// the same bytes: entries are sorted by name, every time is set to
+	// 1980-01-01 UTC, or ClampModTime when set, owners are left out and
+	// tar.gz streams are compressed the same way whatever the number of
+	// workers.
+	Reproducible bool
+	// ClampModTime, when set, records entries modified after it as modified
+	// at it, as build tools do with SOURCE_DATE_EPOCH, so that checking out
+	// the same sources again does not change the archive.
+	ClampModTime time.Time
+}
 
-	return false
+// ArchiveStats describes the payload processed while creating an archive.
+type ArchiveStats struct {
+	TotalBytes int64  `json:"total_bytes"`
+	FileCount  int    `json:"file_count"`
+	Checksum   string `json:"checksum"`            // SHA-256 checksum of the archive
+	Unchanged  int    `json:"unchanged,omitempty"` // files carried over as they were by UpdateWithProgress
+	Removed    int    `json:"removed,omitempty"`   // entries dropped by SyncWithProgress
+	// SkippedFiles lists the entry names of files and folders left out under
+	// ErrorSkip because they could not be read. They are not counted in
+	// TotalBytes or FileCount.
+	SkippedFiles []string `json:"skipped_files,omitempty"`
+	// Warnings lists problems that did not stop archiving, such as symbolic
+	// links that were left out.
+	Warnings []Warning `json:"warnings,omitempty"`
+	// ArchiveSize is the size of the archive written.
+	ArchiveSize int64 `json:"archive_size,omitempty"`
+	// Deduplicated counts files stored as copies of an earlier file with
+	// the same content under CreateOptions.Dedupe, and DeduplicatedBytes
+	// their total size.
+	Deduplicated      int   `json:"deduplicated,omitempty"`
+	DeduplicatedBytes int64 `json:"deduplicated_bytes,omitempty"`
 }
 
-// getWorkerCount returns the number of workers to use (20% of CPU cores, minimum 1)
-func getWorkerCount() int {
+// WorkerCount returns the number of workers used for a Workers option of
+// requested: requested itself when positive, otherwise 20% of CPU cores
+// (minimum 1).
+func WorkerCount(requested int) int {
+	if requested > 0 {
+		return requested
+	}
 	numCPU := runtime.NumCPU()
 	workers := numCPU / 5
 	if workers < 1 {
@@ -105,6 +193,7 @@ type fileJob struct {
 
 // getCompressionMethod returns the optimal compression method for a file
 // Returns zip.Store for already-compressed files, zip.Deflate for everything else
+// This is the fast path by name; sampleMethod also looks at the content
 func getCompressionMethod(filename string) uint16 {
 	ext := strings.ToLower(filepath.Ext(filename))
 	// Already compressed formats - store without recompression
@@ -120,6 +209,20 @@ func getCompressionMethod(filename string) uint16 {
 	return zip.Deflate
 }
 
+// compressionLevel returns the deflate level requested by opts, or the
+// optimal level for totalSize when none was given.
+func compressionLevel(opts CreateOptions, totalSize int64) (int, error) {
+	switch {
+	case opts.Store:
+		return flate.NoCompression, nil
+	case opts.Level == 0:
+		return getOptimalCompressionLevel(totalSize), nil
+	case opts.Level < flate.BestSpeed || opts.Level > flate.BestCompression:
+		return 0, fmt.Errorf("invalid compression level: %d (use 1-9)", opts.Level)
+	}
+	return opts.Level, nil
+}
+
 // getOptimalCompressionLevel returns compression level based on total archive size
 // Larger archives use faster compression, smaller archives get better compression
 func getOptimalCompressionLevel(totalSize int64) int {
@@ -153,215 +256,377 @@ func ZipWithProgress(srcDir, zipPath string, progress ProgressFunc) (stats Archi
 
 // ZipWithProgressAndFile creates a zip archive and reports progress with current file information.
 func ZipWithProgressAndFile(srcDir, zipPath string, progress ProgressWithFileFunc) (stats ArchiveStats, err error) {
-	stats, err = scanDirectory(srcDir)
+	return ZipWithOptions(srcDir, zipPath, CreateOptions{Progress: progress})
+}
+
+// ZipWithOptions creates a zip archive using the supplied options. srcDir
+// may also be a single file, which is archived under its own name.
+func ZipWithOptions(srcDir, zipPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	log := logCreate(&opts, "create", zipPath)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+
+	// Collect all files first
+	skips := newSkipList(opts)
+	files, err := collectRoot(longPath(srcDir), opts, skips)
 	if err != nil {
 		return stats, err
 	}
+	return zipFiles(files, zipPath, opts, skips)
+}
 
-	zipFile, err := os.Create(zipPath)
+// ZipSourcesWithOptions creates a zip archive of several files and folders,
+// each stored under its own name at the top level of the archive.
+func ZipSourcesWithOptions(sources []string, zipPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	log := logCreate(&opts, "create", zipPath)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+
+	skips := newSkipList(opts)
+	files, err := collectSources(sources, opts, skips)
 	if err != nil {
 		return stats, err
 	}
+	return zipFiles(files, zipPath, opts, skips)
+}
 
-	writer := zip.NewWriter(zipFile)
-	// Register custom compressor with optimal level based on total size
-	compressionLevel := getOptimalCompressionLevel(stats.TotalBytes)
-	writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
-		return flate.NewWriter(out, compressionLevel)
-	})
-
-	done := int64(0)
-	var doneMutex sync.Mutex
-	currentFile := ""
-	var currentFileMutex sync.Mutex
+// zipFiles writes the collected files to a new zip archive at zipPath.
+func zipFiles(files []fileJob, zipPath string, opts CreateOptions, skips *skipList) (stats ArchiveStats, err error) {
+	zipPath = longPath(zipPath)
+	stats = sourceStats(files)
 
-	callProgress := func() {
-		if progress != nil {
-			doneMutex.Lock()
-			currentFileMutex.Lock()
-			progress(done, stats.TotalBytes, currentFile)
-			currentFileMutex.Unlock()
-			doneMutex.Unlock()
-		}
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return stats, err
 	}
-	callProgress()
 
-	// Collect all files first
-	var files []fileJob
-	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-
-		rel, err := filepath.Rel(srcDir, path)
-		if err != nil {
-			return err
-		}
-
-		if rel == "." {
-			return nil
-		}
-
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
-
-		files = append(files, fileJob{
-			path:  path,
-			rel:   rel,
-			info:  info,
-			isDir: d.IsDir(),
-		})
-		return nil
-	})
-	if err != nil {
+	writer := zip.NewWriter(zipFile)
+	if err := writeZipArchive(writer, files, &stats, filepath.Dir(zipPath), opts, skips); err != nil {
+		zipFile.Close()
+		os.Remove(zipPath)
 		return stats, err
 	}
 
-	// Process files with worker pool for reading
-	workerCount := getWorkerCount()
-	type fileData struct {
-		job  fileJob
-		data []byte
-		err  error
+	stats.Checksum, err = finishZip(writer, zipFile, opts.Comment)
+	if info, err := os.Stat(zipPath); err == nil {
+		stats.ArchiveSize = info.Size()
 	}
+	return stats, err
+}
 
-	dataChan := make(chan fileData, workerCount)
-	var wg sync.WaitGroup
+// writeZipArchive writes the collected files, and the manifest when one is
+// requested, to writer, leaving it to the caller to close. stats receives
+// the skipped files and warnings.
+func writeZipArchive(writer *zip.Writer, files []fileJob, stats *ArchiveStats, spillDir string, opts CreateOptions, skips *skipList) error {
+	var digests *manifest
+	if opts.Manifest {
+		digests = newManifest()
+	}
 
-	// Start workers to read files in parallel
-	jobChan := make(chan fileJob, len(files))
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for job := range jobChan {
-				if job.isDir {
-					dataChan <- fileData{job: job}
-					continue
-				}
+	if err := writeZipFiles(writer, files, stats, spillDir, opts, skips, digests); err != nil {
+		return err
+	}
+	skips.apply(stats)
 
-				data, err := os.ReadFile(job.path)
-				if err != nil {
-					// Skip inaccessible files instead of failing
-					fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", job.path, err)
-					continue
-				}
-				dataChan <- fileData{
-					job:  job,
-					data: data,
-					err:  nil,
-				}
-			}
-		}()
+	if digests != nil {
+		return digests.writeZip(writer, entryTime(opts))
 	}
+	return nil
+}
 
-	// Send jobs to workers
-	go func() {
-		for _, file := range files {
-			jobChan <- file
-		}
-		close(jobChan)
-	}()
+// writeZipFiles compresses files in parallel within the memory ceiling and
+// appends them to writer, reporting progress against stats.TotalBytes.
+// Large outputs spill to temporary files in spillDir. Files that cannot be
+// read are passed to skips. The digest of each file is added to digests when
+// it is not nil. Under opts.Dedupe, files with the same content as an
+// earlier one are counted in stats.
+func writeZipFiles(writer *zip.Writer, files []fileJob, stats *ArchiveStats, spillDir string, opts CreateOptions, skips *skipList, digests *manifest) error {
+	// Entries are compressed by the workers at the requested level, or the
+	// optimal level for the total size, and appended raw
+	level, err := compressionLevel(opts, stats.TotalBytes)
+	if err != nil {
+		return err
+	}
 
-	// Close data channel when all workers finish
-	go func() {
-		wg.Wait()
-		close(dataChan)
-	}()
+	tracker := newProgressTracker(opts.Progress, opts.ProgressEvents, opts.ProgressInterval, stats.TotalBytes, sourceStats(files).FileCount)
+	tracker.update()
 
-	// Write to zip sequentially (required by zip format)
-	processedCount := 0
-	for fd := range dataChan {
+	ctx := optionsContext(opts.Context)
+	var duplicates *duplicateSet
+	if opts.Dedupe {
+		if files, duplicates, err = findDuplicates(ctx, files, WorkerCount(opts.Workers)); err != nil {
+			return err
+		}
+	}
+	rate := newRateLimiter(ctx, opts.RateLimit)
+	loader := zipLoader(ctx, level, spillDir, opts.BufferSize, digests != nil, rate, tracker)
+	pipeline := startReadPipeline(files, WorkerCount(opts.Workers), opts.MaxMemory, opts.Reproducible, loader)
+	defer pipeline.stop()
+
+	// Append to zip sequentially (required by zip format)
+	for fd := range pipeline.out {
+		pause(ctx, opts.EntryPause)
+		if err := ctx.Err(); err != nil {
+			pipeline.release(fd)
+			return err
+		}
+		if fd.err != nil {
+			pipeline.release(fd)
+			if err := skipFile(tracker, skips, fd); err != nil {
+				return err
+			}
+			if err := skipCopies(tracker, skips, duplicates.copiesOf(fd.job), fd.err); err != nil {
+				return err
+			}
+			continue
+		}
 
 		header, err := zip.FileInfoHeader(fd.job.info)
 		if err != nil {
-			return stats, err
+			pipeline.release(fd)
+			return err
 		}
 
 		header.Name = filepath.ToSlash(fd.job.rel)
+		header.ExternalAttrs |= fileAttributes(fd.job.info)
+		normalizeZipHeader(header, opts)
 		if fd.job.isDir {
 			header.Name += "/"
-		} else {
-			header.Method = getCompressionMethod(fd.job.path)
+			if _, err := writer.CreateHeader(header); err != nil {
+				return err
+			}
+			if opts.OnEntry != nil {
+				tracker.locked(func() { opts.OnEntry(EntryEvent{Name: header.Name, IsDir: true}) })
+			}
+			continue
 		}
 
-		writerEntry, err := writer.CreateHeader(header)
+		header.Method = fd.method
+		header.CRC32 = fd.crc32
+		header.UncompressedSize64 = uint64(fd.rawSize)
+		header.CompressedSize64 = uint64(fd.compressedSize)
+		prepareRawHeader(header)
+
+		writerEntry, err := writer.CreateRaw(header)
+		if err == nil {
+			_, err = io.Copy(writerEntry, fd.compressedReader())
+		}
 		if err != nil {
-			return stats, err
+			pipeline.release(fd)
+			return err
 		}
-
-		if !fd.job.isDir {
-			_, err = writerEntry.Write(fd.data)
-			if err != nil {
-				return stats, err
-			}
-
-			doneMutex.Lock()
-			done += int64(len(fd.data))
-			doneMutex.Unlock()
-
-			currentFileMutex.Lock()
-			currentFile = fd.job.rel
-			currentFileMutex.Unlock()
-
-			if progress != nil {
-				callProgress()
-			}
+		if digests != nil {
+			digests.add(header.Name, fd.sha256)
 		}
+		if opts.OnEntry != nil {
+			tracker.locked(func() {
+				opts.OnEntry(EntryEvent{Name: header.Name, Size: fd.rawSize, CompressedSize: fd.compressedSize, Method: fd.method})
+			})
+		}
+		tracker.fileDone(header.Name)
 
-		processedCount++
+		err = writeZipCopies(writer, fd, duplicates.copiesOf(fd.job), stats, tracker, opts, digests)
+		pipeline.release(fd)
+		if err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	callProgress()
+	tracker.update()
+	return nil
+}
+
+// skipFile passes a file that failed to load to skips, between progress
+// reports, and takes it off the files in flight.
+func skipFile(tracker *progressTracker, skips *skipList, fd fileData) (err error) {
+	tracker.finish(filepath.ToSlash(fd.job.rel))
+	tracker.locked(func() { err = skips.skipFile(fd.job, fd.err) })
+	return err
+}
 
+// finishZip closes the archive and stores comment and its SHA-256 checksum
+// in the zip comment, returning the checksum.
+func finishZip(writer *zip.Writer, zipFile *os.File, comment string) (string, error) {
 	// Close writer and file explicitly before calculating checksum
 	if err := writer.Close(); err != nil {
-		return stats, err
+		return "", err
 	}
 	if err := zipFile.Close(); err != nil {
-		return stats, err
+		return "", err
 	}
 
 	// Calculate checksum of the created archive
-	stats.Checksum, err = calculateFileChecksum(zipPath)
+	checksum, err := calculateFileChecksum(zipFile.Name())
 	if err != nil {
-		return stats, fmt.Errorf("checksum calculation failed: %w", err)
+		return "", fmt.Errorf("checksum calculation failed: %w", err)
 	}
 
 	// Store checksum in zip comment
-	if err := addChecksumToZip(zipPath, stats.Checksum); err != nil {
-		return stats, fmt.Errorf("failed to add checksum: %w", err)
+	if err := addChecksumToZip(zipFile.Name(), checksum, comment); err != nil {
+		return "", fmt.Errorf("failed to add checksum: %w", err)
 	}
 
-	return stats, nil
+	return checksum, nil
+}
+
+// copyStreamed copies exactly n bytes of a streamed file to w, reporting
+// bytes as they are read at the pace rate allows.
+func copyStreamed(ctx context.Context, w io.Writer, fd fileData, n int64, rate *rateLimiter, bufferSize int, onRead func(int64)) error {
+	rc, err := fd.open(rate)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	r := &countingReader{r: contextReader{ctx: ctx, r: io.LimitReader(rc, n)}, onRead: onRead}
+	copied, err := copyBuffered(w, r, bufferSize)
+	if err == nil && copied < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
 }
 
-func scanDirectory(root string) (ArchiveStats, error) {
+// sourceStats returns the number and total size of the files in jobs.
+func sourceStats(jobs []fileJob) ArchiveStats {
 	stats := ArchiveStats{}
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
+	for _, job := range jobs {
+		if !job.isDir {
+			stats.TotalBytes += job.info.Size()
+			stats.FileCount++
 		}
-		if d.IsDir() {
-			return nil
-		}
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
-		stats.TotalBytes += info.Size()
-		stats.FileCount++
-		return nil
-	})
-	return stats, err
+	}
+	return stats
 }
 
 // ExtractStats describes the data extracted from an archive.
 type ExtractStats struct {
-	TotalBytes int64
-	FileCount  int
+	TotalBytes int64 `json:"total_bytes"`
+	FileCount  int   `json:"file_count"`
+	// CorruptFiles lists zip entries whose data failed its CRC-32 check.
+	CorruptFiles []string `json:"corrupt_files,omitempty"`
+	// Skipped and Overwritten count files that already existed and were
+	// kept or replaced according to ExtractOptions.Overwrite.
+	Skipped     int `json:"skipped,omitempty"`
+	Overwritten int `json:"overwritten,omitempty"`
+	// Resumed counts files left in place under ExtractOptions.Resume
+	// because an earlier run had already extracted them.
+	Resumed int `json:"resumed,omitempty"`
+	// Renamed maps entry names to the paths they were written to instead
+	// of existing files under OverwriteRename.
+	Renamed map[string]string `json:"renamed,omitempty"`
+	// NameEncoding names the legacy code page entry names not marked as
+	// UTF-8 were decoded from, if any were.
+	NameEncoding string `json:"name_encoding,omitempty"`
+	// Nested counts the archives extracted under ExtractOptions.Recursive,
+	// whose files are included in the counts above.
+	Nested int `json:"nested,omitempty"`
+	// Warnings lists problems that did not stop extraction, such as links
+	// that were not extracted or times that could not be restored.
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// ExtractOptions configures how an archive is extracted.
+type ExtractOptions struct {
+	// Context cancels extraction when done; nil never cancels.
+	Context context.Context
+	// Progress receives extraction progress: uncompressed bytes written for
+	// zip archives, and compressed bytes read for tar.gz archives, which are
+	// extracted in a single pass without sizing their contents first.
+	Progress ProgressFunc
+	// ProgressEvents receives the same progress with file counts and the
+	// progress of the current file.
+	ProgressEvents ProgressEventFunc
+	// ProgressInterval is the least time between progress reports, which
+	// would otherwise follow every buffer read. Zero uses
+	// DefaultProgressInterval and a negative value reports every change.
+	// The first report and the one at 100% are always made.
+	ProgressInterval time.Duration
+	// OnEntry is called after each file is extracted and each directory
+	// created. Skipped files are not reported.
+	OnEntry EntryFunc
+	// Logger receives the entries, warnings and outcome of extraction as
+	// for CreateOptions.
+	Logger *slog.Logger
+	// Workers is the number of zip entries extracted in parallel; tar.gz
+	// archives are extracted in a single pass. Zero uses WorkerCount's
+	// default.
+	Workers int
+	// SkipHidden and SkipJunk leave out entries as for CreateOptions,
+	// along with the contents of folders that are left out. Hidden entries
+	// are those whose names start with a dot or with the hidden attribute.
+	SkipHidden bool
+	SkipJunk   bool
+	// SkipTimes leaves extracted files with the current time instead of
+	// restoring the modification and access times stored in the archive.
+	SkipTimes bool
+	// BufferSize is the size of the buffers used to write extracted files.
+	// Zero uses DefaultBufferSize.
+	BufferSize int
+	// RateLimit caps the bytes per second of extracted data written, in
+	// total across workers, and of archives ExtractFromURL downloads before
+	// extracting. Zero is unlimited.
+	RateLimit int64
+	// EntryPause is a pause made before each entry is extracted, as in
+	// CreateOptions. Zero does not pause.
+	EntryPause time.Duration
+	// MaxRatio is the largest compression ratio (uncompressed:compressed)
+	// allowed for an entry or the archive as a whole before extraction is
+	// aborted as a decompression bomb. Zero uses DefaultMaxRatio; a negative
+	// value disables the check.
+	MaxRatio float64
+
+	// Resource limits for extracting untrusted archives. Exceeding any of
+	// them aborts extraction with a *LimitError. Zero means unlimited.
+	MaxTotalBytes int64 // total uncompressed bytes of all files
+	MaxEntries    int   // number of entries, including directories
+	MaxFileSize   int64 // uncompressed size of any single file
+	MaxPathDepth  int   // number of path components in an entry name
+
+	// KeepCorrupt leaves files whose data fails its CRC-32 check on disk
+	// instead of removing them. Either way they are listed in
+	// ExtractStats.CorruptFiles and reported with a *ChecksumError.
+	KeepCorrupt bool
+
+	// Flatten extracts every file directly into the destination, dropping
+	// the folders of the archive. Files that end up with the same name are
+	// handled by FlattenCollisions; files already in the destination by
+	// Overwrite.
+	Flatten bool
+	// FlattenCollisions decides what happens when Flatten gives two files
+	// the same name. Zip archives are checked before anything is written;
+	// tar.gz archives are extracted in one pass, so under CollisionFail the
+	// files before the collision have already been written.
+	FlattenCollisions CollisionPolicy
+
+	// NameEncoding is the code page zip entry names without the UTF-8 flag
+	// are decoded from. The default detects it; names that are valid UTF-8
+	// are kept as they are.
+	NameEncoding NameEncoding
+
+	// Recursive extracts the zip and tar.gz archives among the extracted
+	// files into folders named after them, which replace them, and so on
+	// for archives inside those, up to this many levels deep. The limits
+	// above apply to all levels together, and what nested archives expand
+	// to counts towards the compression ratio of the archive holding them.
+	// Files with an archive's name that are not archives are kept, with a
+	// warning. It cannot be combined with Flatten, and is not planned by
+	// PlanExtract. Zero extracts the archive alone.
+	Recursive int
+
+	// Resume continues an extraction that was interrupted, leaving files
+	// that are already complete as they are: zip entries with the same
+	// size and CRC-32, and tar.gz entries with the same size and
+	// modification time. Other existing files go through Overwrite.
+	Resume bool
+
+	// Overwrite decides what happens to files that already exist in the
+	// destination. The default replaces them.
+	Overwrite OverwritePolicy
+	// ConfirmOverwrite is asked whether to replace path when Overwrite is
+	// OverwritePrompt; a nil func keeps every existing file. Calls are made
+	// one at a time.
+	ConfirmOverwrite func(path string) bool
 }
 
 // Extract extracts a zip archive to the destination directory.
@@ -372,39 +637,95 @@ func Extract(zipPath, destDir string) error {
 
 // ExtractWithProgress extracts a zip archive and reports progress via callback.
 func ExtractWithProgress(zipPath, destDir string, progress ProgressFunc) (stats ExtractStats, err error) {
-	reader, err := zip.OpenReader(zipPath)
+	return ExtractWithOptions(zipPath, destDir, ExtractOptions{Progress: progress})
+}
+
+// ExtractWithOptions extracts a zip archive using the supplied options.
+func ExtractWithOptions(zipPath, destDir string, opts ExtractOptions) (stats ExtractStats, err error) {
+	log := logExtract(&opts, "extract", zipPath, destDir)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+
+	zipPath, destDir = longPath(zipPath), longPath(destDir)
+	archive, err := openArchive(zipPath)
+	if err != nil {
+		return stats, err
+	}
+	defer archive.Close()
+	return extractZip(archive, filepath.Base(zipPath), destDir, opts)
+}
+
+// extractZip extracts the zip archive read from archive, called name in
+// errors, to destDir.
+func extractZip(archive archiveReader, name, destDir string, opts ExtractOptions) (stats ExtractStats, err error) {
+	if opts.Recursive > 0 {
+		return extractRecursive(name, archive.Size(), destDir, opts, func(opts ExtractOptions) (ExtractStats, error) {
+			return extractZip(archive, name, destDir, opts)
+		})
+	}
+	reader, err := zip.NewReader(archive, archive.Size())
 	if err != nil {
 		return stats, err
 	}
-	defer reader.Close()
+	if enc := decodeZipNames(reader.File, opts.NameEncoding); enc != NameEncodingUTF8 {
+		stats.NameEncoding = enc.String()
+	}
 
-	// Calculate total size
+	files := reader.File
+	if filter := extractFilter(opts); filter.active() {
+		files = nil
+		for _, f := range reader.File {
+			if !filter.skipEntry(f.Name, f.ExternalAttrs) {
+				files = append(files, f)
+			}
+		}
+	}
+
+	// Calculate total size, rejecting entries whose declared sizes point to
+	// a decompression bomb before anything is written
+	maxRatio := maxRatioOrDefault(opts.MaxRatio)
+	limits := &extractLimits{opts: opts}
 	totalBytes := int64(0)
 	fileCount := 0
-	for _, f := range reader.File {
+	warnings := &warningList{}
+	for _, f := range files {
+		// Declared sizes are enforced while copying, so they can be
+		// checked against the limits up front
+		if err := limits.check(f.Name, int64(f.UncompressedSize64), f.FileInfo().IsDir()); err != nil {
+			return stats, err
+		}
+		if isZipSymlink(f) {
+			warnings.add(WarningSymlinkSkipped, f.Name, "symbolic link not extracted")
+			continue
+		}
 		if !f.FileInfo().IsDir() {
+			if err := checkZipEntryRatio(f, maxRatio); err != nil {
+				return stats, err
+			}
 			totalBytes += int64(f.UncompressedSize64)
 			fileCount++
 		}
 	}
+	if err := checkRatio(name, totalBytes, archive.Size(), maxRatio); err != nil {
+		return stats, err
+	}
+
+	var flatNames map[string]string
+	if opts.Flatten {
+		if flatNames, err = flattenZipNames(files, opts.FlattenCollisions, warnings); err != nil {
+			return stats, err
+		}
+	}
 
 	stats.TotalBytes = totalBytes
 	stats.FileCount = fileCount
 
-	done := int64(0)
-	var doneMutex sync.Mutex
-	callProgress := func() {
-		if progress != nil {
-			doneMutex.Lock()
-			progress(done, totalBytes)
-			doneMutex.Unlock()
-		}
-	}
-	callProgress()
+	tracker := newProgressTracker(withoutFile(opts.Progress), opts.ProgressEvents, opts.ProgressInterval, totalBytes, fileCount)
+	tracker.update()
 
 	// Create directories first
-	for _, f := range reader.File {
-		if f.FileInfo().IsDir() {
+	var dirs []dirTimes
+	for _, f := range files {
+		if f.FileInfo().IsDir() && !opts.Flatten {
 			destPath := filepath.Join(destDir, filepath.FromSlash(f.Name))
 			if !filepath.IsLocal(f.Name) {
 				return stats, fmt.Errorf("invalid file path: %s", f.Name)
@@ -412,102 +733,179 @@ func ExtractWithProgress(zipPath, destDir string, progress ProgressFunc) (stats
 			if err := os.MkdirAll(destPath, f.Mode()); err != nil {
 				return stats, err
 			}
+			dirs = append(dirs, dirTimes{path: destPath, name: f.Name, mtime: f.Modified, attrs: f.ExternalAttrs})
+			if opts.OnEntry != nil {
+				opts.OnEntry(EntryEvent{Name: f.Name, IsDir: true})
+			}
 		}
 	}
 
 	// Extract files in parallel
-	workerCount := getWorkerCount()
+	ctx := optionsContext(opts.Context)
+	rate := newRateLimiter(ctx, opts.RateLimit)
+	workerCount := WorkerCount(opts.Workers)
 	type extractJob struct {
 		file     *zip.File
 		destPath string
 	}
 
-	jobChan := make(chan extractJob, len(reader.File))
+	jobChan := make(chan extractJob, len(files))
 	errChan := make(chan error, 1)
 	var wg sync.WaitGroup
 
+	// fail keeps the first error and tells the sender to queue no more jobs
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	fail := func(err error) {
+		select {
+		case errChan <- err:
+		default:
+		}
+		stopOnce.Do(func() { close(stop) })
+	}
+
 	// Start workers
 	for i := 0; i < workerCount; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for job := range jobChan {
+				pause(ctx, opts.EntryPause)
 				rc, err := job.file.Open()
 				if err != nil {
-					select {
-					case errChan <- err:
-					default:
-					}
+					fail(err)
 					return
 				}
 
 				outFile, err := os.OpenFile(job.destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, job.file.Mode())
 				if err != nil {
 					rc.Close()
-					select {
-					case errChan <- err:
-					default:
-					}
+					fail(err)
 					return
 				}
 
-				written, err := io.Copy(outFile, rc)
+				counted := &countingReader{r: contextReader{ctx: ctx, r: rate.reader(rc)}, onRead: func(n int64) {
+					tracker.read(job.file.Name, int64(job.file.UncompressedSize64), n)
+				}}
+				src := &declaredSizeReader{r: counted, name: job.file.Name, limit: job.file.UncompressedSize64}
+				written, err := copyBuffered(outFile, src, opts.BufferSize)
 				rc.Close()
 				outFile.Close()
 
-				if err != nil {
-					select {
-					case errChan <- err:
-					default:
+				removed := false
+				if err != nil && isCorruptData(err) {
+					// Record the damaged entry and carry on with the rest
+					tracker.locked(func() { stats.CorruptFiles = append(stats.CorruptFiles, job.file.Name) })
+					err = nil
+					if !opts.KeepCorrupt {
+						err = os.Remove(job.destPath)
+						removed = true
 					}
+				}
+				if err != nil {
+					tracker.finish(job.file.Name)
+					fail(err)
 					return
 				}
 
-				doneMutex.Lock()
-				done += written
-				doneMutex.Unlock()
+				if !removed {
+					if !opts.SkipTimes {
+						restoreTimes(job.destPath, job.file.Name, job.file.Modified, time.Time{}, warnings)
+					}
+					restoreAttributes(job.destPath, job.file.Name, job.file.ExternalAttrs, warnings)
+				}
 
-				if progress != nil {
-					callProgress()
+				if opts.OnEntry != nil && !removed {
+					tracker.locked(func() {
+						opts.OnEntry(EntryEvent{
+							Name:           job.file.Name,
+							Size:           written,
+							CompressedSize: int64(job.file.CompressedSize64),
+							Method:         job.file.Method,
+						})
+					})
 				}
+				tracker.fileDone(job.file.Name)
 			}
 		}()
 	}
 
-	// Send jobs
+	// Send jobs, resolving conflicts with existing files in archive order
+	resolver := &conflictResolver{policy: opts.Overwrite, confirm: opts.ConfirmOverwrite, warnings: warnings}
+	skippedBytes := int64(0)
+	resumed, dropped := 0, 0
+	senderDone := make(chan struct{})
 	go func() {
-		for _, f := range reader.File {
-			if f.FileInfo().IsDir() {
+		defer close(senderDone)
+		defer close(jobChan)
+		for _, f := range files {
+			if f.FileInfo().IsDir() || isZipSymlink(f) {
 				continue
 			}
 
-			destPath := filepath.Join(destDir, filepath.FromSlash(f.Name))
+			if err := ctx.Err(); err != nil {
+				fail(err)
+				break
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
 
-			// Security check: prevent path traversal
-			if !filepath.IsLocal(f.Name) {
-				select {
-				case errChan <- fmt.Errorf("invalid file path: %s", f.Name):
-				default:
+			entryName := f.Name
+			if opts.Flatten {
+				if entryName = flatNames[f.Name]; entryName == "" {
+					// Replaced by a later file with the same name
+					dropped++
+					skippedBytes += int64(f.UncompressedSize64)
+					tracker.advance(int64(f.UncompressedSize64))
+					tracker.fileDone(f.Name)
+					continue
 				}
+			}
+			destPath := filepath.Join(destDir, filepath.FromSlash(entryName))
+
+			// Security check: prevent path traversal
+			if !filepath.IsLocal(entryName) {
+				fail(fmt.Errorf("invalid file path: %s", f.Name))
 				break
 			}
 
+			if opts.Resume && zipEntryDone(destPath, f) {
+				resumed++
+				skippedBytes += int64(f.UncompressedSize64)
+				tracker.advance(int64(f.UncompressedSize64))
+				tracker.fileDone(f.Name)
+				continue
+			}
+
 			// Ensure parent directory exists
 			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-				select {
-				case errChan <- err:
-				default:
-				}
+				fail(err)
+				break
+			}
+
+			destPath, write, err := resolver.resolve(f.Name, destPath, f.Modified)
+			if err != nil {
+				fail(err)
 				break
 			}
+			if !write {
+				skippedBytes += int64(f.UncompressedSize64)
+				tracker.advance(int64(f.UncompressedSize64))
+				tracker.fileDone(f.Name)
+				continue
+			}
 
 			jobChan <- extractJob{file: f, destPath: destPath}
 		}
-		close(jobChan)
 	}()
 
-	// Wait for completion
+	// Wait for completion. The sender's counts are read only once it has
+	// finished too, and errChan is closed after its last send
 	wg.Wait()
+	<-senderDone
 	close(errChan)
 
 	// Check for errors
@@ -515,24 +913,32 @@ func ExtractWithProgress(zipPath, destDir string, progress ProgressFunc) (stats
 		return stats, err
 	}
 
-	callProgress()
-	return stats, nil
-}
+	stats.TotalBytes -= skippedBytes
+	stats.FileCount -= resolver.skipped + resumed + dropped
+	stats.Skipped, stats.Overwritten, stats.Renamed = resolver.skipped, resolver.overwritten, resolver.renamed
+	stats.Resumed = resumed
 
-type progressReader struct {
-	r        io.Reader
-	done     *int64
-	total    int64
-	progress ProgressFunc
-}
+	// Directory times are applied last since writing files updates them
+	if !opts.SkipTimes {
+		restoreDirTimes(dirs, warnings)
+	}
+	for _, d := range dirs {
+		restoreAttributes(d.path, d.name, d.attrs, warnings)
+	}
+	stats.Warnings = warnings.list()
 
-func (pr *progressReader) Read(p []byte) (int, error) {
-	n, err := pr.r.Read(p)
-	if pr.progress != nil && n > 0 {
-		*pr.done += int64(n)
-		pr.progress(*pr.done, pr.total)
+	tracker.update()
+	if len(stats.CorruptFiles) > 0 {
+		sort.Strings(stats.CorruptFiles)
+		return stats, &ChecksumError{Archive: name, Entries: stats.CorruptFiles}
 	}
-	return n, err
+	return stats, nil
+}
+
+// isZipSymlink reports whether f is a symbolic link, whose data is the link
+// target rather than file contents.
+func isZipSymlink(f *zip.File) bool {
+	return f.Mode()&fs.ModeSymlink != 0
 }
 
 // Gzip creates a tar.gz archive of the source directory
@@ -552,185 +958,272 @@ func GzipWithProgress(srcDir, gzipPath string, progress ProgressFunc) (stats Arc
 
 // GzipWithProgressAndFile creates a tar.gz archive and reports progress with current file information
 func GzipWithProgressAndFile(srcDir, gzipPath string, progress ProgressWithFileFunc) (stats ArchiveStats, err error) {
-	stats, err = scanDirectory(srcDir)
+	return GzipWithOptions(srcDir, gzipPath, CreateOptions{Progress: progress})
+}
+
+// GzipWithOptions creates a tar.gz archive using the supplied options.
+// srcDir may also be a single file, which is archived under its own name.
+func GzipWithOptions(srcDir, gzipPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	log := logCreate(&opts, "create", gzipPath)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+
+	// Collect all files first
+	skips := newSkipList(opts)
+	files, err := collectRoot(longPath(srcDir), opts, skips)
+	if err != nil {
+		return stats, err
+	}
+	return gzipFiles(files, gzipPath, opts, skips)
+}
+
+// GzipSourcesWithOptions creates a tar.gz archive of several files and
+// folders, each stored under its own name at the top level of the archive.
+func GzipSourcesWithOptions(sources []string, gzipPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	log := logCreate(&opts, "create", gzipPath)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
+
+	skips := newSkipList(opts)
+	files, err := collectSources(sources, opts, skips)
 	if err != nil {
 		return stats, err
 	}
+	return gzipFiles(files, gzipPath, opts, skips)
+}
+
+// gzipFiles writes the collected files to a new tar.gz archive at gzipPath.
+// The archive is written to a temporary file renamed over gzipPath once
+// complete, so a failure leaves an earlier archive at gzipPath in place.
+func gzipFiles(files []fileJob, gzipPath string, opts CreateOptions, skips *skipList) (stats ArchiveStats, err error) {
+	gzipPath = longPath(gzipPath)
+	stats = sourceStats(files)
 
-	gzipFile, err := os.Create(gzipPath)
+	// Use the requested or optimal compression level, compressing blocks of
+	// the stream in parallel when more than one worker is available
+	level, err := compressionLevel(opts, stats.TotalBytes)
 	if err != nil {
 		return stats, err
 	}
 
-	// Use optimal compression level based on total size
-	compressionLevel := getOptimalCompressionLevel(stats.TotalBytes)
-	gzWriter, err := gzip.NewWriterLevel(gzipFile, compressionLevel)
+	gzipFile, err := tempFileFor(gzipPath)
 	if err != nil {
 		return stats, err
 	}
+	tempPath := gzipFile.Name()
 
-	tarWriter := tar.NewWriter(gzWriter)
+	err = writeGzipArchive(gzipFile, files, &stats, level, opts, skips)
+	if closeErr := gzipFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, gzipPath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return stats, err
+	}
 
-	done := int64(0)
-	var doneMutex sync.Mutex
-	currentFile := ""
-	var currentFileMutex sync.Mutex
+	// Calculate checksum of the created archive
+	stats.Checksum, err = calculateFileChecksum(gzipPath)
+	if err != nil {
+		return stats, fmt.Errorf("checksum calculation failed: %w", err)
+	}
+	if info, err := os.Stat(gzipPath); err == nil {
+		stats.ArchiveSize = info.Size()
+	}
 
-	callProgress := func() {
-		if progress != nil {
-			doneMutex.Lock()
-			currentFileMutex.Lock()
-			progress(done, stats.TotalBytes, currentFile)
-			currentFileMutex.Unlock()
-			doneMutex.Unlock()
-		}
+	// Store checksum in a separate .sha256 file
+	if err := writeChecksumFile(gzipPath, stats.Checksum); err != nil {
+		return stats, fmt.Errorf("failed to write checksum file: %w", err)
 	}
-	callProgress()
 
-	// Collect all files first
-	var files []fileJob
-	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
+	return stats, nil
+}
 
-		rel, err := filepath.Rel(srcDir, path)
+// writeGzipArchive writes the collected files, and the manifest when one is
+// requested, to w as a tar.gz stream compressed at level. stats receives
+// the skipped files and warnings.
+func writeGzipArchive(w io.Writer, files []fileJob, stats *ArchiveStats, level int, opts CreateOptions, skips *skipList) (err error) {
+	workerCount := WorkerCount(opts.Workers)
+	tracker := newProgressTracker(opts.Progress, opts.ProgressEvents, opts.ProgressInterval, stats.TotalBytes, stats.FileCount)
+	w = outputWriter{w: w, tracker: tracker}
+
+	var gzWriter io.WriteCloser
+	// The parallel writer's blocks do not depend on the worker count, so
+	// reproducible archives always use it
+	if workerCount > 1 || opts.Reproducible {
+		gzWriter, err = newParallelGzipWriter(w, level, workerCount)
+	} else {
+		gzWriter, err = gzip.NewWriterLevel(w, level)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
 		if err != nil {
-			return err
+			abortGzip(gzWriter)
 		}
+	}()
 
-		if rel == "." {
-			return nil
-		}
+	tarWriter := tar.NewWriter(gzWriter)
+	tracker.update()
 
-		info, err := d.Info()
-		if err != nil {
+	ctx := optionsContext(opts.Context)
+	var duplicates *duplicateSet
+	if opts.Dedupe {
+		if files, duplicates, err = findDuplicates(ctx, files, workerCount); err != nil {
 			return err
 		}
-
-		files = append(files, fileJob{
-			path:  path,
-			rel:   rel,
-			info:  info,
-			isDir: d.IsDir(),
-		})
-		return nil
-	})
-	if err != nil {
-		return stats, err
 	}
 
-	// Process files with worker pool for reading
-	workerCount := getWorkerCount()
-	type fileData struct {
-		job  fileJob
-		data []byte
-		err  error
-	}
+	// Read files in parallel within the memory ceiling
+	rate := newRateLimiter(ctx, opts.RateLimit)
+	pipeline := startReadPipeline(files, workerCount, opts.MaxMemory, opts.Reproducible, loadFile(rate, opts.TarFormat.sparse()))
+	defer pipeline.stop()
 
-	dataChan := make(chan fileData, workerCount)
-	var wg sync.WaitGroup
-
-	// Start workers to read files in parallel
-	jobChan := make(chan fileJob, len(files))
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for job := range jobChan {
-				if job.isDir {
-					dataChan <- fileData{job: job}
-					continue
-				}
-
-				data, err := os.ReadFile(job.path)
-				if err != nil {
-					// Skip inaccessible files instead of failing
-					fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", job.path, err)
-					continue
-				}
-				dataChan <- fileData{
-					job:  job,
-					data: data,
-					err:  nil,
-				}
-			}
-		}()
+	var digests *manifest
+	if opts.Manifest {
+		digests = newManifest()
 	}
 
-	// Send jobs to workers
-	go func() {
-		for _, file := range files {
-			jobChan <- file
-		}
-		close(jobChan)
-	}()
-
-	// Close data channel when all workers finish
-	go func() {
-		wg.Wait()
-		close(dataChan)
-	}()
-
 	// Write to tar sequentially (required by tar format)
-	for fd := range dataChan {
+	for fd := range pipeline.out {
+		pause(ctx, opts.EntryPause)
+		if err := ctx.Err(); err != nil {
+			pipeline.release(fd)
+			return err
+		}
+		if fd.err != nil {
+			pipeline.release(fd)
+			if err := skipFile(tracker, skips, fd); err != nil {
+				return err
+			}
+			if err := skipCopies(tracker, skips, duplicates.copiesOf(fd.job), fd.err); err != nil {
+				return err
+			}
+			continue
+		}
 
 		header, err := tar.FileInfoHeader(fd.job.info, "")
 		if err != nil {
-			return stats, err
+			return err
 		}
 
 		header.Name = filepath.ToSlash(fd.job.rel)
-
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return stats, err
+		normalizeTarHeader(header, opts)
+		addDone := func(n int64) {
+			tracker.read(header.Name, header.Size, n)
+		}
+		// Files with the same content follow as hard links to this one
+		writeLinks := func() error {
+			copies := duplicates.copiesOf(fd.job)
+			if len(copies) == 0 {
+				return nil
+			}
+			return writeTarLinks(tarWriter, header.Name, copies, duplicates.sums[fd.job.rel], stats, tracker, opts, digests)
 		}
 
-		if !fd.job.isDir {
-			_, err = tarWriter.Write(fd.data)
+		if fd.regions != nil {
+			// Sparse file: only the data regions are stored
+			var data io.ReadCloser = io.NopCloser(bytes.NewReader(fd.data))
+			if fd.streamed {
+				if data, err = fd.open(rate); err != nil {
+					return err
+				}
+			}
+			err := writeSparseEntry(tarWriter, gzWriter, header, fd.regions, data)
+			data.Close()
+			pipeline.release(fd)
+			if err == nil && digests != nil {
+				// Holes must be hashed as zeros, so read the file as a whole
+				var sum []byte
+				if sum, err = sumFile(fd.job.path); err == nil {
+					digests.add(header.Name, sum)
+				}
+			}
 			if err != nil {
-				return stats, err
+				return err
 			}
+			addDone(header.Size)
+			tracker.fileDone(header.Name)
+			if opts.OnEntry != nil {
+				opts.OnEntry(EntryEvent{Name: header.Name, Size: header.Size, CompressedSize: -1})
+			}
+			if err := writeLinks(); err != nil {
+				return err
+			}
+			continue
+		}
 
-			doneMutex.Lock()
-			done += int64(len(fd.data))
-			doneMutex.Unlock()
-
-			currentFileMutex.Lock()
-			currentFile = fd.job.rel
-			currentFileMutex.Unlock()
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
 
-			if progress != nil {
-				callProgress()
+		if fd.job.isDir {
+			if opts.OnEntry != nil {
+				opts.OnEntry(EntryEvent{Name: header.Name, IsDir: true, CompressedSize: -1})
 			}
+			continue
 		}
-	}
 
-	callProgress()
+		if fd.streamed {
+			var w io.Writer = tarWriter
+			var h hash.Hash
+			if digests != nil {
+				h = sha256.New()
+				w = io.MultiWriter(tarWriter, h)
+			}
+			if err := copyStreamed(ctx, w, fd, header.Size, rate, opts.BufferSize, addDone); err != nil {
+				return err
+			}
+			if digests != nil {
+				digests.add(header.Name, h.Sum(nil))
+			}
+			tracker.fileDone(header.Name)
+			if opts.OnEntry != nil {
+				opts.OnEntry(EntryEvent{Name: header.Name, Size: header.Size, CompressedSize: -1})
+			}
+			if err := writeLinks(); err != nil {
+				return err
+			}
+			continue
+		}
 
-	// Close writers explicitly before calculating checksum
-	if err := tarWriter.Close(); err != nil {
-		return stats, err
-	}
-	if err := gzWriter.Close(); err != nil {
-		return stats, err
-	}
-	if err := gzipFile.Close(); err != nil {
-		return stats, err
+		if digests != nil {
+			sum := sha256.Sum256(fd.data)
+			digests.add(header.Name, sum[:])
+		}
+		_, err = tarWriter.Write(fd.data)
+		pipeline.release(fd)
+		if err != nil {
+			return err
+		}
+		addDone(int64(len(fd.data)))
+		tracker.fileDone(header.Name)
+		if opts.OnEntry != nil {
+			opts.OnEntry(EntryEvent{Name: header.Name, Size: header.Size, CompressedSize: -1})
+		}
+		if err := writeLinks(); err != nil {
+			return err
+		}
 	}
 
-	// Calculate checksum of the created archive
-	stats.Checksum, err = calculateFileChecksum(gzipPath)
-	if err != nil {
-		return stats, fmt.Errorf("checksum calculation failed: %w", err)
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	// Store checksum in a separate .sha256 file
-	if err := writeChecksumFile(gzipPath, stats.Checksum); err != nil {
-		return stats, fmt.Errorf("failed to write checksum file: %w", err)
+	if digests != nil {
+		if err := digests.writeTar(tarWriter, entryTime(opts), opts.TarFormat); err != nil {
+			return err
+		}
 	}
+	skips.apply(stats)
 
-	return stats, nil
+	tracker.update()
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzWriter.Close()
 }
 
 // ExtractGzip extracts a tar.gz archive to the destination directory
@@ -741,79 +1234,140 @@ func ExtractGzip(gzipPath, destDir string) error {
 
 // ExtractGzipWithProgress extracts a tar.gz archive and reports progress via callback
 func ExtractGzipWithProgress(gzipPath, destDir string, progress ProgressFunc) (stats ExtractStats, err error) {
-	gzipFile, err := os.Open(gzipPath)
-	if err != nil {
-		return stats, err
-	}
-	defer gzipFile.Close()
+	return ExtractGzipWithOptions(gzipPath, destDir, ExtractOptions{Progress: progress})
+}
+
+// ExtractGzipWithOptions extracts a tar.gz archive using the supplied options
+func ExtractGzipWithOptions(gzipPath, destDir string, opts ExtractOptions) (stats ExtractStats, err error) {
+	log := logExtract(&opts, "extract", gzipPath, destDir)
+	defer func() { log.finish(stats.FileCount, stats.TotalBytes, stats.Warnings, err) }()
 
-	// First pass: calculate total size
-	gzReader, err := gzip.NewReader(gzipFile)
+	gzipPath, destDir = longPath(gzipPath), longPath(destDir)
+	archive, err := openArchive(gzipPath)
 	if err != nil {
 		return stats, err
 	}
-	defer gzReader.Close()
+	defer archive.Close()
+	return extractGzip(archive, filepath.Base(gzipPath), destDir, opts)
+}
 
-	tarReader := tar.NewReader(gzReader)
-	totalBytes := int64(0)
-	fileCount := 0
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return stats, err
-		}
-		if header.Typeflag == tar.TypeReg {
-			totalBytes += header.Size
-			fileCount++
-		}
+// extractGzip extracts the tar.gz archive read from archive, called name in
+// errors, to destDir. Only its sequential reader is used.
+func extractGzip(archive archiveReader, name, destDir string, opts ExtractOptions) (stats ExtractStats, err error) {
+	if opts.Recursive > 0 {
+		return extractRecursive(name, archive.Size(), destDir, opts, func(opts ExtractOptions) (ExtractStats, error) {
+			return extractGzip(archive, name, destDir, opts)
+		})
 	}
+	// Extract in a single pass; since totals are not known up front,
+	// progress is measured in compressed bytes read from the archive
+	totalBytes := archive.Size()
+	done := int64(0)
+	tracker := newProgressTracker(withoutFile(opts.Progress), opts.ProgressEvents, opts.ProgressInterval, totalBytes, 0)
+	counted := &countingReader{r: archive.reader(), onRead: func(n int64) {
+		done += n
+		tracker.setDone(done)
+	}}
 
-	stats.TotalBytes = totalBytes
-	stats.FileCount = fileCount
-
-	// Reopen for actual extraction
-	gzipFile.Seek(0, 0)
-	gzReader2, err := gzip.NewReader(gzipFile)
+	gzReader, err := gzip.NewReader(bufio.NewReaderSize(counted, 256<<10))
 	if err != nil {
 		return stats, err
 	}
-	defer gzReader2.Close()
+	defer gzReader.Close()
 
-	tarReader2 := tar.NewReader(gzReader2)
+	tarReader := tar.NewReader(gzReader)
+	tracker.update()
+
+	limits := &extractLimits{opts: opts}
+	filter := extractFilter(opts)
+	warnings := &warningList{}
+	resolver := &conflictResolver{policy: opts.Overwrite, confirm: opts.ConfirmOverwrite, warnings: warnings}
+
+	// The expanded size must stay in proportion to the compressed bytes consumed
+	expanded := int64(0)
+	ctx := optionsContext(opts.Context)
+	rate := newRateLimiter(ctx, opts.RateLimit)
+	entryReader := &ratioReader{
+		r:          contextReader{ctx: ctx, r: rate.reader(tarReader)},
+		name:       name,
+		expanded:   &expanded,
+		compressed: &done,
+		maxRatio:   maxRatioOrDefault(opts.MaxRatio),
+	}
 
-	done := int64(0)
-	callProgress := func() {
-		if progress != nil {
-			progress(done, totalBytes)
-		}
+	var flat *flattener
+	if opts.Flatten {
+		flat = newFlattener(opts.FlattenCollisions, warnings)
 	}
-	callProgress()
 
+	var dirs []dirTimes
+	extracted := make(map[string]extractedFile) // for hard links, by entry name
 	for {
-		header, err := tarReader2.Next()
+		pause(ctx, opts.EntryPause)
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return stats, err
+			return stats, gzipStreamError(name, err)
 		}
 
-		destPath := filepath.Join(destDir, filepath.FromSlash(header.Name))
+		if filter.skipEntry(header.Name, 0) {
+			continue
+		}
+		entryName := header.Name
+		isFile := header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeGNUSparse || header.Typeflag == tar.TypeLink
+		if flat != nil && isFile {
+			if entryName, err = flat.name(header.Name); err != nil {
+				return stats, err
+			}
+		}
+		destPath := filepath.Join(destDir, filepath.FromSlash(entryName))
 
 		// Security check: prevent path traversal
-		if !filepath.IsLocal(header.Name) {
+		if !filepath.IsLocal(entryName) {
 			return stats, fmt.Errorf("invalid file path: %s", header.Name)
 		}
 
+		// A hard link expands to the size of the file it links to
+		size := header.Size
+		target, linked := extracted[header.Linkname]
+		if header.Typeflag == tar.TypeLink {
+			size = target.size
+		}
+		if err := limits.check(header.Name, size, header.Typeflag == tar.TypeDir); err != nil {
+			return stats, err
+		}
+
 		switch header.Typeflag {
 		case tar.TypeDir:
+			if opts.Flatten {
+				continue
+			}
 			if err := os.MkdirAll(destPath, os.FileMode(header.Mode)); err != nil {
 				return stats, err
 			}
-		case tar.TypeReg:
+			dirs = append(dirs, dirTimes{path: destPath, name: header.Name, mtime: header.ModTime, atime: header.AccessTime})
+			if opts.OnEntry != nil {
+				opts.OnEntry(EntryEvent{Name: header.Name, IsDir: true, CompressedSize: -1})
+			}
+		case tar.TypeReg, tar.TypeGNUSparse:
+			if opts.Resume && tarEntryDone(destPath, header) {
+				extracted[header.Name] = extractedFile{path: destPath, size: header.Size}
+				stats.Resumed++
+				continue
+			}
+			destPath, write, err := resolver.resolve(header.Name, destPath, header.ModTime)
+			if err != nil {
+				return stats, err
+			}
+			if !write {
+				continue
+			}
+
 			// Ensure parent directory exists
 			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 				return stats, err
@@ -824,24 +1378,95 @@ func ExtractGzipWithProgress(gzipPath, destDir string, progress ProgressFunc) (s
 				return stats, err
 			}
 
-			pr := &progressReader{
-				r:        tarReader2,
-				done:     &done,
-				total:    totalBytes,
-				progress: progress,
+			src := &countingReader{r: entryReader, onRead: func(n int64) {
+				tracker.readFile(header.Name, header.Size, n)
+			}}
+			if isSparseHeader(header) {
+				// Recreate holes instead of writing out runs of zeros
+				sw := &sparseWriter{f: outFile}
+				if _, err = copyBuffered(sw, src, opts.BufferSize); err == nil {
+					err = sw.finish()
+				}
+			} else {
+				_, err = copyBuffered(outFile, src, opts.BufferSize)
 			}
-
-			if _, err = io.Copy(outFile, pr); err != nil {
+			if err != nil {
 				outFile.Close()
-				return stats, err
+				return stats, gzipStreamError(name, err)
 			}
 			if err := outFile.Close(); err != nil {
 				return stats, err
 			}
+			if !opts.SkipTimes {
+				restoreTimes(destPath, header.Name, header.ModTime, header.AccessTime, warnings)
+			}
+			extracted[header.Name] = extractedFile{path: destPath, size: header.Size}
+
+			stats.TotalBytes += header.Size
+			stats.FileCount++
+			tracker.fileDone(header.Name)
+			if opts.OnEntry != nil {
+				opts.OnEntry(EntryEvent{Name: header.Name, Size: header.Size, CompressedSize: -1})
+			}
+		case tar.TypeLink:
+			if !linked {
+				warnings.add(WarningLinkTargetMissing, header.Name, "hard link to %s not extracted, as that file was not", header.Linkname)
+				continue
+			}
+			expanded += size
+			if err := checkRatio(name, expanded, done, entryReader.maxRatio); err != nil {
+				return stats, err
+			}
+			linkHeader := *header
+			linkHeader.Size = size
+			if opts.Resume && tarEntryDone(destPath, &linkHeader) {
+				extracted[header.Name] = extractedFile{path: destPath, size: size}
+				stats.Resumed++
+				continue
+			}
+			destPath, write, err := resolver.resolve(header.Name, destPath, header.ModTime)
+			if err != nil {
+				return stats, err
+			}
+			if !write {
+				continue
+			}
+			if err := copyExtracted(ctx, target.path, destPath, os.FileMode(header.Mode), rate, opts.BufferSize); err != nil {
+				return stats, err
+			}
+			if !opts.SkipTimes {
+				restoreTimes(destPath, header.Name, header.ModTime, header.AccessTime, warnings)
+			}
+			extracted[header.Name] = extractedFile{path: destPath, size: size}
+
+			stats.TotalBytes += size
+			stats.FileCount++
+			tracker.fileDone(header.Name)
+			if opts.OnEntry != nil {
+				opts.OnEntry(EntryEvent{Name: header.Name, Size: size, CompressedSize: -1})
+			}
+		case tar.TypeSymlink:
+			warnings.add(WarningSymlinkSkipped, header.Name, "symbolic link to %s not extracted", header.Linkname)
+		case tar.TypeXGlobalHeader:
+			// PAX metadata for the entries that follow, not an entry
+		default:
+			warnings.add(WarningUnsupportedEntry, header.Name, "entry of type %q not extracted", header.Typeflag)
 		}
 	}
 
-	callProgress()
+	// The gzip CRC-32 is only checked at the end of the stream, which the
+	// tar reader stops short of
+	if _, err := io.Copy(io.Discard, gzReader); err != nil && !errors.Is(err, gzip.ErrHeader) {
+		return stats, gzipStreamError(name, err)
+	}
+
+	if !opts.SkipTimes {
+		restoreDirTimes(dirs, warnings)
+	}
+	stats.Skipped, stats.Overwritten, stats.Renamed = resolver.skipped, resolver.overwritten, resolver.renamed
+	stats.Warnings = warnings.list()
+
+	tracker.setDone(totalBytes)
 	return stats, nil
 }
 
@@ -861,8 +1486,9 @@ func calculateFileChecksum(filePath string) (string, error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// addChecksumToZip adds the checksum to the zip file comment
-func addChecksumToZip(zipPath, checksum string) error {
+// addChecksumToZip sets the zip file comment to comment followed by the
+// checksum
+func addChecksumToZip(zipPath, checksum, comment string) error {
 	// Read the zip file
 	r, err := zip.OpenReader(zipPath)
 	if err != nil {
@@ -870,16 +1496,21 @@ func addChecksumToZip(zipPath, checksum string) error {
 	}
 
 	// Create a temporary file
-	tempPath := zipPath + ".tmp"
-	tempFile, err := os.Create(tempPath)
+	tempFile, err := tempFileFor(zipPath)
 	if err != nil {
 		r.Close()
 		return err
 	}
+	tempPath := tempFile.Name()
 
 	// Create new zip writer
 	w := zip.NewWriter(tempFile)
-	w.SetComment(fmt.Sprintf("SHA256: %s", checksum))
+	if err := w.SetComment(joinZipComment(comment, checksum)); err != nil {
+		tempFile.Close()
+		r.Close()
+		os.Remove(tempPath)
+		return err
+	}
 
 	// Copy all files from original zip
 	for _, f := range r.File {
@@ -904,24 +1535,46 @@ func addChecksumToZip(zipPath, checksum string) error {
 	}
 
 	// Replace original with temp
-	if err := os.Remove(zipPath); err != nil {
+	if err := os.Rename(tempPath, zipPath); err != nil {
+		os.Remove(tempPath)
 		return err
 	}
-	return os.Rename(tempPath, zipPath)
+	return nil
+}
+
+// tempFileFor creates a temporary file beside path, with the permissions of
+// path if it exists, for a new version of path to be renamed over it once
+// complete. The rename replaces path in one step, so a failure leaves the
+// original intact, and no other file next to path is touched. The name
+// starts with that of path, so watchers ignoring path ignore it too.
+func tempFileFor(path string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
 }
 
-// copyZipFile copies a file from one zip to another
+// copyZipFile copies a file from one zip to another without recompressing it
 func copyZipFile(w *zip.Writer, f *zip.File) error {
-	fw, err := w.CreateHeader(&f.FileHeader)
+	fw, err := w.CreateRaw(&f.FileHeader)
 	if err != nil {
 		return err
 	}
 
-	fr, err := f.Open()
+	fr, err := f.OpenRaw()
 	if err != nil {
 		return err
 	}
-	defer fr.Close()
 
 	_, err = io.Copy(fw, fr)
 	return err
@@ -936,6 +1589,7 @@ func writeChecksumFile(archivePath, checksum string) error {
 
 // VerifyChecksum verifies the checksum of an archive
 func VerifyChecksum(archivePath string) (bool, string, error) {
+	archivePath = longPath(archivePath)
 	ext := strings.ToLower(filepath.Ext(archivePath))
 
 	if ext == ".zip" {
@@ -946,12 +1600,11 @@ func VerifyChecksum(archivePath string) (bool, string, error) {
 		}
 		defer r.Close()
 
-		comment := r.Comment
-		if !strings.HasPrefix(comment, "SHA256: ") {
+		_, storedChecksum := splitZipComment(r.Comment)
+		if storedChecksum == "" {
 			return false, "", fmt.Errorf("no checksum found in archive")
 		}
 
-		storedChecksum := strings.TrimPrefix(comment, "SHA256: ")
 		actualChecksum, err := calculateFileChecksum(archivePath)
 		if err != nil {
 			return false, "", err
@@ -0,0 +1,153 @@
+package zipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestFailedGzipKeepsArchive rewrites a tar.gz archive, as -watch does, with
+// a run that fails; the earlier archive must survive it untouched.
+func TestFailedGzipKeepsArchive(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gzPath := filepath.Join(dir, "out.tar.gz")
+	if _, err := GzipWithOptions(src, gzPath, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GzipWithOptions(src, gzPath, CreateOptions{Context: ctx}); err == nil {
+		t.Fatal("canceled run succeeded")
+	}
+	if after, err := os.ReadFile(gzPath); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("archive changed by a failed run: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("folder holds %d entries; want src, the archive and its checksum file", len(entries))
+	}
+}
+
+// TestFailedZipRemovesArchive checks that a zip run failing while writing
+// entries leaves no partial archive behind.
+func TestFailedZipRemovesArchive(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "out.zip")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ZipWithOptions(src, zipPath, CreateOptions{Context: ctx}); err == nil {
+		t.Fatal("canceled run succeeded")
+	}
+	if _, err := os.Stat(zipPath); !os.IsNotExist(err) {
+		t.Errorf("partial archive left behind: %v", err)
+	}
+}
+
+// writeUnreadableZip writes a zip archive whose first entry uses an unknown
+// compression method, so opening it fails, followed by the entries of
+// files.
+func writeUnreadableZip(t *testing.T, path string, files ...string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateRaw(&zip.FileHeader{Name: "bad.txt", Method: 99, CompressedSize64: 1, UncompressedSize64: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("x"))
+	for _, name := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(name))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestExtractWorkerFailureStopsSender fails the only worker while later
+// entries are still being checked, and cancels the extraction during one
+// of those checks; the error must be returned without the checks going on
+// afterwards.
+func TestExtractWorkerFailureStopsSender(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "in.zip")
+	names := []string{"b.txt", "c.txt", "d.txt"}
+	writeUnreadableZip(t, archivePath, names...)
+	dest := filepath.Join(dir, "out")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Already extracted, so resuming only checks them
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	returned := make(chan struct{})
+	late := make(chan struct{}, len(names))
+	opts := ExtractOptions{
+		Context:          ctx,
+		Workers:          1,
+		Resume:           true,
+		ProgressInterval: -1,
+		Progress: func(done, total int64) {
+			if done == 0 {
+				return
+			}
+			// Give the worker time to fail, then cancel
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+			select {
+			case <-returned:
+				late <- struct{}{}
+			default:
+			}
+		},
+	}
+	_, err := ExtractWithOptions(archivePath, dest, opts)
+	close(returned)
+	if err == nil {
+		t.Fatal("extracting an unreadable entry succeeded")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(late) > 0 {
+		t.Error("entries were still checked after extraction returned")
+	}
+}